@@ -0,0 +1,385 @@
+package goipset
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+// AggregateNets returns the fewest networks that match exactly the
+// addresses entries match. Overlapping networks are merged into the larger
+// one and adjacent networks into their common parent, separately for every
+// family and, for hash:net,port entries, every protocol and port.
+//
+// Entries with NoMatch are exceptions as in a hash:net set, the most
+// specific network decides. They are kept where they are still needed and
+// no new ones are made up. If the same network is given as match and as
+// nomatch, nomatch wins. IP ranges are split into networks first.
+//
+// Entries with another comment, timeout, counters or skbinfo are
+// aggregated separately and the resulting entries keep them. Exceptions
+// apply to the entries of all of them then, so every exception inside a
+// network is kept as it is given.
+func AggregateNets(entries []GoIPSetEntry) ([]GoIPSetEntry, error) {
+	result, _, err := aggregateNets(entries)
+	return result, err
+}
+
+// aggregateNets is AggregateNets, which also returns the number of
+// networks entries amount to with their ranges split.
+func aggregateNets(entries []GoIPSetEntry) ([]GoIPSetEntry, int, error) {
+	if len(entries) == 0 {
+		return nil, 0, nil
+	}
+	var prefixes []aggregatePrefix
+	uniform := true
+	for i := range entries {
+		entry := &entries[i]
+		options := aggregateOptions(entry)
+		uniform = uniform && options == aggregateOptions(&entries[0])
+		e := entryFieldsOf(entry.Set)
+		if e.ip == nil || e.has(DimMAC) {
+			return nil, 0, fmt.Errorf("entry %s is not a network", entry.Set)
+		}
+
+		key := aggregateKey{v6: e.ip.To4() == nil, port: e.has(DimPort), proto: e.proto, portFrom: e.port, portTo: e.portTo}
+		ip := e.ip.To16()
+		if !key.v6 {
+			ip = e.ip.To4()
+		}
+		var nets []*net.IPNet
+		if e.ipTo != nil {
+			var err error
+			if nets, err = RangeToCIDRs(e.ip, e.ipTo); err != nil {
+				return nil, 0, err
+			}
+		} else {
+			cidr := int(e.cidr)
+			if cidr == 0 {
+				cidr = len(ip) * 8
+			}
+			if cidr > len(ip)*8 {
+				return nil, 0, fmt.Errorf("entry %s has an invalid prefix length", entry.Set)
+			}
+			nets = []*net.IPNet{{IP: ip, Mask: net.CIDRMask(cidr, len(ip)*8)}}
+		}
+		for _, prefix := range nets {
+			ones, _ := prefix.Mask.Size()
+			prefixes = append(prefixes, aggregatePrefix{aggregateGroup{key, options}, prefix.IP, ones, entry.NoMatch})
+		}
+	}
+
+	groups := map[aggregateGroup]*trieNode{}
+	var order []aggregateGroup
+	var exceptions []aggregatePrefix
+	for _, p := range prefixes {
+		if p.nomatch && !uniform {
+			exceptions = append(exceptions, p)
+			continue
+		}
+		root, ok := groups[p.aggregateGroup]
+		if !ok {
+			root = &trieNode{}
+			groups[p.aggregateGroup] = root
+			order = append(order, p.aggregateGroup)
+		}
+		root.insert(p.ip, p.cidr, p.nomatch, false)
+	}
+	sort.SliceStable(order, func(i, j int) bool { return order[i].key.less(order[j].key) })
+
+	// With several options, an exception goes into every group with
+	// networks around or inside it and must be kept there, so that the
+	// groups agree on it. It is made once, with its own options, unless
+	// no network is around it.
+	var kept []aggregatePrefix
+	var around [][]*trieNode
+	for _, p := range exceptions {
+		var roots []*trieNode
+		covered := false
+		for _, g := range order {
+			if root := groups[g]; g.key == p.key && root.overlaps(p.ip, p.cidr) {
+				covered = covered || root.covers(p.ip, p.cidr)
+				roots = append(roots, root)
+			}
+		}
+		if covered {
+			kept = append(kept, p)
+			around = append(around, roots)
+		}
+	}
+	for i, p := range kept {
+		for _, root := range around[i] {
+			root.insert(p.ip, p.cidr, true, true)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].key.less(kept[j].key) })
+
+	var result []GoIPSetEntry
+	made := map[string]bool{}
+	for len(order) > 0 {
+		key := order[0].key
+		bits := 32
+		if key.v6 {
+			bits = 128
+		}
+		for ; len(order) > 0 && order[0].key == key; order = order[1:] {
+			g := order[0]
+			root := groups[g]
+			root.solve(false, 0, bits)
+			root.emit(make(net.IP, bits/8), 0, false, func(ip net.IP, cidr int, nomatch bool) {
+				// The kept exceptions are made below.
+				if !uniform && nomatch {
+					return
+				}
+				result = append(result, g.entry(ip, cidr, nomatch))
+			})
+		}
+		for ; len(kept) > 0 && kept[0].key == key; kept = kept[1:] {
+			p := kept[0]
+			entry := p.entry(p.ip, p.cidr, true)
+			if !made[entry.Set.String()] {
+				made[entry.Set.String()] = true
+				result = append(result, entry)
+			}
+		}
+	}
+	return result, len(prefixes), nil
+}
+
+// aggregateOptions returns the options of entry, which is all but its
+// network.
+func aggregateOptions(entry *GoIPSetEntry) GoIPSetEntry {
+	options := *entry
+	options.Set = nil
+	options.NoMatch = false
+	return options
+}
+
+// aggregateGroup separates the networks with other options, which are
+// aggregated independently as well.
+type aggregateGroup struct {
+	key     aggregateKey
+	options GoIPSetEntry
+}
+
+// entry returns the entry of the network ip/cidr with the options of g.
+func (g aggregateGroup) entry(ip net.IP, cidr int, nomatch bool) GoIPSetEntry {
+	entry := g.options
+	entry.NoMatch = nomatch
+	if g.key.port {
+		entry.Set = &SetNetPort{IP: ip, CIDR: uint8(cidr), Port: g.key.portFrom, PortTo: g.key.portTo, Proto: g.key.proto}
+	} else {
+		entry.Set = &SetNet{IP: ip, CIDR: uint8(cidr)}
+	}
+	return entry
+}
+
+// aggregatePrefix is a network of an entry, ranges are split into several.
+type aggregatePrefix struct {
+	aggregateGroup
+	ip      net.IP
+	cidr    int
+	nomatch bool
+}
+
+// aggregateKey separates the networks that are aggregated independently.
+type aggregateKey struct {
+	v6               bool
+	port             bool
+	proto            uint8
+	portFrom, portTo uint16
+}
+
+func (k aggregateKey) less(o aggregateKey) bool {
+	switch {
+	case k.v6 != o.v6:
+		return !k.v6
+	case k.port != o.port:
+		return !k.port
+	case k.proto != o.proto:
+		return k.proto < o.proto
+	case k.portFrom != o.portFrom:
+		return k.portFrom < o.portFrom
+	}
+	return k.portTo < o.portTo
+}
+
+// The entries of a node in the binary trie of the networks.
+const (
+	noEntry = iota
+	matchEntry
+	nomatchEntry
+)
+
+// infinite is the cost of a choice that is not possible.
+const infinite = 1 << 30
+
+// trieNode is a network in the binary trie of all networks given, its
+// children are the two halves.
+type trieNode struct {
+	child [2]*trieNode
+	entry int
+	// kept is an exception that must be made whatever it costs.
+	kept bool
+
+	// Filled in by solve: the fewest entries needed for this network
+	// when the networks around it match or not, and the entry to make
+	// here for it.
+	cost   [2]int
+	choice [2]int
+	// effective is whether the given networks match the parts of this
+	// network without a more specific network below it.
+	effective bool
+}
+
+func (n *trieNode) insert(ip net.IP, cidr int, nomatch, kept bool) {
+	for depth := 0; depth < cidr; depth++ {
+		bit := ip[depth/8] >> (7 - uint(depth%8)) & 1
+		if n.child[bit] == nil {
+			n.child[bit] = &trieNode{}
+		}
+		n = n.child[bit]
+	}
+	if nomatch {
+		n.entry = nomatchEntry
+		n.kept = n.kept || kept
+	} else if n.entry == noEntry {
+		n.entry = matchEntry
+	}
+}
+
+// overlaps returns whether n has networks around or inside the network
+// ip/cidr.
+func (n *trieNode) overlaps(ip net.IP, cidr int) bool {
+	for depth := 0; depth < cidr; depth++ {
+		if n.entry == matchEntry {
+			return true
+		}
+		if n = n.child[ip[depth/8]>>(7-uint(depth%8))&1]; n == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// covers returns whether n has a network around the network ip/cidr or
+// the network itself.
+func (n *trieNode) covers(ip net.IP, cidr int) bool {
+	for depth := 0; n.entry != matchEntry; depth++ {
+		if depth == cidr {
+			return false
+		}
+		if n = n.child[ip[depth/8]>>(7-uint(depth%8))&1]; n == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// solve computes the costs of n and its children bottom up. effective is
+// whether the given networks around n match, bits the length of an address.
+func (n *trieNode) solve(effective bool, depth, bits int) {
+	switch n.entry {
+	case matchEntry:
+		effective = true
+	case nomatchEntry:
+		effective = false
+	}
+	n.effective = effective
+	for _, child := range n.child {
+		if child != nil {
+			child.solve(effective, depth+1, bits)
+		}
+	}
+
+	for state := 0; state < 2; state++ {
+		n.cost[state], n.choice[state] = infinite, noEntry
+		for _, choice := range []int{noEntry, matchEntry, nomatchEntry} {
+			if n.kept && depth > 0 && choice != nomatchEntry {
+				continue
+			}
+			cost, inside := 0, state == 1
+			switch choice {
+			case matchEntry:
+				// The kernel does not take a prefix length of 0.
+				if depth == 0 {
+					continue
+				}
+				cost, inside = 1, true
+			case nomatchEntry:
+				// Only where an exception was given.
+				if depth == 0 || n.entry != nomatchEntry {
+					continue
+				}
+				cost, inside = 1, false
+			}
+			if depth == bits {
+				// A single address has no halves to match otherwise.
+				if inside != effective {
+					continue
+				}
+			} else {
+				cost += n.childCost(0, inside) + n.childCost(1, inside)
+			}
+			if cost < n.cost[state] {
+				n.cost[state], n.choice[state] = cost, choice
+			}
+		}
+	}
+}
+
+// childCost is the cost of the half i of n when the networks around it
+// match or not. A missing child is matched as n is.
+func (n *trieNode) childCost(i int, inside bool) int {
+	state := 0
+	if inside {
+		state = 1
+	}
+	if child := n.child[i]; child != nil {
+		return child.cost[state]
+	}
+	switch {
+	case n.effective == inside:
+		return 0
+	case n.effective:
+		// A network of its own for this half.
+		return 1
+	}
+	return infinite
+}
+
+// emit calls fn for the entries solve chose, in address order.
+func (n *trieNode) emit(ip net.IP, depth int, inside bool, fn func(ip net.IP, cidr int, nomatch bool)) {
+	state := 0
+	if inside {
+		state = 1
+	}
+	switch n.choice[state] {
+	case matchEntry:
+		fn(copyIP(ip), depth, false)
+		inside = true
+	case nomatchEntry:
+		fn(copyIP(ip), depth, true)
+		inside = false
+	}
+	if depth == len(ip)*8 {
+		return
+	}
+	for i := range n.child {
+		half := copyIP(ip)
+		if i == 1 {
+			half[depth/8] |= 0x80 >> uint(depth%8)
+		}
+		if child := n.child[i]; child != nil {
+			child.emit(half, depth+1, inside, fn)
+		} else if n.effective && !inside {
+			fn(half, depth+1, false)
+		}
+	}
+}
+
+func copyIP(ip net.IP) net.IP {
+	c := make(net.IP, len(ip))
+	copy(c, ip)
+	return c
+}
@@ -0,0 +1,229 @@
+package goipset
+
+import (
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/JiHanHuang/goipset/ipsettest"
+	"golang.org/x/sys/unix"
+)
+
+func netEntries(t *testing.T, nets ...string) []GoIPSetEntry {
+	t.Helper()
+	var entries []GoIPSetEntry
+	for _, text := range nets {
+		nomatch := strings.HasPrefix(text, "!")
+		set, err := ParseEntry("hash:net", familyOf(text), strings.TrimPrefix(text, "!"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, GoIPSetEntry{Set: set, NoMatch: nomatch})
+	}
+	return entries
+}
+
+func familyOf(text string) string {
+	if strings.Contains(text, ":") {
+		return "inet6"
+	}
+	return "inet"
+}
+
+func netStrings(entries []GoIPSetEntry) []string {
+	var nets []string
+	for _, entry := range entries {
+		s := entry.Set.String()
+		if entry.NoMatch {
+			s = "!" + s
+		}
+		nets = append(nets, s)
+	}
+	return nets
+}
+
+func TestAggregateNets(t *testing.T) {
+	tests := []struct {
+		nets     []string
+		expected []string
+	}{
+		{[]string{"10.0.0.0/25", "10.0.0.128/25"}, []string{"10.0.0.0/24"}},
+		{[]string{"10.0.0.0/24", "10.0.0.5", "10.0.0.0/26"}, []string{"10.0.0.0/24"}},
+		{[]string{"10.0.0.0/24", "10.0.0.0/24"}, []string{"10.0.0.0/24"}},
+		{[]string{"10.0.0.0/26", "10.0.0.64/26", "10.0.0.128/25", "10.0.1.0/24"}, []string{"10.0.0.0/23"}},
+		{[]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, []string{"10.0.0.1/32", "10.0.0.2/31"}},
+		{[]string{"10.0.0.0-10.0.0.255", "10.0.1.0/24"}, []string{"10.0.0.0/23"}},
+		{[]string{"192.168.0.0/24", "10.0.0.0/8"}, []string{"10.0.0.0/8", "192.168.0.0/24"}},
+		// The exception stays, also inside merged networks.
+		{[]string{"10.0.0.0/25", "10.0.0.128/25", "!10.0.0.5"}, []string{"10.0.0.0/24", "!10.0.0.5/32"}},
+		// Covering the exception with fewer networks drops it.
+		{[]string{"10.0.0.0/24", "!10.0.0.0/25", "10.0.0.0/26"}, []string{"10.0.0.0/26", "10.0.0.128/25"}},
+		// An exception without a network around it does nothing.
+		{[]string{"!10.0.0.0/24", "10.1.0.0/24"}, []string{"10.1.0.0/24"}},
+		// The same network as match and as exception is an exception.
+		{[]string{"10.0.0.0/24", "!10.0.0.0/24"}, nil},
+		{[]string{"0.0.0.0/1", "128.0.0.0/1"}, []string{"0.0.0.0/1", "128.0.0.0/1"}},
+		{[]string{"2001:db8::/33", "2001:db8:8000::/33", "10.0.0.0/8"}, []string{"10.0.0.0/8", "2001:db8::/32"}},
+		{[]string{"2001:db8::1-2001:db8::8", "2001:db8::"}, []string{"2001:db8::/125", "2001:db8::8/128"}},
+	}
+	for _, test := range tests {
+		result, err := AggregateNets(netEntries(t, test.nets...))
+		if err != nil {
+			t.Errorf("aggregating %v failed: %v", test.nets, err)
+			continue
+		}
+		if got := netStrings(result); strings.Join(got, " ") != strings.Join(test.expected, " ") {
+			t.Errorf("expected %v to aggregate to %v, got %v", test.nets, test.expected, got)
+		}
+	}
+
+	ports := []GoIPSetEntry{
+		{Set: &SetNetPort{IP: net.ParseIP("10.0.0.0"), CIDR: 25, Port: 80, Proto: unix.IPPROTO_TCP}},
+		{Set: &SetNetPort{IP: net.ParseIP("10.0.0.128"), CIDR: 25, Port: 80, Proto: unix.IPPROTO_TCP}},
+		{Set: &SetNetPort{IP: net.ParseIP("10.0.0.128"), CIDR: 25, Port: 53, Proto: unix.IPPROTO_UDP}},
+	}
+	result, err := AggregateNets(ports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(netStrings(result), " "); got != "10.0.0.0/24,TCP:80 10.0.0.128/25,UDP:53" {
+		t.Errorf("expected the ports to be aggregated separately, got %s", got)
+	}
+
+	mixed := netEntries(t, "10.0.0.0/25", "10.0.1.0/25", "10.0.0.128/25", "10.0.1.128/25", "!10.0.0.8/30", "!10.2.0.0/24")
+	mixed[1].Comment = "other"
+	mixed[3].Comment = "other"
+	mixed[4].Comment = "exception"
+	result, err = AggregateNets(mixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range result {
+		got = append(got, netStrings([]GoIPSetEntry{entry})[0]+" "+entry.Comment)
+	}
+	if strings.Join(got, ", ") != "10.0.0.0/24 , 10.0.1.0/24 other, !10.0.0.8/30 exception" {
+		t.Errorf("expected the comments to be aggregated separately, got %v", got)
+	}
+	if _, err := AggregateNets([]GoIPSetEntry{{Set: &SetMac{MAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}}}}); err == nil {
+		t.Errorf("expected a MAC address to fail")
+	}
+}
+
+// lookup returns whether ip matches entries the way the kernel matches a
+// hash:net set, the most specific network wins.
+func lookup(entries []GoIPSetEntry, ip net.IP) bool {
+	best, match := -1, false
+	for _, entry := range entries {
+		set := entry.Set.(*SetNet)
+		cidr := int(set.CIDR)
+		if cidr == 0 {
+			cidr = 32
+		}
+		if set.IPTO != nil {
+			if ipCompare(ip.To4(), set.IP.To4()) >= 0 && ipCompare(ip.To4(), set.IPTO.To4()) <= 0 && 32 > best {
+				best, match = 32, !entry.NoMatch
+			}
+			continue
+		}
+		n := net.IPNet{IP: set.IP.To4(), Mask: net.CIDRMask(cidr, 32)}
+		if n.Contains(ip) && (cidr > best || cidr == best && entry.NoMatch) {
+			best, match = cidr, !entry.NoMatch
+		}
+	}
+	return match
+}
+
+func TestAggregateNetsRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for round := 0; round < 200; round++ {
+		var entries []GoIPSetEntry
+		for i := r.Intn(12) + 1; i > 0; i-- {
+			cidr := uint8(24 + r.Intn(9))
+			ip := net.IPv4(10, 0, 0, byte(r.Intn(256))).Mask(net.CIDRMask(int(cidr), 32))
+			entry := GoIPSetEntry{Set: &SetNet{IP: ip, CIDR: cidr}, NoMatch: r.Intn(3) == 0}
+			// Half of the rounds with other options, which are aggregated separately.
+			if round%2 == 1 {
+				entry.Comment = string(rune('a' + r.Intn(3)))
+			}
+			entries = append(entries, entry)
+		}
+		result, err := AggregateNets(entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result) > len(entries) {
+			t.Errorf("expected at most %d networks, got %v for %v", len(entries), netStrings(result), netStrings(entries))
+		}
+		for i := 0; i < 256; i++ {
+			ip := net.IPv4(10, 0, 0, byte(i)).To4()
+			if lookup(entries, ip) != lookup(result, ip) {
+				t.Fatalf("expected %v to match %s as %v does, got %v", netStrings(result), ip, netStrings(entries), lookup(result, ip))
+			}
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	ipset := NewGoIpsetWithTransport(ipsettest.NewKernel())
+	if err := ipset.Create("test", "hash:net", GoIpsetCreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := netEntries(t, "10.0.0.0/25", "10.0.0.128/25", "10.0.0.7", "!10.0.0.8/30", "10.0.1.0/24")
+	report, err := ipset.Load("test", entries, LoadOptions{Aggregate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (LoadReport{Entries: 5, Loaded: 2, Saved: 3}) {
+		t.Errorf("unexpected report %+v", report)
+	}
+	result, err := ipset.List("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, entry := range result.Entries {
+		got = append(got, entry.Set.String())
+	}
+	expectEntries(t, got, "10.0.0.0/23", "10.0.0.8/30")
+
+	report, err = ipset.Load("test", netEntries(t, "10.0.0.0/23", "!10.0.0.8/30"), LoadOptions{Exist: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (LoadReport{Entries: 2, Loaded: 2}) {
+		t.Errorf("unexpected report %+v", report)
+	}
+	result, err = ipset.List("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nomatch := 0
+	for _, entry := range result.Entries {
+		if entry.NoMatch {
+			nomatch++
+			if s := entry.Set.String(); s != "10.0.0.8/30" {
+				t.Errorf("expected 10.0.0.8/30 to be the exception, got %s", s)
+			}
+		}
+	}
+	if len(result.Entries) != 2 || nomatch != 1 {
+		t.Errorf("expected 2 entries with 1 exception, got %+v", result.Entries)
+	}
+
+	if _, err := ipset.Load("test", netEntries(t, "10.0.0.0/23"), LoadOptions{}); err == nil {
+		t.Errorf("expected loading an existing entry without Exist to fail")
+	}
+	if err := ipset.Create("ips", "hash:ip", GoIpsetCreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ipset.Load("ips", nil, LoadOptions{Aggregate: true}); err != nil {
+		t.Errorf("expected loading nothing to succeed, got %v", err)
+	}
+	ips := []GoIPSetEntry{{Set: &SetIP{IP: net.ParseIP("1.1.1.1")}}}
+	if _, err := ipset.Load("ips", ips, LoadOptions{Aggregate: true}); err == nil {
+		t.Errorf("expected aggregating a hash:ip set to fail")
+	}
+}
@@ -0,0 +1,438 @@
+package goipset
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetOp is an operation of set algebra, see Combine.
+type SetOp int
+
+const (
+	OpUnion        SetOp = iota // what is in either set
+	OpIntersection              // what is in both sets
+	OpDifference                // what is in the first set but not in the second
+)
+
+func (op SetOp) String() string {
+	switch op {
+	case OpUnion:
+		return "union"
+	case OpIntersection:
+		return "intersection"
+	case OpDifference:
+		return "difference"
+	}
+	return fmt.Sprintf("SetOp(%d)", int(op))
+}
+
+func (op SetOp) apply(a, b bool) bool {
+	switch op {
+	case OpUnion:
+		return a || b
+	case OpIntersection:
+		return a && b
+	}
+	return a && !b
+}
+
+// CombineOptions are the options of CombineSets.
+type CombineOptions struct {
+	// Into is the set the result is loaded into, replacing its entries
+	// atomically as Replace does. A set that does not exist is created
+	// with the type, family and options of the first set. A set of a type
+	// without networks, such as hash:ip, gets the result as addresses and
+	// ranges even if the sets have networks. Empty for only returning the
+	// result.
+	Into string
+}
+
+// Union returns the entries of what is in a or b, see Combine.
+func Union(a, b GoIPSetResult) ([]GoIPSetEntry, error) {
+	return Combine(OpUnion, a, b)
+}
+
+// Intersection returns the entries of what is in both a and b, see
+// Combine.
+func Intersection(a, b GoIPSetResult) ([]GoIPSetEntry, error) {
+	return Combine(OpIntersection, a, b)
+}
+
+// Difference returns the entries of what is in a but not in b, see
+// Combine.
+func Difference(a, b GoIPSetResult) ([]GoIPSetEntry, error) {
+	return Combine(OpDifference, a, b)
+}
+
+// Combine applies op to the entries of the sets a and b as listed by List,
+// or made up by the caller, and returns the entries of the result.
+//
+// Entries are compared by the addresses they match, not by how they are
+// written: a set of networks matches an address if the most specific
+// network containing it is not a nomatch entry, and the result of
+// 10.0.0.0/24 minus 10.0.0.1 is 10.0.0.0/32, 10.0.0.2/31, 10.0.0.4/30 and
+// so on up to 10.0.0.128/25. Entries only meet those of the same family
+// and, with ports, the same protocol and port, port ranges are split into
+// their ports. The sets must both have ports or both not, and can't mix
+// MAC addresses with IP addresses.
+//
+// If either set has networks or nomatch entries, the result consists of the
+// fewest networks, as SetNet or SetNetPort, and has no nomatch entries.
+// Otherwise it is made of addresses, as SetIP or SetIPPort with IPv4
+// ranges for runs of consecutive addresses, or of MAC addresses as SetMac.
+// The entries come in address order and have no options such as timeouts
+// or comments.
+func Combine(op SetOp, a, b GoIPSetResult) ([]GoIPSetEntry, error) {
+	return combine(op, a, b, true)
+}
+
+// combine is Combine, but with nets false the result is made of addresses
+// even if either set has networks.
+func combine(op SetOp, a, b GoIPSetResult, nets bool) ([]GoIPSetEntry, error) {
+	c := combiner{tries: map[aggregateKey]*algebraNode{}}
+	for operand, set := range []*GoIPSetResult{&a, &b} {
+		for i := range set.Entries {
+			if err := c.add(operand, &set.Entries[i]); err != nil {
+				return nil, fmt.Errorf("%s: %v", set.SetName, err)
+			}
+		}
+	}
+	if c.kinds[0] != 0 && c.kinds[1] != 0 && c.kinds[0] != c.kinds[1] {
+		return nil, fmt.Errorf("%s and %s can't be combined, only one of them has %s",
+			a.SetName, b.SetName, c.kindDifference())
+	}
+	if c.macs != nil {
+		return c.combineMACs(op), nil
+	}
+	c.nets = c.nets && nets
+	return c.combineIPs(op)
+}
+
+// CombineSets applies op to the sets a and b, see Combine.
+func CombineSets(op SetOp, a, b string, options CombineOptions) ([]GoIPSetEntry, error) {
+	return gipset.CombineSets(op, a, b, options)
+}
+
+// CombineSets lists the sets a and b and applies op to them, see Combine.
+// With CombineOptions.Into the result is also loaded into a set, and the
+// entries returned are those loaded.
+func (g *GoIpset) CombineSets(op SetOp, a, b string, options CombineOptions) ([]GoIPSetEntry, error) {
+	setA, err := g.List(a)
+	if err != nil {
+		return nil, err
+	}
+	setB, err := g.List(b)
+	if err != nil {
+		return nil, err
+	}
+	if options.Into == "" {
+		return Combine(op, setA, setB)
+	}
+
+	into, err := g.Header(options.Into)
+	exists := err == nil
+	if !exists {
+		if !errors.Is(err, unix.ENOENT) {
+			return nil, err
+		}
+		into = setA
+	}
+	setType, _ := LookupSetType(into.TypeName)
+	entries, err := combine(op, setA, setB, setType == nil || setType.hasNet())
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return entries, g.Replace(options.Into, entries)
+	}
+
+	create, err := createOptionsOf(&setA)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.Create(options.Into, setA.TypeName, create); err != nil {
+		return nil, err
+	}
+	if _, err := g.Load(options.Into, entries, LoadOptions{Exist: true}); err != nil {
+		g.Destroy(options.Into)
+		return nil, err
+	}
+	return entries, nil
+}
+
+// The kinds of entries of a set, those of the two sets of Combine must
+// agree.
+const (
+	kindIP = 1 << iota
+	kindPort
+	kindMAC
+)
+
+// combiner collects the entries of the two sets of Combine.
+type combiner struct {
+	tries map[aggregateKey]*algebraNode
+	macs  map[string]*[2]bool
+	kinds [2]int
+	// nets is whether the result is made of networks.
+	nets bool
+}
+
+func (c *combiner) kindDifference() string {
+	switch diff := c.kinds[0] ^ c.kinds[1]; {
+	case diff&kindMAC != 0:
+		return "MAC addresses"
+	case diff&kindPort != 0:
+		return "ports"
+	}
+	return "IP addresses"
+}
+
+// add adds an entry of the set operand, 0 or 1.
+func (c *combiner) add(operand int, entry *GoIPSetEntry) error {
+	if entry.Set == nil {
+		return fmt.Errorf("Set is nil in GoIPSetEntry")
+	}
+	e := entryFieldsOf(entry.Set)
+	if e.has(DimMAC) {
+		c.kinds[operand] |= kindMAC
+		if c.macs == nil {
+			c.macs = map[string]*[2]bool{}
+		}
+		mac := entryMAC(entry.Set).String()
+		if c.macs[mac] == nil {
+			c.macs[mac] = &[2]bool{}
+		}
+		c.macs[mac][operand] = true
+		return nil
+	}
+	if e.ip == nil {
+		return fmt.Errorf("entry %s has no address", entry.Set)
+	}
+	c.kinds[operand] |= kindIP
+	if e.has(DimPort) {
+		c.kinds[operand] |= kindPort
+	}
+	c.nets = c.nets || e.has(DimNet) || entry.NoMatch
+
+	ip := e.ip.To4()
+	if ip == nil {
+		ip = e.ip.To16()
+	}
+	var prefixes []*net.IPNet
+	if e.ipTo != nil {
+		var err error
+		if prefixes, err = RangeToCIDRs(e.ip, e.ipTo); err != nil {
+			return err
+		}
+	} else {
+		cidr := int(e.cidr)
+		if cidr == 0 {
+			cidr = len(ip) * 8
+		}
+		if cidr > len(ip)*8 {
+			return fmt.Errorf("entry %s has an invalid prefix length", entry.Set)
+		}
+		prefixes = []*net.IPNet{{IP: ip.Mask(net.CIDRMask(cidr, len(ip)*8)), Mask: net.CIDRMask(cidr, len(ip)*8)}}
+	}
+
+	portTo := e.port
+	if e.portTo > e.port {
+		portTo = e.portTo
+	}
+	for port := int(e.port); port <= int(portTo); port++ {
+		key := aggregateKey{v6: len(ip) == net.IPv6len, port: e.has(DimPort), proto: e.proto, portFrom: uint16(port)}
+		root, ok := c.tries[key]
+		if !ok {
+			root = &algebraNode{}
+			c.tries[key] = root
+		}
+		for _, prefix := range prefixes {
+			ones, _ := prefix.Mask.Size()
+			root.insert(prefix.IP, ones, operand, entry.NoMatch)
+		}
+	}
+	return nil
+}
+
+// combineMACs applies op to the MAC addresses.
+func (c *combiner) combineMACs(op SetOp) []GoIPSetEntry {
+	var macs []string
+	for mac, in := range c.macs {
+		if op.apply(in[0], in[1]) {
+			macs = append(macs, mac)
+		}
+	}
+	sort.Strings(macs)
+	entries := make([]GoIPSetEntry, 0, len(macs))
+	for _, mac := range macs {
+		hw, _ := net.ParseMAC(mac)
+		entries = append(entries, GoIPSetEntry{Set: &SetMac{MAC: hw}})
+	}
+	return entries
+}
+
+// combineIPs applies op to the tries of the addresses.
+func (c *combiner) combineIPs(op SetOp) ([]GoIPSetEntry, error) {
+	keys := make([]aggregateKey, 0, len(c.tries))
+	for key := range c.tries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+
+	var entries []GoIPSetEntry
+	for _, key := range keys {
+		bits := 32
+		if key.v6 {
+			bits = 128
+		}
+		var nets []*net.IPNet
+		emit := func(ip net.IP, cidr int) {
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(cidr, bits)})
+		}
+		if c.tries[key].walk(make(net.IP, bits/8), 0, [2]bool{}, op, emit) {
+			emit(make(net.IP, bits/8), 0)
+		}
+		sort.Slice(nets, func(i, j int) bool { return ipCompare(nets[i].IP, nets[j].IP) < 0 })
+
+		if c.nets {
+			for _, n := range splitWhole(nets) {
+				ones, _ := n.Mask.Size()
+				var set Set = &SetNet{IP: n.IP, CIDR: uint8(ones)}
+				if key.port {
+					set = &SetNetPort{IP: n.IP, CIDR: uint8(ones), Port: key.portFrom, Proto: key.proto}
+				}
+				entries = append(entries, GoIPSetEntry{Set: set})
+			}
+			continue
+		}
+		ranges, err := addressRanges(nets, key.v6)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range ranges {
+			var set Set = &SetIP{IP: r[0], IPTO: r[1]}
+			if key.port {
+				set = &SetIPPort{IP: r[0], IPTO: r[1], Port: key.portFrom, Proto: key.proto}
+			}
+			entries = append(entries, GoIPSetEntry{Set: set})
+		}
+	}
+	return entries, nil
+}
+
+// addressRanges merges the sorted networks nets into runs of consecutive
+// addresses, the first and the last address of each, the last one nil for
+// a single address. IPv6 runs are split into their addresses, since sets
+// of IPv6 addresses don't take ranges.
+func addressRanges(nets []*net.IPNet, v6 bool) ([][2]net.IP, error) {
+	var ranges [][2]net.IP
+	one := big.NewInt(1)
+	var first, last *big.Int
+	flush := func() error {
+		if first == nil {
+			return nil
+		}
+		n := len(nets[0].IP)
+		if !v6 || first.Cmp(last) == 0 {
+			r := [2]net.IP{bigToIP(first, n)}
+			if first.Cmp(last) != 0 {
+				r[1] = bigToIP(last, n)
+			}
+			ranges = append(ranges, r)
+			return nil
+		}
+		if size := new(big.Int).Sub(last, first); size.Cmp(big.NewInt(maxSyncExpand)) >= 0 {
+			return fmt.Errorf("%s-%s has too many IPv6 addresses", bigToIP(first, n), bigToIP(last, n))
+		}
+		for ip := new(big.Int).Set(first); ip.Cmp(last) <= 0; ip.Add(ip, one) {
+			ranges = append(ranges, [2]net.IP{bigToIP(ip, n)})
+		}
+		return nil
+	}
+
+	for _, n := range nets {
+		ones, _ := n.Mask.Size()
+		start := new(big.Int).SetBytes(n.IP)
+		end := new(big.Int).SetBytes(lastIP(n.IP, uint8(ones)))
+		if last != nil && new(big.Int).Add(last, one).Cmp(start) == 0 {
+			last = end
+			continue
+		}
+		if err := flush(); err != nil {
+			return nil, err
+		}
+		first, last = start, end
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return ranges, nil
+}
+
+// algebraNode is a network in the binary trie of the networks of both sets
+// of Combine, its children are the two halves.
+type algebraNode struct {
+	child [2]*algebraNode
+	// entry is noEntry, matchEntry or nomatchEntry for each set.
+	entry [2]int
+}
+
+func (n *algebraNode) insert(ip net.IP, cidr int, operand int, nomatch bool) {
+	for depth := 0; depth < cidr; depth++ {
+		bit := ip[depth/8] >> (7 - uint(depth%8)) & 1
+		if n.child[bit] == nil {
+			n.child[bit] = &algebraNode{}
+		}
+		n = n.child[bit]
+	}
+	if nomatch {
+		n.entry[operand] = nomatchEntry
+	} else if n.entry[operand] == noEntry {
+		n.entry[operand] = matchEntry
+	}
+}
+
+// walk applies op to the network n at ip/depth, in tells whether the sets
+// match around it. It reports whether the result has all of n, otherwise
+// it calls emit for the networks of the result within n.
+func (n *algebraNode) walk(ip net.IP, depth int, in [2]bool, op SetOp, emit func(ip net.IP, cidr int)) bool {
+	for i, entry := range n.entry {
+		switch entry {
+		case matchEntry:
+			in[i] = true
+		case nomatchEntry:
+			in[i] = false
+		}
+	}
+	value := op.apply(in[0], in[1])
+	if n.child[0] == nil && n.child[1] == nil {
+		return value
+	}
+
+	var halves [2]net.IP
+	var full [2]bool
+	for i, child := range n.child {
+		halves[i] = copyIP(ip)
+		if i == 1 {
+			halves[i][depth/8] |= 0x80 >> uint(depth%8)
+		}
+		full[i] = value
+		if child != nil {
+			full[i] = child.walk(halves[i], depth+1, in, op, emit)
+		}
+	}
+	if full[0] && full[1] {
+		return true
+	}
+	for i := range halves {
+		if full[i] {
+			emit(halves[i], depth+1)
+		}
+	}
+	return false
+}
@@ -0,0 +1,248 @@
+package goipset
+
+import (
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/JiHanHuang/goipset/ipsettest"
+	"golang.org/x/sys/unix"
+)
+
+func TestCombineNets(t *testing.T) {
+	tests := []struct {
+		op       SetOp
+		a, b     []string
+		expected []string
+	}{
+		{OpDifference, []string{"10.0.0.0/24"}, []string{"10.0.0.1"},
+			[]string{"10.0.0.0/32", "10.0.0.2/31", "10.0.0.4/30", "10.0.0.8/29", "10.0.0.16/28", "10.0.0.32/27", "10.0.0.64/26", "10.0.0.128/25"}},
+		{OpDifference, []string{"10.0.0.0/24"}, []string{"10.0.0.0/25"}, []string{"10.0.0.128/25"}},
+		{OpDifference, []string{"10.0.0.0/24"}, []string{"10.0.0.0/16"}, nil},
+		{OpUnion, []string{"10.0.0.0/25"}, []string{"10.0.0.128/25", "192.168.0.0/16"}, []string{"10.0.0.0/24", "192.168.0.0/16"}},
+		{OpIntersection, []string{"10.0.0.0/16"}, []string{"10.0.5.0/24", "10.1.0.0/24"}, []string{"10.0.5.0/24"}},
+		// The exceptions of a set are not in it.
+		{OpIntersection, []string{"10.0.0.0/24", "!10.0.0.0/25"}, []string{"10.0.0.0/16"}, []string{"10.0.0.128/25"}},
+		{OpDifference, []string{"10.0.0.0/23"}, []string{"10.0.0.0/22", "!10.0.1.0/24"}, []string{"10.0.1.0/24"}},
+		{OpUnion, []string{"0.0.0.0/1"}, []string{"128.0.0.0/1"}, []string{"0.0.0.0/1", "128.0.0.0/1"}},
+		{OpUnion, []string{"10.0.0.0/8", "2001:db8::/33"}, []string{"2001:db8:8000::/33"}, []string{"10.0.0.0/8", "2001:db8::/32"}},
+		{OpDifference, []string{"2001:db8::/32"}, []string{"10.0.0.0/8"}, []string{"2001:db8::/32"}},
+	}
+	for _, test := range tests {
+		a := GoIPSetResult{SetName: "a", Entries: netEntries(t, test.a...)}
+		b := GoIPSetResult{SetName: "b", Entries: netEntries(t, test.b...)}
+		result, err := Combine(test.op, a, b)
+		if err != nil {
+			t.Errorf("%s of %v and %v failed: %v", test.op, test.a, test.b, err)
+			continue
+		}
+		if got := netStrings(result); strings.Join(got, " ") != strings.Join(test.expected, " ") {
+			t.Errorf("expected the %s of %v and %v to be %v, got %v", test.op, test.a, test.b, test.expected, got)
+		}
+	}
+}
+
+func TestCombineAddresses(t *testing.T) {
+	ips := func(elems ...string) GoIPSetResult {
+		var result GoIPSetResult
+		for _, elem := range elems {
+			ip := strings.Split(elem, ",")[0]
+			set, err := ParseEntry("hash:ip,port", familyOf(ip), elem)
+			if ip == elem {
+				set, err = ParseEntry("hash:ip", familyOf(ip), elem)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			result.Entries = append(result.Entries, GoIPSetEntry{Set: set})
+		}
+		return result
+	}
+	tests := []struct {
+		op       SetOp
+		a, b     GoIPSetResult
+		expected string
+	}{
+		{OpUnion, ips("10.0.0.1", "10.0.0.2"), ips("10.0.0.3", "10.0.0.9"), "10.0.0.1-10.0.0.3 10.0.0.9"},
+		{OpDifference, ips("10.0.0.0-10.0.0.255"), ips("10.0.0.7"), "10.0.0.0-10.0.0.6 10.0.0.8-10.0.0.255"},
+		{OpIntersection, ips("2001:db8::1", "2001:db8::2", "2001:db8::3"), ips("2001:db8::2", "2001:db8::3"), "2001:db8::2 2001:db8::3"},
+		{OpDifference, ips("10.0.0.1,tcp:80", "10.0.0.1,udp:53"), ips("10.0.0.1,tcp:80-81"), "10.0.0.1,udp:53"},
+		{OpUnion, ips("10.0.0.1,tcp:80", "10.0.0.2,tcp:80"), ips("10.0.0.2,tcp:81"), "10.0.0.1-10.0.0.2,tcp:80 10.0.0.2,tcp:81"},
+		// A network makes a result of networks.
+		{OpUnion, ips("10.0.0.1"), GoIPSetResult{Entries: netEntries(t, "10.0.0.0")}, "10.0.0.0/31"},
+	}
+	for _, test := range tests {
+		result, err := Combine(test.op, test.a, test.b)
+		if err != nil {
+			t.Errorf("%s failed: %v", test.op, err)
+			continue
+		}
+		if got := strings.ToLower(strings.Join(netStrings(result), " ")); got != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.op, test.expected, got)
+		}
+	}
+
+	mac := func(s string) GoIPSetEntry {
+		hw, _ := net.ParseMAC(s)
+		return GoIPSetEntry{Set: &SetMac{MAC: hw}}
+	}
+	a := GoIPSetResult{SetName: "a", Entries: []GoIPSetEntry{mac("02:00:00:00:00:02"), mac("02:00:00:00:00:01")}}
+	b := GoIPSetResult{SetName: "b", Entries: []GoIPSetEntry{mac("02:00:00:00:00:02")}}
+	result, err := Union(a, b)
+	if err != nil || strings.Join(netStrings(result), " ") != "02:00:00:00:00:01 02:00:00:00:00:02" {
+		t.Errorf("expected the union of the MAC addresses, got %v %v", netStrings(result), err)
+	}
+	result, err = Difference(a, b)
+	if err != nil || strings.Join(netStrings(result), " ") != "02:00:00:00:00:01" {
+		t.Errorf("expected the difference of the MAC addresses, got %v %v", netStrings(result), err)
+	}
+
+	for _, b := range []GoIPSetResult{ips("10.0.0.1,tcp:80"), ips("10.0.0.1")} {
+		b.SetName = "b"
+		if _, err := Intersection(a, b); err == nil {
+			t.Errorf("expected MAC addresses and %v to fail", netStrings(b.Entries))
+		}
+	}
+	if _, err := Union(ips("10.0.0.1"), ips("10.0.0.1,tcp:80")); err == nil || !strings.Contains(err.Error(), "ports") {
+		t.Errorf("expected a set with ports and one without to fail, got %v", err)
+	}
+	if result, err := Intersection(a, GoIPSetResult{}); err != nil || len(result) != 0 {
+		t.Errorf("expected nothing in common with an empty set, got %v %v", result, err)
+	}
+}
+
+func TestCombineRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	randomNets := func() []GoIPSetEntry {
+		var entries []GoIPSetEntry
+		for i := r.Intn(8); i > 0; i-- {
+			cidr := uint8(24 + r.Intn(9))
+			ip := net.IPv4(10, 0, 0, byte(r.Intn(256))).Mask(net.CIDRMask(int(cidr), 32))
+			entries = append(entries, GoIPSetEntry{Set: &SetNet{IP: ip, CIDR: cidr}, NoMatch: r.Intn(3) == 0})
+		}
+		return entries
+	}
+	for round := 0; round < 300; round++ {
+		a, b := randomNets(), randomNets()
+		op := SetOp(r.Intn(3))
+		result, err := Combine(op, GoIPSetResult{Entries: a}, GoIPSetResult{Entries: b})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 256; i++ {
+			ip := net.IPv4(10, 0, 0, byte(i)).To4()
+			if expected := op.apply(lookup(a, ip), lookup(b, ip)); lookup(result, ip) != expected {
+				t.Fatalf("%s of %v and %v: expected %s to be %v in %v", op, netStrings(a), netStrings(b), ip, expected, netStrings(result))
+			}
+		}
+	}
+}
+
+func TestCombineSets(t *testing.T) {
+	ipset := NewGoIpsetWithTransport(ipsettest.NewKernel())
+	for name, nets := range map[string][]string{
+		"feed":  {"10.0.0.0/24", "192.168.0.0/24"},
+		"allow": {"10.0.0.0/25", "192.168.0.7"},
+	} {
+		if err := ipset.Create(name, "hash:net", GoIpsetCreateOptions{Timeout: 600}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ipset.Load(name, netEntries(t, nets...), LoadOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	listed := func(name string) string {
+		result, err := ipset.List(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var elems []string
+		for _, entry := range result.Entries {
+			elems = append(elems, entry.Set.String())
+		}
+		return strings.Join(elems, " ")
+	}
+
+	result, err := ipset.CombineSets(OpIntersection, "feed", "allow", CombineOptions{Into: "both"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(netStrings(result), " "); got != "10.0.0.0/25 192.168.0.7/32" {
+		t.Errorf("unexpected intersection %s", got)
+	}
+	if got := listed("both"); got != "10.0.0.0/25 192.168.0.7/32" {
+		t.Errorf("expected the intersection in both, got %s", got)
+	}
+	header, err := ipset.ListHeader("both")
+	if err != nil || header.TypeName != "hash:net" || header.Timeout != 600 {
+		t.Errorf("expected both to be created like feed, got %+v %v", header, err)
+	}
+
+	// An existing set is replaced.
+	if _, err := ipset.CombineSets(OpDifference, "feed", "allow", CombineOptions{Into: "both"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := listed("both"); !strings.HasPrefix(got, "10.0.0.128/25 192.168.0.0/30 ") {
+		t.Errorf("expected the difference in both, got %s", got)
+	}
+
+	if _, err := ipset.CombineSets(OpUnion, "feed", "missing", CombineOptions{}); err != unix.ENOENT {
+		t.Errorf("expected ENOENT, got %v", err)
+	}
+}
+
+func TestCombineSetsIntoAddresses(t *testing.T) {
+	ipset := NewGoIpsetWithTransport(ipsettest.NewKernel())
+	for name, typename := range map[string]string{"feed": "hash:ip", "allow": "hash:net"} {
+		if err := ipset.Create(name, typename, GoIpsetCreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	feed, err := ParseEntry("hash:ip", "", "10.0.0.1-10.0.0.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ipset.Add("feed", &GoIPSetEntry{Set: feed}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipset.Add("allow", &GoIPSetEntry{Set: &SetNet{IP: net.ParseIP("10.0.0.4"), CIDR: 30}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The feed without the allowlist is networks, which a hash:ip set
+	// only takes as addresses.
+	result, err := ipset.CombineSets(OpDifference, "feed", "allow", CombineOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(netStrings(result), " "); got != "10.0.0.1/32 10.0.0.2/31 10.0.0.8/31 10.0.0.10/32" {
+		t.Errorf("unexpected difference %s", got)
+	}
+	const expected = "10.0.0.1 10.0.0.2 10.0.0.3 10.0.0.8 10.0.0.9 10.0.0.10"
+	for _, into := range []string{"blocked", "feed"} {
+		result, err := ipset.CombineSets(OpDifference, "feed", "allow", CombineOptions{Into: into})
+		if err != nil {
+			t.Fatalf("%s: %v", into, err)
+		}
+		var elems []string
+		for _, entry := range result {
+			elems = append(elems, entry.Set.String())
+		}
+		if got := strings.Join(elems, " "); got != "10.0.0.1-10.0.0.3 10.0.0.8-10.0.0.10" {
+			t.Errorf("%s: unexpected result %s", into, got)
+		}
+		listed, err := ipset.List(into)
+		if err != nil {
+			t.Fatal(err)
+		}
+		elems = nil
+		for _, entry := range listed.Entries {
+			elems = append(elems, entry.Set.String())
+		}
+		if listed.TypeName != "hash:ip" || strings.Join(elems, " ") != expected {
+			t.Errorf("expected %s to be a hash:ip with %s, got %s with %v", into, expected, listed.TypeName, elems)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package goipset
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+)
+
+// RangeToCIDRs returns the fewest networks that together cover the
+// addresses from first to last, which are both IPv4 or both IPv6.
+func RangeToCIDRs(first, last net.IP) ([]*net.IPNet, error) {
+	if first.To16() == nil || last.To16() == nil || (first.To4() != nil) != (last.To4() != nil) {
+		return nil, fmt.Errorf("range %s-%s mixes IPv4 and IPv6", first, last)
+	}
+	bits := 128
+	first, last = first.To16(), last.To16()
+	if first.To4() != nil {
+		first, last = first.To4(), last.To4()
+		bits = 32
+	}
+	start := new(big.Int).SetBytes(first)
+	end := new(big.Int).SetBytes(last)
+	if start.Cmp(end) > 0 {
+		return nil, fmt.Errorf("range %s-%s ends before it starts", first, last)
+	}
+
+	var nets []*net.IPNet
+	one := big.NewInt(1)
+	for start.Cmp(end) <= 0 {
+		// The largest block aligned at start that does not go past end.
+		size := int(start.TrailingZeroBits())
+		if start.Sign() == 0 || size > bits {
+			size = bits
+		}
+		for ; size > 0; size-- {
+			blockEnd := new(big.Int).Lsh(one, uint(size))
+			blockEnd.Add(blockEnd, start).Sub(blockEnd, one)
+			if blockEnd.Cmp(end) <= 0 {
+				break
+			}
+		}
+		nets = append(nets, &net.IPNet{
+			IP:   bigToIP(start, len(first)),
+			Mask: net.CIDRMask(bits-size, bits),
+		})
+		start.Add(start, new(big.Int).Lsh(one, uint(size)))
+	}
+	return nets, nil
+}
+
+// bigToIP returns v as an address of n bytes.
+func bigToIP(v *big.Int, n int) net.IP {
+	ip := make(net.IP, n)
+	b := v.Bytes()
+	copy(ip[n-len(b):], b)
+	return ip
+}
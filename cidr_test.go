@@ -0,0 +1,104 @@
+package goipset
+
+import (
+	"net"
+	"testing"
+
+	"github.com/JiHanHuang/goipset/ipsettest"
+	"golang.org/x/sys/unix"
+)
+
+func TestRangeToCIDRs(t *testing.T) {
+	tests := []struct {
+		first, last string
+		expected    []string
+	}{
+		{"10.0.0.0", "10.0.0.255", []string{"10.0.0.0/24"}},
+		{"10.0.0.1", "10.0.0.1", []string{"10.0.0.1/32"}},
+		{"10.0.0.1", "10.0.0.6", []string{"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/31", "10.0.0.6/32"}},
+		{"0.0.0.0", "255.255.255.255", []string{"0.0.0.0/0"}},
+		{"255.255.255.254", "255.255.255.255", []string{"255.255.255.254/31"}},
+		{"2001:db8::", "2001:db8::ffff", []string{"2001:db8::/112"}},
+		{"2001:db8::1", "2001:db8::8", []string{"2001:db8::1/128", "2001:db8::2/127", "2001:db8::4/126", "2001:db8::8/128"}},
+		{"2001:db8::", "2001:db9::ffff", []string{"2001:db8::/32", "2001:db9::/112"}},
+		{"::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", []string{"::/0"}},
+	}
+	for _, test := range tests {
+		nets, err := RangeToCIDRs(net.ParseIP(test.first), net.ParseIP(test.last))
+		if err != nil {
+			t.Errorf("expanding %s-%s failed: %v", test.first, test.last, err)
+			continue
+		}
+		got := []string{}
+		for _, n := range nets {
+			got = append(got, n.String())
+		}
+		if len(got) != len(test.expected) {
+			t.Errorf("expected %s-%s to expand to %v, got %v", test.first, test.last, test.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.expected[i] {
+				t.Errorf("expected %s-%s to expand to %v, got %v", test.first, test.last, test.expected, got)
+				break
+			}
+		}
+	}
+
+	nets, err := RangeToCIDRs(net.ParseIP("::1"), net.ParseIP("ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe"))
+	if err != nil || len(nets) != 254 {
+		t.Errorf("expected the worst case to expand to 254 networks, got %d and %v", len(nets), err)
+	}
+
+	if _, err := RangeToCIDRs(net.ParseIP("10.0.0.1"), net.ParseIP("::1")); err == nil {
+		t.Errorf("expected a range mixing IPv4 and IPv6 to fail")
+	}
+	if _, err := RangeToCIDRs(net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.1")); err == nil {
+		t.Errorf("expected a backwards range to fail")
+	}
+}
+
+func TestAddIPv6Range(t *testing.T) {
+	recorder := ipsettest.NewRecorder(ipsettest.NewKernel())
+	ipset := NewGoIpsetWithTransport(recorder)
+
+	if err := ipset.Create("test", "hash:net", GoIpsetCreateOptions{Family: unix.AF_INET6, Comments: true}); err != nil {
+		t.Fatal(err)
+	}
+	set, err := ParseEntry("hash:net", "inet6", "2001:db8::1-2001:db8::8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ipset.Add("test", &GoIPSetEntry{Set: set, Comment: "partner"}); err != nil {
+		t.Fatal(err)
+	}
+	expectEntries(t, entryStrings(t, ipset, "test"),
+		"2001:db8::1/128", "2001:db8::2/127", "2001:db8::4/126", "2001:db8::8/128")
+
+	if err := ipset.Del("test", &GoIPSetEntry{Set: set}); err != nil {
+		t.Fatal(err)
+	}
+	expectEntries(t, entryStrings(t, ipset, "test"))
+
+	// 254 networks take two requests.
+	before := len(recorder.Exchanges())
+	wide := &SetNet{IP: net.ParseIP("::1"), IPTO: net.ParseIP("ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe")}
+	if err := ipset.Add("test", &GoIPSetEntry{Set: wide}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(recorder.Exchanges()) - before; n != 2 {
+		t.Errorf("expected 2 requests for 254 networks, got %d", n)
+	}
+	if n := len(entryStrings(t, ipset, "test")); n != 254 {
+		t.Errorf("expected 254 entries, got %d", n)
+	}
+
+	if err := ipset.Create("ports", "hash:net,port", GoIpsetCreateOptions{Family: unix.AF_INET6}); err != nil {
+		t.Fatal(err)
+	}
+	all := &SetNetPort{IP: net.ParseIP("::"), IPTO: net.ParseIP("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"), Port: 443, Proto: unix.IPPROTO_TCP}
+	if err := ipset.Add("ports", &GoIPSetEntry{Set: all}); err != nil {
+		t.Fatal(err)
+	}
+	expectEntries(t, entryStrings(t, ipset, "ports"), "::/1,TCP:443", "8000::/1,TCP:443")
+}
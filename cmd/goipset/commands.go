@@ -0,0 +1,286 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/JiHanHuang/goipset"
+	"golang.org/x/sys/unix"
+)
+
+func cmdCreate(c *cli, args []string) error {
+	if err := checkArgs(args, 2, len(args)); err != nil {
+		return err
+	}
+	options, err := goipset.ParseCreateOptions(args[2:])
+	if err != nil {
+		return paramErrorf("%v", err)
+	}
+	options.Replace = c.exist
+	return c.ipset.Create(args[0], args[1], options)
+}
+
+// parseEntry parses the entry and its options for the set setname.
+func (c *cli) parseEntry(setname string, args []string) (*goipset.GoIPSetEntry, error) {
+	header, err := c.ipset.Header(setname)
+	if err != nil {
+		return nil, err
+	}
+	family := ""
+	if header.Family == unix.AF_INET6 {
+		family = "inet6"
+	}
+	set, err := goipset.ParseEntry(header.TypeName, family, args[0])
+	if err != nil {
+		return nil, paramErrorf("%v", err)
+	}
+	entry := &goipset.GoIPSetEntry{Set: set, Replace: c.exist}
+	if err := goipset.ParseEntryOptions(entry, args[1:]); err != nil {
+		return nil, paramErrorf("%v", err)
+	}
+	return entry, nil
+}
+
+func cmdAddDel(cmdName string) func(*cli, []string) error {
+	return func(c *cli, args []string) error {
+		if err := checkArgs(args, 2, len(args)); err != nil {
+			return err
+		}
+		entry, err := c.parseEntry(args[0], args[1:])
+		if err != nil {
+			return err
+		}
+		if cmdName == "del" {
+			return c.ipset.Del(args[0], entry)
+		}
+		return c.ipset.Add(args[0], entry)
+	}
+}
+
+// cmdTest warns that the entry is in the set, or fails with exitError
+// when it is not, as ipset test does.
+func cmdTest(c *cli, args []string) error {
+	if err := checkArgs(args, 2, len(args)); err != nil {
+		return err
+	}
+	entry, err := c.parseEntry(args[0], args[1:])
+	if err != nil {
+		return err
+	}
+	found, err := c.ipset.Test(args[0], entry)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return &testError{fmt.Sprintf("%s is NOT in set %s.", args[1], args[0])}
+	}
+	fmt.Fprintf(c.stderr, "Warning: %s is in set %s.\n", args[1], args[0])
+	return nil
+}
+
+// cmdExplain prints the entry of the set that matches the address of the
+// entry, as looked up in userspace by goipset.LookupIndex, and whether the
+// address is in the set. As test, it fails with exitError when it is not.
+func cmdExplain(c *cli, args []string) error {
+	if err := checkArgs(args, 2, 2); err != nil {
+		return err
+	}
+	entry, err := c.parseEntry(args[0], args[1:])
+	if err != nil {
+		return err
+	}
+	result, err := c.ipset.List(args[0])
+	if err != nil {
+		return err
+	}
+	index, err := goipset.NewLookupIndex(result)
+	if err != nil {
+		return err
+	}
+	match, matched, err := index.LookupEntry(entry.Set)
+	if err != nil {
+		return paramErrorf("%v", err)
+	}
+
+	in := "is in"
+	if !matched {
+		in = "is NOT in"
+	}
+	because := "it matches no entry"
+	if match != nil {
+		text, err := match.MarshalText()
+		if err != nil {
+			return err
+		}
+		because = "it matches " + string(text)
+	}
+	fmt.Fprintf(c.stdout, "%s %s set %s, %s.\n", args[1], in, args[0], because)
+	if !matched {
+		return &statusError{exitError}
+	}
+	return nil
+}
+
+func cmdDestroy(c *cli, args []string) error {
+	if err := checkArgs(args, 0, 1); err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return c.ipset.DestroyAll()
+	}
+	return c.ipset.Destroy(args[0])
+}
+
+func cmdFlush(c *cli, args []string) error {
+	if err := checkArgs(args, 0, 1); err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return c.ipset.FlushAll()
+	}
+	return c.ipset.Flush(args[0])
+}
+
+func cmdRename(c *cli, args []string) error {
+	if err := checkArgs(args, 2, 2); err != nil {
+		return err
+	}
+	return c.ipset.Rename(args[0], args[1])
+}
+
+func cmdSwap(c *cli, args []string) error {
+	if err := checkArgs(args, 2, 2); err != nil {
+		return err
+	}
+	return c.ipset.Swap(args[0], args[1])
+}
+
+func cmdList(c *cli, args []string) error {
+	return c.list(args, "plain")
+}
+
+func cmdSave(c *cli, args []string) error {
+	return c.list(args, "save")
+}
+
+// list prints the set of args, or all sets, in the mode of -output or else
+// in mode.
+func (c *cli) list(args []string, mode string) error {
+	if err := checkArgs(args, 0, 1); err != nil {
+		return err
+	}
+	if c.mode != "" {
+		mode = c.mode
+	}
+
+	var sets []goipset.GoIPSetResult
+	var err error
+	switch {
+	case c.listName && len(args) == 1:
+		// Like ipset, fail for a set that does not exist.
+		_, err = c.ipset.Header(args[0])
+		sets = []goipset.GoIPSetResult{{SetName: args[0]}}
+	case c.listName:
+		var names []string
+		names, err = c.ipset.ListNames()
+		for _, name := range names {
+			sets = append(sets, goipset.GoIPSetResult{SetName: name})
+		}
+	case c.terse && len(args) == 1:
+		var set goipset.GoIPSetResult
+		set, err = c.ipset.ListHeader(args[0])
+		sets = []goipset.GoIPSetResult{set}
+	case c.terse:
+		sets, err = c.ipset.ListAllHeaders()
+	case len(args) == 1:
+		var set goipset.GoIPSetResult
+		set, err = c.ipset.List(args[0])
+		sets = []goipset.GoIPSetResult{set}
+	default:
+		sets, err = c.ipset.ListAll()
+	}
+	if err != nil {
+		return err
+	}
+
+	w, err := c.output()
+	if err != nil {
+		return err
+	}
+	switch mode {
+	case "save":
+		err = goipset.WriteSave(w, sets...)
+	case "xml":
+		err = goipset.WriteXML(w, sets...)
+	case "json":
+		var v interface{} = sets
+		if len(args) == 1 {
+			v = sets[0]
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(v)
+	default:
+		err = goipset.WriteList(w, goipset.ListOptions{
+			Terse:   c.terse,
+			Name:    c.listName,
+			Sorted:  c.sorted,
+			Resolve: c.resolve,
+		}, sets...)
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// cmdRestore restores the commands of -file or stdin.
+func cmdRestore(c *cli, args []string) error {
+	if err := checkArgs(args, 0, 0); err != nil {
+		return err
+	}
+	if c.inShell && c.file == "" {
+		return paramErrorf("In the shell restore reads -file only")
+	}
+	r, err := c.input()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return c.ipset.Restore(r, goipset.RestoreOptions{Exist: c.exist})
+}
+
+// cmdCombine prints the entries of op applied to two sets, one per line, or
+// stores them in a third set, see goipset.Combine.
+func cmdCombine(op goipset.SetOp) func(*cli, []string) error {
+	return func(c *cli, args []string) error {
+		if err := checkArgs(args, 2, 3); err != nil {
+			return err
+		}
+		var options goipset.CombineOptions
+		if len(args) == 3 {
+			options.Into = args[2]
+		}
+		entries, err := c.ipset.CombineSets(op, args[0], args[1], options)
+		if err != nil || options.Into != "" {
+			return err
+		}
+
+		w, err := c.output()
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if _, err = fmt.Fprintln(w, entry.Set); err != nil {
+				break
+			}
+		}
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+}
@@ -0,0 +1,82 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/JiHanHuang/goipset"
+	"github.com/JiHanHuang/goipset/nl"
+)
+
+// commandMessages are the messages of ipset for the errors of a command,
+// those of "" are for the errors of any command.
+var commandMessages = map[string]map[error]string{
+	"": {
+		syscall.ENOENT:                             "The set with the given name does not exist",
+		syscall.EPERM:                              "Kernel error received: Operation not permitted",
+		nl.IPSetError(nl.IPSET_ERR_PROTOCOL):       "Kernel error received: ipset protocol error",
+		nl.IPSetError(nl.IPSET_ERR_TYPE_MISMATCH):  "The sets cannot be swapped: their type does not match",
+		nl.IPSetError(nl.IPSET_ERR_TIMEOUT):        "Timeout cannot be used: set was created without timeout support",
+		nl.IPSetError(nl.IPSET_ERR_COUNTER):        "Packet/byte counters cannot be used: set was created without counter support",
+		nl.IPSetError(nl.IPSET_ERR_COMMENT):        "Comment cannot be used: set was created without comment support",
+		nl.IPSetError(nl.IPSET_ERR_SKBINFO):        "Skbinfo mapping cannot be used: set was created without skbinfo support",
+		nl.IPSetError(nl.IPSET_ERR_HASH_FULL):      "Hash is full, cannot add more elements",
+		nl.IPSetError(nl.IPSET_ERR_INVALID_PROTO):  "Invalid protocol specified",
+		nl.IPSetError(nl.IPSET_ERR_MISSING_PROTO):  "Protocol must be specified",
+		nl.IPSetError(nl.IPSET_ERR_INVALID_CIDR):   "The value of the CIDR parameter of the IP address is invalid",
+		nl.IPSetError(nl.IPSET_ERR_INVALID_FAMILY): "Protocol family not supported by the set type",
+		nl.IPSetError(nl.IPSET_ERR_IPADDR_IPV4):    "An IPv4 address is expected",
+		nl.IPSetError(nl.IPSET_ERR_IPADDR_IPV6):    "An IPv6 address is expected",
+	},
+	"create": {
+		syscall.EEXIST:                        "Set cannot be created: set with the same name already exists",
+		nl.IPSetError(nl.IPSET_ERR_FIND_TYPE): "Kernel error received: set type not supported",
+		nl.IPSetError(nl.IPSET_ERR_MAX_SETS):  "Kernel error received: maximal number of sets reached, cannot create more",
+	},
+	"destroy": {
+		nl.IPSetError(nl.IPSET_ERR_BUSY):       "Set cannot be destroyed: it is in use by a kernel component",
+		nl.IPSetError(nl.IPSET_ERR_REFERENCED): "Set cannot be destroyed: it is in use by a kernel component",
+	},
+	"rename": {
+		nl.IPSetError(nl.IPSET_ERR_EXIST_SETNAME2): "Set cannot be renamed: a set with the new name already exists",
+		nl.IPSetError(nl.IPSET_ERR_REFERENCED):     "Set cannot be renamed: it is in use by another system",
+	},
+	"swap": {
+		nl.IPSetError(nl.IPSET_ERR_EXIST_SETNAME2): "The second set does not exist",
+	},
+	"add": {
+		nl.IPSetError(nl.IPSET_ERR_EXIST): "Element cannot be added to the set: it's already added",
+	},
+	"del": {
+		nl.IPSetError(nl.IPSET_ERR_EXIST): "Element cannot be deleted from the set: it's not added",
+	},
+}
+
+// message returns the message ipset prints for an error of cmdName.
+func message(cmdName string, err error) string {
+	if restoreErr, ok := err.(*goipset.RestoreError); ok {
+		return fmt.Sprintf("Error in line %d: %s", restoreErr.Line, message("", restoreErr.Err))
+	}
+	var code error
+	var ipsetErr nl.IPSetError
+	var errno syscall.Errno
+	switch {
+	case errors.As(err, &ipsetErr):
+		code = ipsetErr
+	case errors.As(err, &errno):
+		code = errno
+	default:
+		return err.Error()
+	}
+	if msg, ok := commandMessages[cmdName][code]; ok {
+		return msg
+	}
+	if msg, ok := commandMessages[""][code]; ok {
+		return msg
+	}
+	return fmt.Sprintf("Kernel error received: %v", err)
+}
@@ -0,0 +1,401 @@
+//go:build linux
+// +build linux
+
+// Command goipset is a client of the goipset library with the command line
+// of ipset:
+//
+//	goipset [OPTIONS] COMMAND [COMMAND-OPTIONS]
+//
+// It takes the commands, options, keywords and exit codes of ipset, so that
+// scripts can call it instead of ipset. See goipset help.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/JiHanHuang/goipset"
+)
+
+// The exit codes of ipset.
+const (
+	exitOK        = 0
+	exitError     = 1 // the command failed, or test did not find the entry
+	exitParameter = 2 // the command line is invalid
+)
+
+type command struct {
+	Function    func(c *cli, args []string) error
+	Usage       string
+	Description string
+}
+
+var commands = map[string]command{
+	"create":  {cmdCreate, "SETNAME TYPENAME [CREATE-OPTIONS]", "Create a new set"},
+	"add":     {cmdAddDel("add"), "SETNAME ENTRY [ADD-OPTIONS]", "Add a new entry to the set"},
+	"del":     {cmdAddDel("del"), "SETNAME ENTRY", "Delete an entry from the set"},
+	"test":    {cmdTest, "SETNAME ENTRY", "Test an entry in a set"},
+	"destroy": {cmdDestroy, "[SETNAME]", "Destroy a named set or all sets"},
+	"list":    {cmdList, "[SETNAME]", "List the entries of a named set or all sets"},
+	"save":    {cmdSave, "[SETNAME]", "Save the named set or all sets"},
+	"restore": {cmdRestore, "", "Restore a saved state"},
+	"flush":   {cmdFlush, "[SETNAME]", "Flush a named set or all sets"},
+	"rename":  {cmdRename, "FROM-SETNAME TO-SETNAME", "Rename two sets"},
+	"swap":    {cmdSwap, "FROM-SETNAME TO-SETNAME", "Swap the contents of two existing sets"},
+	"version": {cmdVersion, "", "Print version information"},
+
+	"union":        {cmdCombine(goipset.OpUnion), "SETNAME-A SETNAME-B [TO-SETNAME]", "Print or store what is in either set"},
+	"intersection": {cmdCombine(goipset.OpIntersection), "SETNAME-A SETNAME-B [TO-SETNAME]", "Print or store what is in both sets"},
+	"difference":   {cmdCombine(goipset.OpDifference), "SETNAME-A SETNAME-B [TO-SETNAME]", "Print or store what is in set A but not in B"},
+	"explain":      {cmdExplain, "SETNAME ENTRY", "Print the entry that decides whether ENTRY is in the set"},
+}
+
+func init() {
+	// help and the shell look up the commands, so they can't be in their
+	// initializer.
+	commands["help"] = command{cmdHelp, "[TYPENAME]", "Print help, and settype specific help"}
+	commands["shell"] = command{cmdShell, "[--batch [--continue]]", "Run the commands of stdin or -file, interactively on a terminal"}
+	commands["-"] = command{cmdShell, "[--batch [--continue]]", "The same as shell"}
+}
+
+// commandAliases are the short forms of the commands.
+var commandAliases = map[string]string{
+	"-N": "create",
+	"-A": "add",
+	"-D": "del",
+	"-T": "test",
+	"-X": "destroy",
+	"-L": "list",
+	"-S": "save",
+	"-R": "restore",
+	"-F": "flush",
+	"-E": "rename",
+	"-W": "swap",
+	"-H": "help",
+	"-V": "version",
+}
+
+// cli runs a command line against ipset, with the global options of
+// ipset, which may come before or after the command.
+type cli struct {
+	ipset  *goipset.GoIpset
+	name   string // of the program, for the messages
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+
+	exist    bool
+	quiet    bool
+	resolve  bool
+	sorted   bool
+	listName bool
+	terse    bool
+	mode     string // of -output: plain, save, xml or json
+	file     string
+
+	shareSocket bool // whether the shell keeps one netlink socket open
+	inShell     bool // whether the command is a line of the shell
+}
+
+// paramError is an invalid command line.
+type paramError struct {
+	msg string
+}
+
+func (e *paramError) Error() string {
+	return e.msg
+}
+
+func paramErrorf(format string, args ...interface{}) error {
+	return &paramError{fmt.Sprintf(format, args...)}
+}
+
+// testError is the exit status of a test that did not find the entry.
+type testError struct {
+	msg string
+}
+
+func (e *testError) Error() string {
+	return e.msg
+}
+
+// statusError is the exit code of a command that reported its errors
+// itself.
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+func main() {
+	c := &cli{
+		ipset:       goipset.NewGoIpset(),
+		name:        filepath.Base(os.Args[0]),
+		stdin:       os.Stdin,
+		stdout:      os.Stdout,
+		stderr:      os.Stderr,
+		shareSocket: true,
+	}
+	os.Exit(c.run(os.Args[1:]))
+}
+
+// run runs a command line and returns the exit code.
+func (c *cli) run(args []string) int {
+	cmdName, err := c.execute(args)
+	return c.report(cmdName, err, 0)
+}
+
+// execute runs a command line and returns its command and error.
+func (c *cli) execute(args []string) (string, error) {
+	cmdName, args, err := c.parseArgs(args)
+	if err != nil {
+		return cmdName, err
+	}
+	if c.quiet {
+		c.stderr = ioutil.Discard
+	}
+	if cmdName == "" {
+		return "", paramErrorf("No command specified.")
+	}
+	return cmdName, commands[cmdName].Function(c, args)
+}
+
+// report prints the error of a command as ipset does and returns the exit
+// code. The error of the line lineno of the shell, if not 0, says so.
+func (c *cli) report(cmdName string, err error, lineno int) int {
+	var msg string
+	code := exitError
+	switch e := err.(type) {
+	case nil:
+		return exitOK
+	case *statusError:
+		return e.code
+	case *testError:
+		fmt.Fprintln(c.stderr, err)
+		return exitError
+	case *paramError:
+		msg = err.Error()
+		code = exitParameter
+	default:
+		msg = message(cmdName, err)
+	}
+	if lineno > 0 {
+		msg = fmt.Sprintf("Error in line %d: %s", lineno, msg)
+	}
+	fmt.Fprintf(c.stderr, "%s: %s\n", c.name, msg)
+	if code == exitParameter && lineno == 0 {
+		fmt.Fprintf(c.stderr, "Try `%s help' for more information.\n", c.name)
+	}
+	return code
+}
+
+// parseArgs takes the global options out of args and returns the command
+// and its arguments.
+func (c *cli) parseArgs(args []string) (string, []string, error) {
+	var cmdName string
+	var cmdArgs []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		value := func() (string, error) {
+			if i+1 == len(args) {
+				return "", paramErrorf("Missing mandatory argument of option `%s'", arg)
+			}
+			i++
+			return args[i], nil
+		}
+
+		var err error
+		switch arg {
+		case "-exist", "-!":
+			c.exist = true
+		case "-quiet", "-q":
+			c.quiet = true
+		case "-resolve", "-r":
+			c.resolve = true
+		case "-sorted", "-s":
+			c.sorted = true
+		case "-name", "-n":
+			c.listName = true
+		case "-terse", "-t":
+			c.terse = true
+		case "-debug":
+			goipset.Debug = true
+		case "-output", "-o":
+			c.mode, err = value()
+			if err == nil && c.mode != "plain" && c.mode != "save" && c.mode != "xml" && c.mode != "json" {
+				err = paramErrorf("Syntax error: unknown output mode '%s'", c.mode)
+			}
+		case "-file", "-f":
+			c.file, err = value()
+		default:
+			if cmdName != "" {
+				cmdArgs = append(cmdArgs, arg)
+				continue
+			}
+			if long, ok := commandAliases[arg]; ok {
+				arg = long
+			}
+			if _, ok := commands[arg]; !ok {
+				if strings.HasPrefix(arg, "-") && arg != "-" {
+					return "", nil, paramErrorf("Unknown argument: `%s'", arg)
+				}
+				return "", nil, paramErrorf("No command specified: unknown argument %s", arg)
+			}
+			cmdName = arg
+		}
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	return cmdName, cmdArgs, nil
+}
+
+// checkArgs checks the number of arguments of a command.
+func checkArgs(args []string, min, max int) error {
+	switch {
+	case len(args) < min && min-len(args) == 1 && min > 1:
+		return paramErrorf("Missing second mandatory argument to command")
+	case len(args) < min:
+		return paramErrorf("Missing mandatory argument to command")
+	case len(args) > max:
+		return paramErrorf("Unknown argument: `%s'", args[max])
+	}
+	return nil
+}
+
+// input opens the file of -file, or returns stdin.
+func (c *cli) input() (io.ReadCloser, error) {
+	if c.file == "" {
+		return ioutil.NopCloser(c.stdin), nil
+	}
+	return os.Open(c.file)
+}
+
+// output creates the file of -file, or returns stdout.
+func (c *cli) output() (io.WriteCloser, error) {
+	if c.file == "" {
+		return nopWriteCloser{c.stdout}, nil
+	}
+	return os.Create(c.file)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func cmdHelp(c *cli, args []string) error {
+	if err := checkArgs(args, 0, 1); err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		return c.typeHelp(args[0])
+	}
+
+	w := bufio.NewWriter(c.stdout)
+	fmt.Fprintf(w, "%s, an ipset compatible client of goipset\n\n", c.name)
+	fmt.Fprintf(w, "Usage: %s [options] COMMAND\n\nCommands:\n", c.name)
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd := commands[name]
+		fmt.Fprintf(w, "%-12s %-33s %s\n", name, cmd.Usage, cmd.Description)
+	}
+	fmt.Fprint(w, `
+Options:
+-o plain|save|xml|json  Specify output mode for listing sets
+                        (default plain, save for the save command)
+-exist, -!              Ignore errors when exactly the same set is to be
+                        created or already added entry is added or missing
+                        entry is deleted
+-quiet, -q              Suppress any notice or warning message
+-resolve, -r            Try to resolve IP addresses in the output (slow!)
+-sorted, -s             Print the entries sorted
+-name, -n               List just the names of the existing sets
+-terse, -t              List the set names and headers
+-file, -f filename      Read from the given file instead of standard input
+                        (restore) or print into it (list, save)
+-debug                  Print the netlink messages
+
+Type '`+c.name+` help TYPENAME' for help on the specific set type.
+`)
+	return w.Flush()
+}
+
+// typeHelp prints what a set type takes.
+func (c *cli) typeHelp(typename string) error {
+	setType, ok := goipset.LookupSetType(typename)
+	if !ok {
+		return fmt.Errorf("Syntax error: typename '%s' is unknown", typename)
+	}
+	var dims []string
+	for _, dim := range setType.Dimensions {
+		dims = append(dims, dimensionUsage[dim])
+	}
+	fmt.Fprintf(c.stdout, "%s type specific options:\n\n", setType.Name)
+	fmt.Fprintf(c.stdout, "CREATE-OPTIONS := [family inet|inet6] [hashsize N] [maxelem N] [bucketsize N] [initval N]\n")
+	fmt.Fprintf(c.stdout, "                  %s\n", setOptions(setType.CreateOptions, true))
+	fmt.Fprintf(c.stdout, "ADD-OPTIONS := %s\n", setOptions(setType.EntryOptions, false))
+	fmt.Fprintf(c.stdout, "ENTRY := %s\n", strings.Join(dims, ","))
+	return nil
+}
+
+// dimensionUsage is how the entries of typeHelp write the dimensions.
+var dimensionUsage = map[goipset.Dimension]string{
+	goipset.DimIP:   "IP",
+	goipset.DimNet:  "IP[/CIDR]",
+	goipset.DimPort: "[PROTO:]PORT",
+	goipset.DimMAC:  "MAC",
+}
+
+// setOptions returns the keywords of the create or the entry options.
+func setOptions(options goipset.SetOption, create bool) string {
+	var keywords []string
+	for _, o := range []struct {
+		option      goipset.SetOption
+		create, add string
+	}{
+		{goipset.OptionTimeout, "[timeout VALUE]", "[timeout VALUE]"},
+		{goipset.OptionCounters, "[counters]", "[packets VALUE] [bytes VALUE]"},
+		{goipset.OptionComment, "[comment]", "[comment \"string\"]"},
+		{goipset.OptionSkbinfo, "[skbinfo]", "[skbmark VALUE] [skbprio VALUE] [skbqueue VALUE]"},
+		{goipset.OptionNomatch, "", "[nomatch]"},
+		{goipset.OptionForceAdd, "[forceadd]", ""},
+		{goipset.OptionNetMask, "[netmask CIDR]", ""},
+	} {
+		keyword := o.add
+		if create {
+			keyword = o.create
+		}
+		if options&o.option != 0 && keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+	return strings.Join(keywords, " ")
+}
+
+func cmdVersion(c *cli, args []string) error {
+	if err := checkArgs(args, 0, 0); err != nil {
+		return err
+	}
+	protocol, err := c.ipset.Protocol()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "%s, protocol version: %d\n", c.name, protocol)
+	return nil
+}
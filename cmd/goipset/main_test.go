@@ -0,0 +1,116 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/JiHanHuang/goipset"
+	"github.com/JiHanHuang/goipset/ipsettest"
+)
+
+func newTestCLI(stdin string) (*cli, *bytes.Buffer, *bytes.Buffer) {
+	var stdout, stderr bytes.Buffer
+	return &cli{
+		ipset:  goipset.NewGoIpsetWithTransport(ipsettest.NewKernel()),
+		name:   "ipset",
+		stdin:  strings.NewReader(stdin),
+		stdout: &stdout,
+		stderr: &stderr,
+	}, &stdout, &stderr
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{[]string{"create", "a", "hash:ip", "timeout", "60", "comment"}, exitOK, "", ""},
+		{[]string{"create", "a", "hash:ip"}, exitError, "", "ipset: Set cannot be created: set with the same name already exists\n"},
+		{[]string{"-exist", "create", "a", "hash:ip", "timeout", "60", "comment"}, exitOK, "", ""},
+		{[]string{"-A", "a", "10.0.0.1", "timeout", "30", "comment", "a host"}, exitOK, "", ""},
+		{[]string{"add", "a", "10.0.0.1"}, exitError, "", "ipset: Element cannot be added to the set: it's already added\n"},
+		{[]string{"add", "a", "10.0.0.1", "-exist"}, exitOK, "", ""},
+		{[]string{"test", "a", "10.0.0.1"}, exitOK, "", "Warning: 10.0.0.1 is in set a.\n"},
+		{[]string{"test", "a", "10.0.0.2"}, exitError, "", "10.0.0.2 is NOT in set a.\n"},
+		{[]string{"-q", "test", "a", "10.0.0.2"}, exitError, "", ""},
+		{[]string{"del", "a", "10.0.0.2"}, exitError, "", "ipset: Element cannot be deleted from the set: it's not added\n"},
+		{[]string{"add", "missing", "10.0.0.1"}, exitError, "", "ipset: The set with the given name does not exist\n"},
+		{[]string{"save", "a"}, exitOK, "create a hash:ip family inet hashsize 1024 maxelem 65536 timeout 60 comment bucketsize 12 initval 0xe40c292c\nadd a 10.0.0.1 timeout 60 comment \"a host\"\n", ""},
+		{[]string{"list", "-n"}, exitOK, "a\n", ""},
+		{[]string{"-N", "c", "hash:ip"}, exitOK, "", ""},
+		{[]string{"add", "c", "10.0.0.2"}, exitOK, "", ""},
+		{[]string{"union", "a", "c"}, exitOK, "10.0.0.1-10.0.0.2\n", ""},
+		{[]string{"difference", "a", "c", "d"}, exitOK, "", ""},
+		{[]string{"-o", "save", "list", "d"}, exitOK, "create d hash:ip family inet hashsize 1024 maxelem 65536 timeout 60 comment bucketsize 12 initval 0xe40c292c\nadd d 10.0.0.1 timeout 60\n", ""},
+		{[]string{"-X", "c"}, exitOK, "", ""},
+		{[]string{"-X", "d"}, exitOK, "", ""},
+		{[]string{"create", "n", "hash:net"}, exitOK, "", ""},
+		{[]string{"add", "n", "10.0.0.0/8"}, exitOK, "", ""},
+		{[]string{"add", "n", "10.1.0.0/16", "nomatch"}, exitOK, "", ""},
+		{[]string{"explain", "n", "10.2.0.1"}, exitOK, "10.2.0.1 is in set n, it matches 10.0.0.0/8.\n", ""},
+		{[]string{"explain", "n", "10.1.0.1"}, exitError, "10.1.0.1 is NOT in set n, it matches 10.1.0.0/16 nomatch.\n", ""},
+		{[]string{"explain", "n", "11.0.0.1"}, exitError, "11.0.0.1 is NOT in set n, it matches no entry.\n", ""},
+		{[]string{"explain", "n", "10.0.0.0/24"}, exitParameter, "", "ipset: 10.0.0.0/24 is not a single address\nTry `ipset help' for more information.\n"},
+		{[]string{"-X", "n"}, exitOK, "", ""},
+		{[]string{"rename", "a", "b"}, exitOK, "", ""},
+		{[]string{"-X"}, exitOK, "", ""},
+		{[]string{"list", "-n"}, exitOK, "", ""},
+		{nil, exitParameter, "", "ipset: No command specified.\nTry `ipset help' for more information.\n"},
+		{[]string{"bogus"}, exitParameter, "", "ipset: No command specified: unknown argument bogus\nTry `ipset help' for more information.\n"},
+		{[]string{"-bogus"}, exitParameter, "", "ipset: Unknown argument: `-bogus'\nTry `ipset help' for more information.\n"},
+		{[]string{"create", "a"}, exitParameter, "", "ipset: Missing second mandatory argument to command\nTry `ipset help' for more information.\n"},
+		{[]string{"flush", "a", "b"}, exitParameter, "", "ipset: Unknown argument: `b'\nTry `ipset help' for more information.\n"},
+		{[]string{"list", "-o", "yaml"}, exitParameter, "", "ipset: Syntax error: unknown output mode 'yaml'\nTry `ipset help' for more information.\n"},
+	}
+	c, _, _ := newTestCLI("")
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		c.stdout, c.stderr = &stdout, &stderr
+		c.exist, c.quiet, c.listName = false, false, false
+		code := c.run(test.args)
+		if code != test.code || stdout.String() != test.stdout || stderr.String() != test.stderr {
+			t.Errorf("%s: expected %d %q %q, got %d %q %q", test.args, test.code, test.stdout, test.stderr,
+				code, stdout.String(), stderr.String())
+		}
+	}
+}
+
+func TestRunRestore(t *testing.T) {
+	input := "create a hash:net\nadd a 10.0.0.0/8\nadd a 10.1.0.0/16 nomatch\nadd a 10.0.0.0/8\n"
+	c, _, stderr := newTestCLI(input)
+	if code := c.run([]string{"restore"}); code != exitError {
+		t.Errorf("expected %d, got %d", exitError, code)
+	}
+	if expected := "ipset: Error in line 4: Kernel error received: exist\n"; stderr.String() != expected {
+		t.Errorf("expected %q, got %q", expected, stderr.String())
+	}
+
+	for _, test := range []struct {
+		elem string
+		code int
+	}{
+		{"10.2.0.1", exitOK},
+		{"10.1.0.1", exitError},
+	} {
+		if code := c.run([]string{"test", "a", test.elem}); code != test.code {
+			t.Errorf("test %s: expected %d, got %d", test.elem, test.code, code)
+		}
+	}
+
+	c, stdout, _ := newTestCLI(input)
+	if code := c.run([]string{"-exist", "restore"}); code != exitOK {
+		t.Errorf("expected -exist to restore, got %d", code)
+	}
+	if code := c.run([]string{"list", "a", "-o", "save"}); code != exitOK {
+		t.Errorf("expected list to succeed, got %d", code)
+	}
+	if !strings.Contains(stdout.String(), "add a 10.1.0.0/16 nomatch\n") {
+		t.Errorf("expected the nomatch entry, got %q", stdout.String())
+	}
+}
@@ -0,0 +1,211 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/JiHanHuang/goipset"
+)
+
+// The prompt of the interactive shell.
+const prompt = "goipset> "
+
+// cmdShell runs command lines, one per line of -file or stdin, over one
+// netlink socket. On a terminal it prompts for them with completion,
+// otherwise, or with --batch, it runs them as a batch: it stops at the
+// first error, or with --continue goes on and fails at the end, and
+// reports the errors with their line numbers.
+//
+// The lines are those of the command line without the program name, so
+// the output of save runs as well. The global options of the shell apply
+// to every line, those of a line to the line only.
+func cmdShell(c *cli, args []string) error {
+	if c.inShell {
+		return paramErrorf("The shell can't be started in the shell")
+	}
+	batch, cont := false, false
+	for _, arg := range args {
+		switch arg {
+		case "--batch":
+			batch = true
+		case "--continue":
+			cont = true
+		default:
+			return paramErrorf("Unknown argument: `%s'", arg)
+		}
+	}
+	if cont && !batch {
+		return paramErrorf("--continue needs --batch")
+	}
+
+	r, err := c.input()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if c.shareSocket {
+		transport, err := goipset.NewSocketTransport()
+		if err != nil {
+			return err
+		}
+		defer transport.Close()
+		c.ipset = goipset.NewGoIpsetWithTransport(transport)
+	}
+
+	if f, ok := c.stdin.(*os.File); ok && c.file == "" && !batch && isTerminal(f) {
+		return c.interactive(f)
+	}
+	return c.batch(r, cont)
+}
+
+// line runs a line of the shell, it reports whether the shell should quit.
+func (c *cli) line(text string) (cmdName string, quit bool, err error) {
+	args, err := goipset.SplitFields(text)
+	if err != nil {
+		return "", false, paramErrorf("%v", err)
+	}
+	if len(args) == 0 || strings.HasPrefix(args[0], "#") || args[0] == "COMMIT" {
+		return "", false, nil
+	}
+	if args[0] == "quit" || args[0] == "exit" {
+		return "", true, nil
+	}
+
+	// The line gets the options of the shell, not those of the lines
+	// before it, and writes to stdout even if the shell reads -file.
+	lc := *c
+	lc.file = ""
+	lc.inShell = true
+	cmdName, err = lc.execute(args)
+	return cmdName, false, err
+}
+
+// batch runs the lines of r.
+func (c *cli) batch(r io.Reader, cont bool) error {
+	code := exitOK
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		cmdName, quit, err := c.line(scanner.Text())
+		if quit {
+			break
+		}
+		if err == nil {
+			continue
+		}
+		if lineCode := c.report(cmdName, err, lineno); code == exitOK {
+			code = lineCode
+		}
+		if !cont {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if code != exitOK {
+		return &statusError{code}
+	}
+	return nil
+}
+
+// interactive prompts for the lines on the terminal f until quit or the
+// end of the input.
+func (c *cli) interactive(f *os.File) error {
+	restore, err := makeRaw(f)
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	editor := newEditor(f, c.stdout, c.complete)
+	for {
+		text, err := editor.readLine(prompt)
+		if err == io.EOF {
+			fmt.Fprintln(c.stdout)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		cmdName, quit, err := c.line(text)
+		if quit {
+			return nil
+		}
+		c.report(cmdName, err, 0)
+	}
+}
+
+// complete returns the words that complete the last word of line: the
+// commands, the set names or the set types, depending on where it is.
+func (c *cli) complete(line string) []string {
+	words := strings.Fields(line)
+	prefix := ""
+	if len(words) > 0 && !strings.HasSuffix(line, " ") {
+		prefix = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+
+	// The command and its arguments, without the global options.
+	var cmdName string
+	var args []string
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		switch {
+		case cmdName == "" && commandAliases[word] != "":
+			cmdName = commandAliases[word]
+		case word == "-o" || word == "-output" || word == "-f" || word == "-file":
+			i++
+		case strings.HasPrefix(word, "-") && word != "-":
+		case cmdName == "":
+			cmdName = word
+		default:
+			args = append(args, word)
+		}
+	}
+
+	var candidates []string
+	switch {
+	case cmdName == "":
+		for name := range commands {
+			if name != "shell" && name != "-" {
+				candidates = append(candidates, name)
+			}
+		}
+		candidates = append(candidates, "quit")
+	case cmdName == "create" && len(args) == 1, cmdName == "help" && len(args) == 0:
+		for _, setType := range goipset.SetTypes() {
+			candidates = append(candidates, setType.Name)
+		}
+	case cmdName == "create" && len(args) >= 2:
+		candidates = createKeywords
+	case cmdName == "add" && len(args) >= 2:
+		candidates = addKeywords
+	case len(args) == 0 && cmdName != "create" && cmdName != "help" && cmdName != "version",
+		len(args) == 1 && cmdName == "swap",
+		len(args) <= 2 && (cmdName == "union" || cmdName == "intersection" || cmdName == "difference"):
+		candidates, _ = c.ipset.ListNames()
+	}
+
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) {
+			matches = append(matches, candidate)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// The keywords the options of create and add complete to.
+var (
+	createKeywords = []string{"bucketsize", "comment", "counters", "family", "forceadd", "hashsize", "initval", "maxelem", "skbinfo", "timeout"}
+	addKeywords    = []string{"bytes", "comment", "nomatch", "packets", "skbmark", "skbprio", "skbqueue", "timeout"}
+)
@@ -0,0 +1,158 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShellBatch(t *testing.T) {
+	input := `# a comment
+create a hash:ip comment
+add a 10.0.0.1 comment "a host"
+add a 10.0.0.1
+-exist add a 10.0.0.1
+bogus
+add a 10.0.0.2
+`
+	tests := []struct {
+		args   []string
+		code   int
+		stderr string
+		list   string
+	}{
+		{[]string{"-"}, exitError,
+			"ipset: Error in line 4: Element cannot be added to the set: it's already added\n",
+			"10.0.0.1"},
+		{[]string{"shell", "--batch", "--continue"}, exitError,
+			"ipset: Error in line 4: Element cannot be added to the set: it's already added\n" +
+				"ipset: Error in line 6: No command specified: unknown argument bogus\n",
+			"10.0.0.1 10.0.0.2"},
+		{[]string{"-exist", "shell", "--batch", "--continue"}, exitParameter,
+			"ipset: Error in line 6: No command specified: unknown argument bogus\n",
+			"10.0.0.1 10.0.0.2"},
+	}
+	for _, test := range tests {
+		c, _, stderr := newTestCLI(input)
+		if code := c.run(test.args); code != test.code || stderr.String() != test.stderr {
+			t.Errorf("%v: expected %d %q, got %d %q", test.args, test.code, test.stderr, code, stderr.String())
+		}
+		// The options of the lines don't stick.
+		if c.exist != (test.args[0] == "-exist") {
+			t.Errorf("%v: -exist of line 5 stuck", test.args)
+		}
+		set, err := c.ipset.List("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var elems []string
+		for _, entry := range set.Entries {
+			elems = append(elems, entry.Set.String())
+		}
+		if strings.Join(elems, " ") != test.list {
+			t.Errorf("%v: expected %s, got %v", test.args, test.list, elems)
+		}
+	}
+}
+
+func TestShellSave(t *testing.T) {
+	c, stdout, _ := newTestCLI("")
+	c.stdin = strings.NewReader("create a hash:net timeout 60\nadd a 10.0.0.0/8 nomatch\ncreate b hash:ip\n")
+	if code := c.run([]string{"-"}); code != exitOK {
+		t.Fatalf("expected %d, got %d", exitOK, code)
+	}
+	c.run([]string{"save"})
+	saved := stdout.String()
+
+	// The output of save runs in the shell, quit ends it.
+	c, stdout, stderr := newTestCLI(saved + "quit\nbogus\n")
+	if code := c.run([]string{"shell", "--batch"}); code != exitOK {
+		t.Fatalf("expected %d, got %d: %s", exitOK, code, stderr)
+	}
+	c.run([]string{"save"})
+	if stdout.String() != saved {
+		t.Errorf("expected\n%s\ngot\n%s", saved, stdout)
+	}
+
+	for _, line := range []string{"shell\n", "-exist -\n", "restore\n"} {
+		c, _, stderr := newTestCLI(line)
+		if code := c.run([]string{"-"}); code != exitParameter {
+			t.Errorf("%q: expected %d, got %d %s", line, exitParameter, code, stderr)
+		}
+	}
+}
+
+func TestShellComplete(t *testing.T) {
+	c, _, _ := newTestCLI("")
+	c.run([]string{"create", "feed", "hash:net"})
+	c.run([]string{"create", "allow", "hash:net"})
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"", []string{"add", "create", "del", "destroy", "difference", "explain", "flush", "help", "intersection", "list", "quit", "rename", "restore", "save", "swap", "test", "union", "version"}},
+		{"de", []string{"del", "destroy"}},
+		{"-exist ad", []string{"add"}},
+		{"add ", []string{"allow", "feed"}},
+		{"-A f", []string{"feed"}},
+		{"-o save list ", []string{"allow", "feed"}},
+		{"swap feed a", []string{"allow"}},
+		{"difference feed allow f", []string{"feed"}},
+		{"create x hash:", []string{"hash:ip", "hash:ip,port", "hash:mac", "hash:net", "hash:net,port"}},
+		{"create x hash:ip ti", []string{"timeout"}},
+		{"add feed 10.0.0.0/8 no", []string{"nomatch"}},
+		{"help hash:m", []string{"hash:mac"}},
+		{"del feed ", nil},
+		{"version ", nil},
+	}
+	for _, test := range tests {
+		if got := c.complete(test.line); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%q: expected %q, got %q", test.line, test.expected, got)
+		}
+	}
+}
+
+func TestEditor(t *testing.T) {
+	complete := func(line string) []string {
+		switch line {
+		case "a":
+			return []string{"add"}
+		case "add ":
+			return []string{"allow", "alpha"}
+		case "add al":
+			return []string{"allow", "alpha"}
+		}
+		return nil
+	}
+	input := "a\t\t\tlow\n" + // add allow, the third tab lists
+		"xy\x7fz\n" + // xz
+		"junk\x03ok\n" + // ctrl-c drops junk
+		"junk\x15ok2\n" + // ctrl-u clears junk
+		"\x1b[A\x1b[A\n" + // ok2, ok
+		"\x04"
+	var out bytes.Buffer
+	e := newEditor(strings.NewReader(input), &out, complete)
+	var lines []string
+	for {
+		line, err := e.readLine("> ")
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	expected := []string{"add allow", "xz", "ok", "ok2", "ok"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %q, got %q", expected, lines)
+	}
+	if !strings.Contains(out.String(), "\nallow  alpha\n> add al") {
+		t.Errorf("expected the candidates to be listed, got %q", out.String())
+	}
+}
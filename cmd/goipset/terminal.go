@@ -0,0 +1,170 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/sys/unix"
+)
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	return err == nil
+}
+
+// makeRaw turns off the line editing, echo and signals of the terminal f,
+// so that editor gets every key, and returns the function that turns them
+// back on. The output is still processed, "\n" starts a new line.
+func makeRaw(f *os.File) (func(), error) {
+	fd := int(f.Fd())
+	saved, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	raw := *saved
+	raw.Lflag &^= unix.ICANON | unix.ECHO | unix.ISIG
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		return nil, err
+	}
+	return func() {
+		unix.IoctlSetTermios(fd, unix.TCSETS, saved)
+	}, nil
+}
+
+// The keys editor handles.
+const (
+	keyCtrlC     = 0x03
+	keyCtrlD     = 0x04
+	keyBell      = 0x07
+	keyBackspace = 0x08
+	keyTab       = 0x09
+	keyCtrlU     = 0x15
+	keyEscape    = 0x1b
+	keyDelete    = 0x7f
+)
+
+// editor reads lines from a terminal in raw mode. It echoes them, and
+// handles backspace, ctrl-u to clear the line, ctrl-c to drop it, ctrl-d
+// to end the input, the up and down arrows to go through the history and
+// tab to complete the last word with the words of complete.
+type editor struct {
+	r        *bufio.Reader
+	w        io.Writer
+	complete func(line string) []string
+	history  []string
+}
+
+func newEditor(r io.Reader, w io.Writer, complete func(line string) []string) *editor {
+	return &editor{r: bufio.NewReader(r), w: w, complete: complete}
+}
+
+// readLine prints prompt and returns the line typed after it, io.EOF for
+// ctrl-d on an empty line.
+func (e *editor) readLine(prompt string) (string, error) {
+	io.WriteString(e.w, prompt)
+	var line []byte
+	current := len(e.history) // the history entry shown, or the new line
+	redraw := func() {
+		io.WriteString(e.w, "\r\x1b[K"+prompt+string(line))
+	}
+
+	for {
+		key, err := e.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				io.WriteString(e.w, "\n")
+				break
+			}
+			return "", err
+		}
+
+		switch key {
+		case '\r', '\n':
+			io.WriteString(e.w, "\n")
+			text := string(line)
+			if strings.TrimSpace(text) != "" {
+				e.history = append(e.history, text)
+			}
+			return text, nil
+		case keyBackspace, keyDelete:
+			if len(line) > 0 {
+				_, size := utf8.DecodeLastRune(line)
+				line = line[:len(line)-size]
+				io.WriteString(e.w, "\b \b")
+			}
+		case keyCtrlC:
+			io.WriteString(e.w, "^C\n"+prompt)
+			line = line[:0]
+		case keyCtrlD:
+			if len(line) == 0 {
+				return "", io.EOF
+			}
+		case keyCtrlU:
+			line = line[:0]
+			redraw()
+		case keyTab:
+			line = e.completeLine(line, prompt)
+		case keyEscape:
+			// The arrows are ESC [ A to D, other sequences are ignored.
+			if b, _ := e.r.ReadByte(); b != '[' {
+				continue
+			}
+			switch b, _ := e.r.ReadByte(); {
+			case b == 'A' && current > 0:
+				current--
+			case b == 'B' && current < len(e.history):
+				current++
+			default:
+				continue
+			}
+			line = line[:0]
+			if current < len(e.history) {
+				line = append(line, e.history[current]...)
+			}
+			redraw()
+		default:
+			if key >= 0x20 {
+				line = append(line, key)
+				e.w.Write([]byte{key})
+			}
+		}
+	}
+	return string(line), nil
+}
+
+// completeLine completes the last word of line as far as the candidates
+// agree, and lists them when they don't.
+func (e *editor) completeLine(line []byte, prompt string) []byte {
+	candidates := e.complete(string(line))
+	word := string(line[strings.LastIndexAny(string(line), " \t")+1:])
+	if len(candidates) == 0 {
+		io.WriteString(e.w, string(rune(keyBell)))
+		return line
+	}
+
+	common := candidates[0]
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(candidates) == 1 {
+		common += " "
+	}
+	if len(common) > len(word) {
+		rest := common[len(word):]
+		io.WriteString(e.w, rest)
+		return append(line, rest...)
+	}
+	io.WriteString(e.w, "\n"+strings.Join(candidates, "  ")+"\n"+prompt+string(line))
+	return line
+}
@@ -13,7 +13,7 @@ set -e
 ipset list | grep Name | awk -F': ' '{print $2}' | xargs -t -i ipset destroy {}
 
 if [ ! -f ./goipset ];then
-    go build -o goipset main.go
+    go build -o goipset .
 fi
 
 while read line
@@ -160,16 +160,22 @@ func debugUnSerializeNlMsghdr(buf []byte) (hdr unix.NlMsghdr) {
 }
 
 func debugUnSerializeNlData(msg []byte) {
-	nf := nl.DeserializeNfgenmsg(msg)
+	nf, err := nl.DeserializeNfgenmsg(msg)
+	if err != nil {
+		debugf("%v\n", err)
+		return
+	}
 	debugf("NfgenFamily:%d    Version:%d    ResId:%0x\n", nf.NfgenFamily, nf.Version, nf.ResId)
 
-	for attr := range nl.ParseAttributes(msg[4:]) {
+	it := nl.NewAttributeIterator(msg[4:])
+	for it.Next() {
+		attr := it.Attribute()
 		switch attr.Type {
 		case nl.IPSET_ATTR_PROTOCOL,
 			nl.IPSET_ATTR_REVISION,
 			nl.IPSET_ATTR_FLAGS,
 			nl.IPSET_ATTR_FAMILY:
-			debugf("%s:%v\n", attrStr[int(attr.Type)], attr.Value[0])
+			debugf("%s:%v\n", attrStr[int(attr.Type)], attr.Uint8())
 		case nl.IPSET_ATTR_SETNAME,
 			nl.IPSET_ATTR_TYPENAME:
 			debugf("%s:%v\n", attrStr[int(attr.Type)], nl.BytesToString(attr.Value))
@@ -181,7 +187,7 @@ func debugUnSerializeNlData(msg []byte) {
 			debugf("%s:\n", attrStr[nl.IPSET_ATTR_ADT])
 			//debugParseAttrADT(attr.Value)
 		case nl.IPSET_ATTR_LINENO | nl.NLA_F_NESTED:
-			debugf("%s:%v\n", attrStr[nl.IPSET_ATTR_LINENO], attr.Value[0])
+			debugf("%s:%v\n", attrStr[nl.IPSET_ATTR_LINENO], attr.Uint8())
 
 			break
 		default:
@@ -191,7 +197,9 @@ func debugUnSerializeNlData(msg []byte) {
 }
 
 func debugParseAttrData(data []byte) {
-	for attr := range nl.ParseAttributes(data) {
+	it := nl.NewAttributeIterator(data)
+	for it.Next() {
+		attr := it.Attribute()
 		switch attr.Type {
 		case nl.IPSET_ATTR_HASHSIZE | nl.NLA_F_NET_BYTEORDER,
 			nl.IPSET_ATTR_MAXELEM | nl.NLA_F_NET_BYTEORDER,
@@ -208,7 +216,9 @@ func debugParseAttrData(data []byte) {
 }
 
 func debugParseAttrADT(data []byte) {
-	for attr := range nl.ParseAttributes(data) {
+	it := nl.NewAttributeIterator(data)
+	for it.Next() {
+		attr := it.Attribute()
 		switch attr.Type {
 		case nl.IPSET_ATTR_DATA | nl.NLA_F_NESTED:
 			debugf("%s:\n", attrStr[nl.IPSET_ATTR_DATA])
@@ -220,7 +230,9 @@ func debugParseAttrADT(data []byte) {
 }
 
 func debugParseEntry(data []byte) {
-	for attr := range nl.ParseAttributes(data) {
+	it := nl.NewAttributeIterator(data)
+	for it.Next() {
+		attr := it.Attribute()
 		switch attr.Type {
 		case nl.IPSET_ATTR_TIMEOUT | nl.NLA_F_NET_BYTEORDER:
 			debugf("    %s:%d\n", cadtStr[nl.IPSET_ATTR_TIMEOUT], attr.Uint32())
@@ -235,7 +247,9 @@ func debugParseEntry(data []byte) {
 		case nl.IPSET_ATTR_IP | nl.NLA_F_NESTED,
 			nl.IPSET_ATTR_IP_TO | nl.NLA_F_NESTED:
 			debugf("    %s:\n", cadtStr[int(attr.Type&nl.NLA_TYPE_MASK)])
-			for attr := range nl.ParseAttributes(attr.Value) {
+			nested := nl.NewAttributeIterator(attr.Value)
+			for nested.Next() {
+				attr := nested.Attribute()
 				switch attr.Type {
 				case nl.IPSET_ATTR_IPADDR_IPV4 | nl.NLA_F_NET_BYTEORDER,
 					nl.IPSET_ATTR_IPADDR_IPV6 | nl.NLA_F_NET_BYTEORDER:
@@ -0,0 +1,159 @@
+package goipset
+
+import (
+	"fmt"
+
+	"github.com/JiHanHuang/goipset/nl"
+	"golang.org/x/sys/unix"
+)
+
+// DualStackSet is a pair of ipsets of the same type holding the IPv4 and
+// the IPv6 entries of what is used as one set, since every ipset has a
+// single family. The sets are named after the pair, see V4Name and V6Name.
+type DualStackSet struct {
+	Name string
+
+	ipset *GoIpset
+}
+
+// NewDualStackSet returns the pair of ipsets called name.
+func NewDualStackSet(name string) *DualStackSet {
+	return gipset.DualStack(name)
+}
+
+// DualStack returns the pair of ipsets called name.
+func (g *GoIpset) DualStack(name string) *DualStackSet {
+	return &DualStackSet{Name: name, ipset: g}
+}
+
+// V4Name returns the name of the ipset with the IPv4 entries.
+func (d *DualStackSet) V4Name() string {
+	return d.Name + "-v4"
+}
+
+// V6Name returns the name of the ipset with the IPv6 entries.
+func (d *DualStackSet) V6Name() string {
+	return d.Name + "-v6"
+}
+
+// setName returns the name of the ipset for entries of family.
+func (d *DualStackSet) setName(family int) string {
+	if family == nl.FAMILY_V6 {
+		return d.V6Name()
+	}
+	return d.V4Name()
+}
+
+// Create creates both ipsets with the same options, options.Family is
+// ignored. If the second one can't be created, the first one is destroyed
+// again unless it replaced an existing set.
+func (d *DualStackSet) Create(typename string, options GoIpsetCreateOptions) error {
+	options.Family = unix.AF_INET
+	if err := d.ipset.Create(d.V4Name(), typename, options); err != nil {
+		return err
+	}
+	options.Family = unix.AF_INET6
+	if err := d.ipset.Create(d.V6Name(), typename, options); err != nil {
+		if !options.Replace {
+			d.ipset.Destroy(d.V4Name())
+		}
+		return err
+	}
+	return nil
+}
+
+// Destroy destroys both ipsets. The IPv6 one is destroyed even if
+// destroying the IPv4 one fails, the first error is returned.
+func (d *DualStackSet) Destroy() error {
+	err := d.ipset.Destroy(d.V4Name())
+	if err6 := d.ipset.Destroy(d.V6Name()); err == nil {
+		err = err6
+	}
+	return err
+}
+
+// Flush flushes both ipsets.
+func (d *DualStackSet) Flush() error {
+	if err := d.ipset.Flush(d.V4Name()); err != nil {
+		return err
+	}
+	return d.ipset.Flush(d.V6Name())
+}
+
+// Add adds an entry to the ipset of its family.
+func (d *DualStackSet) Add(entry *GoIPSetEntry) error {
+	setname, err := d.route(entry)
+	if err != nil {
+		return err
+	}
+	return d.ipset.Add(setname, entry)
+}
+
+// Del deletes an entry from the ipset of its family.
+func (d *DualStackSet) Del(entry *GoIPSetEntry) error {
+	setname, err := d.route(entry)
+	if err != nil {
+		return err
+	}
+	return d.ipset.Del(setname, entry)
+}
+
+// Load adds entries to the ipsets of their families with Load, the reports
+// of both are added up.
+func (d *DualStackSet) Load(entries []GoIPSetEntry, options LoadOptions) (LoadReport, error) {
+	var v4, v6 []GoIPSetEntry
+	for i := range entries {
+		setname, err := d.route(&entries[i])
+		if err != nil {
+			return LoadReport{Entries: len(entries)}, err
+		}
+		if setname == d.V6Name() {
+			v6 = append(v6, entries[i])
+		} else {
+			v4 = append(v4, entries[i])
+		}
+	}
+
+	report, err := d.ipset.Load(d.V4Name(), v4, options)
+	if err != nil {
+		report.Entries = len(entries)
+		return report, err
+	}
+	report6, err := d.ipset.Load(d.V6Name(), v6, options)
+	report.Entries = len(entries)
+	report.Loaded += report6.Loaded
+	report.Saved += report6.Saved
+	return report, err
+}
+
+// List lists both ipsets and merges them into one result named after the
+// pair, with the IPv4 entries first. The header is the one of the IPv4
+// set with Family 0, the counts and sizes are added up.
+func (d *DualStackSet) List() (GoIPSetResult, error) {
+	result, err := d.ipset.List(d.V4Name())
+	if err != nil {
+		return GoIPSetResult{}, err
+	}
+	v6, err := d.ipset.List(d.V6Name())
+	if err != nil {
+		return GoIPSetResult{}, err
+	}
+	result.SetName = d.Name
+	result.Family = 0
+	result.NumEntries += v6.NumEntries
+	result.SizeInMemory += v6.SizeInMemory
+	result.Entries = append(result.Entries, v6.Entries...)
+	return result, nil
+}
+
+// route returns the name of the ipset an entry belongs to.
+func (d *DualStackSet) route(entry *GoIPSetEntry) (string, error) {
+	if entry.Set == nil {
+		return "", fmt.Errorf("Set is nil in GoIPSetEntry")
+	}
+	ip := entryFieldsOf(entry.Set).ip
+	if ip == nil {
+		return "", fmt.Errorf("%s: entry %s has no IP address", d.Name, entry.Set)
+	}
+	return d.setName(nl.GetIPFamily(ip)), nil
+}
@@ -0,0 +1,98 @@
+package goipset
+
+import (
+	"net"
+	"testing"
+
+	"github.com/JiHanHuang/goipset/ipsettest"
+	"golang.org/x/sys/unix"
+)
+
+func TestDualStackSet(t *testing.T) {
+	kernel := ipsettest.NewKernel()
+	ipset := NewGoIpsetWithTransport(kernel)
+	pair := ipset.DualStack("blocked")
+
+	if err := pair.Create("hash:net", GoIpsetCreateOptions{Comments: true}); err != nil {
+		t.Fatal(err)
+	}
+	for name, family := range map[string]uint8{"blocked-v4": unix.AF_INET, "blocked-v6": unix.AF_INET6} {
+		header, err := ipset.Header(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Family != family {
+			t.Errorf("expected %s to have family %d, got %d", name, family, header.Family)
+		}
+	}
+
+	entries := []GoIPSetEntry{
+		{Set: &SetNet{IP: net.ParseIP("10.0.0.0"), CIDR: 8}, Comment: "v4"},
+		{Set: &SetNet{IP: net.ParseIP("2001:db8::"), CIDR: 32}, Comment: "v6"},
+		{Set: &SetIP{IP: net.ParseIP("192.168.0.1")}},
+	}
+	for i := range entries {
+		if err := pair.Add(&entries[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pair.Add(&GoIPSetEntry{Set: &SetMac{MAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}}}); err == nil {
+		t.Errorf("expected an entry without IP address to fail")
+	}
+
+	result, err := pair.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.SetName != "blocked" || result.Family != 0 || result.NumEntries != 3 {
+		t.Errorf("unexpected header %+v", result)
+	}
+	var got []string
+	for _, entry := range result.Entries {
+		got = append(got, entry.Set.String())
+	}
+	expectEntries(t, got, "10.0.0.0/8", "192.168.0.1/32", "2001:db8::/32")
+
+	if err := pair.Del(&entries[1]); err != nil {
+		t.Fatal(err)
+	}
+	report, err := pair.Load([]GoIPSetEntry{
+		{Set: &SetNet{IP: net.ParseIP("2001:db8::"), CIDR: 33}},
+		{Set: &SetNet{IP: net.ParseIP("2001:db8:8000::"), CIDR: 33}},
+		{Set: &SetNet{IP: net.ParseIP("172.16.0.0"), CIDR: 12}},
+	}, LoadOptions{Aggregate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (LoadReport{Entries: 3, Loaded: 2, Saved: 1}) {
+		t.Errorf("unexpected report %+v", report)
+	}
+	v6, err := ipset.List("blocked-v6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v6.Entries) != 1 || v6.Entries[0].Set.String() != "2001:db8::/32" {
+		t.Errorf("expected 2001:db8::/32 in blocked-v6, got %+v", v6.Entries)
+	}
+
+	if err := pair.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pair.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ipset.Header("blocked-v6"); err != unix.ENOENT {
+		t.Errorf("expected blocked-v6 to be destroyed, got %v", err)
+	}
+
+	// The IPv4 set is not left behind if the IPv6 one exists already.
+	if err := ipset.Create("taken-v6", "hash:ip", GoIpsetCreateOptions{Family: unix.AF_INET6}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipset.DualStack("taken").Create("hash:ip", GoIpsetCreateOptions{}); err == nil {
+		t.Errorf("expected creating over an existing set to fail")
+	}
+	if _, err := ipset.Header("taken-v4"); err != unix.ENOENT {
+		t.Errorf("expected taken-v4 to be destroyed again, got %v", err)
+	}
+}
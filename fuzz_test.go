@@ -0,0 +1,92 @@
+//go:build go1.18
+// +build go1.18
+
+package goipset
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/JiHanHuang/goipset/ipsettest"
+	"github.com/JiHanHuang/goipset/nl"
+)
+
+// fixtureMessages returns the kernel messages captured in testdata, the
+// replies of the golden sessions included.
+func fixtureMessages(f *testing.F) [][]byte {
+	var msgs [][]byte
+	for _, name := range []string{"ipset_list_result", "ipset_protocol_result"} {
+		msg, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			f.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	paths, err := filepath.Glob(filepath.Join("testdata", "golden", "*"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		exchanges, err := ipsettest.ReadExchanges(file)
+		file.Close()
+		if err != nil {
+			f.Fatalf("%s: %v", path, err)
+		}
+		for _, ex := range exchanges {
+			msgs = append(msgs, ex.Replies...)
+		}
+	}
+	return msgs
+}
+
+func FuzzUnserialize(f *testing.F) {
+	for _, msg := range fixtureMessages(f) {
+		f.Add(msg)
+	}
+	// Messages which used to crash the decoder.
+	f.Add([]byte{2, 0})
+	f.Add([]byte{2, 0, 0, 0, 4, 0, nl.IPSET_ATTR_PROTOCOL, 0})
+	f.Add([]byte{2, 0, 0, 0, 6, 0, nl.IPSET_ATTR_DATA, 0x80, 6, 0, nl.IPSET_ATTR_HASHSIZE, 0x40})
+
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		var result GoIPSetResult
+		if err := result.unserialize(msg); err != nil {
+			return
+		}
+		for _, entry := range result.Entries {
+			_ = entry.Set.String()
+		}
+	})
+}
+
+func FuzzParseIPSetEntry(f *testing.F) {
+	for _, msg := range fixtureMessages(f) {
+		it := nl.NewAttributeIterator(msg[nl.SizeofNfgenmsg:])
+		for it.Next() {
+			if attr := it.Attribute(); attr.Type == nl.IPSET_ATTR_ADT|nl.NLA_F_NESTED {
+				entries := nl.NewAttributeIterator(attr.Value)
+				for entries.Next() {
+					f.Add(entries.Attribute().Value)
+				}
+			}
+		}
+	}
+	// Entries which used to crash the decoder.
+	f.Add([]byte{5, 0, nl.IPSET_ATTR_TIMEOUT, 0x40, 1, 0, 0, 0})
+	f.Add([]byte{8, 0, nl.IPSET_ATTR_IP, 0x80, 5, 0, nl.IPSET_ATTR_IPADDR_IPV4, 0, 1, 0, 0, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		entry, err := parseIPSetEntry(data)
+		if err != nil {
+			return
+		}
+		_ = entry.Set.String()
+	})
+}
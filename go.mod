@@ -4,4 +4,5 @@ go 1.15
 
 require (
 	golang.org/x/sys v0.0.0-20210220050731-9a76102bfb43
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -1,6 +1,8 @@
 package goipset
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
@@ -12,13 +14,20 @@ import (
 
 // GoIPSetEntry is used for adding, updating, retreiving and deleting entries
 type GoIPSetEntry struct {
-	Comment string
+	Comment string // left out when added to a set without comments
 
 	Set
 
 	Timeout uint32
-	Packets uint64
+	Packets uint64 // Packets and Bytes are left out for a set without counters
 	Bytes   uint64
+	NoMatch bool // an exception in a set of networks
+
+	// skbinfo of sets created with it, for the SET target of iptables
+	SkbMark  uint32 // packet mark to set
+	SkbMask  uint32 // bits of the packet mark to set, 0 for all
+	SkbPrio  uint32 // tc class as major<<16 | minor
+	SkbQueue uint16 // hardware queue
 
 	Replace bool // replace existing entry
 }
@@ -41,6 +50,9 @@ type GoIPSetResult struct {
 	SizeInMemory uint32
 	CadtFlags    uint32
 	Timeout      uint32
+	InitVal      uint32
+	BucketSize   uint8
+	NetMask      uint8
 
 	Entries []GoIPSetEntry
 }
@@ -53,16 +65,43 @@ type GoIpsetCreateOptions struct {
 	Comments bool
 	Skbinfo  bool
 	Family   int
+
+	HashSize   uint32 // initial hash size, 0 for the default of the kernel
+	MaxElem    uint32 // most entries, 0 for the default of the kernel
+	BucketSize uint8  // most entries per hash bucket, 0 for the default
+	InitVal    uint32 // hash seed, 0 for a random one
+	ForceAdd   bool   // evict a random entry when the set is full
+	NetMask    uint8  // prefix length addresses are stored with, 0 for whole addresses
 }
 
 // GoIpset using save sockets...
 type GoIpset struct {
 	sockets   map[int]*nl.SocketHandle
 	domainSet sync.Map
+	transport Transport
+	// headers caches the setHeader of the sets entries were added to or
+	// deleted from, so that they can be validated without asking the
+	// kernel every time. A header an entry is rejected by is asked for
+	// again, see withHeader.
+	headers sync.Map
+}
+
+// setHeader is what validating an entry needs to know about its set.
+type setHeader struct {
+	setType  *SetType
+	family   int
+	revision uint8
+	netmask  uint8 // prefix length addresses are stored with, 0 for whole addresses
+	// cadtFlags tells the extensions of the set, the IPSET_FLAG_WITH_* bits
+	cadtFlags uint32
 }
 
 var gipset = GoIpset{}
 
+// ErrStopList can be returned by the callback of ListEach to stop the
+// listing early without ListEach reporting an error.
+var ErrStopList = errors.New("stop listing")
+
 // Protocol returns the ipset protocol version from the kernel
 func Protocol() (uint8, error) {
 	return gipset.Protocol()
@@ -83,16 +122,67 @@ func Flush(setname string) error {
 	return gipset.Flush(setname)
 }
 
+// DestroyAll destroys all ipsets that are not referenced by iptables.
+func DestroyAll() error {
+	return gipset.DestroyAll()
+}
+
+// FlushAll flushes all ipsets.
+func FlushAll() error {
+	return gipset.FlushAll()
+}
+
 // List dumps an specific ipset.
 func List(setname string) (GoIPSetResult, error) {
 	return gipset.List(setname)
 }
 
+// Header returns the name, type, family and revision of an ipset.
+func Header(setname string) (GoIPSetResult, error) {
+	return gipset.Header(setname)
+}
+
+// ListHeader lists an ipset without its entries.
+func ListHeader(setname string) (GoIPSetResult, error) {
+	return gipset.ListHeader(setname)
+}
+
+// Rename renames an ipset.
+func Rename(from, to string) error {
+	return gipset.Rename(from, to)
+}
+
+// Swap swaps the contents of two ipsets of the same type and family.
+func Swap(a, b string) error {
+	return gipset.Swap(a, b)
+}
+
+// ListEach dumps an specific ipset and calls fn for every entry as it is
+// received from the kernel.
+func ListEach(setname string, fn func(GoIPSetEntry) error) error {
+	return gipset.ListEach(setname, fn)
+}
+
 // ListAll dumps all ipsets.
 func ListAll() ([]GoIPSetResult, error) {
 	return gipset.ListAll()
 }
 
+// Test reports whether entry is in an existing ipset.
+func Test(setname string, entry *GoIPSetEntry) (bool, error) {
+	return gipset.Test(setname, entry)
+}
+
+// ListAllHeaders lists all ipsets without their entries.
+func ListAllHeaders() ([]GoIPSetResult, error) {
+	return gipset.ListAllHeaders()
+}
+
+// ListNames returns the names of all ipsets.
+func ListNames() ([]string, error) {
+	return gipset.ListNames()
+}
+
 // Add adds an entry to an existing ipset.
 func Add(setname string, entry *GoIPSetEntry) error {
 	return gipset.ipsetAddDel(nl.IPSET_CMD_ADD, setname, entry)
@@ -103,13 +193,25 @@ func Del(setname string, entry *GoIPSetEntry) error {
 	return gipset.ipsetAddDel(nl.IPSET_CMD_DEL, setname, entry)
 }
 
+// SetTransport makes the package level functions use t instead of the
+// kernel. It must be called before any of them is used.
+func SetTransport(t Transport) {
+	gipset.transport = t
+}
+
 func NewGoIpset() *GoIpset {
 	return &GoIpset{}
 }
 
+// NewGoIpsetWithTransport returns a GoIpset sending its requests over t,
+// e.g. an ipsettest.Kernel in tests.
+func NewGoIpsetWithTransport(t Transport) *GoIpset {
+	return &GoIpset{transport: t}
+}
+
 func (g *GoIpset) Protocol() (uint8, error) {
 	req := g.newIpsetRequest(nl.IPSET_CMD_PROTOCOL)
-	msgs, err := ipsetExecute(req)
+	msgs, err := g.execute(req)
 	if err != nil {
 		return 0, err
 	}
@@ -120,15 +222,22 @@ func (g *GoIpset) Protocol() (uint8, error) {
 
 func (g *GoIpset) Create(setname, typename string, options GoIpsetCreateOptions) error {
 
-	family := uint8(unix.AF_INET)
-	if options.Family == unix.AF_INET6 {
-		family = uint8(options.Family)
+	setType, ok := LookupSetType(typename)
+	if !ok {
+		return fmt.Errorf("unsupported set type %q", typename)
+	}
+	family, err := setType.family(options.Family)
+	if err != nil {
+		return err
 	}
 
 	result, err := g.ipsetType(typename, family)
 	if err != nil {
 		return err
 	}
+	if err := setType.ValidateCreate(options, result.Revision); err != nil {
+		return err
+	}
 
 	req := g.newIpsetRequest(nl.IPSET_CMD_CREATE)
 
@@ -146,6 +255,21 @@ func (g *GoIpset) Create(setname, typename string, options GoIpsetCreateOptions)
 	if timeout := options.Timeout; timeout != 0 {
 		data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_TIMEOUT | nl.NLA_F_NET_BYTEORDER, Value: timeout})
 	}
+	if options.HashSize != 0 {
+		data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_HASHSIZE | nl.NLA_F_NET_BYTEORDER, Value: options.HashSize})
+	}
+	if options.MaxElem != 0 {
+		data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_MAXELEM | nl.NLA_F_NET_BYTEORDER, Value: options.MaxElem})
+	}
+	if options.BucketSize != 0 {
+		data.AddRtAttr(nl.IPSET_ATTR_BUCKETSIZE, nl.Uint8Attr(options.BucketSize))
+	}
+	if options.InitVal != 0 {
+		data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_INITVAL | nl.NLA_F_NET_BYTEORDER, Value: options.InitVal})
+	}
+	if options.NetMask != 0 {
+		data.AddRtAttr(nl.IPSET_ATTR_NETMASK, nl.Uint8Attr(options.NetMask))
+	}
 
 	var cadtFlags uint32
 
@@ -158,6 +282,9 @@ func (g *GoIpset) Create(setname, typename string, options GoIpsetCreateOptions)
 	if options.Skbinfo {
 		cadtFlags |= nl.IPSET_FLAG_WITH_SKBINFO
 	}
+	if options.ForceAdd {
+		cadtFlags |= nl.IPSET_FLAG_WITH_FORCEADD
+	}
 
 	if cadtFlags != 0 {
 		data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_CADT_FLAGS | nl.NLA_F_NET_BYTEORDER, Value: cadtFlags})
@@ -167,21 +294,42 @@ func (g *GoIpset) Create(setname, typename string, options GoIpsetCreateOptions)
 
 	debugIpsetRequest(req)
 
-	_, err = ipsetExecute(req)
-	return err
+	if _, err = g.execute(req); err != nil {
+		return err
+	}
+	g.headers.Store(setname, &setHeader{setType: setType, family: int(family), revision: result.Revision, netmask: options.NetMask, cadtFlags: cadtFlags})
+	return nil
 }
 
 func (g *GoIpset) Destroy(setname string) error {
 	req := g.newIpsetRequest(nl.IPSET_CMD_DESTROY)
 	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated(setname)))
-	_, err := ipsetExecute(req)
+	_, err := g.execute(req)
+	g.headers.Delete(setname)
 	return err
 }
 
 func (g *GoIpset) Flush(setname string) error {
 	req := g.newIpsetRequest(nl.IPSET_CMD_FLUSH)
 	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated(setname)))
-	_, err := ipsetExecute(req)
+	_, err := g.execute(req)
+	return err
+}
+
+// DestroyAll destroys all ipsets, the kernel refuses if one of them is
+// referenced by iptables.
+func (g *GoIpset) DestroyAll() error {
+	_, err := g.execute(g.newIpsetRequest(nl.IPSET_CMD_DESTROY))
+	g.headers.Range(func(name, _ interface{}) bool {
+		g.headers.Delete(name)
+		return true
+	})
+	return err
+}
+
+// FlushAll flushes all ipsets.
+func (g *GoIpset) FlushAll() error {
+	_, err := g.execute(g.newIpsetRequest(nl.IPSET_CMD_FLUSH))
 	return err
 }
 
@@ -189,7 +337,7 @@ func (g *GoIpset) List(name string) (GoIPSetResult, error) {
 	req := g.newIpsetRequest(nl.IPSET_CMD_LIST)
 	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated(name)))
 
-	msgs, err := ipsetExecute(req)
+	msgs, err := g.execute(req)
 	if err != nil {
 		return GoIPSetResult{}, err
 	}
@@ -197,17 +345,129 @@ func (g *GoIpset) List(name string) (GoIPSetResult, error) {
 	return ipsetUnserialize(msgs)
 }
 
+// Header asks the kernel for the name, type, family and revision of an
+// ipset, which is cheaper than listing it.
+func (g *GoIpset) Header(setname string) (GoIPSetResult, error) {
+	req := g.newIpsetRequest(nl.IPSET_CMD_HEADER)
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated(setname)))
+
+	msgs, err := g.execute(req)
+	if err != nil {
+		return GoIPSetResult{}, err
+	}
+
+	return ipsetUnserialize(msgs)
+}
+
+// ListHeader lists an ipset without its entries: everything Header tells
+// and the create options, hash size, size in memory and number of entries.
+func (g *GoIpset) ListHeader(setname string) (GoIPSetResult, error) {
+	req := g.newIpsetRequest(nl.IPSET_CMD_LIST)
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated(setname)))
+	req.AddData(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_FLAGS | nl.NLA_F_NET_BYTEORDER, Value: nl.IPSET_FLAG_LIST_HEADER})
+
+	msgs, err := g.execute(req)
+	if err != nil {
+		return GoIPSetResult{}, err
+	}
+
+	return ipsetUnserialize(msgs)
+}
+
+// Rename renames an ipset, which must not be referenced by iptables.
+func (g *GoIpset) Rename(from, to string) error {
+	req := g.newIpsetRequest(nl.IPSET_CMD_RENAME)
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated(from)))
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME2, nl.ZeroTerminated(to)))
+	_, err := g.execute(req)
+	g.headers.Delete(from)
+	g.headers.Delete(to)
+	return err
+}
+
+// Swap swaps the contents of two ipsets of the same type and family, so
+// that iptables rules using one of them use the other one from then on.
+func (g *GoIpset) Swap(a, b string) error {
+	req := g.newIpsetRequest(nl.IPSET_CMD_SWAP)
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated(a)))
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME2, nl.ZeroTerminated(b)))
+	_, err := g.execute(req)
+	g.headers.Delete(a)
+	g.headers.Delete(b)
+	return err
+}
+
+// ListEach dumps an specific ipset without keeping it in memory. Every
+// netlink message is decoded as soon as it arrives and fn is called for each
+// of its entries. Returning ErrStopList, or an error wrapping it, from fn
+// ends the listing and makes ListEach return nil, any other error is
+// returned as is. A listing that is interrupted after fn was called is not
+// restarted, nl.ErrDumpInterrupted is returned instead.
+func (g *GoIpset) ListEach(name string, fn func(GoIPSetEntry) error) error {
+	req := g.newIpsetRequest(nl.IPSET_CMD_LIST)
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated(name)))
+
+	var header GoIPSetResult
+	err := g.executeEach(req, func(msg []byte) error {
+		return header.unserializeEach(msg, fn)
+	})
+	if errors.Is(err, ErrStopList) {
+		return nil
+	}
+	return err
+}
+
 func (g *GoIpset) ListAll() ([]GoIPSetResult, error) {
+	return g.listAll(0)
+}
+
+// ListAllHeaders lists all ipsets without their entries, as ListHeader
+// does for one.
+func (g *GoIpset) ListAllHeaders() ([]GoIPSetResult, error) {
+	return g.listAll(nl.IPSET_FLAG_LIST_HEADER)
+}
+
+// ListNames returns the names of all ipsets.
+func (g *GoIpset) ListNames() ([]string, error) {
+	sets, err := g.listAll(nl.IPSET_FLAG_LIST_SETNAME)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(sets))
+	for i := range sets {
+		names[i] = sets[i].SetName
+	}
+	return names, nil
+}
+
+// listAll dumps all ipsets, flags are IPSET_FLAG_LIST_* flags telling to
+// leave out the entries or all but the names.
+func (g *GoIpset) listAll(flags uint32) ([]GoIPSetResult, error) {
 	req := g.newIpsetRequest(nl.IPSET_CMD_LIST)
+	if flags != 0 {
+		req.AddData(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_FLAGS | nl.NLA_F_NET_BYTEORDER, Value: flags})
+	}
 
-	msgs, err := ipsetExecute(req)
+	msgs, err := g.execute(req)
 	if err != nil {
 		return nil, err
 	}
 
-	result := make([]GoIPSetResult, len(msgs))
-	for i, msg := range msgs {
-		result[i].unserialize(msg)
+	// A set too large for one message continues in the following ones.
+	var result []GoIPSetResult
+	for _, msg := range msgs {
+		var set GoIPSetResult
+		if err := set.unserializeEach(msg, func(entry GoIPSetEntry) error {
+			set.Entries = append(set.Entries, entry)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		if n := len(result); n > 0 && result[n-1].SetName == set.SetName {
+			result[n-1].Entries = append(result[n-1].Entries, set.Entries...)
+			continue
+		}
+		result = append(result, set)
 	}
 
 	return result, nil
@@ -223,6 +483,17 @@ func (g *GoIpset) Del(setname string, entry *GoIPSetEntry) error {
 	return g.ipsetAddDel(nl.IPSET_CMD_DEL, setname, entry)
 }
 
+// Test reports whether entry is in an existing ipset. For sets of
+// networks an address is in the set if a network containing it is, unless
+// the most specific one is a nomatch entry.
+func (g *GoIpset) Test(setname string, entry *GoIPSetEntry) (bool, error) {
+	err := g.ipsetAddDel(nl.IPSET_CMD_TEST, setname, entry)
+	if errors.Is(err, nl.IPSetError(nl.IPSET_ERR_EXIST)) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 func (g *GoIpset) ipsetType(typename string, family uint8) (GoIPSetResult, error) {
 	req := g.newIpsetRequest(nl.IPSET_CMD_TYPE)
 	req.Flags |= unix.NLM_F_EXCL
@@ -231,7 +502,7 @@ func (g *GoIpset) ipsetType(typename string, family uint8) (GoIPSetResult, error
 
 	debugIpsetRequest(req)
 
-	msgs, err := ipsetExecute(req)
+	msgs, err := g.execute(req)
 	if err != nil {
 		return GoIPSetResult{}, err
 	}
@@ -243,27 +514,211 @@ func (g *GoIpset) ipsetAddDel(nlCmd int, setname string, entry *GoIPSetEntry) er
 	if entry.Set == nil {
 		return fmt.Errorf("Set is nil in GoIPSetEntry")
 	}
+	var entries []*GoIPSetEntry
+	var cadtFlags uint32
+	err := g.withHeader(setname, func(header *setHeader) error {
+		entries, cadtFlags = []*GoIPSetEntry{entry}, header.cadtFlags
+		if header.setType == nil {
+			return nil
+		}
+		if err := header.setType.ValidateEntry(header.family, header.revision, entry); err != nil {
+			return fmt.Errorf("%s: %v", setname, err)
+		}
+		var err error
+		if entries, err = header.setType.expandRange(header.family, header.revision, entry); err != nil {
+			return fmt.Errorf("%s: %v", setname, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(entries) > 1 {
+		if nlCmd == nl.IPSET_CMD_TEST {
+			return fmt.Errorf("%s: the kernel can't test the range %s", setname, entry.Set)
+		}
+		return g.ipsetAddDelBatch(nlCmd, setname, entries, entry.Replace)
+	}
+
 	req := g.newIpsetRequest(nlCmd)
 
 	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated(setname)))
-	data := nl.NewRtAttr(nl.IPSET_ATTR_DATA|int(nl.NLA_F_NESTED), nil)
 
 	if !entry.Replace {
 		req.Flags |= unix.NLM_F_EXCL
 	}
 
+	req.AddData(entryData(entry, 0, cadtFlags))
+
+	debugIpsetRequest(req)
+
+	_, err = g.execute(req)
+	if errors.Is(err, unix.ENOENT) {
+		g.headers.Delete(setname)
+	}
+	return err
+}
+
+// adtBatchSize is the number of entries ipsetAddDelBatch sends per request,
+// which keeps the requests well below the socket buffer sizes.
+const adtBatchSize = 128
+
+// ipsetAddDelBatch adds or deletes entries with as few requests as
+// possible, each carrying up to adtBatchSize entries in IPSET_ATTR_ADT. The
+// kernel stops at the first entry that fails, the entries of the previous
+// requests stay added or deleted.
+func (g *GoIpset) ipsetAddDelBatch(nlCmd int, setname string, entries []*GoIPSetEntry, replace bool) error {
+	_, err := g.addDelBatch(nlCmd, setname, entries, replace)
+	return err
+}
+
+// addDelBatch is ipsetAddDelBatch, which also returns the index of the
+// entry the kernel failed at, or -1 if it did not tell.
+func (g *GoIpset) addDelBatch(nlCmd int, setname string, entries []*GoIPSetEntry, replace bool) (int, error) {
+	header, err := g.setHeader(setname)
+	if err != nil {
+		return -1, err
+	}
+	for start := 0; start < len(entries); start += adtBatchSize {
+		end := start + adtBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+
+		req := g.newIpsetRequest(nlCmd)
+		req.EchoErrors = true
+		req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated(setname)))
+		if !replace {
+			req.Flags |= unix.NLM_F_EXCL
+		}
+		adt := nl.NewRtAttr(nl.IPSET_ATTR_ADT|int(nl.NLA_F_NESTED), nil)
+		for i, entry := range entries[start:end] {
+			adt.AddChild(entryData(entry, uint32(start+i+1), header.cadtFlags))
+		}
+		req.AddData(adt)
+		// The kernel insists on a line number next to a batch, and puts
+		// the one of the entry that failed there when it echoes the
+		// request with the error.
+		req.AddData(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_LINENO | nl.NLA_F_NET_BYTEORDER, Value: 0})
+
+		debugIpsetRequest(req)
+
+		if _, err := g.execute(req); err != nil {
+			failed := -1
+			var reqErr *nl.RequestError
+			if errors.As(err, &reqErr) {
+				if lineno := echoedLineno(reqErr.Request); lineno > start && lineno <= end {
+					failed = lineno - 1
+				}
+				err = reqErr.Err
+			}
+			if errors.Is(err, unix.ENOENT) {
+				g.headers.Delete(setname)
+			}
+			return failed, err
+		}
+	}
+	return -1, nil
+}
+
+// echoedLineno returns the top level IPSET_ATTR_LINENO of an echoed ipset
+// request, 0 if there is none. It comes back in the byte order it was
+// sent in.
+func echoedLineno(req []byte) int {
+	if len(req) < unix.SizeofNlMsghdr+nl.SizeofNfgenmsg {
+		return 0
+	}
+	it := nl.NewAttributeIterator(req[unix.SizeofNlMsghdr+nl.SizeofNfgenmsg:])
+	for it.Next() {
+		attr := it.Attribute()
+		if attr.Type&nl.NLA_TYPE_MASK == nl.IPSET_ATTR_LINENO && len(attr.Value) == 4 {
+			return int(binary.BigEndian.Uint32(attr.Value))
+		}
+	}
+	return 0
+}
+
+// entryData serializes entry into an IPSET_ATTR_DATA attribute, lineno
+// numbers the entries of a batch. The comment and the counters are left out
+// for sets without them, cadtFlags are the extensions of the set.
+func entryData(entry *GoIPSetEntry, lineno uint32, cadtFlags uint32) *nl.RtAttr {
+	data := nl.NewRtAttr(nl.IPSET_ATTR_DATA|int(nl.NLA_F_NESTED), nil)
+
 	if entry.Timeout != 0 {
 		data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_TIMEOUT | nl.NLA_F_NET_BYTEORDER, Value: entry.Timeout})
 	}
 	entry.Set.serializeAttr(data)
+	if entry.Comment != "" && cadtFlags&nl.IPSET_FLAG_WITH_COMMENT != 0 {
+		data.AddChild(nl.NewRtAttr(nl.IPSET_ATTR_COMMENT, nl.ZeroTerminated(entry.Comment)))
+	}
+	if (entry.Packets != 0 || entry.Bytes != 0) && cadtFlags&nl.IPSET_FLAG_WITH_COUNTERS != 0 {
+		data.AddChild(nl.NewRtAttr(nl.IPSET_ATTR_PACKETS|int(nl.NLA_F_NET_BYTEORDER), netUint64(entry.Packets)))
+		data.AddChild(nl.NewRtAttr(nl.IPSET_ATTR_BYTES|int(nl.NLA_F_NET_BYTEORDER), netUint64(entry.Bytes)))
+	}
+	if entry.NoMatch {
+		data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_CADT_FLAGS | nl.NLA_F_NET_BYTEORDER, Value: nl.IPSET_FLAG_NOMATCH})
+	}
+	if entry.SkbMark != 0 || entry.SkbMask != 0 {
+		mask := entry.SkbMask
+		if mask == 0 {
+			mask = 0xffffffff
+		}
+		data.AddChild(nl.NewRtAttr(nl.IPSET_ATTR_SKBMARK|int(nl.NLA_F_NET_BYTEORDER), netUint64(uint64(entry.SkbMark)<<32|uint64(mask))))
+	}
+	if entry.SkbPrio != 0 {
+		data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_SKBPRIO | nl.NLA_F_NET_BYTEORDER, Value: entry.SkbPrio})
+	}
+	if entry.SkbQueue != 0 {
+		queue := make([]byte, 2)
+		binary.BigEndian.PutUint16(queue, entry.SkbQueue)
+		data.AddChild(nl.NewRtAttr(nl.IPSET_ATTR_SKBQUEUE|int(nl.NLA_F_NET_BYTEORDER), queue))
+	}
 
-	data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_LINENO | nl.NLA_F_NET_BYTEORDER, Value: 0})
-	req.AddData(data)
+	data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_LINENO | nl.NLA_F_NET_BYTEORDER, Value: lineno})
+	return data
+}
 
-	debugIpsetRequest(req)
+// setHeader returns the cached header of setname, asking the kernel for it
+// the first time. Sets of types goipset does not know have no SetType.
+func (g *GoIpset) setHeader(setname string) (*setHeader, error) {
+	if header, ok := g.headers.Load(setname); ok {
+		return header.(*setHeader), nil
+	}
+	// Unlike Header, the listing tells the netmask.
+	result, err := g.ListHeader(setname)
+	if err != nil {
+		return nil, err
+	}
+	setType, _ := LookupSetType(result.TypeName)
+	header := &setHeader{setType: setType, family: int(result.Family), revision: result.Revision, netmask: result.NetMask, cadtFlags: result.CadtFlags}
+	g.headers.Store(setname, header)
+	return header, nil
+}
 
-	_, err := ipsetExecute(req)
-	return err
+// withHeader calls fn with the header of setname. If fn rejects a cached
+// header, the set may have been recreated by someone else since, so the
+// header is asked for again and fn called once more with it.
+func (g *GoIpset) withHeader(setname string, fn func(header *setHeader) error) error {
+	_, cached := g.headers.Load(setname)
+	header, err := g.setHeader(setname)
+	if err != nil {
+		return err
+	}
+	if err = fn(header); err == nil || !cached {
+		return err
+	}
+	g.headers.Delete(setname)
+	if header, err = g.setHeader(setname); err != nil {
+		return err
+	}
+	return fn(header)
+}
+
+// netUint64 returns v in network byte order.
+func netUint64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
 }
 
 func (g *GoIpset) newIpsetRequest(cmd int) *nl.NetlinkRequest {
@@ -281,20 +736,55 @@ func (g *GoIpset) newIpsetRequest(cmd int) *nl.NetlinkRequest {
 	return req
 }
 
-func ipsetExecute(req *nl.NetlinkRequest) (msgs [][]byte, err error) {
-	msgs, err = req.Execute(unix.NETLINK_NETFILTER, 0)
+func (g *GoIpset) execute(req *nl.NetlinkRequest) (msgs [][]byte, err error) {
+	err = g.getTransport().Execute(req, func(msg []byte) error {
+		msgs = append(msgs, msg)
+		return nil
+	}, func() error {
+		msgs = nil
+		return nil
+	})
 	if err != nil {
-		switch v := err.(type) {
-		case syscall.Errno:
-			if v >= nl.IPSET_ERR_PRIVATE {
-				err = nl.IPSetError(uintptr(v))
-			}
-		}
+		msgs = nil
 	}
+	err = ipsetError(err)
 	debugIpsetResult(msgs, err)
 	return
 }
 
+// executeEach is the streaming counterpart of execute, fn is called for
+// every reply message as it is received.
+func (g *GoIpset) executeEach(req *nl.NetlinkRequest, fn func(msg []byte) error) error {
+	err := g.getTransport().Execute(req, func(msg []byte) error {
+		debugIpsetResult([][]byte{msg}, nil)
+		return fn(msg)
+	}, nil)
+	return ipsetError(err)
+}
+
+func (g *GoIpset) getTransport() Transport {
+	if g.transport == nil {
+		return netlinkTransport
+	}
+	return g.transport
+}
+
+// ipsetError converts the ipset specific errnos to IPSetError, also when
+// they come with an extended ACK or the echoed request.
+func ipsetError(err error) error {
+	switch v := err.(type) {
+	case syscall.Errno:
+		if v >= nl.IPSET_ERR_PRIVATE {
+			return nl.IPSetError(uintptr(v))
+		}
+	case *nl.ExtAckError:
+		v.Err = ipsetError(v.Err)
+	case *nl.RequestError:
+		v.Err = ipsetError(v.Err)
+	}
+	return err
+}
+
 func ipsetUnserialize(msgs [][]byte) (result GoIPSetResult, err error) {
 	for _, msg := range msgs {
 		err = result.unserialize(msg)
@@ -306,41 +796,64 @@ func ipsetUnserialize(msgs [][]byte) (result GoIPSetResult, err error) {
 }
 
 func (result *GoIPSetResult) unserialize(msg []byte) error {
-	result.Nfgenmsg = nl.DeserializeNfgenmsg(msg)
+	return result.unserializeEach(msg, func(entry GoIPSetEntry) error {
+		result.Entries = append(result.Entries, entry)
+		return nil
+	})
+}
 
-	for attr := range nl.ParseAttributes(msg[4:]) {
+// unserializeEach decodes the header attributes of msg into result and
+// passes the entries to fn instead of collecting them.
+func (result *GoIPSetResult) unserializeEach(msg []byte, fn func(GoIPSetEntry) error) error {
+	nfgenmsg, err := nl.DeserializeNfgenmsg(msg)
+	if err != nil {
+		return err
+	}
+	result.Nfgenmsg = nfgenmsg
+
+	it := nl.NewAttributeIterator(msg[nl.SizeofNfgenmsg:])
+	for it.Next() {
+		attr := it.Attribute()
+		if err := checkAttrLen(attr, headerAttrLen); err != nil {
+			return err
+		}
 		switch attr.Type {
 		case nl.IPSET_ATTR_PROTOCOL:
-			result.Protocol = attr.Value[0]
+			result.Protocol = attr.Uint8()
 		case nl.IPSET_ATTR_SETNAME:
 			result.SetName = nl.BytesToString(attr.Value)
 		case nl.IPSET_ATTR_TYPENAME:
 			result.TypeName = nl.BytesToString(attr.Value)
 		case nl.IPSET_ATTR_REVISION:
-			result.Revision = attr.Value[0]
+			result.Revision = attr.Uint8()
 		case nl.IPSET_ATTR_FAMILY:
-			result.Family = attr.Value[0]
+			result.Family = attr.Uint8()
 		case nl.IPSET_ATTR_FLAGS:
-			result.Flags = attr.Value[0]
+			result.Flags = attr.Uint8()
 		case nl.IPSET_ATTR_PROTOCOL_MIN:
-			result.ProtoMin = attr.Value[0]
+			result.ProtoMin = attr.Uint8()
 		case nl.IPSET_ATTR_DATA | nl.NLA_F_NESTED:
 			if err := result.parseAttrData(attr.Value); err != nil {
 				return err
 			}
 		case nl.IPSET_ATTR_ADT | nl.NLA_F_NESTED:
-			if err := result.parseAttrADT(attr.Value); err != nil {
+			if err := parseAttrADT(attr.Value, fn); err != nil {
 				return err
 			}
 		default:
 			return fmt.Errorf("unknown ipset attribute from kernel: %+v %v", attr, attr.Type&nl.NLA_TYPE_MASK)
 		}
 	}
-	return nil
+	return it.Err()
 }
 
 func (result *GoIPSetResult) parseAttrData(data []byte) error {
-	for attr := range nl.ParseAttributes(data) {
+	it := nl.NewAttributeIterator(data)
+	for it.Next() {
+		attr := it.Attribute()
+		if err := checkAttrLen(attr, dataAttrLen); err != nil {
+			return err
+		}
 		switch attr.Type {
 		case nl.IPSET_ATTR_HASHSIZE | nl.NLA_F_NET_BYTEORDER:
 			result.HashSize = attr.Uint32()
@@ -357,33 +870,48 @@ func (result *GoIPSetResult) parseAttrData(data []byte) error {
 			result.SizeInMemory = attr.Uint32()
 		case nl.IPSET_ATTR_CADT_FLAGS | nl.NLA_F_NET_BYTEORDER:
 			result.CadtFlags = attr.Uint32()
+		case nl.IPSET_ATTR_INITVAL | nl.NLA_F_NET_BYTEORDER:
+			result.InitVal = attr.Uint32()
+		case nl.IPSET_ATTR_BUCKETSIZE:
+			result.BucketSize = attr.Uint8()
+		case nl.IPSET_ATTR_NETMASK:
+			result.NetMask = attr.Uint8()
 		default:
 			return fmt.Errorf("unknown ipset data attribute from kernel: %+v %v", attr, attr.Type&nl.NLA_TYPE_MASK)
 		}
 	}
-	return nil
+	return it.Err()
 }
 
-func (result *GoIPSetResult) parseAttrADT(data []byte) error {
-	for attr := range nl.ParseAttributes(data) {
+func parseAttrADT(data []byte, fn func(GoIPSetEntry) error) error {
+	it := nl.NewAttributeIterator(data)
+	for it.Next() {
+		attr := it.Attribute()
 		switch attr.Type {
 		case nl.IPSET_ATTR_DATA | nl.NLA_F_NESTED:
 			entry, err := parseIPSetEntry(attr.Value)
 			if err != nil {
 				return err
 			}
-			result.Entries = append(result.Entries, entry)
+			if err := fn(entry); err != nil {
+				return err
+			}
 		default:
 			return fmt.Errorf("unknown ADT attribute from kernel: %+v %v", attr, attr.Type&nl.NLA_TYPE_MASK)
 		}
 	}
-	return nil
+	return it.Err()
 }
 
 func parseIPSetEntry(data []byte) (entry GoIPSetEntry, err error) {
 	set := SetResult{}
 	entry.Set = &set
-	for attr := range nl.ParseAttributes(data) {
+	it := nl.NewAttributeIterator(data)
+	for it.Next() {
+		attr := it.Attribute()
+		if err := checkAttrLen(attr, entryAttrLen); err != nil {
+			return entry, err
+		}
 		switch attr.Type {
 		case nl.IPSET_ATTR_TIMEOUT | nl.NLA_F_NET_BYTEORDER:
 			val := attr.Uint32()
@@ -398,8 +926,22 @@ func parseIPSetEntry(data []byte) (entry GoIPSetEntry, err error) {
 			set.MAC = net.HardwareAddr(attr.Value)
 		case nl.IPSET_ATTR_COMMENT:
 			entry.Comment = nl.BytesToString(attr.Value)
+		case nl.IPSET_ATTR_CADT_FLAGS | nl.NLA_F_NET_BYTEORDER:
+			entry.NoMatch = attr.Uint32()&nl.IPSET_FLAG_NOMATCH != 0
+		case nl.IPSET_ATTR_SKBMARK | nl.NLA_F_NET_BYTEORDER:
+			val := attr.Uint64()
+			entry.SkbMark, entry.SkbMask = uint32(val>>32), uint32(val)
+		case nl.IPSET_ATTR_SKBPRIO | nl.NLA_F_NET_BYTEORDER:
+			entry.SkbPrio = attr.Uint32()
+		case nl.IPSET_ATTR_SKBQUEUE | nl.NLA_F_NET_BYTEORDER:
+			entry.SkbQueue = attr.Uint16()
 		case nl.IPSET_ATTR_IP | nl.NLA_F_NESTED:
-			for attr := range nl.ParseAttributes(attr.Value) {
+			nested := nl.NewAttributeIterator(attr.Value)
+			for nested.Next() {
+				attr := nested.Attribute()
+				if err := checkAttrLen(attr, ipAttrLen); err != nil {
+					return entry, err
+				}
 				switch attr.Type {
 				case nl.IPSET_ATTR_IPADDR_IPV4,
 					nl.IPSET_ATTR_IPADDR_IPV6:
@@ -408,6 +950,9 @@ func parseIPSetEntry(data []byte) (entry GoIPSetEntry, err error) {
 					err = fmt.Errorf("unknown nested ADT attribute from kernel: %+v", attr)
 				}
 			}
+			if nested.Err() != nil {
+				err = nested.Err()
+			}
 		case nl.IPSET_ATTR_CIDR:
 			set.CIDR = attr.Uint8()
 		case nl.IPSET_ATTR_PROTO:
@@ -418,5 +963,59 @@ func parseIPSetEntry(data []byte) (entry GoIPSetEntry, err error) {
 			err = fmt.Errorf("unknown ADT attribute from kernel: %+v", attr)
 		}
 	}
+	if it.Err() != nil {
+		err = it.Err()
+	}
 	return
 }
+
+// The value lengths of the fixed size attributes in the different nesting
+// levels of a message. The decoders check them up front, so that a
+// malformed message results in an error and not in garbage.
+var (
+	headerAttrLen = map[uint16]int{
+		nl.IPSET_ATTR_PROTOCOL:     1,
+		nl.IPSET_ATTR_REVISION:     1,
+		nl.IPSET_ATTR_FAMILY:       1,
+		nl.IPSET_ATTR_FLAGS:        1,
+		nl.IPSET_ATTR_PROTOCOL_MIN: 1,
+	}
+	dataAttrLen = map[uint16]int{
+		nl.IPSET_ATTR_HASHSIZE | nl.NLA_F_NET_BYTEORDER:   4,
+		nl.IPSET_ATTR_MAXELEM | nl.NLA_F_NET_BYTEORDER:    4,
+		nl.IPSET_ATTR_TIMEOUT | nl.NLA_F_NET_BYTEORDER:    4,
+		nl.IPSET_ATTR_ELEMENTS | nl.NLA_F_NET_BYTEORDER:   4,
+		nl.IPSET_ATTR_REFERENCES | nl.NLA_F_NET_BYTEORDER: 4,
+		nl.IPSET_ATTR_MEMSIZE | nl.NLA_F_NET_BYTEORDER:    4,
+		nl.IPSET_ATTR_CADT_FLAGS | nl.NLA_F_NET_BYTEORDER: 4,
+		nl.IPSET_ATTR_INITVAL | nl.NLA_F_NET_BYTEORDER:    4,
+		nl.IPSET_ATTR_BUCKETSIZE:                          1,
+		nl.IPSET_ATTR_NETMASK:                             1,
+	}
+	entryAttrLen = map[uint16]int{
+		nl.IPSET_ATTR_TIMEOUT | nl.NLA_F_NET_BYTEORDER:    4,
+		nl.IPSET_ATTR_BYTES | nl.NLA_F_NET_BYTEORDER:      8,
+		nl.IPSET_ATTR_PACKETS | nl.NLA_F_NET_BYTEORDER:    8,
+		nl.IPSET_ATTR_ETHER:                               6,
+		nl.IPSET_ATTR_CIDR:                                1,
+		nl.IPSET_ATTR_PROTO:                               1,
+		nl.IPSET_ATTR_PORT | nl.NLA_F_NET_BYTEORDER:       2,
+		nl.IPSET_ATTR_CADT_FLAGS | nl.NLA_F_NET_BYTEORDER: 4,
+		nl.IPSET_ATTR_SKBMARK | nl.NLA_F_NET_BYTEORDER:    8,
+		nl.IPSET_ATTR_SKBPRIO | nl.NLA_F_NET_BYTEORDER:    4,
+		nl.IPSET_ATTR_SKBQUEUE | nl.NLA_F_NET_BYTEORDER:   2,
+	}
+	ipAttrLen = map[uint16]int{
+		nl.IPSET_ATTR_IPADDR_IPV4: net.IPv4len,
+		nl.IPSET_ATTR_IPADDR_IPV6: net.IPv6len,
+	}
+)
+
+// checkAttrLen returns an error if attr is listed in lengths with another
+// length.
+func checkAttrLen(attr nl.Attribute, lengths map[uint16]int) error {
+	if want, ok := lengths[attr.Type]; ok && len(attr.Value) != want {
+		return fmt.Errorf("ipset attribute %d has %d bytes, expected %d", attr.Type&nl.NLA_TYPE_MASK, len(attr.Value), want)
+	}
+	return nil
+}
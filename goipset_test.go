@@ -6,11 +6,47 @@ import (
 	"net"
 	"testing"
 
+	"github.com/JiHanHuang/goipset/ipsettest"
 	"github.com/JiHanHuang/goipset/nl"
+	"golang.org/x/sys/unix"
 )
 
 func TestAddEntry(t *testing.T) {
+	ipset := NewGoIpsetWithTransport(ipsettest.NewKernel())
 
+	err := ipset.Create("test", "hash:ip", GoIpsetCreateOptions{Comments: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := GoIPSetEntry{
+		Set:     &SetIP{IP: net.ParseIP("1.1.1.1")},
+		Comment: "first",
+	}
+	if err := ipset.Add("test", &entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipset.Add("test", &entry); err != nl.IPSetError(nl.IPSET_ERR_EXIST) {
+		t.Errorf("expected adding an entry twice to fail with exist, got %v", err)
+	}
+	entry.Replace = true
+	entry.Comment = "replaced"
+	if err := ipset.Add("test", &entry); err != nil {
+		t.Errorf("expected replacing an entry to succeed, got %v", err)
+	}
+
+	result, err := ipset.List("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(result.Entries))
+	}
+	if s := result.Entries[0].Set.String(); s != "1.1.1.1" {
+		t.Errorf("expected entry 1.1.1.1, got %s", s)
+	}
+	if c := result.Entries[0].Comment; c != "replaced" {
+		t.Errorf(`expected comment "replaced", got %q`, c)
+	}
 }
 
 func TestParseIpsetProtocolResult(t *testing.T) {
@@ -97,3 +133,111 @@ func TestParseIpsetListResult(t *testing.T) {
 			ent.Set.(*SetResult).MAC.String())
 	}
 }
+
+func TestParseIpsetListResultEach(t *testing.T) {
+	msgBytes, err := ioutil.ReadFile("testdata/ipset_list_result")
+	if err != nil {
+		t.Fatalf("reading test fixture failed: %v", err)
+	}
+
+	var header GoIPSetResult
+	var macs []string
+	err = header.unserializeEach(msgBytes, func(entry GoIPSetEntry) error {
+		macs = append(macs, entry.Set.String())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.SetName != "clients" {
+		t.Errorf(`expected SetName to equal "clients", got %q`, header.SetName)
+	}
+	if len(header.Entries) != 0 {
+		t.Errorf("expected no Entries to be collected, got %d", len(header.Entries))
+	}
+	if len(macs) != 2 || macs[0] != "de:ad:00:00:be:ef" || macs[1] != "01:02:03:00:01:02" {
+		t.Errorf("unexpected entries: %v", macs)
+	}
+
+	// stop after the first entry
+	count := 0
+	err = header.unserializeEach(msgBytes, func(entry GoIPSetEntry) error {
+		count++
+		return ErrStopList
+	})
+	if err != ErrStopList {
+		t.Errorf("expected ErrStopList, got %v", err)
+	}
+	if count != 1 {
+		t.Errorf("expected the callback to be called once, got %d", count)
+	}
+}
+
+// BenchmarkListUnserialize measures the decoding side of listing a large
+// hash:ip set, the netlink messages are built once up front.
+func BenchmarkListUnserialize(b *testing.B) {
+	adt := nl.NewRtAttr(nl.IPSET_ATTR_ADT|int(nl.NLA_F_NESTED), nil)
+	for i := 0; i < 1000; i++ {
+		data := nl.NewRtAttr(nl.IPSET_ATTR_DATA|int(nl.NLA_F_NESTED), nil)
+		ip := nl.NewRtAttr(nl.IPSET_ATTR_IP|int(nl.NLA_F_NESTED), nil)
+		ip.AddRtAttr(nl.IPSET_ATTR_IPADDR_IPV4, []byte{10, 0, byte(i >> 8), byte(i)})
+		data.AddChild(ip)
+		data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_TIMEOUT | nl.NLA_F_NET_BYTEORDER, Value: 300})
+		adt.AddChild(data)
+	}
+	msg := (&nl.Nfgenmsg{NfgenFamily: 2}).Serialize()
+	msg = append(msg, nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated("bench")).Serialize()...)
+	msg = append(msg, adt.Serialize()...)
+
+	b.SetBytes(int64(len(msg)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var result GoIPSetResult
+		err := result.unserializeEach(msg, func(GoIPSetEntry) error { return nil })
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestHeader(t *testing.T) {
+	ipset := NewGoIpsetWithTransport(ipsettest.NewKernel())
+	if err := ipset.Create("test", "hash:net", GoIpsetCreateOptions{Family: unix.AF_INET6}); err != nil {
+		t.Fatal(err)
+	}
+	header, err := ipset.Header("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.SetName != "test" || header.TypeName != "hash:net" || header.Family != unix.AF_INET6 {
+		t.Errorf("unexpected header %+v", header)
+	}
+	if _, err := ipset.Header("missing"); err != unix.ENOENT {
+		t.Errorf("expected ENOENT for a missing set, got %v", err)
+	}
+}
+
+func TestTestEntry(t *testing.T) {
+	ipset := NewGoIpsetWithTransport(ipsettest.NewKernel())
+	if err := ipset.Create("test", "hash:net", GoIpsetCreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []GoIPSetEntry{
+		{Set: &SetNet{IP: net.ParseIP("10.0.0.0"), CIDR: 8}},
+		{Set: &SetNet{IP: net.ParseIP("10.1.0.0"), CIDR: 16}, NoMatch: true},
+	} {
+		if err := ipset.Add("test", &entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for ip, expected := range map[string]bool{"10.0.0.1": true, "10.1.0.1": false, "11.0.0.1": false} {
+		in, err := ipset.Test("test", &GoIPSetEntry{Set: &SetNet{IP: net.ParseIP(ip)}})
+		if err != nil || in != expected {
+			t.Errorf("expected %s in set to be %v, got %v %v", ip, expected, in, err)
+		}
+	}
+	if _, err := ipset.Test("missing", &GoIPSetEntry{Set: &SetNet{IP: net.ParseIP("10.0.0.1")}}); err != unix.ENOENT {
+		t.Errorf("expected ENOENT for a missing set, got %v", err)
+	}
+}
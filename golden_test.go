@@ -0,0 +1,258 @@
+package goipset
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JiHanHuang/goipset/ipsettest"
+	"github.com/JiHanHuang/goipset/nl"
+	"golang.org/x/sys/unix"
+)
+
+var goldenRecord = flag.String("golden.record", "",
+	`record the sessions in testdata/golden again against "netlink", which needs ip_set and CAP_NET_ADMIN, or "fake" for ipsettest.Kernel, which marks them synthetic`)
+
+// runGolden replays the session recorded in testdata/golden/name for fn, or
+// records it again when asked to.
+func runGolden(t *testing.T, name string, fn func(t *testing.T, ipset *GoIpset)) {
+	path := filepath.Join("testdata", "golden", name)
+
+	var recorder *ipsettest.Recorder
+	switch *goldenRecord {
+	case "":
+		replayer, err := ipsettest.LoadReplayer(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fn(t, NewGoIpsetWithTransport(replayer))
+		if err := replayer.Done(); err != nil {
+			t.Error(err)
+		}
+		return
+	case "netlink":
+		recorder = ipsettest.NewRecorder(&NetlinkTransport{})
+	case "fake":
+		kernel := ipsettest.NewKernel()
+		kernel.Now = func() time.Time { return time.Unix(0, 0) }
+		recorder = ipsettest.NewRecorder(kernel)
+	default:
+		t.Fatalf("unknown transport %q to record with", *goldenRecord)
+	}
+
+	fn(t, NewGoIpsetWithTransport(recorder))
+	if t.Failed() {
+		return
+	}
+	if err := recorder.Err(); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if *goldenRecord == "fake" {
+		// Only set types the recording kernel lacks are recorded from the
+		// fake, the file has to tell it is no kernel's answer.
+		b.WriteString(syntheticGolden)
+	}
+	if err := ipsettest.WriteExchanges(&b, recorder.Exchanges()); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// syntheticGolden heads the sessions recorded from ipsettest.Kernel.
+const syntheticGolden = "# synthetic: recorded from ipsettest.Kernel, not from a kernel\n\n"
+
+func entryStrings(t *testing.T, ipset *GoIpset, setname string) []string {
+	t.Helper()
+	result, err := ipset.List(setname)
+	if err != nil {
+		t.Fatalf("listing %s failed: %v", setname, err)
+	}
+	entries := []string{}
+	for _, entry := range result.Entries {
+		entries = append(entries, entry.Set.String())
+	}
+	return entries
+}
+
+func expectEntries(t *testing.T, got []string, expected ...string) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Errorf("expected entries %v, got %v", expected, got)
+		return
+	}
+	seen := map[string]bool{}
+	for _, entry := range got {
+		seen[entry] = true
+	}
+	for _, entry := range expected {
+		if !seen[entry] {
+			t.Errorf("expected entries %v, got %v", expected, got)
+			return
+		}
+	}
+}
+
+func TestGoldenSetTypes(t *testing.T) {
+	tests := []struct {
+		name     string
+		typename string
+		options  GoIpsetCreateOptions
+		entries  []GoIPSetEntry
+		expected []string
+	}{
+		{
+			name:     "hash_ip",
+			typename: "hash:ip",
+			entries: []GoIPSetEntry{
+				{Set: &SetIP{IP: net.ParseIP("192.168.0.1")}},
+				{Set: &SetIP{IP: net.ParseIP("10.0.0.1"), IPTO: net.ParseIP("10.0.0.2")}},
+			},
+			expected: []string{"192.168.0.1", "10.0.0.1", "10.0.0.2"},
+		},
+		{
+			name:     "hash_ip6",
+			typename: "hash:ip",
+			options:  GoIpsetCreateOptions{Family: unix.AF_INET6},
+			entries: []GoIPSetEntry{
+				{Set: &SetIP{IP: net.ParseIP("2001:db8::1")}},
+			},
+			expected: []string{"2001:db8::1"},
+		},
+		{
+			name:     "hash_net",
+			typename: "hash:net",
+			entries: []GoIPSetEntry{
+				{Set: &SetNet{IP: net.ParseIP("10.0.0.0"), CIDR: 8}},
+				{Set: &SetNet{IP: net.ParseIP("192.168.1.0"), CIDR: 24}},
+			},
+			expected: []string{"10.0.0.0/8", "192.168.1.0/24"},
+		},
+		{
+			name:     "hash_ip_port",
+			typename: "hash:ip,port",
+			entries: []GoIPSetEntry{
+				{Set: &SetIPPort{IP: net.ParseIP("10.0.0.1"), Port: 80, Proto: unix.IPPROTO_TCP}},
+				{Set: &SetIPPort{IP: net.ParseIP("10.0.0.1"), Port: 53, Proto: unix.IPPROTO_UDP}},
+			},
+			expected: []string{"10.0.0.1,TCP:80", "10.0.0.1,UDP:53"},
+		},
+		{
+			name:     "hash_net_port",
+			typename: "hash:net,port",
+			entries: []GoIPSetEntry{
+				{Set: &SetNetPort{IP: net.ParseIP("10.1.0.0"), CIDR: 16, Port: 443, Proto: unix.IPPROTO_TCP}},
+			},
+			expected: []string{"10.1.0.0/16,TCP:443"},
+		},
+		{
+			name:     "hash_mac",
+			typename: "hash:mac",
+			entries: []GoIPSetEntry{
+				{Set: &SetMac{MAC: net.HardwareAddr{0x02, 0x42, 0xac, 0x11, 0x00, 0x02}}},
+			},
+			expected: []string{"02:42:ac:11:00:02"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			runGolden(t, test.name, func(t *testing.T, ipset *GoIpset) {
+				setname := "goipset-golden"
+				if err := ipset.Create(setname, test.typename, test.options); err != nil {
+					t.Fatal(err)
+				}
+				defer ipset.Destroy(setname)
+
+				for i := range test.entries {
+					if err := ipset.Add(setname, &test.entries[i]); err != nil {
+						t.Fatal(err)
+					}
+				}
+				expectEntries(t, entryStrings(t, ipset, setname), test.expected...)
+
+				if err := ipset.Del(setname, &test.entries[0]); err != nil {
+					t.Fatal(err)
+				}
+				if err := ipset.Flush(setname); err != nil {
+					t.Fatal(err)
+				}
+				expectEntries(t, entryStrings(t, ipset, setname))
+			})
+		})
+	}
+}
+
+func TestGoldenExtensions(t *testing.T) {
+	runGolden(t, "extensions", func(t *testing.T, ipset *GoIpset) {
+		setname := "goipset-golden"
+		err := ipset.Create(setname, "hash:ip", GoIpsetCreateOptions{
+			Timeout:  600,
+			Counters: true,
+			Comments: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ipset.Destroy(setname)
+
+		entry := GoIPSetEntry{
+			Set:     &SetIP{IP: net.ParseIP("10.0.0.1")},
+			Comment: "golden",
+			Timeout: 300,
+			Packets: 7,
+			Bytes:   420,
+		}
+		if err := ipset.Add(setname, &entry); err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := ipset.List(setname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.TypeName != "hash:ip" || result.Timeout != 600 {
+			t.Errorf("unexpected header %+v", result)
+		}
+		if len(result.Entries) != 1 {
+			t.Fatalf("expected 1 entry, got %d", len(result.Entries))
+		}
+		got := result.Entries[0]
+		// The timeout may have started running down while recording.
+		if got.Comment != "golden" || got.Packets != 7 || got.Bytes != 420 || got.Timeout == 0 || got.Timeout > 300 {
+			t.Errorf("unexpected entry %+v", got)
+		}
+	})
+}
+
+func TestGoldenErrors(t *testing.T) {
+	runGolden(t, "errors", func(t *testing.T, ipset *GoIpset) {
+		setname := "goipset-golden"
+		if _, err := ipset.List(setname); err != unix.ENOENT {
+			t.Errorf("expected listing a missing set to fail with ENOENT, got %v", err)
+		}
+		if err := ipset.Create(setname, "hash:ip", GoIpsetCreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		defer ipset.Destroy(setname)
+
+		if err := ipset.Create(setname, "hash:ip", GoIpsetCreateOptions{}); err != unix.EEXIST {
+			t.Errorf("expected creating a set twice to fail with EEXIST, got %v", err)
+		}
+		entry := GoIPSetEntry{Set: &SetIP{IP: net.ParseIP("10.0.0.1")}}
+		if err := ipset.Del(setname, &entry); err != nl.IPSetError(nl.IPSET_ERR_EXIST) {
+			t.Errorf("expected deleting a missing entry to fail with exist, got %v", err)
+		}
+		entry.Timeout = 10
+		if err := ipset.Add(setname, &entry); err != nl.IPSetError(nl.IPSET_ERR_TIMEOUT) {
+			t.Errorf("expected a timeout to fail without timeout support, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,772 @@
+//go:build integration
+// +build integration
+
+package goipset
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/JiHanHuang/goipset/nl"
+	"golang.org/x/sys/unix"
+)
+
+// The integration tests talk to the ip_set module of the running kernel.
+// They run in a new user and network namespace, so they neither need root
+// nor touch the sets of the host:
+//
+//	go test -tags integration -run Integration -v .
+//
+// They are skipped when the namespace can't be created or ip_set isn't
+// available in it. The ipset binary is used to cross check the library,
+// those checks are skipped when it isn't installed.
+
+const integrationEnv = "GOIPSET_INTEGRATION_NETNS"
+
+// inNamespace runs the calling test again in a child process inside a new
+// user and network namespace. It returns true in the child, where the test
+// should go on, and false in the parent, after reporting the outcome of
+// the child.
+func inNamespace(t *testing.T) bool {
+	if os.Getenv(integrationEnv) == t.Name() {
+		return true
+	}
+
+	args := []string{"-test.run=^" + t.Name() + "$", "-test.v"}
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), integrationEnv+"="+t.Name())
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET,
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getuid(), Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getgid(), Size: 1},
+		},
+	}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Start(); err != nil {
+		t.Skipf("creating a user and network namespace failed: %v", err)
+	}
+	err := cmd.Wait()
+	t.Log("\n" + out.String())
+	if err != nil {
+		t.Fatalf("running in the namespace failed: %v", err)
+	}
+	if strings.Contains(out.String(), "--- SKIP: "+t.Name()) {
+		t.Skip("skipped in the namespace")
+	}
+	return false
+}
+
+// requireIPSet skips the test if ip_set isn't usable in the namespace.
+func requireIPSet(t *testing.T) *GoIpset {
+	ipset := NewGoIpset()
+	if _, err := ipset.Protocol(); err != nil {
+		t.Skipf("ip_set is not available: %v", err)
+	}
+	return ipset
+}
+
+// ipsetSave returns the sets in `ipset save`, each parsed by UnmarshalText.
+// It returns false if the ipset binary isn't installed.
+func ipsetSave(t *testing.T) (map[string]GoIPSetResult, bool) {
+	path, err := exec.LookPath("ipset")
+	if err != nil {
+		return nil, false
+	}
+	out, err := exec.Command(path, "save").CombinedOutput()
+	if err != nil {
+		t.Fatalf("ipset save failed: %v\n%s", err, out)
+	}
+
+	sets := map[string]GoIPSetResult{}
+	var lines []string
+	parse := func() {
+		if len(lines) == 0 {
+			return
+		}
+		var result GoIPSetResult
+		if err := result.UnmarshalText([]byte(strings.Join(lines, "\n"))); err != nil {
+			t.Fatalf("parsing ipset save failed: %v\n%s", err, out)
+		}
+		sets[result.SetName] = result
+		lines = nil
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "create ") {
+			parse()
+		}
+		lines = append(lines, line)
+	}
+	parse()
+	return sets, true
+}
+
+// entryKeys returns the entries of a set in a form that is the same for
+// the library and ipset save: the element parsed by ParseEntry for the
+// type and family of the set, with the options ipset save prints, apart
+// from timeouts and counters, which change on their own.
+func entryKeys(t *testing.T, result GoIPSetResult) []string {
+	family := ""
+	if result.Family == unix.AF_INET6 {
+		family = "inet6"
+	}
+	keys := []string{}
+	for _, entry := range result.Entries {
+		set, err := ParseEntry(result.TypeName, family, entry.Set.String())
+		if err != nil {
+			t.Fatalf("set %s: %v", result.SetName, err)
+		}
+		entry.Set = set
+		entry.Timeout, entry.Packets, entry.Bytes = 0, 0, 0
+		keys = append(keys, saveEntry(&GoIPSetResult{}, &entry))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func libraryEntries(t *testing.T, ipset *GoIpset) map[string][]string {
+	results, err := ipset.ListAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets := map[string][]string{}
+	for _, result := range results {
+		sets[result.SetName] = entryKeys(t, result)
+	}
+	return sets
+}
+
+func compareWithSave(t *testing.T, ipset *GoIpset) {
+	t.Helper()
+	results, err := ipset.ListAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, ok := ipsetSave(t)
+	if !ok {
+		t.Log("ipset binary not found, not comparing with ipset save")
+		return
+	}
+	if len(results) != len(saved) {
+		t.Errorf("library lists %d sets, ipset save %d", len(results), len(saved))
+	}
+	for _, result := range results {
+		s, ok := saved[result.SetName]
+		if !ok {
+			t.Errorf("set %s is missing in ipset save", result.SetName)
+			continue
+		}
+		if s.TypeName != result.TypeName || s.Family != result.Family {
+			t.Errorf("set %s: library lists %s family %d, ipset save %s family %d",
+				result.SetName, result.TypeName, result.Family, s.TypeName, s.Family)
+		}
+		library, c := entryKeys(t, result), entryKeys(t, s)
+		if strings.Join(library, "\n") != strings.Join(c, "\n") {
+			t.Errorf("set %s: library lists %q, ipset save %q", result.SetName, library, c)
+		}
+	}
+}
+
+func TestIntegrationSetTypes(t *testing.T) {
+	if !inNamespace(t) {
+		return
+	}
+	ipset := requireIPSet(t)
+
+	tests := []struct {
+		name     string
+		typename string
+		family   int
+		entries  []Set
+		expected []string
+	}{
+		{"hash_ip", "hash:ip", unix.AF_INET, []Set{
+			&SetIP{IP: net.ParseIP("1.1.1.1")},
+			&SetIP{IP: net.ParseIP("1.1.1.3"), IPTO: net.ParseIP("1.1.1.6")},
+		}, []string{"1.1.1.1", "1.1.1.3", "1.1.1.4", "1.1.1.5", "1.1.1.6"}},
+		{"hash_ip_port", "hash:ip,port", unix.AF_INET, []Set{
+			&SetIPPort{IP: net.ParseIP("1.1.1.1"), Port: 80, Proto: unix.IPPROTO_TCP},
+			&SetIPPort{IP: net.ParseIP("1.1.1.4"), IPTO: net.ParseIP("1.1.1.5"), Port: 80, Proto: unix.IPPROTO_UDP},
+			&SetIPPort{IP: net.ParseIP("1.1.1.6"), Port: 8 << 8, Proto: unix.IPPROTO_ICMP},
+			&SetIPPort{IP: net.ParseIP("1.1.1.6"), Port: 9899, Proto: unix.IPPROTO_SCTP},
+			&SetIPPort{IP: net.ParseIP("1.1.1.6"), Proto: unix.IPPROTO_GRE},
+		}, []string{"1.1.1.1,tcp:80", "1.1.1.4,udp:80", "1.1.1.5,udp:80",
+			"1.1.1.6,icmp:echo-request", "1.1.1.6,sctp:9899", "1.1.1.6,gre:0"}},
+		{"hash_net", "hash:net", unix.AF_INET, []Set{
+			&SetNet{IP: net.ParseIP("1.1.1.0"), CIDR: 24},
+			&SetNet{IP: net.ParseIP("1.1.2.1"), CIDR: 32},
+		}, []string{"1.1.1.0/24", "1.1.2.1"}},
+		{"hash_net_port", "hash:net,port", unix.AF_INET, []Set{
+			&SetNetPort{IP: net.ParseIP("1.1.1.0"), CIDR: 24, Port: 80, Proto: unix.IPPROTO_TCP},
+			&SetNetPort{IP: net.ParseIP("1.1.2.0"), CIDR: 24, Port: 88, Proto: unix.IPPROTO_UDP},
+		}, []string{"1.1.1.0/24,tcp:80", "1.1.2.0/24,udp:88"}},
+		{"hash_mac", "hash:mac", unix.AF_UNSPEC, []Set{
+			&SetMac{MAC: net.HardwareAddr{0x02, 0x42, 0xac, 0x11, 0x00, 0x02}},
+		}, []string{"02:42:ac:11:00:02"}},
+		{"hash_ipv6", "hash:ip", unix.AF_INET6, []Set{
+			&SetIP{IP: net.ParseIP("fe80::250:56ff:fea9:1cd4")},
+			&SetIP{IP: net.ParseIP("fe80::250:56ff:fea9:1cd5")},
+		}, []string{"fe80::250:56ff:fea9:1cd4", "fe80::250:56ff:fea9:1cd5"}},
+		{"hash_ipv6_port", "hash:ip,port", unix.AF_INET6, []Set{
+			&SetIPPort{IP: net.ParseIP("fe80::250:56ff:fea9:1cd4"), Port: 80, Proto: unix.IPPROTO_TCP},
+			&SetIPPort{IP: net.ParseIP("fe80::250:56ff:fea9:1cd4"), Port: 128 << 8, Proto: unix.IPPROTO_ICMPV6},
+		}, []string{"fe80::250:56ff:fea9:1cd4,tcp:80", "fe80::250:56ff:fea9:1cd4,icmpv6:echo-request"}},
+		{"hash_net_v6", "hash:net", unix.AF_INET6, []Set{
+			&SetNet{IP: net.ParseIP("fe80:2510::"), CIDR: 64},
+			&SetNet{IP: net.ParseIP("fe80:2520::1"), IPTO: net.ParseIP("fe80:2520::6")},
+		}, []string{"fe80:2510::/64", "fe80:2520::1", "fe80:2520::2/127", "fe80:2520::4/127", "fe80:2520::6"}},
+		{"hash_net_port_v6", "hash:net,port", unix.AF_INET6, []Set{
+			&SetNetPort{IP: net.ParseIP("fe80:2511::"), CIDR: 64, Port: 88, Proto: unix.IPPROTO_UDP},
+		}, []string{"fe80:2511::/64,udp:88"}},
+	}
+
+	created := tests[:0]
+	for _, test := range tests {
+		err := ipset.Create(test.name, test.typename, GoIpsetCreateOptions{Family: test.family})
+		if errors.Is(err, nl.IPSetError(nl.IPSET_ERR_FIND_TYPE)) {
+			t.Logf("the kernel doesn't support %s, skipping it", test.typename)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("creating %s failed: %v", test.name, err)
+		}
+		for _, set := range test.entries {
+			if err := ipset.Add(test.name, &GoIPSetEntry{Set: set}); err != nil {
+				t.Fatalf("adding %s to %s failed: %v", set, test.name, err)
+			}
+		}
+		created = append(created, test)
+	}
+	tests = created
+
+	listed := libraryEntries(t, ipset)
+	for _, test := range tests {
+		sort.Strings(test.expected)
+		if got := strings.Join(listed[test.name], " "); got != strings.Join(test.expected, " ") {
+			t.Errorf("set %s: expected %v, got %v", test.name, test.expected, listed[test.name])
+		}
+	}
+	compareWithSave(t, ipset)
+
+	for _, test := range tests {
+		if err := ipset.Del(test.name, &GoIPSetEntry{Set: test.entries[0]}); err != nil {
+			t.Errorf("deleting %s from %s failed: %v", test.entries[0], test.name, err)
+		}
+	}
+	compareWithSave(t, ipset)
+
+	for _, test := range tests {
+		if err := ipset.Flush(test.name); err != nil {
+			t.Errorf("flushing %s failed: %v", test.name, err)
+		}
+		result, err := ipset.List(test.name)
+		if err != nil || len(result.Entries) != 0 {
+			t.Errorf("expected %s to be empty after flushing, got %v and %v", test.name, result.Entries, err)
+		}
+		if err := ipset.Destroy(test.name); err != nil {
+			t.Errorf("destroying %s failed: %v", test.name, err)
+		}
+	}
+	if results, err := ipset.ListAll(); err != nil || len(results) != 0 {
+		t.Errorf("expected no sets to be left, got %v and %v", results, err)
+	}
+}
+
+func TestIntegrationExtensions(t *testing.T) {
+	if !inNamespace(t) {
+		return
+	}
+	ipset := requireIPSet(t)
+
+	err := ipset.Create("extensions", "hash:ip", GoIpsetCreateOptions{
+		Timeout:  600,
+		Counters: true,
+		Comments: true,
+		Skbinfo:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ipset.Destroy("extensions")
+
+	entry := GoIPSetEntry{
+		Set:     &SetIP{IP: net.ParseIP("10.0.0.1")},
+		Timeout: 300,
+		Packets: 7,
+		Bytes:   420,
+		Comment: "integration",
+		SkbMark: 0x20,
+		SkbPrio: 1<<16 | 5,
+	}
+	if err := ipset.Add("extensions", &entry); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ipset.List("extensions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Timeout != 600 {
+		t.Errorf("expected set timeout 600, got %d", result.Timeout)
+	}
+	if len(result.Entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(result.Entries))
+	}
+	got := result.Entries[0]
+	if got.Comment != "integration" || got.Packets != 7 || got.Bytes != 420 || got.Timeout == 0 || got.Timeout > 300 {
+		t.Errorf("unexpected entry %+v", got)
+	}
+	if got.SkbMark != 0x20 || got.SkbMask != 0xffffffff || got.SkbPrio != 1<<16|5 {
+		t.Errorf("unexpected skbinfo %+v", got)
+	}
+
+	var out bytes.Buffer
+	if err := WriteSave(&out, result); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 ||
+		!strings.HasPrefix(lines[0], "create extensions hash:ip family inet hashsize 1024 maxelem 65536 timeout 600 counters comment skbinfo") ||
+		!strings.HasPrefix(lines[1], "add extensions 10.0.0.1 timeout ") ||
+		!strings.HasSuffix(lines[1], ` packets 7 bytes 420 comment "integration" skbmark 0x20 skbprio 1:5`) {
+		t.Errorf("unexpected save output\n%s", out.String())
+	}
+	compareWithSave(t, ipset)
+}
+
+func TestIntegrationErrors(t *testing.T) {
+	if !inNamespace(t) {
+		return
+	}
+	ipset := requireIPSet(t)
+
+	if _, err := ipset.List("missing"); err != unix.ENOENT {
+		t.Errorf("expected listing a missing set to fail with ENOENT, got %v", err)
+	}
+	if err := ipset.Create("errors", "hash:ip", GoIpsetCreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	defer ipset.Destroy("errors")
+
+	if err := ipset.Create("errors", "hash:ip", GoIpsetCreateOptions{}); !errors.Is(err, unix.EEXIST) {
+		t.Errorf("expected creating a set twice to fail with EEXIST, got %v", err)
+	}
+	entry := GoIPSetEntry{Set: &SetIP{IP: net.ParseIP("10.0.0.1")}}
+	if err := ipset.Add("errors", &entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipset.Add("errors", &entry); !errors.Is(err, nl.IPSetError(nl.IPSET_ERR_EXIST)) {
+		t.Errorf("expected adding an entry twice to fail with exist, got %v", err)
+	}
+	entry.Timeout = 10
+	if err := ipset.Add("errors", &entry); !errors.Is(err, nl.IPSetError(nl.IPSET_ERR_TIMEOUT)) {
+		t.Errorf("expected a timeout to fail without timeout support, got %v", err)
+	}
+	v6 := GoIPSetEntry{Set: &SetIP{IP: net.ParseIP("::1")}}
+	if err := ipset.Add("errors", &v6); err == nil {
+		t.Errorf("expected adding an IPv6 address to an IPv4 set to fail")
+	}
+}
+
+func TestIntegrationLoad(t *testing.T) {
+	if !inNamespace(t) {
+		return
+	}
+	ipset := requireIPSet(t)
+
+	if err := ipset.Create("load", "hash:net", GoIpsetCreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	defer ipset.Destroy("load")
+
+	var entries []GoIPSetEntry
+	for i := 0; i < 256; i++ {
+		entries = append(entries, GoIPSetEntry{Set: &SetNet{IP: net.IPv4(10, 1, byte(i), 0), CIDR: 24}})
+	}
+	entries = append(entries, GoIPSetEntry{Set: &SetNet{IP: net.ParseIP("10.1.7.0"), CIDR: 28}, NoMatch: true})
+	report, err := ipset.Load("load", entries, LoadOptions{Aggregate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (LoadReport{Entries: 257, Loaded: 2, Saved: 255}) {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	result, err := ipset.List("load")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for _, entry := range result.Entries {
+		got[entry.Set.String()] = entry.NoMatch
+	}
+	if len(got) != 2 || got["10.1.0.0/16"] || !got["10.1.7.0/28"] {
+		t.Errorf("expected 10.1.0.0/16 and the exception 10.1.7.0/28, got %v", got)
+	}
+	compareWithSave(t, ipset)
+}
+
+func TestIntegrationSync(t *testing.T) {
+	if !inNamespace(t) {
+		return
+	}
+	ipset := requireIPSet(t)
+
+	if err := ipset.Create("sync", "hash:net,port", GoIpsetCreateOptions{Comments: true}); err != nil {
+		t.Fatal(err)
+	}
+	defer ipset.Destroy("sync")
+	old := GoIPSetEntry{Set: &SetNetPort{IP: net.ParseIP("10.9.0.0"), CIDR: 16, Port: 22, Proto: unix.IPPROTO_TCP}}
+	if err := ipset.Add("sync", &old); err != nil {
+		t.Fatal(err)
+	}
+
+	desired := []GoIPSetEntry{
+		{Set: &SetNetPort{IP: net.ParseIP("10.0.0.1"), IPTO: net.ParseIP("10.0.0.6"), Port: 80, PortTo: 81, Proto: unix.IPPROTO_TCP}},
+		{Set: &SetNetPort{IP: net.ParseIP("10.1.2.3"), CIDR: 24, Port: 53, Proto: unix.IPPROTO_UDP}, Comment: "dns"},
+	}
+	report, err := ipset.Sync("sync", desired, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 10.0.0.1/32, 10.0.0.2/31, 10.0.0.4/31, 10.0.0.6/32 for two ports.
+	if report != (SyncReport{Added: 9, Deleted: 1}) {
+		t.Errorf("unexpected report %+v", report)
+	}
+	report, err = ipset.Sync("sync", desired, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (SyncReport{Unchanged: 9}) {
+		t.Errorf("expected nothing to change, got %+v", report)
+	}
+	compareWithSave(t, ipset)
+}
+
+func TestIntegrationReplace(t *testing.T) {
+	if !inNamespace(t) {
+		return
+	}
+	ipset := requireIPSet(t)
+
+	options := GoIpsetCreateOptions{Counters: true, HashSize: 2048, MaxElem: 500, BucketSize: 4}
+	if err := ipset.Create("replace", "hash:ip", options); err != nil {
+		t.Fatal(err)
+	}
+	defer ipset.Destroy("replace")
+	if err := ipset.Add("replace", &GoIPSetEntry{Set: &SetIP{IP: net.ParseIP("10.0.0.1")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	header, err := ipset.ListHeader("replace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.HashSize != 2048 || header.MaxElements != 500 || header.NumEntries != 1 || len(header.Entries) != 0 {
+		t.Errorf("unexpected header %+v", header)
+	}
+
+	entries := []GoIPSetEntry{
+		{Set: &SetIP{IP: net.ParseIP("10.0.0.2")}},
+		{Set: &SetIP{IP: net.ParseIP("10.0.0.3")}, Packets: 5, Bytes: 50},
+	}
+	if err := ipset.Replace("replace", entries); err != nil {
+		t.Fatal(err)
+	}
+	result, err := ipset.List("replace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range result.Entries {
+		got = append(got, entry.Set.String())
+	}
+	expectEntries(t, got, "10.0.0.2", "10.0.0.3")
+	if result.HashSize != 2048 || result.MaxElements != 500 || result.CadtFlags&nl.IPSET_FLAG_WITH_COUNTERS == 0 {
+		t.Errorf("expected the options to be kept, got %+v", result)
+	}
+	all, err := ipset.ListAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, set := range all {
+		if strings.Contains(set.SetName, "~") {
+			t.Errorf("expected the temporary set %s to be destroyed", set.SetName)
+		}
+	}
+	compareWithSave(t, ipset)
+}
+
+func TestIntegrationRestore(t *testing.T) {
+	if !inNamespace(t) {
+		return
+	}
+	ipset := requireIPSet(t)
+
+	// More entries than fit in a batch, the kernel tells the line of the
+	// duplicate in the second one.
+	var input strings.Builder
+	input.WriteString("create restore hash:net comment\n")
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&input, "add restore 10.%d.0.0/16 comment \"net %d\"\n", i, i)
+	}
+	input.WriteString("add restore 10.150.0.0/16\nCOMMIT\n")
+	defer ipset.Destroy("restore")
+
+	err := ipset.Restore(strings.NewReader(input.String()), RestoreOptions{})
+	var restoreErr *RestoreError
+	if !errors.As(err, &restoreErr) || restoreErr.Line != 202 || !errors.Is(err, nl.IPSetError(nl.IPSET_ERR_EXIST)) {
+		t.Fatalf("expected line 202 to fail with IPSET_ERR_EXIST, got %v", err)
+	}
+	if err := ipset.Restore(strings.NewReader(input.String()), RestoreOptions{Exist: true}); err != nil {
+		t.Fatal(err)
+	}
+	result, err := ipset.List("restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Entries) != 200 {
+		t.Errorf("expected 200 entries, got %d", len(result.Entries))
+	}
+	// The comments have spaces, which ipset save quotes.
+	compareWithSave(t, ipset)
+
+	var out bytes.Buffer
+	if err := WriteSave(&out, result); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipset.Destroy("restore"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipset.Restore(&out, RestoreOptions{}); err != nil {
+		t.Errorf("expected the saved set to restore, got %v", err)
+	}
+}
+
+func TestIntegrationListNames(t *testing.T) {
+	if !inNamespace(t) {
+		return
+	}
+	ipset := requireIPSet(t)
+
+	for _, name := range []string{"names-a", "names-b"} {
+		if err := ipset.Create(name, "hash:ip", GoIpsetCreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		defer ipset.Destroy(name)
+		if err := ipset.Add(name, &GoIPSetEntry{Set: &SetIP{IP: net.ParseIP("10.0.0.1")}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	in, err := ipset.Test("names-a", &GoIPSetEntry{Set: &SetIP{IP: net.ParseIP("10.0.0.1")}})
+	if err != nil || !in {
+		t.Errorf("expected 10.0.0.1 to be in names-a, got %v %v", in, err)
+	}
+	in, err = ipset.Test("names-a", &GoIPSetEntry{Set: &SetIP{IP: net.ParseIP("10.0.0.2")}})
+	if err != nil || in {
+		t.Errorf("expected 10.0.0.2 not to be in names-a, got %v %v", in, err)
+	}
+
+	names, err := ipset.ListNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if strings.Join(names, " ") != "names-a names-b" {
+		t.Errorf("expected names-a and names-b, got %v", names)
+	}
+	headers, err := ipset.ListAllHeaders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 2 || headers[0].NumEntries != 1 || len(headers[0].Entries) != 0 || headers[0].TypeName != "hash:ip" {
+		t.Errorf("unexpected headers %+v", headers)
+	}
+}
+
+func TestIntegrationSocketTransport(t *testing.T) {
+	if !inNamespace(t) {
+		return
+	}
+	requireIPSet(t)
+	transport, err := NewSocketTransport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+	ipset := NewGoIpsetWithTransport(transport)
+
+	if err := ipset.Create("socket", "hash:ip", GoIpsetCreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	defer ipset.Destroy("socket")
+	var entries []*GoIPSetEntry
+	for i := 0; i < 300; i++ {
+		entries = append(entries, &GoIPSetEntry{Set: &SetIP{IP: net.IPv4(10, 0, byte(i>>8), byte(i))}})
+	}
+	if err := ipset.ipsetAddDelBatch(nl.IPSET_CMD_ADD, "socket", entries, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The errors and the dumps stopped early must not leave replies behind
+	// for the requests after them on the socket.
+	if err := ipset.Add("socket", entries[0]); !errors.Is(err, nl.IPSetError(nl.IPSET_ERR_EXIST)) {
+		t.Errorf("expected IPSET_ERR_EXIST, got %v", err)
+	}
+	if err := ipset.Create("socket", "hash:ip", GoIpsetCreateOptions{}); !errors.Is(err, unix.EEXIST) {
+		t.Errorf("expected EEXIST, got %v", err)
+	}
+	n := 0
+	err = ipset.ListEach("socket", func(GoIPSetEntry) error {
+		if n++; n == 10 {
+			return ErrStopList
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		result, err := ipset.List("socket")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Entries) != len(entries) {
+			t.Errorf("expected %d entries, got %d", len(entries), len(result.Entries))
+		}
+	}
+}
+
+func TestIntegrationCombine(t *testing.T) {
+	if !inNamespace(t) {
+		return
+	}
+	ipset := requireIPSet(t)
+
+	for name, nets := range map[string][]string{
+		"combine-feed":  {"10.0.0.0/24", "192.168.0.0/24"},
+		"combine-allow": {"10.0.0.0/16", "!10.0.0.0/25", "192.168.0.0/16"},
+	} {
+		if err := ipset.Create(name, "hash:net", GoIpsetCreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		defer ipset.Destroy(name)
+		if _, err := ipset.Load(name, netEntries(t, nets...), LoadOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first time the set is created, the second time replaced.
+	defer ipset.Destroy("combine-diff")
+	for i := 0; i < 2; i++ {
+		if _, err := ipset.CombineSets(OpDifference, "combine-feed", "combine-allow", CombineOptions{Into: "combine-diff"}); err != nil {
+			t.Fatal(err)
+		}
+		result, err := ipset.List("combine-diff")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(netStrings(result.Entries), " "); got != "10.0.0.0/25" {
+			t.Errorf("expected 10.0.0.0/25, got %s", got)
+		}
+	}
+}
+
+func TestIntegrationLookup(t *testing.T) {
+	if !inNamespace(t) {
+		return
+	}
+	ipset := requireIPSet(t)
+
+	if err := ipset.Create("lookup", "hash:net", GoIpsetCreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	defer ipset.Destroy("lookup")
+	if _, err := ipset.Load("lookup", netEntries(t, "10.0.0.0/8", "!10.1.0.0/16", "10.1.2.0/24", "10.1.2.3"), LoadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	result, err := ipset.List("lookup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := NewLookupIndex(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The index agrees with the test of the kernel.
+	for _, text := range []string{"10.9.9.9", "10.1.9.9", "10.1.2.9", "10.1.2.3", "11.0.0.1"} {
+		ip := net.ParseIP(text)
+		in, err := ipset.Test("lookup", &GoIPSetEntry{Set: &SetNet{IP: ip}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, matched := index.Lookup(ip, 0, 0); matched != in {
+			t.Errorf("%s: expected %v, got %v", text, in, matched)
+		}
+	}
+}
+
+func TestIntegrationNetMask(t *testing.T) {
+	if !inNamespace(t) {
+		return
+	}
+	ipset := requireIPSet(t)
+
+	if err := ipset.Create("netmask", "hash:ip", GoIpsetCreateOptions{NetMask: 24}); err != nil {
+		t.Fatal(err)
+	}
+	defer ipset.Destroy("netmask")
+	result, err := ipset.List("netmask")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved bytes.Buffer
+	if err := WriteSave(&saved, result); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipset.Destroy("netmask"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipset.Restore(bytes.NewReader(saved.Bytes()), RestoreOptions{}); err != nil {
+		t.Fatalf("restoring %q failed: %v", saved.String(), err)
+	}
+	header, err := ipset.ListHeader("netmask")
+	if err != nil || header.NetMask != 24 {
+		t.Errorf("expected netmask 24, got %+v %v", header, err)
+	}
+
+	// The kernel stores addresses by their network, which Sync has to
+	// compare them by, also when it has to ask for the netmask.
+	desired := []GoIPSetEntry{{Set: &SetIP{IP: net.ParseIP("10.0.0.5")}}}
+	for i, ipset := range []*GoIpset{ipset, ipset, NewGoIpset()} {
+		report, err := ipset.Sync("netmask", desired, SyncOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := SyncReport{Unchanged: 1}
+		if i == 0 {
+			expected = SyncReport{Added: 1}
+		}
+		if report != expected {
+			t.Errorf("sync %d: expected %+v, got %+v", i, expected, report)
+		}
+	}
+	compareWithSave(t, ipset)
+
+	if err := ipset.Create("netmask_net", "hash:net", GoIpsetCreateOptions{NetMask: 24}); err == nil || err.Error() != "hash:net does not accept netmask" {
+		t.Errorf("expected hash:net to refuse a netmask, got %v", err)
+	}
+}
@@ -0,0 +1,530 @@
+// Package ipsettest provides an in-memory stand-in for the ipset kernel
+// module, so that code using goipset can be tested without privileges.
+//
+//	k := ipsettest.NewKernel()
+//	ipset := goipset.NewGoIpsetWithTransport(k)
+package ipsettest
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/JiHanHuang/goipset/nl"
+	"golang.org/x/sys/unix"
+)
+
+// Kernel decodes ipset netlink requests and keeps the sets they create in
+// memory. It supports hash:ip, hash:net, hash:ip,port, hash:net,port and
+// hash:mac with timeouts, counters, comments and skbinfo, and answers with
+// the same messages and errors as the kernel. It implements
+// goipset.Transport and is safe for concurrent use.
+type Kernel struct {
+	// Now returns the current time, entries time out relative to it.
+	Now func() time.Time
+	// EntriesPerMessage is how many entries a list reply carries per
+	// netlink message, larger sets are split like the kernel does.
+	EntriesPerMessage int
+
+	mu   sync.Mutex
+	sets []*set
+	seq  uint64
+}
+
+// NewKernel returns a Kernel without any sets.
+func NewKernel() *Kernel {
+	return &Kernel{
+		Now:               time.Now,
+		EntriesPerMessage: 64,
+	}
+}
+
+// Execute handles a single request, see goipset.Transport.
+func (k *Kernel) Execute(req *nl.NetlinkRequest, fn func(msg []byte) error, restart func() error) error {
+	b := req.Serialize()
+	if len(b) < unix.SizeofNlMsghdr+nl.SizeofNfgenmsg {
+		return syscall.EINVAL
+	}
+	native := nl.NativeEndian()
+	msgType := native.Uint16(b[4:6])
+	flags := native.Uint16(b[6:8])
+	if msgType>>8 != unix.NFNL_SUBSYS_IPSET {
+		return syscall.EINVAL
+	}
+
+	attrs, err := parseAttrs(b[unix.SizeofNlMsghdr+nl.SizeofNfgenmsg:])
+	if err != nil {
+		return err
+	}
+	if protocol, ok := attrs[nl.IPSET_ATTR_PROTOCOL]; !ok || len(protocol.Value) != 1 || protocol.Value[0] != nl.IPSET_PROTOCOL {
+		return syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+	}
+
+	k.mu.Lock()
+	replies, err := k.handle(int(msgType&0xff), flags, attrs)
+	k.mu.Unlock()
+	if lineErr, ok := err.(*lineError); ok {
+		// Like the kernel, tell the line number of the entry of a batch
+		// that failed in the echoed request.
+		if !req.EchoErrors {
+			return lineErr.err
+		}
+		return &nl.RequestError{Err: lineErr.err, Request: withLineno(b, lineErr.lineno)}
+	}
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if err := fn(reply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (k *Kernel) handle(cmd int, flags uint16, attrs map[uint16]nl.Attribute) ([][]byte, error) {
+	exist := flags&unix.NLM_F_EXCL == 0
+
+	switch cmd {
+	case nl.IPSET_CMD_PROTOCOL:
+		return [][]byte{message(
+			nl.NewRtAttr(nl.IPSET_ATTR_PROTOCOL, nl.Uint8Attr(nl.IPSET_PROTOCOL)),
+			nl.NewRtAttr(nl.IPSET_ATTR_PROTOCOL_MIN, nl.Uint8Attr(nl.IPSET_PROTOCOL)),
+		)}, nil
+	case nl.IPSET_CMD_TYPE:
+		return k.cmdType(attrs)
+	case nl.IPSET_CMD_CREATE:
+		return nil, k.cmdCreate(attrs, exist)
+	case nl.IPSET_CMD_DESTROY:
+		return nil, k.cmdDestroy(attrs)
+	case nl.IPSET_CMD_FLUSH:
+		return nil, k.cmdFlush(attrs)
+	case nl.IPSET_CMD_RENAME:
+		return nil, k.cmdRename(attrs)
+	case nl.IPSET_CMD_SWAP:
+		return nil, k.cmdSwap(attrs)
+	case nl.IPSET_CMD_LIST, nl.IPSET_CMD_SAVE:
+		return k.cmdList(attrs)
+	case nl.IPSET_CMD_HEADER:
+		return k.cmdHeader(attrs)
+	case nl.IPSET_CMD_ADD, nl.IPSET_CMD_DEL, nl.IPSET_CMD_TEST:
+		return nil, k.cmdADT(cmd, attrs, exist)
+	}
+	return nil, syscall.EOPNOTSUPP
+}
+
+func (k *Kernel) cmdType(attrs map[uint16]nl.Attribute) ([][]byte, error) {
+	name, ok := stringAttr(attrs, nl.IPSET_ATTR_TYPENAME)
+	if !ok {
+		return nil, syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+	}
+	family, ok := uint8Attr(attrs, nl.IPSET_ATTR_FAMILY)
+	if !ok {
+		return nil, syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+	}
+	typ, ok := setTypes[name]
+	if !ok {
+		return nil, syscall.Errno(nl.IPSET_ERR_FIND_TYPE)
+	}
+	return [][]byte{message(
+		nl.NewRtAttr(nl.IPSET_ATTR_PROTOCOL, nl.Uint8Attr(nl.IPSET_PROTOCOL)),
+		nl.NewRtAttr(nl.IPSET_ATTR_TYPENAME, nl.ZeroTerminated(name)),
+		nl.NewRtAttr(nl.IPSET_ATTR_FAMILY, nl.Uint8Attr(family)),
+		nl.NewRtAttr(nl.IPSET_ATTR_REVISION, nl.Uint8Attr(typ.revisionMax)),
+		nl.NewRtAttr(nl.IPSET_ATTR_REVISION_MIN, nl.Uint8Attr(typ.revisionMin)),
+	)}, nil
+}
+
+func (k *Kernel) cmdCreate(attrs map[uint16]nl.Attribute, exist bool) error {
+	name, ok := setName(attrs, nl.IPSET_ATTR_SETNAME)
+	if !ok {
+		return syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+	}
+	typeName, ok1 := stringAttr(attrs, nl.IPSET_ATTR_TYPENAME)
+	revision, ok2 := uint8Attr(attrs, nl.IPSET_ATTR_REVISION)
+	family, ok3 := uint8Attr(attrs, nl.IPSET_ATTR_FAMILY)
+	data, ok4 := attrs[nl.IPSET_ATTR_DATA]
+	if !ok1 || !ok2 || !ok3 || !ok4 {
+		return syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+	}
+	typ, ok := setTypes[typeName]
+	if !ok || revision < typ.revisionMin || revision > typ.revisionMax {
+		return syscall.Errno(nl.IPSET_ERR_FIND_TYPE)
+	}
+	if !typ.supportsFamily(family) {
+		return syscall.Errno(nl.IPSET_ERR_INVALID_FAMILY)
+	}
+
+	s := &set{
+		name:       name,
+		typ:        typ,
+		revision:   revision,
+		family:     family,
+		hashSize:   1024,
+		maxElem:    65536,
+		bucketSize: 12,
+		initval:    initval(name),
+		members:    map[elemKey]*member{},
+	}
+	if err := s.parseCreateData(data.Value); err != nil {
+		return err
+	}
+
+	if old := k.find(name); old != nil {
+		if !exist || !old.sameSet(s) {
+			return syscall.EEXIST
+		}
+		return nil
+	}
+	k.sets = append(k.sets, s)
+	return nil
+}
+
+// initval stands in for the random hash seed of the kernel, it is derived
+// from the set name to keep recordings reproducible.
+func initval(name string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return h.Sum32()
+}
+
+func (k *Kernel) cmdDestroy(attrs map[uint16]nl.Attribute) error {
+	if _, ok := attrs[nl.IPSET_ATTR_SETNAME]; !ok {
+		k.sets = nil
+		return nil
+	}
+	name, _ := setName(attrs, nl.IPSET_ATTR_SETNAME)
+	for i, s := range k.sets {
+		if s.name == name {
+			k.sets = append(k.sets[:i], k.sets[i+1:]...)
+			return nil
+		}
+	}
+	return syscall.ENOENT
+}
+
+func (k *Kernel) cmdFlush(attrs map[uint16]nl.Attribute) error {
+	if _, ok := attrs[nl.IPSET_ATTR_SETNAME]; !ok {
+		for _, s := range k.sets {
+			s.flush()
+		}
+		return nil
+	}
+	name, _ := setName(attrs, nl.IPSET_ATTR_SETNAME)
+	s := k.find(name)
+	if s == nil {
+		return syscall.ENOENT
+	}
+	s.flush()
+	return nil
+}
+
+func (k *Kernel) cmdRename(attrs map[uint16]nl.Attribute) error {
+	from, ok1 := setName(attrs, nl.IPSET_ATTR_SETNAME)
+	to, ok2 := setName(attrs, nl.IPSET_ATTR_SETNAME2)
+	if !ok1 || !ok2 {
+		return syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+	}
+	s := k.find(from)
+	if s == nil {
+		return syscall.ENOENT
+	}
+	if k.find(to) != nil {
+		return syscall.Errno(nl.IPSET_ERR_EXIST_SETNAME2)
+	}
+	s.name = to
+	return nil
+}
+
+func (k *Kernel) cmdSwap(attrs map[uint16]nl.Attribute) error {
+	from, ok1 := setName(attrs, nl.IPSET_ATTR_SETNAME)
+	to, ok2 := setName(attrs, nl.IPSET_ATTR_SETNAME2)
+	if !ok1 || !ok2 {
+		return syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+	}
+	a, b := k.find(from), k.find(to)
+	if a == nil {
+		return syscall.ENOENT
+	}
+	if b == nil {
+		return syscall.Errno(nl.IPSET_ERR_EXIST_SETNAME2)
+	}
+	if a.typ != b.typ || a.family != b.family {
+		return syscall.Errno(nl.IPSET_ERR_TYPE_MISMATCH)
+	}
+	a.name, b.name = b.name, a.name
+	return nil
+}
+
+func (k *Kernel) cmdHeader(attrs map[uint16]nl.Attribute) ([][]byte, error) {
+	name, ok := setName(attrs, nl.IPSET_ATTR_SETNAME)
+	if !ok {
+		return nil, syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+	}
+	s := k.find(name)
+	if s == nil {
+		return nil, syscall.ENOENT
+	}
+	return [][]byte{message(
+		nl.NewRtAttr(nl.IPSET_ATTR_PROTOCOL, nl.Uint8Attr(nl.IPSET_PROTOCOL)),
+		nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated(s.name)),
+		nl.NewRtAttr(nl.IPSET_ATTR_TYPENAME, nl.ZeroTerminated(s.typ.name)),
+		nl.NewRtAttr(nl.IPSET_ATTR_FAMILY, nl.Uint8Attr(s.family)),
+		nl.NewRtAttr(nl.IPSET_ATTR_REVISION, nl.Uint8Attr(s.revision)),
+	)}, nil
+}
+
+func (k *Kernel) cmdList(attrs map[uint16]nl.Attribute) ([][]byte, error) {
+	sets := k.sets
+	if _, ok := attrs[nl.IPSET_ATTR_SETNAME]; ok {
+		name, _ := setName(attrs, nl.IPSET_ATTR_SETNAME)
+		s := k.find(name)
+		if s == nil {
+			return nil, syscall.ENOENT
+		}
+		sets = []*set{s}
+	}
+	var flags uint32
+	if attr, ok := attrs[nl.IPSET_ATTR_FLAGS]; ok {
+		if len(attr.Value) != 4 {
+			return nil, syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+		}
+		flags = binary.BigEndian.Uint32(attr.Value)
+	}
+
+	now := k.Now()
+	perMessage := k.EntriesPerMessage
+	if perMessage <= 0 {
+		perMessage = 1
+	}
+
+	var replies [][]byte
+	for _, s := range sets {
+		s.expire(now)
+		members := s.sortedMembers()
+
+		first := true
+		for first || len(members) > 0 {
+			msg := []*nl.RtAttr{
+				nl.NewRtAttr(nl.IPSET_ATTR_PROTOCOL, nl.Uint8Attr(nl.IPSET_PROTOCOL)),
+				nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated(s.name)),
+			}
+			if flags&nl.IPSET_FLAG_LIST_SETNAME != 0 {
+				replies = append(replies, message(msg...))
+				break
+			}
+			if first {
+				msg = append(msg,
+					nl.NewRtAttr(nl.IPSET_ATTR_TYPENAME, nl.ZeroTerminated(s.typ.name)),
+					nl.NewRtAttr(nl.IPSET_ATTR_FAMILY, nl.Uint8Attr(s.family)),
+					nl.NewRtAttr(nl.IPSET_ATTR_REVISION, nl.Uint8Attr(s.revision)),
+					s.headerData())
+				first = false
+			}
+			if flags&nl.IPSET_FLAG_LIST_HEADER != 0 {
+				replies = append(replies, message(msg...))
+				break
+			}
+			n := perMessage
+			if n > len(members) {
+				n = len(members)
+			}
+			adt := nl.NewRtAttr(nl.IPSET_ATTR_ADT|int(nl.NLA_F_NESTED), nil)
+			for _, m := range members[:n] {
+				adt.AddChild(s.memberData(m, now))
+			}
+			members = members[n:]
+			replies = append(replies, message(append(msg, adt)...))
+		}
+	}
+	return replies, nil
+}
+
+func (k *Kernel) cmdADT(cmd int, attrs map[uint16]nl.Attribute, exist bool) error {
+	name, ok := setName(attrs, nl.IPSET_ATTR_SETNAME)
+	if !ok {
+		return syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+	}
+	s := k.find(name)
+	if s == nil {
+		return syscall.ENOENT
+	}
+
+	// A single entry comes in IPSET_ATTR_DATA, a batch as several of them
+	// in IPSET_ATTR_ADT.
+	var datas [][]byte
+	if data, ok := attrs[nl.IPSET_ATTR_DATA]; ok {
+		datas = append(datas, data.Value)
+	} else if adt, ok := attrs[nl.IPSET_ATTR_ADT]; ok {
+		// Like the kernel, batches need a line number.
+		if _, ok := attrs[nl.IPSET_ATTR_LINENO]; !ok {
+			return syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+		}
+		it := nl.NewAttributeIterator(adt.Value)
+		for it.Next() {
+			attr := it.Attribute()
+			if attr.Type&nl.NLA_TYPE_MASK != nl.IPSET_ATTR_DATA {
+				return syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+			}
+			datas = append(datas, attr.Value)
+		}
+		if it.Err() != nil {
+			return syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+		}
+	} else {
+		return syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+	}
+
+	now := k.Now()
+	s.expire(now)
+	batch := len(datas) > 1 || attrs[nl.IPSET_ATTR_ADT].Value != nil
+	for _, data := range datas {
+		d, err := parseADTData(data)
+		if err == nil {
+			switch cmd {
+			case nl.IPSET_CMD_ADD:
+				err = s.add(d, exist, now, k.nextSeq)
+			case nl.IPSET_CMD_DEL:
+				err = s.del(d, exist)
+			case nl.IPSET_CMD_TEST:
+				err = s.test(d)
+			}
+		}
+		if err != nil {
+			if lineno := lineno(data); batch && lineno != nil {
+				return &lineError{err: err, lineno: lineno}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// lineError is the error of an entry of a batch with its line number.
+type lineError struct {
+	err    error
+	lineno []byte
+}
+
+func (e *lineError) Error() string {
+	return e.err.Error()
+}
+
+// lineno returns the line number of an entry as it was sent, nil if it has
+// none or it is 0.
+func lineno(data []byte) []byte {
+	attrs, err := parseAttrs(data)
+	if err != nil {
+		return nil
+	}
+	attr, ok := attrs[nl.IPSET_ATTR_LINENO]
+	if !ok || len(attr.Value) != 4 || binary.BigEndian.Uint32(attr.Value) == 0 {
+		return nil
+	}
+	return attr.Value
+}
+
+// withLineno returns a copy of the request b with its top level line
+// number set to lineno.
+func withLineno(b, lineno []byte) []byte {
+	b = append([]byte{}, b...)
+	if value := linenoValue(b); value != nil {
+		copy(value, lineno)
+	}
+	return b
+}
+
+// linenoValue returns the value of the top level line number of the
+// request b, from its netlink header on, nil if it has none.
+func linenoValue(b []byte) []byte {
+	native := nl.NativeEndian()
+	for off := unix.SizeofNlMsghdr + nl.SizeofNfgenmsg; off+4 <= len(b); {
+		n := int(native.Uint16(b[off:]))
+		if n < 4 || off+n > len(b) {
+			break
+		}
+		if native.Uint16(b[off+2:])&nl.NLA_TYPE_MASK == nl.IPSET_ATTR_LINENO && n == 8 {
+			return b[off+4 : off+8]
+		}
+		off += (n + unix.NLA_ALIGNTO - 1) &^ (unix.NLA_ALIGNTO - 1)
+	}
+	return nil
+}
+
+func (k *Kernel) find(name string) *set {
+	for _, s := range k.sets {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func (k *Kernel) nextSeq() uint64 {
+	k.seq++
+	return k.seq
+}
+
+// SetNames returns the names of all sets, sorted.
+func (k *Kernel) SetNames() []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	names := make([]string, 0, len(k.sets))
+	for _, s := range k.sets {
+		names = append(names, s.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// message serializes the attributes of a reply behind its nfgenmsg header.
+func message(attrs ...*nl.RtAttr) []byte {
+	msg := (&nl.Nfgenmsg{NfgenFamily: unix.AF_INET, Version: nl.NFNETLINK_V0}).Serialize()
+	for _, attr := range attrs {
+		msg = append(msg, attr.Serialize()...)
+	}
+	return msg
+}
+
+// parseAttrs indexes the attributes of a request by type, without the
+// nested and byte order flags.
+func parseAttrs(data []byte) (map[uint16]nl.Attribute, error) {
+	attrs := map[uint16]nl.Attribute{}
+	it := nl.NewAttributeIterator(data)
+	for it.Next() {
+		attr := it.Attribute()
+		attrs[attr.Type&nl.NLA_TYPE_MASK] = attr
+	}
+	if it.Err() != nil {
+		return nil, syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+	}
+	return attrs, nil
+}
+
+func stringAttr(attrs map[uint16]nl.Attribute, attrType uint16) (string, bool) {
+	attr, ok := attrs[attrType]
+	if !ok || len(attr.Value) == 0 || attr.Value[len(attr.Value)-1] != 0 {
+		return "", false
+	}
+	return nl.BytesToString(attr.Value), true
+}
+
+func setName(attrs map[uint16]nl.Attribute, attrType uint16) (string, bool) {
+	name, ok := stringAttr(attrs, attrType)
+	if !ok || name == "" || len(name) >= nl.IPSET_MAXNAMELEN {
+		return "", false
+	}
+	return name, true
+}
+
+func uint8Attr(attrs map[uint16]nl.Attribute, attrType uint16) (uint8, bool) {
+	attr, ok := attrs[attrType]
+	if !ok || len(attr.Value) != 1 {
+		return 0, false
+	}
+	return attr.Value[0], true
+}
@@ -0,0 +1,292 @@
+package ipsettest_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/JiHanHuang/goipset"
+	"github.com/JiHanHuang/goipset/ipsettest"
+	"github.com/JiHanHuang/goipset/nl"
+	"golang.org/x/sys/unix"
+)
+
+func listStrings(t *testing.T, ipset *goipset.GoIpset, name string) []string {
+	result, err := ipset.List(name)
+	if err != nil {
+		t.Fatalf("listing %s failed: %v", name, err)
+	}
+	var entries []string
+	for _, entry := range result.Entries {
+		entries = append(entries, entry.Set.String())
+	}
+	return entries
+}
+
+func expectStrings(t *testing.T, got []string, expected ...string) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, got)
+		}
+	}
+}
+
+func discard([]byte) error { return nil }
+
+// newRequest builds an ipset request of cmd the way goipset does.
+func newRequest(cmd int, attrs ...*nl.RtAttr) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest(cmd|(unix.NFNL_SUBSYS_IPSET<<8), unix.NLM_F_ACK)
+	req.AddData(&nl.Nfgenmsg{NfgenFamily: unix.AF_INET, Version: nl.NFNETLINK_V0})
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_PROTOCOL, nl.Uint8Attr(nl.IPSET_PROTOCOL)))
+	for _, attr := range attrs {
+		req.AddData(attr)
+	}
+	return req
+}
+
+func TestKernelSetTypes(t *testing.T) {
+	ipset := goipset.NewGoIpsetWithTransport(ipsettest.NewKernel())
+
+	tests := []struct {
+		typename string
+		family   int
+		set      goipset.Set
+		expected []string
+	}{
+		{"hash:ip", unix.AF_INET, &goipset.SetIP{IP: net.ParseIP("10.0.0.1"), IPTO: net.ParseIP("10.0.0.3")},
+			[]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{"hash:ip", unix.AF_INET6, &goipset.SetIP{IP: net.ParseIP("2001:db8::1")},
+			[]string{"2001:db8::1"}},
+		{"hash:net", unix.AF_INET, &goipset.SetNet{IP: net.ParseIP("10.1.2.3"), CIDR: 24},
+			[]string{"10.1.2.0/24"}},
+		{"hash:net", unix.AF_INET6, &goipset.SetNet{IP: net.ParseIP("2001:db8::"), CIDR: 32},
+			[]string{"2001:db8::/32"}},
+		{"hash:ip,port", unix.AF_INET, &goipset.SetIPPort{IP: net.ParseIP("10.0.0.1"), Port: 80, PortTo: 81, Proto: unix.IPPROTO_TCP},
+			[]string{"10.0.0.1,TCP:80", "10.0.0.1,TCP:81"}},
+		{"hash:net,port", unix.AF_INET, &goipset.SetNetPort{IP: net.ParseIP("10.0.0.0"), CIDR: 8, Port: 53, Proto: unix.IPPROTO_UDP},
+			[]string{"10.0.0.0/8,UDP:53"}},
+		{"hash:mac", unix.AF_UNSPEC, &goipset.SetMac{MAC: net.HardwareAddr{0xde, 0xad, 0, 0, 0xbe, 0xef}},
+			[]string{"de:ad:00:00:be:ef"}},
+	}
+	for i, test := range tests {
+		name := test.typename + "-" + string(rune('a'+i))
+		err := ipset.Create(name, test.typename, goipset.GoIpsetCreateOptions{Family: test.family})
+		if err != nil {
+			t.Fatalf("creating %s failed: %v", test.typename, err)
+		}
+		if err := ipset.Add(name, &goipset.GoIPSetEntry{Set: test.set}); err != nil {
+			t.Fatalf("adding %s to %s failed: %v", test.set, test.typename, err)
+		}
+		expectStrings(t, listStrings(t, ipset, name), test.expected...)
+	}
+}
+
+func TestKernelErrors(t *testing.T) {
+
+	kernel := ipsettest.NewKernel()
+	ipset := goipset.NewGoIpsetWithTransport(kernel)
+
+	// goipset rejects these itself, so they are sent as they are.
+	typeReq := newRequest(nl.IPSET_CMD_TYPE,
+		nl.NewRtAttr(nl.IPSET_ATTR_TYPENAME, nl.ZeroTerminated("hash:foo")),
+		nl.NewRtAttr(nl.IPSET_ATTR_FAMILY, nl.Uint8Attr(unix.AF_INET)))
+	if err := kernel.Execute(typeReq, discard, nil); err != syscall.Errno(nl.IPSET_ERR_FIND_TYPE) {
+		t.Errorf("expected an unknown type to fail with IPSET_ERR_FIND_TYPE, got %v", err)
+	}
+	if err := ipset.Create("test", "hash:ip", goipset.GoIpsetCreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipset.Create("test", "hash:ip", goipset.GoIpsetCreateOptions{}); err != syscall.EEXIST {
+		t.Errorf("expected creating a set twice to fail with EEXIST, got %v", err)
+	}
+	if err := ipset.Create("test", "hash:ip", goipset.GoIpsetCreateOptions{Replace: true}); err != nil {
+		t.Errorf("expected creating an identical set with replace to succeed, got %v", err)
+	}
+
+	ip := nl.NewRtAttr(nl.IPSET_ATTR_IP|int(nl.NLA_F_NESTED), nil)
+	ip.AddChild(nl.NewRtAttr(nl.IPSET_ATTR_IPADDR_IPV6|int(nl.NLA_F_NET_BYTEORDER), net.ParseIP("::1")))
+	data := nl.NewRtAttr(nl.IPSET_ATTR_DATA|int(nl.NLA_F_NESTED), nil)
+	data.AddChild(ip)
+	v6Req := newRequest(nl.IPSET_CMD_ADD, nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated("test")), data)
+	if err := kernel.Execute(v6Req, discard, nil); err != syscall.Errno(nl.IPSET_ERR_INVALID_FAMILY) {
+		t.Errorf("expected an IPv6 entry to fail with IPSET_ERR_INVALID_FAMILY, got %v", err)
+	}
+	timeout := &goipset.GoIPSetEntry{Set: &goipset.SetIP{IP: net.ParseIP("1.2.3.4")}, Timeout: 10}
+	if err := ipset.Add("test", timeout); err != nl.IPSetError(nl.IPSET_ERR_TIMEOUT) {
+		t.Errorf("expected a timeout to fail with IPSET_ERR_TIMEOUT, got %v", err)
+	}
+	ip = nl.NewRtAttr(nl.IPSET_ATTR_IP|int(nl.NLA_F_NESTED), nil)
+	ip.AddChild(nl.NewRtAttr(nl.IPSET_ATTR_IPADDR_IPV4|int(nl.NLA_F_NET_BYTEORDER), net.ParseIP("1.2.3.4").To4()))
+	data = nl.NewRtAttr(nl.IPSET_ATTR_DATA|int(nl.NLA_F_NESTED), nil)
+	data.AddChild(ip)
+	data.AddChild(nl.NewRtAttr(nl.IPSET_ATTR_COMMENT, nl.ZeroTerminated("foo")))
+	commentReq := newRequest(nl.IPSET_CMD_ADD, nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated("test")), data)
+	if err := kernel.Execute(commentReq, discard, nil); err != syscall.Errno(nl.IPSET_ERR_COMMENT) {
+		t.Errorf("expected a comment to fail with IPSET_ERR_COMMENT, got %v", err)
+	}
+	// The library leaves the comment out for a set without comments.
+	comment := &goipset.GoIPSetEntry{Set: &goipset.SetIP{IP: net.ParseIP("1.2.3.5")}, Comment: "foo", Packets: 1, Bytes: 60}
+	if err := ipset.Add("test", comment); err != nil {
+		t.Errorf("expected a comment and counters to be left out, got %v", err)
+	}
+	if err := ipset.Del("test", comment); err != nil {
+		t.Fatal(err)
+	}
+	missing := &goipset.GoIPSetEntry{Set: &goipset.SetIP{IP: net.ParseIP("1.2.3.4")}}
+	if err := ipset.Del("test", missing); err != nl.IPSetError(nl.IPSET_ERR_EXIST) {
+		t.Errorf("expected deleting a missing entry to fail with IPSET_ERR_EXIST, got %v", err)
+	}
+	if _, err := ipset.List("nosuchset"); err != syscall.ENOENT {
+		t.Errorf("expected listing a missing set to fail with ENOENT, got %v", err)
+	}
+	if err := ipset.Destroy("test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipset.Destroy("test"); err != syscall.ENOENT {
+		t.Errorf("expected destroying a missing set to fail with ENOENT, got %v", err)
+	}
+}
+
+func TestKernelExtensions(t *testing.T) {
+	kernel := ipsettest.NewKernel()
+	now := time.Unix(1000, 0)
+	kernel.Now = func() time.Time { return now }
+	ipset := goipset.NewGoIpsetWithTransport(kernel)
+
+	err := ipset.Create("test", "hash:net", goipset.GoIpsetCreateOptions{
+		Timeout:  300,
+		Counters: true,
+		Comments: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []goipset.GoIPSetEntry{
+		{Set: &goipset.SetNet{IP: net.ParseIP("10.0.0.0"), CIDR: 8}, Comment: "default timeout"},
+		{Set: &goipset.SetNet{IP: net.ParseIP("11.0.0.0"), CIDR: 8}, Timeout: 10, Packets: 3, Bytes: 180},
+	}
+	for i := range entries {
+		if err := ipset.Add("test", &entries[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := ipset.List("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Timeout != 300 || result.CadtFlags != nl.IPSET_FLAG_WITH_COUNTERS|nl.IPSET_FLAG_WITH_COMMENT {
+		t.Errorf("unexpected header: %+v", result)
+	}
+	if result.NumEntries != 2 || len(result.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(result.Entries))
+	}
+	first, second := result.Entries[0], result.Entries[1]
+	if first.Timeout != 300 || first.Comment != "default timeout" {
+		t.Errorf("unexpected first entry: %+v", first)
+	}
+	if second.Timeout != 10 || second.Packets != 3 || second.Bytes != 180 {
+		t.Errorf("unexpected second entry: %+v", second)
+	}
+
+	now = now.Add(10 * time.Second)
+	expectStrings(t, listStrings(t, ipset, "test"), "10.0.0.0/8")
+}
+
+func TestKernelListSplit(t *testing.T) {
+	kernel := ipsettest.NewKernel()
+	kernel.EntriesPerMessage = 2
+	ipset := goipset.NewGoIpsetWithTransport(kernel)
+
+	for _, name := range []string{"a", "b"} {
+		if err := ipset.Create(name, "hash:ip", goipset.GoIpsetCreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		entry := goipset.GoIPSetEntry{Set: &goipset.SetIP{IP: net.ParseIP("10.0.0.1"), IPTO: net.ParseIP("10.0.0.5")}}
+		if err := ipset.Add(name, &entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := ipset.ListAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 sets, got %d", len(results))
+	}
+	for _, result := range results {
+		if result.TypeName != "hash:ip" || len(result.Entries) != 5 {
+			t.Errorf("expected 5 entries in %s, got %d", result.SetName, len(result.Entries))
+		}
+	}
+
+	count := 0
+	err = ipset.ListEach("a", func(goipset.GoIPSetEntry) error {
+		count++
+		if count == 3 {
+			return goipset.ErrStopList
+		}
+		return nil
+	})
+	if err != nil || count != 3 {
+		t.Errorf("expected ListEach to stop after 3 entries, got %d and %v", count, err)
+	}
+
+	// A wrapped ErrStopList stops the listing as well.
+	err = ipset.ListEach("a", func(goipset.GoIPSetEntry) error {
+		return fmt.Errorf("enough: %w", goipset.ErrStopList)
+	})
+	if err != nil {
+		t.Errorf("expected a wrapped ErrStopList to stop ListEach, got %v", err)
+	}
+}
+
+// A failed batch is recorded with the line number the kernel echoed, so
+// that the replay fails at the same entry and goes on in step.
+func TestRecordReplayBatchError(t *testing.T) {
+	session := func(ipset *goipset.GoIpset) (int, []string) {
+		input := "create a hash:ip\nadd a 10.0.0.1\nadd a 10.0.0.2\nadd a 10.0.0.1\nadd a 10.0.0.3\n"
+		err := ipset.Restore(strings.NewReader(input), goipset.RestoreOptions{})
+		var restoreErr *goipset.RestoreError
+		if !errors.As(err, &restoreErr) || !errors.Is(err, nl.IPSetError(nl.IPSET_ERR_EXIST)) {
+			t.Fatalf("expected the restore to fail with IPSET_ERR_EXIST, got %v", err)
+		}
+		return restoreErr.Line, listStrings(t, ipset, "a")
+	}
+
+	recorder := ipsettest.NewRecorder(ipsettest.NewKernel())
+	line, entries := session(goipset.NewGoIpsetWithTransport(recorder))
+	if line != 4 {
+		t.Errorf("expected line 4 to fail, got %d", line)
+	}
+
+	var b bytes.Buffer
+	if err := ipsettest.WriteExchanges(&b, recorder.Exchanges()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), " lineno ") {
+		t.Errorf("expected the line number to be recorded, got\n%s", b.String())
+	}
+	exchanges, err := ipsettest.ReadExchanges(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayer := ipsettest.NewReplayer(exchanges)
+	replayedLine, replayedEntries := session(goipset.NewGoIpsetWithTransport(replayer))
+	if replayedLine != line || strings.Join(replayedEntries, " ") != strings.Join(entries, " ") {
+		t.Errorf("expected line %d and %v when replayed, got %d and %v", line, entries, replayedLine, replayedEntries)
+	}
+	if err := replayer.Done(); err != nil {
+		t.Error(err)
+	}
+}
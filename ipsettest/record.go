@@ -0,0 +1,489 @@
+package ipsettest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unicode"
+
+	"github.com/JiHanHuang/goipset/nl"
+	"golang.org/x/sys/unix"
+)
+
+// Transport is the same as goipset.Transport, which this package can't
+// import without creating a cycle for the tests of goipset.
+type Transport interface {
+	Execute(req *nl.NetlinkRequest, fn func(msg []byte) error, restart func() error) error
+}
+
+// Exchange is a single request together with the kernel's answer to it.
+type Exchange struct {
+	// Type and Flags are taken from the netlink header of the request,
+	// its sequence number and port id are not recorded.
+	Type  uint16
+	Flags uint16
+	// Request is the body of the request, starting with its nfgenmsg
+	// header.
+	Request []byte
+	// Replies are the messages delivered for the request, each starting
+	// with its nfgenmsg header.
+	Replies [][]byte
+	// Errno is the error the kernel answered with, zero on success.
+	Errno syscall.Errno
+	// Lineno is the line number the kernel put into the request it echoed
+	// with the error, that of the entry of a batch that failed, zero if
+	// it echoed none. It is kept in the byte order it was sent in.
+	Lineno uint32
+}
+
+func newExchange(req *nl.NetlinkRequest) Exchange {
+	b := req.Serialize()
+	return Exchange{
+		Type:    req.Type,
+		Flags:   req.Flags,
+		Request: b[unix.SizeofNlMsghdr:],
+	}
+}
+
+// Recorder passes requests on to another Transport and records every
+// exchange, so that a session with a real kernel can be saved and replayed
+// by a Replayer later.
+//
+//	r := ipsettest.NewRecorder(&goipset.NetlinkTransport{})
+//	ipset := goipset.NewGoIpsetWithTransport(r)
+//	...
+//	r.Save("testdata/golden/session")
+type Recorder struct {
+	Transport Transport
+
+	mu        sync.Mutex
+	exchanges []Exchange
+	// err is the first error that could not be recorded, which fails the
+	// recording.
+	err error
+}
+
+// NewRecorder returns a Recorder sending requests to t.
+func NewRecorder(t Transport) *Recorder {
+	return &Recorder{Transport: t}
+}
+
+// Execute sends req on and records it, see goipset.Transport.
+func (r *Recorder) Execute(req *nl.NetlinkRequest, fn func(msg []byte) error, restart func() error) error {
+	ex := newExchange(req)
+	var fnErr error
+	record := func(msg []byte) error {
+		ex.Replies = append(ex.Replies, append([]byte(nil), msg...))
+		fnErr = fn(msg)
+		return fnErr
+	}
+	var recordRestart func() error
+	if restart != nil {
+		recordRestart = func() error {
+			ex.Replies = nil
+			return restart()
+		}
+	}
+
+	err := r.Transport.Execute(req, record, recordRestart)
+	// An error of fn is the caller's own business, replaying the same
+	// replies will make fn fail the same way again.
+	if err != nil && err != fnErr {
+		var errno syscall.Errno
+		if !errors.As(err, &errno) {
+			// A replay could not fail the same way.
+			r.mu.Lock()
+			if r.err == nil {
+				r.err = fmt.Errorf("request %d, %s, failed with %v, which can't be recorded",
+					len(r.exchanges), describeRequest(ex), err)
+			}
+			r.mu.Unlock()
+			return err
+		}
+		ex.Errno = errno
+		var reqErr *nl.RequestError
+		if errors.As(err, &reqErr) {
+			if lineno := linenoValue(reqErr.Request); lineno != nil {
+				ex.Lineno = binary.BigEndian.Uint32(lineno)
+			}
+		}
+	}
+
+	r.mu.Lock()
+	r.exchanges = append(r.exchanges, ex)
+	r.mu.Unlock()
+	return err
+}
+
+// Exchanges returns everything recorded so far.
+func (r *Recorder) Exchanges() []Exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Exchange(nil), r.exchanges...)
+}
+
+// Err returns the first error of the transport that was not an errno,
+// nil if every exchange was recorded.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Save writes the recorded exchanges to the file at path, see
+// WriteExchanges for the format. It writes nothing if an exchange could
+// not be recorded, see Err.
+func (r *Recorder) Save(path string) error {
+	if err := r.Err(); err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	if err := WriteExchanges(&b, r.Exchanges()); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b.Bytes(), 0644)
+}
+
+// Replayer answers requests from recorded exchanges. Requests have to come
+// in the recorded order and match the recording byte for byte, apart from
+// the sequence number and port id.
+type Replayer struct {
+	mu        sync.Mutex
+	exchanges []Exchange
+	next      int
+}
+
+// NewReplayer returns a Replayer serving exchanges in order.
+func NewReplayer(exchanges []Exchange) *Replayer {
+	return &Replayer{exchanges: exchanges}
+}
+
+// LoadReplayer reads the exchanges to replay from the file at path.
+func LoadReplayer(path string) (*Replayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	exchanges, err := ReadExchanges(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return NewReplayer(exchanges), nil
+}
+
+// MismatchError is returned by a Replayer for a request that differs from
+// the recording.
+type MismatchError struct {
+	Index    int
+	Expected Exchange
+	Got      Exchange
+}
+
+func (e *MismatchError) Error() string {
+	if e.Expected.Request == nil {
+		return fmt.Sprintf("unexpected request %d, %s", e.Index, describeRequest(e.Got))
+	}
+	return fmt.Sprintf("request %d differs from the recording\nexpected %s\n%sgot %s\n%s",
+		e.Index,
+		describeRequest(e.Expected), dumpMessage(e.Expected.Request),
+		describeRequest(e.Got), dumpMessage(e.Got.Request))
+}
+
+// Execute checks req against the next recorded exchange and delivers the
+// recorded replies, see goipset.Transport.
+func (p *Replayer) Execute(req *nl.NetlinkRequest, fn func(msg []byte) error, restart func() error) error {
+	got := newExchange(req)
+
+	p.mu.Lock()
+	index := p.next
+	if index >= len(p.exchanges) {
+		p.mu.Unlock()
+		return &MismatchError{Index: index, Got: got}
+	}
+	expected := p.exchanges[index]
+	p.next++
+	p.mu.Unlock()
+
+	if got.Type != expected.Type || got.Flags != expected.Flags || !bytes.Equal(got.Request, expected.Request) {
+		return &MismatchError{Index: index, Expected: expected, Got: got}
+	}
+	for _, reply := range expected.Replies {
+		if err := fn(append([]byte(nil), reply...)); err != nil {
+			return err
+		}
+	}
+	if expected.Errno != 0 && expected.Lineno != 0 && req.EchoErrors {
+		lineno := make([]byte, 4)
+		binary.BigEndian.PutUint32(lineno, expected.Lineno)
+		return &nl.RequestError{Err: expected.Errno, Request: withLineno(req.Serialize(), lineno)}
+	}
+	if expected.Errno != 0 {
+		return expected.Errno
+	}
+	return nil
+}
+
+// Done returns an error if some recorded requests were never made.
+func (p *Replayer) Done() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.next < len(p.exchanges) {
+		return fmt.Errorf("%d of %d recorded requests were not made, next is %s",
+			len(p.exchanges)-p.next, len(p.exchanges), describeRequest(p.exchanges[p.next]))
+	}
+	return nil
+}
+
+var commandNames = [...]string{
+	nl.IPSET_CMD_PROTOCOL: "PROTOCOL",
+	nl.IPSET_CMD_CREATE:   "CREATE",
+	nl.IPSET_CMD_DESTROY:  "DESTROY",
+	nl.IPSET_CMD_FLUSH:    "FLUSH",
+	nl.IPSET_CMD_RENAME:   "RENAME",
+	nl.IPSET_CMD_SWAP:     "SWAP",
+	nl.IPSET_CMD_LIST:     "LIST",
+	nl.IPSET_CMD_SAVE:     "SAVE",
+	nl.IPSET_CMD_ADD:      "ADD",
+	nl.IPSET_CMD_DEL:      "DEL",
+	nl.IPSET_CMD_TEST:     "TEST",
+	nl.IPSET_CMD_HEADER:   "HEADER",
+	nl.IPSET_CMD_TYPE:     "TYPE",
+}
+
+func describeRequest(ex Exchange) string {
+	name := "unknown"
+	if cmd := int(ex.Type & 0xff); ex.Type>>8 == unix.NFNL_SUBSYS_IPSET && cmd < len(commandNames) && commandNames[cmd] != "" {
+		name = commandNames[cmd]
+	}
+	return fmt.Sprintf("request type=%#04x flags=%#04x # %s", ex.Type, ex.Flags, name)
+}
+
+func describeErrno(errno syscall.Errno) string {
+	if errno >= nl.IPSET_ERR_PRIVATE {
+		return nl.IPSetError(errno).Error()
+	}
+	return errno.Error()
+}
+
+// WriteExchanges writes exchanges in a line based text format meant to be
+// read in reviews. Every exchange starts with a request line followed by
+// the bytes of the request, then a reply line with the bytes of each reply
+// and a closing line with the errno, followed by the echoed line number if
+// there is one:
+//
+//	request type=0x0602 flags=0x0605 # CREATE
+//		02 00 00 00                      # nfgenmsg family 2
+//		05 00 01 00 06 00 00 00          # attr 1
+//		...
+//	errno 0
+//
+// Bytes are hexadecimal and everything after a '#' is a comment, the
+// writer puts every attribute on its own line and annotates it.
+func WriteExchanges(w io.Writer, exchanges []Exchange) error {
+	bw := bufio.NewWriter(w)
+	for i, ex := range exchanges {
+		if i > 0 {
+			fmt.Fprintln(bw)
+		}
+		fmt.Fprintln(bw, describeRequest(ex))
+		bw.WriteString(dumpMessage(ex.Request))
+		for _, reply := range ex.Replies {
+			fmt.Fprintln(bw, "reply")
+			bw.WriteString(dumpMessage(reply))
+		}
+		if ex.Errno != 0 && ex.Lineno != 0 {
+			fmt.Fprintf(bw, "errno %d lineno %d # %s\n", int(ex.Errno), ex.Lineno, describeErrno(ex.Errno))
+		} else if ex.Errno != 0 {
+			fmt.Fprintf(bw, "errno %d # %s\n", int(ex.Errno), describeErrno(ex.Errno))
+		} else {
+			fmt.Fprintln(bw, "errno 0")
+		}
+	}
+	return bw.Flush()
+}
+
+func dumpMessage(msg []byte) string {
+	var b strings.Builder
+	if len(msg) < nl.SizeofNfgenmsg {
+		dumpLine(&b, 1, msg, "truncated")
+		return b.String()
+	}
+	dumpLine(&b, 1, msg[:nl.SizeofNfgenmsg], fmt.Sprintf("nfgenmsg family %d", msg[0]))
+	dumpAttrs(&b, 1, msg[nl.SizeofNfgenmsg:])
+	return b.String()
+}
+
+func dumpAttrs(b *strings.Builder, depth int, data []byte) {
+	native := nl.NativeEndian()
+	for len(data) > 0 {
+		if len(data) < 4 {
+			dumpLine(b, depth, data, "trailing bytes")
+			return
+		}
+		attrLen := int(native.Uint16(data[0:2]))
+		attrType := native.Uint16(data[2:4])
+		if attrLen < 4 || attrLen > len(data) {
+			dumpLine(b, depth, data, "malformed")
+			return
+		}
+		aligned := (attrLen + 3) &^ 3
+		if aligned > len(data) {
+			aligned = len(data)
+		}
+		value := data[4:attrLen]
+
+		comment := fmt.Sprintf("attr %d", attrType&nl.NLA_TYPE_MASK)
+		if attrType&nl.NLA_F_NET_BYTEORDER != 0 {
+			comment += " net"
+		}
+		if attrType&nl.NLA_F_NESTED != 0 && nestedAttrs(value) {
+			dumpLine(b, depth, data[:4], comment+" nested")
+			dumpAttrs(b, depth+1, data[4:aligned])
+		} else {
+			if s, ok := printable(value); ok {
+				comment += " " + strconv.Quote(s)
+			}
+			dumpLine(b, depth, data[:aligned], comment)
+		}
+		data = data[aligned:]
+	}
+}
+
+func nestedAttrs(data []byte) bool {
+	it := nl.NewAttributeIterator(data)
+	for it.Next() {
+	}
+	return it.Err() == nil
+}
+
+func printable(value []byte) (string, bool) {
+	s := string(bytes.TrimRight(value, "\x00"))
+	if len(s) < 2 || len(s) == len(value) {
+		return "", false
+	}
+	for _, r := range s {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+			return "", false
+		}
+	}
+	return s, true
+}
+
+const bytesPerLine = 16
+
+func dumpLine(b *strings.Builder, depth int, data []byte, comment string) {
+	for len(data) > 0 {
+		n := len(data)
+		if n > bytesPerLine {
+			n = bytesPerLine
+		}
+		line := strings.Repeat("\t", depth)
+		for i, c := range data[:n] {
+			if i > 0 {
+				line += " "
+			}
+			line += hex.EncodeToString([]byte{c})
+		}
+		if comment != "" {
+			line += strings.Repeat(" ", 3*bytesPerLine+1-3*n) + "# " + comment
+			comment = ""
+		}
+		b.WriteString(line + "\n")
+		data = data[n:]
+	}
+}
+
+// ReadExchanges parses exchanges written by WriteExchanges.
+func ReadExchanges(r io.Reader) ([]Exchange, error) {
+	var exchanges []Exchange
+	var ex *Exchange
+	var data *[]byte
+
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case fields[0] == "request":
+			if ex != nil {
+				return nil, fmt.Errorf("line %d: request %d has no errno", lineno, len(exchanges))
+			}
+			exchanges = append(exchanges, Exchange{})
+			ex = &exchanges[len(exchanges)-1]
+			for _, field := range fields[1:] {
+				kv := strings.SplitN(field, "=", 2)
+				if len(kv) != 2 {
+					return nil, fmt.Errorf("line %d: invalid request field %q", lineno, field)
+				}
+				v, err := strconv.ParseUint(kv[1], 0, 16)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", lineno, err)
+				}
+				switch kv[0] {
+				case "type":
+					ex.Type = uint16(v)
+				case "flags":
+					ex.Flags = uint16(v)
+				default:
+					return nil, fmt.Errorf("line %d: unknown request field %q", lineno, kv[0])
+				}
+			}
+			ex.Request = []byte{}
+			data = &ex.Request
+		case ex == nil:
+			return nil, fmt.Errorf("line %d: expected a request", lineno)
+		case fields[0] == "reply" && len(fields) == 1:
+			ex.Replies = append(ex.Replies, []byte{})
+			data = &ex.Replies[len(ex.Replies)-1]
+		case fields[0] == "errno" && (len(fields) == 2 || len(fields) == 4 && fields[2] == "lineno"):
+			errno, err := strconv.ParseUint(fields[1], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineno, err)
+			}
+			ex.Errno = syscall.Errno(errno)
+			if len(fields) == 4 {
+				v, err := strconv.ParseUint(fields[3], 10, 32)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", lineno, err)
+				}
+				ex.Lineno = uint32(v)
+			}
+			ex, data = nil, nil
+		default:
+			for _, field := range fields {
+				c, err := hex.DecodeString(field)
+				if err != nil || len(c) != 1 {
+					return nil, fmt.Errorf("line %d: invalid byte %q", lineno, field)
+				}
+				*data = append(*data, c[0])
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if ex != nil {
+		return nil, fmt.Errorf("line %d: request %d has no errno", lineno, len(exchanges)-1)
+	}
+	return exchanges, nil
+}
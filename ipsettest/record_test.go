@@ -0,0 +1,111 @@
+package ipsettest
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/JiHanHuang/goipset/nl"
+	"golang.org/x/sys/unix"
+)
+
+func newRequest(cmd int, setname string) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest(cmd|(unix.NFNL_SUBSYS_IPSET<<8), unix.NLM_F_ACK)
+	req.AddData(&nl.Nfgenmsg{NfgenFamily: unix.AF_INET, Version: nl.NFNETLINK_V0})
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_PROTOCOL, nl.Uint8Attr(nl.IPSET_PROTOCOL)))
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated(setname)))
+	return req
+}
+
+func TestRecordReplay(t *testing.T) {
+	recorder := NewRecorder(NewKernel())
+	discard := func([]byte) error { return nil }
+
+	if err := recorder.Execute(newRequest(nl.IPSET_CMD_LIST, "missing"), discard, nil); err != syscall.ENOENT {
+		t.Fatalf("expected ENOENT, got %v", err)
+	}
+	if err := recorder.Execute(newRequest(nl.IPSET_CMD_PROTOCOL, ""), discard, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := WriteExchanges(&b, recorder.Exchanges()); err != nil {
+		t.Fatal(err)
+	}
+	exchanges, err := ReadExchanges(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(exchanges, recorder.Exchanges()) {
+		t.Fatalf("expected the exchanges to survive a round trip, got %+v", exchanges)
+	}
+
+	replayer := NewReplayer(exchanges)
+	if err := replayer.Execute(newRequest(nl.IPSET_CMD_LIST, "missing"), discard, nil); err != syscall.ENOENT {
+		t.Errorf("expected the recorded ENOENT, got %v", err)
+	}
+	if err := replayer.Done(); err == nil {
+		t.Errorf("expected Done to report the request that was not made")
+	}
+	var replies int
+	err = replayer.Execute(newRequest(nl.IPSET_CMD_PROTOCOL, "changed"), func([]byte) error {
+		replies++
+		return nil
+	}, nil)
+	if _, ok := err.(*MismatchError); !ok || replies != 0 {
+		t.Errorf("expected a mismatch without replies, got %v and %d replies", err, replies)
+	}
+	if err := replayer.Execute(newRequest(nl.IPSET_CMD_PROTOCOL, ""), discard, nil); err == nil {
+		t.Errorf("expected a request past the end of the recording to fail")
+	}
+}
+
+// failingTransport fails every request with err.
+type failingTransport struct {
+	err error
+}
+
+func (t failingTransport) Execute(req *nl.NetlinkRequest, fn func(msg []byte) error, restart func() error) error {
+	return t.err
+}
+
+func TestRecordUnrecordableError(t *testing.T) {
+	recorder := NewRecorder(failingTransport{nl.ErrDumpInterrupted})
+	discard := func([]byte) error { return nil }
+	if err := recorder.Execute(newRequest(nl.IPSET_CMD_LIST, "a"), discard, nil); !errors.Is(err, nl.ErrDumpInterrupted) {
+		t.Fatalf("expected the error of the transport, got %v", err)
+	}
+	recorder.Transport = failingTransport{syscall.ENOENT}
+	if err := recorder.Execute(newRequest(nl.IPSET_CMD_LIST, "b"), discard, nil); err != syscall.ENOENT {
+		t.Fatalf("expected ENOENT, got %v", err)
+	}
+
+	if err := recorder.Err(); err == nil || !strings.Contains(err.Error(), "request 0, request type=0x0607") {
+		t.Errorf("expected the recording to fail at the first request, got %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "session")
+	if err := recorder.Save(path); err == nil {
+		t.Errorf("expected Save to fail")
+	}
+	if _, err := LoadReplayer(path); err == nil {
+		t.Errorf("expected Save not to write the file")
+	}
+}
+
+func TestReadExchangesErrors(t *testing.T) {
+	tests := []string{
+		"02 00 00 00\n",
+		"request type=0x060a\n\t02 00 00 00\n",
+		"request type=0x060a\n\t02 0\nerrno 0\n",
+		"request kind=list\nerrno 0\n",
+	}
+	for _, test := range tests {
+		if _, err := ReadExchanges(strings.NewReader(test)); err == nil {
+			t.Errorf("expected an error for %q", test)
+		}
+	}
+}
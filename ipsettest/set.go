@@ -0,0 +1,734 @@
+package ipsettest
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/JiHanHuang/goipset/nl"
+	"golang.org/x/sys/unix"
+)
+
+// setType describes the dimensions of a set type the fake kernel knows.
+type setType struct {
+	name        string
+	revisionMin uint8
+	revisionMax uint8
+	net         bool // the IP dimension is a network with a CIDR
+	port        bool
+	mac         bool
+	netmask     bool  // sets can be created with a netmask
+	netmaskMin  uint8 // from this revision on
+}
+
+var setTypes = map[string]*setType{
+	"hash:ip":       {name: "hash:ip", revisionMin: 0, revisionMax: 6, netmask: true},
+	"hash:net":      {name: "hash:net", revisionMin: 0, revisionMax: 7, net: true},
+	"hash:ip,port":  {name: "hash:ip,port", revisionMin: 0, revisionMax: 7, port: true, netmask: true, netmaskMin: 7},
+	"hash:net,port": {name: "hash:net,port", revisionMin: 1, revisionMax: 8, net: true, port: true},
+	"hash:mac":      {name: "hash:mac", revisionMin: 0, revisionMax: 1, mac: true},
+}
+
+// supportsFamily reports whether a set of the type can be created with
+// family, hash:mac is the only type without an IP family.
+func (t *setType) supportsFamily(family uint8) bool {
+	if t.mac {
+		return family == unix.AF_UNSPEC
+	}
+	return family == unix.AF_INET || family == unix.AF_INET6
+}
+
+const extFlags = nl.IPSET_FLAG_WITH_COUNTERS | nl.IPSET_FLAG_WITH_COMMENT |
+	nl.IPSET_FLAG_WITH_SKBINFO | nl.IPSET_FLAG_WITH_FORCEADD
+
+type set struct {
+	name        string
+	typ         *setType
+	revision    uint8
+	family      uint8
+	hashSize    uint32
+	maxElem     uint32
+	bucketSize  uint8
+	initval     uint32
+	netmask     uint8
+	withTimeout bool
+	timeout     uint32
+	cadtFlags   uint32
+
+	members map[elemKey]*member
+}
+
+// elemKey identifies an element, the IP is stored in its 16 byte form.
+type elemKey struct {
+	ip    [net.IPv6len]byte
+	cidr  uint8
+	proto uint8
+	port  uint16
+	mac   [6]byte
+}
+
+type member struct {
+	key      elemKey
+	seq      uint64
+	expires  time.Time
+	nomatch  bool
+	packets  uint64
+	bytes    uint64
+	comment  string
+	skbmark  uint64
+	skbprio  uint32
+	skbqueue uint16
+}
+
+func (s *set) parseCreateData(data []byte) error {
+	it := nl.NewAttributeIterator(data)
+	for it.Next() {
+		attr := it.Attribute()
+		switch attr.Type & nl.NLA_TYPE_MASK {
+		case nl.IPSET_ATTR_TIMEOUT:
+			if len(attr.Value) != 4 {
+				return syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+			}
+			s.withTimeout = true
+			s.timeout = binary.BigEndian.Uint32(attr.Value)
+		case nl.IPSET_ATTR_CADT_FLAGS:
+			if len(attr.Value) != 4 {
+				return syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+			}
+			s.cadtFlags = binary.BigEndian.Uint32(attr.Value) & extFlags
+		case nl.IPSET_ATTR_HASHSIZE:
+			if len(attr.Value) != 4 {
+				return syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+			}
+			s.hashSize = roundHashSize(binary.BigEndian.Uint32(attr.Value))
+		case nl.IPSET_ATTR_MAXELEM:
+			if len(attr.Value) != 4 {
+				return syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+			}
+			s.maxElem = binary.BigEndian.Uint32(attr.Value)
+		case nl.IPSET_ATTR_BUCKETSIZE:
+			if len(attr.Value) != 1 {
+				return syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+			}
+			s.bucketSize = attr.Value[0]
+		case nl.IPSET_ATTR_INITVAL:
+			if len(attr.Value) != 4 {
+				return syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+			}
+			s.initval = binary.BigEndian.Uint32(attr.Value)
+		case nl.IPSET_ATTR_NETMASK:
+			if len(attr.Value) != 1 {
+				return syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+			}
+			if !s.typ.netmask || s.revision < s.typ.netmaskMin {
+				return syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+			}
+			hostMask := uint8(32)
+			if s.family == unix.AF_INET6 {
+				hostMask = 128
+			}
+			if attr.Value[0] == 0 || attr.Value[0] > hostMask {
+				return syscall.Errno(nl.IPSET_ERR_INVALID_NETMASK)
+			}
+			if attr.Value[0] != hostMask {
+				s.netmask = attr.Value[0]
+			}
+		}
+	}
+	if it.Err() != nil {
+		return syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+	}
+	return nil
+}
+
+// roundHashSize rounds up to a power of two, at least 64, like the kernel.
+func roundHashSize(size uint32) uint32 {
+	rounded := uint32(64)
+	for rounded < size && rounded < 1<<31 {
+		rounded <<= 1
+	}
+	return rounded
+}
+
+func (s *set) sameSet(o *set) bool {
+	return s.typ == o.typ && s.family == o.family && s.maxElem == o.maxElem &&
+		s.withTimeout == o.withTimeout && s.timeout == o.timeout && s.cadtFlags == o.cadtFlags &&
+		s.netmask == o.netmask
+}
+
+func (s *set) flush() {
+	s.members = map[elemKey]*member{}
+}
+
+func (s *set) expire(now time.Time) {
+	for key, m := range s.members {
+		if !m.expires.IsZero() && !now.Before(m.expires) {
+			delete(s.members, key)
+		}
+	}
+}
+
+func (s *set) sortedMembers() []*member {
+	members := make([]*member, 0, len(s.members))
+	for _, m := range s.members {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].seq < members[j].seq })
+	return members
+}
+
+func (s *set) memSize() uint32 {
+	return 200 + uint32(len(s.members))*64
+}
+
+func (s *set) headerData() *nl.RtAttr {
+	data := nl.NewRtAttr(nl.IPSET_ATTR_DATA|int(nl.NLA_F_NESTED), nil)
+	data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_HASHSIZE | nl.NLA_F_NET_BYTEORDER, Value: s.hashSize})
+	data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_MAXELEM | nl.NLA_F_NET_BYTEORDER, Value: s.maxElem})
+	data.AddRtAttr(nl.IPSET_ATTR_BUCKETSIZE, nl.Uint8Attr(s.bucketSize))
+	data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_INITVAL | nl.NLA_F_NET_BYTEORDER, Value: s.initval})
+	if s.netmask != 0 {
+		data.AddRtAttr(nl.IPSET_ATTR_NETMASK, nl.Uint8Attr(s.netmask))
+	}
+	data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_REFERENCES | nl.NLA_F_NET_BYTEORDER, Value: 0})
+	data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_MEMSIZE | nl.NLA_F_NET_BYTEORDER, Value: s.memSize()})
+	data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_ELEMENTS | nl.NLA_F_NET_BYTEORDER, Value: uint32(len(s.members))})
+	if s.withTimeout {
+		data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_TIMEOUT | nl.NLA_F_NET_BYTEORDER, Value: s.timeout})
+	}
+	if s.cadtFlags != 0 {
+		data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_CADT_FLAGS | nl.NLA_F_NET_BYTEORDER, Value: s.cadtFlags})
+	}
+	return data
+}
+
+func (s *set) memberData(m *member, now time.Time) *nl.RtAttr {
+	data := nl.NewRtAttr(nl.IPSET_ATTR_DATA|int(nl.NLA_F_NESTED), nil)
+	if s.typ.mac {
+		data.AddRtAttr(nl.IPSET_ATTR_ETHER, append([]byte{}, m.key.mac[:]...))
+	} else {
+		ip := nl.NewRtAttr(nl.IPSET_ATTR_IP|int(nl.NLA_F_NESTED), nil)
+		if s.family == unix.AF_INET {
+			ip.AddRtAttr(nl.IPSET_ATTR_IPADDR_IPV4, append([]byte{}, m.key.ip[12:]...))
+		} else {
+			ip.AddRtAttr(nl.IPSET_ATTR_IPADDR_IPV6, append([]byte{}, m.key.ip[:]...))
+		}
+		data.AddChild(ip)
+	}
+	if s.typ.net {
+		data.AddRtAttr(nl.IPSET_ATTR_CIDR, nl.Uint8Attr(m.key.cidr))
+	}
+	if s.typ.port {
+		data.AddRtAttr(nl.IPSET_ATTR_PORT|int(nl.NLA_F_NET_BYTEORDER), net16(m.key.port))
+		data.AddRtAttr(nl.IPSET_ATTR_PROTO, nl.Uint8Attr(m.key.proto))
+	}
+	if m.nomatch {
+		data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_CADT_FLAGS | nl.NLA_F_NET_BYTEORDER, Value: nl.IPSET_FLAG_NOMATCH})
+	}
+	if s.withTimeout {
+		var left uint32
+		if !m.expires.IsZero() {
+			left = uint32((m.expires.Sub(now) + time.Second - 1) / time.Second)
+		}
+		data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_TIMEOUT | nl.NLA_F_NET_BYTEORDER, Value: left})
+	}
+	if s.cadtFlags&nl.IPSET_FLAG_WITH_COUNTERS != 0 {
+		data.AddRtAttr(nl.IPSET_ATTR_BYTES|int(nl.NLA_F_NET_BYTEORDER), net64(m.bytes))
+		data.AddRtAttr(nl.IPSET_ATTR_PACKETS|int(nl.NLA_F_NET_BYTEORDER), net64(m.packets))
+	}
+	if s.cadtFlags&nl.IPSET_FLAG_WITH_COMMENT != 0 && m.comment != "" {
+		data.AddRtAttr(nl.IPSET_ATTR_COMMENT, nl.ZeroTerminated(m.comment))
+	}
+	if s.cadtFlags&nl.IPSET_FLAG_WITH_SKBINFO != 0 {
+		if m.skbmark != 0 {
+			data.AddRtAttr(nl.IPSET_ATTR_SKBMARK|int(nl.NLA_F_NET_BYTEORDER), net64(m.skbmark))
+		}
+		if m.skbprio != 0 {
+			data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_SKBPRIO | nl.NLA_F_NET_BYTEORDER, Value: m.skbprio})
+		}
+		if m.skbqueue != 0 {
+			data.AddRtAttr(nl.IPSET_ATTR_SKBQUEUE|int(nl.NLA_F_NET_BYTEORDER), net16(m.skbqueue))
+		}
+	}
+	return data
+}
+
+// adtData holds the attributes of one add, del or test request entry.
+type adtData struct {
+	ip, ipTo     net.IP
+	cidr         uint8
+	hasCIDR      bool
+	port, portTo uint16
+	hasPort      bool
+	hasPortTo    bool
+	proto        uint8
+	hasProto     bool
+	mac          net.HardwareAddr
+	cadtFlags    uint32
+
+	timeout    uint32
+	hasTimeout bool
+	packets    uint64
+	bytes      uint64
+	hasCounter bool
+	comment    string
+	hasComment bool
+	skbmark    uint64
+	skbprio    uint32
+	skbqueue   uint16
+	hasSkbinfo bool
+}
+
+func parseADTData(data []byte) (*adtData, error) {
+	errProtocol := syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+	d := &adtData{}
+	it := nl.NewAttributeIterator(data)
+	for it.Next() {
+		attr := it.Attribute()
+		v := attr.Value
+		var err error
+		switch attr.Type & nl.NLA_TYPE_MASK {
+		case nl.IPSET_ATTR_IP:
+			d.ip, err = parseIPAttr(v)
+		case nl.IPSET_ATTR_IP_TO:
+			d.ipTo, err = parseIPAttr(v)
+		case nl.IPSET_ATTR_CIDR:
+			d.hasCIDR, err = len(v) == 1, lenErr(v, 1)
+			d.cidr = first(v)
+		case nl.IPSET_ATTR_PORT:
+			if err = lenErr(v, 2); err == nil {
+				d.hasPort, d.port = true, binary.BigEndian.Uint16(v)
+			}
+		case nl.IPSET_ATTR_PORT_TO:
+			if err = lenErr(v, 2); err == nil {
+				d.hasPortTo, d.portTo = true, binary.BigEndian.Uint16(v)
+			}
+		case nl.IPSET_ATTR_PROTO:
+			d.hasProto, err = len(v) == 1, lenErr(v, 1)
+			d.proto = first(v)
+		case nl.IPSET_ATTR_CADT_FLAGS:
+			if err = lenErr(v, 4); err == nil {
+				d.cadtFlags = binary.BigEndian.Uint32(v)
+			}
+		case nl.IPSET_ATTR_TIMEOUT:
+			if err = lenErr(v, 4); err == nil {
+				d.hasTimeout, d.timeout = true, binary.BigEndian.Uint32(v)
+			}
+		case nl.IPSET_ATTR_PACKETS:
+			if err = lenErr(v, 8); err == nil {
+				d.hasCounter, d.packets = true, binary.BigEndian.Uint64(v)
+			}
+		case nl.IPSET_ATTR_BYTES:
+			if err = lenErr(v, 8); err == nil {
+				d.hasCounter, d.bytes = true, binary.BigEndian.Uint64(v)
+			}
+		case nl.IPSET_ATTR_COMMENT:
+			if len(v) == 0 || v[len(v)-1] != 0 || len(v) > nl.IPSET_MAX_COMMENT_SIZE+1 {
+				err = errProtocol
+			} else {
+				d.hasComment, d.comment = true, nl.BytesToString(v)
+			}
+		case nl.IPSET_ATTR_SKBMARK:
+			if err = lenErr(v, 8); err == nil {
+				d.hasSkbinfo, d.skbmark = true, binary.BigEndian.Uint64(v)
+			}
+		case nl.IPSET_ATTR_SKBPRIO:
+			if err = lenErr(v, 4); err == nil {
+				d.hasSkbinfo, d.skbprio = true, binary.BigEndian.Uint32(v)
+			}
+		case nl.IPSET_ATTR_SKBQUEUE:
+			if err = lenErr(v, 2); err == nil {
+				d.hasSkbinfo, d.skbqueue = true, binary.BigEndian.Uint16(v)
+			}
+		case nl.IPSET_ATTR_ETHER:
+			if err = lenErr(v, 6); err == nil {
+				d.mac = net.HardwareAddr(v)
+			}
+		case nl.IPSET_ATTR_LINENO:
+		default:
+			err = errProtocol
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if it.Err() != nil {
+		return nil, errProtocol
+	}
+	return d, nil
+}
+
+func parseIPAttr(data []byte) (net.IP, error) {
+	it := nl.NewAttributeIterator(data)
+	if !it.Next() {
+		return nil, syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+	}
+	attr := it.Attribute()
+	switch attr.Type & nl.NLA_TYPE_MASK {
+	case nl.IPSET_ATTR_IPADDR_IPV4:
+		if len(attr.Value) == net.IPv4len {
+			return net.IP(attr.Value).To16(), nil
+		}
+		return nil, syscall.Errno(nl.IPSET_ERR_IPADDR_IPV4)
+	case nl.IPSET_ATTR_IPADDR_IPV6:
+		if len(attr.Value) == net.IPv6len {
+			return net.IP(attr.Value), nil
+		}
+		return nil, syscall.Errno(nl.IPSET_ERR_IPADDR_IPV6)
+	}
+	return nil, syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+}
+
+func lenErr(v []byte, n int) error {
+	if len(v) != n {
+		return syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+	}
+	return nil
+}
+
+func first(v []byte) uint8 {
+	if len(v) == 0 {
+		return 0
+	}
+	return v[0]
+}
+
+// checkExtensions rejects extensions the set was not created with.
+func (s *set) checkExtensions(d *adtData) error {
+	switch {
+	case d.hasTimeout && !s.withTimeout:
+		return syscall.Errno(nl.IPSET_ERR_TIMEOUT)
+	case d.hasCounter && s.cadtFlags&nl.IPSET_FLAG_WITH_COUNTERS == 0:
+		return syscall.Errno(nl.IPSET_ERR_COUNTER)
+	case d.hasComment && s.cadtFlags&nl.IPSET_FLAG_WITH_COMMENT == 0:
+		return syscall.Errno(nl.IPSET_ERR_COMMENT)
+	case d.hasSkbinfo && s.cadtFlags&nl.IPSET_FLAG_WITH_SKBINFO == 0:
+		return syscall.Errno(nl.IPSET_ERR_SKBINFO)
+	}
+	return nil
+}
+
+func (s *set) hostMask() uint8 {
+	if s.family == unix.AF_INET6 {
+		return 128
+	}
+	return 32
+}
+
+// maskIP returns ip with the netmask of the set applied, as it is stored.
+func (s *set) maskIP(ip net.IP) net.IP {
+	if s.netmask == 0 {
+		return ip
+	}
+	return ip.Mask(net.CIDRMask(int(s.netmask), int(s.hostMask()))).To16()
+}
+
+// keys expands the entry into the elements it stands for. For test only
+// the first one is returned, ranges are not tested.
+func (s *set) keys(d *adtData, test bool) ([]elemKey, error) {
+	errProtocol := syscall.Errno(nl.IPSET_ERR_PROTOCOL)
+
+	if s.typ.mac {
+		if d.mac == nil {
+			return nil, errProtocol
+		}
+		var key elemKey
+		copy(key.mac[:], d.mac)
+		return []elemKey{key}, nil
+	}
+
+	if d.ip == nil {
+		return nil, errProtocol
+	}
+	v4 := d.ip.To4() != nil
+	if v4 != (s.family == unix.AF_INET) || (d.ipTo != nil && (d.ipTo.To4() != nil) != v4) {
+		return nil, syscall.Errno(nl.IPSET_ERR_INVALID_FAMILY)
+	}
+
+	// the port dimension
+	type portKey struct {
+		proto uint8
+		port  uint16
+	}
+	ports := []portKey{{}}
+	if s.typ.port {
+		if !d.hasPort {
+			return nil, errProtocol
+		}
+		if !d.hasProto {
+			return nil, syscall.Errno(nl.IPSET_ERR_MISSING_PROTO)
+		}
+		if d.proto == 0 {
+			return nil, syscall.Errno(nl.IPSET_ERR_INVALID_PROTO)
+		}
+		switch {
+		case withPorts(d.proto):
+			from, to := d.port, d.port
+			if d.hasPortTo && !test {
+				to = d.portTo
+				if to < from {
+					from, to = to, from
+				}
+			}
+			ports = ports[:0]
+			for p := uint32(from); p <= uint32(to); p++ {
+				ports = append(ports, portKey{d.proto, uint16(p)})
+			}
+		case d.proto == unix.IPPROTO_ICMP && v4, d.proto == unix.IPPROTO_ICMPV6 && !v4:
+			ports[0] = portKey{d.proto, d.port}
+		default:
+			ports[0] = portKey{d.proto, 0}
+		}
+	}
+
+	// the IP dimension
+	type ipKey struct {
+		ip   net.IP
+		cidr uint8
+	}
+	var ips []ipKey
+	host := s.hostMask()
+	if s.typ.net {
+		cidr := host
+		if d.hasCIDR {
+			cidr = d.cidr
+		}
+		if cidr == 0 || cidr > host {
+			return nil, syscall.Errno(nl.IPSET_ERR_INVALID_CIDR)
+		}
+		switch {
+		case d.ipTo != nil && !test:
+			if !v4 {
+				return nil, syscall.Errno(nl.IPSET_ERR_HASH_RANGE_UNSUPPORTED)
+			}
+			from, to := ip4(d.ip), ip4(d.ipTo)
+			if to < from {
+				from, to = to, from
+			}
+			if from == 0 && to == ^uint32(0) {
+				return nil, syscall.Errno(nl.IPSET_ERR_HASH_RANGE)
+			}
+			for _, n := range rangeToCIDRs4(from, to) {
+				ips = append(ips, ipKey{ip4To16(n.ip), n.cidr})
+			}
+		default:
+			ips = []ipKey{{d.ip.Mask(net.CIDRMask(int(cidr), int(host))).To16(), cidr}}
+		}
+	} else {
+		switch {
+		case test || (d.ipTo == nil && !d.hasCIDR):
+			ips = []ipKey{{s.maskIP(d.ip), 0}}
+		case !v4:
+			if d.ipTo != nil {
+				return nil, syscall.Errno(nl.IPSET_ERR_HASH_RANGE_UNSUPPORTED)
+			}
+			if d.cidr != host {
+				return nil, syscall.Errno(nl.IPSET_ERR_INVALID_CIDR)
+			}
+			ips = []ipKey{{s.maskIP(d.ip), 0}}
+		default:
+			from, to := ip4(d.ip), ip4(d.ip)
+			if d.ipTo != nil {
+				to = ip4(d.ipTo)
+				if to < from {
+					from, to = to, from
+				}
+			} else {
+				if d.cidr == 0 || d.cidr > 32 {
+					return nil, syscall.Errno(nl.IPSET_ERR_INVALID_CIDR)
+				}
+				mask := ^uint32(0) << (32 - d.cidr)
+				from, to = from&mask, from|^mask
+			}
+			// With a netmask every network of the range is one entry.
+			step := uint64(1)
+			if s.netmask != 0 {
+				mask := ^uint32(0) << (32 - s.netmask)
+				from, to = from&mask, to&mask
+				step = 1 << (32 - s.netmask)
+			}
+			if uint64(to-from)/step+1 > uint64(s.maxElem) {
+				return nil, syscall.Errno(nl.IPSET_ERR_HASH_FULL)
+			}
+			for ip := uint64(from); ip <= uint64(to); ip += step {
+				ips = append(ips, ipKey{ip4To16(uint32(ip)), 0})
+			}
+		}
+	}
+
+	if uint64(len(ips))*uint64(len(ports)) > uint64(s.maxElem) {
+		return nil, syscall.Errno(nl.IPSET_ERR_HASH_FULL)
+	}
+	keys := make([]elemKey, 0, len(ips)*len(ports))
+	for _, ip := range ips {
+		for _, p := range ports {
+			key := elemKey{cidr: ip.cidr, proto: p.proto, port: p.port}
+			copy(key.ip[:], ip.ip.To16())
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (s *set) add(d *adtData, exist bool, now time.Time, seq func() uint64) error {
+	if err := s.checkExtensions(d); err != nil {
+		return err
+	}
+	keys, err := s.keys(d, false)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		m, ok := s.members[key]
+		if ok && !exist {
+			return syscall.Errno(nl.IPSET_ERR_EXIST)
+		}
+		if !ok {
+			if uint32(len(s.members)) >= s.maxElem {
+				if s.cadtFlags&nl.IPSET_FLAG_WITH_FORCEADD == 0 {
+					return syscall.Errno(nl.IPSET_ERR_HASH_FULL)
+				}
+				// forceadd evicts an element to make room
+				for victim := range s.members {
+					delete(s.members, victim)
+					break
+				}
+			}
+			m = &member{key: key, seq: seq()}
+			s.members[key] = m
+		}
+
+		m.nomatch = s.typ.net && d.cadtFlags&nl.IPSET_FLAG_NOMATCH != 0
+		m.expires = time.Time{}
+		if s.withTimeout {
+			timeout := s.timeout
+			if d.hasTimeout {
+				timeout = d.timeout
+			}
+			if timeout != 0 {
+				m.expires = now.Add(time.Duration(timeout) * time.Second)
+			}
+		}
+		if d.hasCounter || !ok {
+			m.packets, m.bytes = d.packets, d.bytes
+		}
+		if d.hasComment || !ok {
+			m.comment = d.comment
+		}
+		if d.hasSkbinfo || !ok {
+			m.skbmark, m.skbprio, m.skbqueue = d.skbmark, d.skbprio, d.skbqueue
+		}
+	}
+	return nil
+}
+
+func (s *set) del(d *adtData, exist bool) error {
+	if err := s.checkExtensions(d); err != nil {
+		return err
+	}
+	keys, err := s.keys(d, false)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, ok := s.members[key]; !ok {
+			if exist {
+				continue
+			}
+			return syscall.Errno(nl.IPSET_ERR_EXIST)
+		}
+		delete(s.members, key)
+	}
+	return nil
+}
+
+// test looks up the entry. For net types without an explicit CIDR the
+// most specific matching network wins, and a nomatch network means the
+// entry is not in the set.
+func (s *set) test(d *adtData) error {
+	if err := s.checkExtensions(d); err != nil {
+		return err
+	}
+	keys, err := s.keys(d, true)
+	if err != nil {
+		return err
+	}
+	key := keys[0]
+	host := s.hostMask()
+	if !s.typ.net || key.cidr != host {
+		if m, ok := s.members[key]; ok && !m.nomatch {
+			return nil
+		}
+		return syscall.Errno(nl.IPSET_ERR_EXIST)
+	}
+	ip := net.IP(key.ip[:])
+	for cidr := int(host); cidr > 0; cidr-- {
+		key.cidr = uint8(cidr)
+		copy(key.ip[:], ip.Mask(net.CIDRMask(cidr, int(host))).To16())
+		if m, ok := s.members[key]; ok {
+			if m.nomatch {
+				break
+			}
+			return nil
+		}
+	}
+	return syscall.Errno(nl.IPSET_ERR_EXIST)
+}
+
+func withPorts(proto uint8) bool {
+	switch proto {
+	case unix.IPPROTO_TCP, unix.IPPROTO_UDP, unix.IPPROTO_SCTP, unix.IPPROTO_UDPLITE:
+		return true
+	}
+	return false
+}
+
+type net4 struct {
+	ip   uint32
+	cidr uint8
+}
+
+// rangeToCIDRs4 covers from-to with the fewest networks.
+func rangeToCIDRs4(from, to uint32) []net4 {
+	var nets []net4
+	for {
+		cidr := uint8(32)
+		for cidr > 0 {
+			mask := ^uint32(0) << (32 - (cidr - 1))
+			if from&^mask != 0 || from|^mask > to {
+				break
+			}
+			cidr--
+		}
+		nets = append(nets, net4{from, cidr})
+		last := from | ^(^uint32(0) << (32 - cidr))
+		if cidr == 0 || last >= to {
+			return nets
+		}
+		from = last + 1
+	}
+}
+
+func ip4(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+func ip4To16(ip uint32) net.IP {
+	b := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(b, ip)
+	return b.To16()
+}
+
+func net16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func net64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
@@ -0,0 +1,131 @@
+package goipset
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+)
+
+// ListOptions are the options of WriteList, those of ipset list.
+type ListOptions struct {
+	Terse   bool // the headers without the members, see ListAllHeaders
+	Name    bool // the set names only, see ListNames
+	Sorted  bool // the members in order instead of as the kernel lists them
+	Resolve bool // the names of hosts instead of their addresses
+}
+
+// lookupAddr is net.LookupAddr, tests replace it.
+var lookupAddr = net.LookupAddr
+
+// WriteList writes sets as listed by List or ListAll in the format of
+// ipset list:
+//
+//	Name: ips
+//	Type: hash:ip
+//	Revision: 6
+//	Header: family inet hashsize 1024 maxelem 65536 timeout 600 bucketsize 12 initval 0x990187fd
+//	Size in memory: 264
+//	References: 0
+//	Number of entries: 1
+//	Members:
+//	10.0.0.1 timeout 300
+//
+// Sets are separated by an empty line. The header and the members have the
+// options of ipset save.
+func WriteList(w io.Writer, options ListOptions, sets ...GoIPSetResult) error {
+	formatIP := net.IP.String
+	if options.Resolve {
+		formatIP = resolveIP
+	}
+	for i := range sets {
+		set := &sets[i]
+		if options.Name {
+			if _, err := fmt.Fprintln(w, set.SetName); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var b strings.Builder
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "Name: %s\n", set.SetName)
+		fmt.Fprintf(&b, "Type: %s\n", set.TypeName)
+		fmt.Fprintf(&b, "Revision: %d\n", set.Revision)
+		fmt.Fprintf(&b, "Header:%s\n", joinOptions(createOptions(set)))
+		fmt.Fprintf(&b, "Size in memory: %d\n", set.SizeInMemory)
+		fmt.Fprintf(&b, "References: %d\n", set.References)
+		fmt.Fprintf(&b, "Number of entries: %d\n", set.NumEntries)
+		if !options.Terse {
+			b.WriteString("Members:\n")
+			entries := set.Entries
+			if options.Sorted {
+				entries = append([]GoIPSetEntry{}, entries...)
+				sort.SliceStable(entries, func(i, j int) bool {
+					return entryLess(&entries[i], &entries[j])
+				})
+			}
+			for j := range entries {
+				if entries[j].Set == nil {
+					return fmt.Errorf("Set is nil in GoIPSetEntry")
+				}
+				b.WriteString(formatElem(entries[j].Set, formatIP))
+				b.WriteString(joinOptions(entryOptions(set, &entries[j])))
+				b.WriteString("\n")
+			}
+		}
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveIP returns the host name of ip, or ip if it has none.
+func resolveIP(ip net.IP) string {
+	names, err := lookupAddr(ip.String())
+	if err != nil || len(names) == 0 {
+		return ip.String()
+	}
+	return strings.TrimSuffix(names[0], ".")
+}
+
+// entryLess orders entries by their MAC address or by their IP address,
+// prefix length, protocol and port.
+func entryLess(a, b *GoIPSetEntry) bool {
+	if macA, macB := entryMAC(a.Set), entryMAC(b.Set); macA != nil || macB != nil {
+		return bytes.Compare(macA, macB) < 0
+	}
+	ea, eb := entryFieldsOf(a.Set), entryFieldsOf(b.Set)
+	if c := bytes.Compare(ea.ip.To16(), eb.ip.To16()); c != 0 {
+		return c < 0
+	}
+	if ea.cidr != eb.cidr {
+		return ea.cidr < eb.cidr
+	}
+	if c := bytes.Compare(ea.ipTo.To16(), eb.ipTo.To16()); c != 0 {
+		return c < 0
+	}
+	if ea.proto != eb.proto {
+		return ea.proto < eb.proto
+	}
+	if ea.port != eb.port {
+		return ea.port < eb.port
+	}
+	return ea.portTo < eb.portTo
+}
+
+// entryMAC returns the MAC address of set, nil if it has none.
+func entryMAC(set Set) net.HardwareAddr {
+	switch s := set.(type) {
+	case *SetMac:
+		return s.MAC
+	case *SetResult:
+		return s.MAC
+	}
+	return nil
+}
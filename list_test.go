@@ -0,0 +1,94 @@
+package goipset
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/JiHanHuang/goipset/ipsettest"
+)
+
+func TestWriteList(t *testing.T) {
+	sets, _ := restoredSets(t)
+	var out bytes.Buffer
+	if err := WriteList(&out, ListOptions{}, sets...); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadFile("testdata/list.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != string(expected) {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+
+	out.Reset()
+	if err := WriteList(&out, ListOptions{Name: true}, sets...); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "ips\nnets\nmacs\n" {
+		t.Errorf("expected the names, got\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := WriteList(&out, ListOptions{Terse: true}, sets[:2]...); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "Members:") || strings.Count(out.String(), "Number of entries:") != 2 {
+		t.Errorf("expected the headers only, got\n%s", out.String())
+	}
+}
+
+func TestWriteListSortedResolved(t *testing.T) {
+	defer func(f func(string) ([]string, error)) { lookupAddr = f }(lookupAddr)
+	lookupAddr = func(addr string) ([]string, error) {
+		if addr == "10.0.0.2" {
+			return []string{"two.example."}, nil
+		}
+		return nil, fmt.Errorf("no name")
+	}
+
+	set := GoIPSetResult{SetName: "s", TypeName: "hash:net", Entries: []GoIPSetEntry{
+		{Set: &SetResult{IP: net.ParseIP("10.0.0.9"), CIDR: 32}},
+		{Set: &SetResult{IP: net.ParseIP("10.0.0.0"), CIDR: 24}},
+		{Set: &SetResult{IP: net.ParseIP("10.0.0.2"), CIDR: 32}},
+		{Set: &SetResult{IP: net.ParseIP("9.0.0.0"), CIDR: 8}},
+	}}
+	var out bytes.Buffer
+	if err := WriteList(&out, ListOptions{Sorted: true, Resolve: true}, set); err != nil {
+		t.Fatal(err)
+	}
+	members := strings.SplitN(out.String(), "Members:\n", 2)[1]
+	if members != "9.0.0.0/8\n10.0.0.0/24\ntwo.example\n10.0.0.9\n" {
+		t.Errorf("unexpected members\n%s", members)
+	}
+	if set.Entries[0].Set.(*SetResult).IP.String() != "10.0.0.9" {
+		t.Errorf("expected the entries of the set to stay in their order")
+	}
+}
+
+func TestListNamesHeaders(t *testing.T) {
+	ipset := NewGoIpsetWithTransport(ipsettest.NewKernel())
+	input := "create a hash:ip\nadd a 10.0.0.1\ncreate b hash:net\nadd b 10.0.0.0/8\n"
+	if err := ipset.Restore(strings.NewReader(input), RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	names, err := ipset.ListNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("expected a and b, got %v", names)
+	}
+	headers, err := ipset.ListAllHeaders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 2 || headers[1].TypeName != "hash:net" || headers[1].NumEntries != 1 || len(headers[1].Entries) != 0 {
+		t.Errorf("unexpected headers %+v", headers)
+	}
+}
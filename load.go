@@ -0,0 +1,84 @@
+package goipset
+
+import (
+	"fmt"
+
+	"github.com/JiHanHuang/goipset/nl"
+)
+
+// LoadOptions are the options of a bulk load with Load.
+type LoadOptions struct {
+	// Aggregate merges overlapping and adjacent networks before loading
+	// them, see AggregateNets. Only sets of network types can be
+	// aggregated.
+	Aggregate bool
+	// Exist ignores entries that are already in the set, like
+	// ipset -exist.
+	Exist bool
+}
+
+// LoadReport tells what a bulk load did.
+type LoadReport struct {
+	Entries int // entries given
+	Loaded  int // entries sent to the kernel
+	Saved   int // entries saved by aggregating
+}
+
+// Load adds many entries to an existing ipset.
+func Load(setname string, entries []GoIPSetEntry, options LoadOptions) (LoadReport, error) {
+	return gipset.Load(setname, entries, options)
+}
+
+// Load adds entries to an existing ipset in batches, which is a lot faster
+// than adding them one by one. Ranges the kernel cannot take are split into
+// networks as with Add. The kernel stops at the first entry it rejects,
+// the entries of the batches before stay added.
+func (g *GoIpset) Load(setname string, entries []GoIPSetEntry, options LoadOptions) (LoadReport, error) {
+	report := LoadReport{Entries: len(entries)}
+	var batch []*GoIPSetEntry
+	err := g.withHeader(setname, func(header *setHeader) error {
+		loading := entries
+		report.Saved = 0
+		if options.Aggregate && len(loading) > 0 {
+			if header.setType == nil || !header.setType.hasNet() {
+				return fmt.Errorf("%s: only sets of network types can be aggregated", setname)
+			}
+			aggregated, nets, err := aggregateNets(loading)
+			if err != nil {
+				return fmt.Errorf("%s: %v", setname, err)
+			}
+			report.Saved = nets - len(aggregated)
+			loading = aggregated
+		}
+
+		batch = make([]*GoIPSetEntry, 0, len(loading))
+		for i := range loading {
+			entry := &loading[i]
+			if entry.Set == nil {
+				return fmt.Errorf("Set is nil in GoIPSetEntry")
+			}
+			if header.setType == nil {
+				batch = append(batch, entry)
+				continue
+			}
+			if err := header.setType.ValidateEntry(header.family, header.revision, entry); err != nil {
+				return fmt.Errorf("%s: %v", setname, err)
+			}
+			expanded, err := header.setType.expandRange(header.family, header.revision, entry)
+			if err != nil {
+				return fmt.Errorf("%s: %v", setname, err)
+			}
+			batch = append(batch, expanded...)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	if err := g.ipsetAddDelBatch(nl.IPSET_CMD_ADD, setname, batch, options.Exist); err != nil {
+		return report, err
+	}
+	report.Loaded = len(batch)
+	return report, nil
+}
@@ -0,0 +1,203 @@
+package goipset
+
+import (
+	"fmt"
+	"math/bits"
+	"net"
+)
+
+// LookupIndex finds the entry of a set that matches an address in
+// userspace, as the kernel does for a packet: it tells which entry decides
+// and whether it is a nomatch exception, where the test command only says
+// whether the address is in the set.
+//
+// It keeps a radix tree of the networks of the set per family and, for
+// sets with ports, per protocol and port.
+type LookupIndex struct {
+	ports bool
+	trees map[aggregateKey]*lookupNode
+}
+
+// NewLookupIndex indexes the entries of the set result, as listed by List
+// or made up by the caller. The entries are those of the hash:net and
+// hash:net,port types, SetNet and SetNetPort, or addresses, which match as
+// networks of one address. Ranges are split into networks and port ranges
+// into their ports. The entries must all have ports or all not.
+func NewLookupIndex(result GoIPSetResult) (*LookupIndex, error) {
+	x := &LookupIndex{trees: map[aggregateKey]*lookupNode{}}
+	for i := range result.Entries {
+		if err := x.add(&result.Entries[i], i == 0); err != nil {
+			return nil, fmt.Errorf("%s: %v", result.SetName, err)
+		}
+	}
+	return x, nil
+}
+
+func (x *LookupIndex) add(entry *GoIPSetEntry, first bool) error {
+	if entry.Set == nil {
+		return fmt.Errorf("Set is nil in GoIPSetEntry")
+	}
+	e := entryFieldsOf(entry.Set)
+	if e.has(DimMAC) || e.ip == nil {
+		return fmt.Errorf("entry %s has no address", entry.Set)
+	}
+	if first {
+		x.ports = e.has(DimPort)
+	} else if x.ports != e.has(DimPort) {
+		return fmt.Errorf("entry %s can't be looked up with the other entries, only some of them have ports", entry.Set)
+	}
+
+	ip := e.ip.To4()
+	if ip == nil {
+		ip = e.ip.To16()
+	}
+	var prefixes []*net.IPNet
+	if e.ipTo != nil {
+		var err error
+		if prefixes, err = RangeToCIDRs(e.ip, e.ipTo); err != nil {
+			return err
+		}
+	} else {
+		cidr := int(e.cidr)
+		if cidr == 0 {
+			cidr = len(ip) * 8
+		}
+		if cidr > len(ip)*8 {
+			return fmt.Errorf("entry %s has an invalid prefix length", entry.Set)
+		}
+		prefixes = []*net.IPNet{{IP: ip.Mask(net.CIDRMask(cidr, len(ip)*8)), Mask: net.CIDRMask(cidr, len(ip)*8)}}
+	}
+
+	portTo := e.port
+	if e.portTo > e.port {
+		portTo = e.portTo
+	}
+	for port := int(e.port); port <= int(portTo); port++ {
+		key := aggregateKey{v6: len(ip) == net.IPv6len, port: x.ports, proto: e.proto, portFrom: uint16(port)}
+		for _, prefix := range prefixes {
+			ones, _ := prefix.Mask.Size()
+			x.trees[key] = x.trees[key].insert(prefix.IP, ones, entry)
+		}
+	}
+	return nil
+}
+
+// Lookup returns the entry that decides whether ip, and for a set with
+// ports the port and protocol, is in the set, and whether it is. As in the
+// kernel, the most specific network containing ip decides: ip is in the set
+// if it is a match entry and not if it is a nomatch entry. The entry is nil
+// if no network contains ip. The port of ICMP is the type and the code,
+// type<<8 | code.
+func (x *LookupIndex) Lookup(ip net.IP, port uint16, proto uint8) (entry *GoIPSetEntry, matched bool) {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	} else if ip = ip.To16(); ip == nil {
+		return nil, false
+	}
+	key := aggregateKey{v6: len(ip) == net.IPv6len}
+	if x.ports {
+		key.port, key.proto, key.portFrom = true, proto, port
+	}
+	entry = x.trees[key].lookup(ip)
+	return entry, entry != nil && !entry.NoMatch
+}
+
+// LookupEntry is Lookup for the address of an element, as ParseEntry
+// returns it for a set of the type of the indexed one. The element must be
+// a single address, not a network or a range.
+func (x *LookupIndex) LookupEntry(set Set) (entry *GoIPSetEntry, matched bool, err error) {
+	if set == nil {
+		return nil, false, fmt.Errorf("Set is nil")
+	}
+	e := entryFieldsOf(set)
+	if e.ip == nil {
+		return nil, false, fmt.Errorf("%s has no address", set)
+	}
+	length := net.IPv6len * 8
+	if e.ip.To4() != nil {
+		length = net.IPv4len * 8
+	}
+	if e.ipTo != nil || e.portTo > e.port || (e.cidr != 0 && int(e.cidr) != length) {
+		return nil, false, fmt.Errorf("%s is not a single address", set)
+	}
+	entry, matched = x.Lookup(e.ip, e.port, e.proto)
+	return entry, matched, nil
+}
+
+// lookupNode is a network in the radix tree of LookupIndex. Its children
+// are the networks in its two halves, the nodes between them are left out.
+// Nodes without an entry only join two children.
+type lookupNode struct {
+	ip    net.IP
+	cidr  int
+	entry *GoIPSetEntry
+	child [2]*lookupNode
+}
+
+// insert adds the network ip/cidr of entry below n, which may be nil, and
+// returns the node that takes the place of n.
+func (n *lookupNode) insert(ip net.IP, cidr int, entry *GoIPSetEntry) *lookupNode {
+	if n == nil {
+		return &lookupNode{ip: ip, cidr: cidr, entry: entry}
+	}
+	common := commonPrefixLen(n.ip, ip)
+	if common > cidr {
+		common = cidr
+	}
+	if common > n.cidr {
+		common = n.cidr
+	}
+
+	switch {
+	case common == n.cidr && common == cidr:
+		// A network listed twice, the nomatch entry wins.
+		if n.entry == nil || entry.NoMatch {
+			n.entry = entry
+		}
+		return n
+	case common == n.cidr:
+		bit := ipBit(ip, n.cidr)
+		n.child[bit] = n.child[bit].insert(ip, cidr, entry)
+		return n
+	case common == cidr:
+		parent := &lookupNode{ip: ip, cidr: cidr, entry: entry}
+		parent.child[ipBit(n.ip, cidr)] = n
+		return parent
+	}
+	join := &lookupNode{ip: ip.Mask(net.CIDRMask(common, len(ip)*8)), cidr: common}
+	join.child[ipBit(n.ip, common)] = n
+	join.child[ipBit(ip, common)] = &lookupNode{ip: ip, cidr: cidr, entry: entry}
+	return join
+}
+
+// lookup returns the entry of the most specific network below n that
+// contains ip.
+func (n *lookupNode) lookup(ip net.IP) *GoIPSetEntry {
+	var entry *GoIPSetEntry
+	for n != nil && len(n.ip) == len(ip) && commonPrefixLen(n.ip, ip) >= n.cidr {
+		if n.entry != nil {
+			entry = n.entry
+		}
+		if n.cidr == len(ip)*8 {
+			break
+		}
+		n = n.child[ipBit(ip, n.cidr)]
+	}
+	return entry
+}
+
+// commonPrefixLen returns the number of leading bits a and b, of the same
+// length, have in common.
+func commonPrefixLen(a, b net.IP) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return len(a) * 8
+}
+
+// ipBit returns the bit i of ip, bit 0 being the most significant.
+func ipBit(ip net.IP, i int) int {
+	return int(ip[i/8] >> (7 - uint(i%8)) & 1)
+}
@@ -0,0 +1,123 @@
+package goipset
+
+import (
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestLookupIndex(t *testing.T) {
+	result := GoIPSetResult{SetName: "a", Entries: netEntries(t,
+		"10.0.0.0/8", "!10.1.0.0/16", "10.1.2.0/24", "10.1.2.3", "192.168.0.0-192.168.0.5",
+		"2001:db8::/32", "!2001:db8:1::/48", "0.0.0.0/1")}
+	index, err := NewLookupIndex(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip       string
+		expected string
+		matched  bool
+	}{
+		{"10.9.9.9", "10.0.0.0/8", true},
+		{"10.1.9.9", "!10.1.0.0/16", false},
+		{"10.1.2.9", "10.1.2.0/24", true},
+		{"10.1.2.3", "10.1.2.3/32", true},
+		{"11.0.0.1", "0.0.0.0/1", true},
+		{"192.168.0.4", "192.168.0.0-192.168.0.5", true},
+		{"192.168.0.6", "", false},
+		{"2001:db8:1::1", "!2001:db8:1::/48", false},
+		{"2001:db8:2::1", "2001:db8::/32", true},
+		{"2001:db9::1", "", false},
+		{"::ffff:10.9.9.9", "10.0.0.0/8", true},
+	}
+	for _, test := range tests {
+		entry, matched := index.Lookup(net.ParseIP(test.ip), 0, 0)
+		got := ""
+		if entry != nil {
+			got = netStrings([]GoIPSetEntry{*entry})[0]
+		}
+		if got != test.expected || matched != test.matched {
+			t.Errorf("%s: expected %q %v, got %q %v", test.ip, test.expected, test.matched, got, matched)
+		}
+	}
+
+	set, _ := ParseEntry("hash:net", "inet", "10.1.2.4")
+	if entry, matched, err := index.LookupEntry(set); err != nil || !matched || entry.Set.String() != "10.1.2.0/24" {
+		t.Errorf("expected 10.1.2.4 to match 10.1.2.0/24, got %v %v %v", entry, matched, err)
+	}
+	set, _ = ParseEntry("hash:net", "inet", "10.1.2.0/24")
+	if _, _, err := index.LookupEntry(set); err == nil {
+		t.Errorf("expected a network to fail")
+	}
+}
+
+func TestLookupIndexPorts(t *testing.T) {
+	var result GoIPSetResult
+	for _, elem := range []string{"10.0.0.0/8,tcp:80", "10.0.0.0/24,tcp:80-81", "10.0.0.7,tcp:80", "10.0.0.0/16,udp:53", "10.0.0.0/8,icmp:echo-request"} {
+		set, err := ParseEntry("hash:net,port", "inet", elem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result.Entries = append(result.Entries, GoIPSetEntry{Set: set, NoMatch: elem == "10.0.0.7,tcp:80"})
+	}
+	index, err := NewLookupIndex(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip       string
+		port     uint16
+		proto    uint8
+		expected string
+		matched  bool
+	}{
+		{"10.0.0.1", 80, 6, "10.0.0.0/24,tcp:80-81", true},
+		{"10.0.0.1", 81, 6, "10.0.0.0/24,tcp:80-81", true},
+		{"10.0.1.1", 80, 6, "10.0.0.0/8,tcp:80", true},
+		{"10.0.1.1", 81, 6, "", false},
+		{"10.0.0.7", 80, 6, "10.0.0.7/32,tcp:80", false},
+		{"10.0.0.7", 81, 6, "10.0.0.0/24,tcp:80-81", true},
+		{"10.0.0.1", 53, 17, "10.0.0.0/16,udp:53", true},
+		{"10.0.0.1", 53, 6, "", false},
+		{"10.0.0.1", 8 << 8, 1, "10.0.0.0/8,icmp:echo-request", true},
+	}
+	for _, test := range tests {
+		entry, matched := index.Lookup(net.ParseIP(test.ip), test.port, test.proto)
+		got := ""
+		if entry != nil {
+			got = strings.ToLower(entry.Set.String())
+		}
+		if got != test.expected || matched != test.matched {
+			t.Errorf("%s %d/%d: expected %q %v, got %q %v", test.ip, test.port, test.proto, test.expected, test.matched, got, matched)
+		}
+	}
+
+	result.Entries = append(result.Entries, netEntries(t, "10.0.0.0/8")...)
+	if _, err := NewLookupIndex(result); err == nil {
+		t.Errorf("expected entries with and without ports to fail")
+	}
+}
+
+func TestLookupIndexRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for round := 0; round < 300; round++ {
+		var entries []GoIPSetEntry
+		for i := r.Intn(16); i > 0; i-- {
+			cidr := uint8(20 + r.Intn(13))
+			ip := net.IPv4(10, 0, byte(r.Intn(16)), byte(r.Intn(256))).Mask(net.CIDRMask(int(cidr), 32))
+			entries = append(entries, GoIPSetEntry{Set: &SetNet{IP: ip, CIDR: cidr}, NoMatch: r.Intn(3) == 0})
+		}
+		index, err := NewLookupIndex(GoIPSetResult{Entries: entries})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 4096; i++ {
+			ip := net.IPv4(10, 0, byte(i>>8), byte(i)).To4()
+			if _, matched := index.Lookup(ip, 0, 0); matched != lookup(entries, ip) {
+				t.Fatalf("expected %s to be %v in %v", ip, !matched, netStrings(entries))
+			}
+		}
+	}
+}
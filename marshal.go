@@ -0,0 +1,753 @@
+package goipset
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/JiHanHuang/goipset/nl"
+	"golang.org/x/sys/unix"
+)
+
+// The JSON and YAML encoding of a set, as listed by List, is an object
+// with the header of the set and its entries:
+//
+//	{
+//	  "name": "ips",            set name
+//	  "type": "hash:ip",        set type
+//	  "family": "inet",         inet or inet6, left out for hash:mac
+//	  "revision": 6,
+//	  "hashsize": 1024,         the create options, left out when 0 or false
+//	  "maxelem": 65536,
+//	  "netmask": 24,
+//	  "timeout": 600,
+//	  "counters": true,
+//	  "comment": true,
+//	  "skbinfo": true,
+//	  "forceadd": true,
+//	  "bucketsize": 12,
+//	  "initval": 2567014397,
+//	  "references": 0,          what the kernel tells about the set
+//	  "memsize": 408,
+//	  "numentries": 1,
+//	  "entries": [...]
+//	}
+//
+// Every entry is an object tagged with the type of its Set, the fields of
+// the Set and the options of the entry, which are left out when 0 or
+// false:
+//
+//	{
+//	  "type": "net,port",       ip, ip,port, net, net,port, mac or result
+//	  "elem": "10.0.0.0/8,tcp:80-90",  as ipset prints it, only for reading
+//	  "name": "web",            SetIPPort.Name
+//	  "ip": "10.0.0.0",
+//	  "ip_to": "10.0.0.255",
+//	  "cidr": 8,
+//	  "port": 80,
+//	  "port_to": 90,
+//	  "proto": "tcp",           the name of the protocol, or its number
+//	  "mac": "02:00:00:00:00:01",
+//	  "timeout": 300,
+//	  "packets": 7,
+//	  "bytes": 420,
+//	  "comment": "a host",
+//	  "nomatch": true,
+//	  "skbmark": 16,
+//	  "skbmask": 255,
+//	  "skbprio": 65538,
+//	  "skbqueue": 3
+//	}
+//
+// The text encoding of a set is its ipset save output, that of an entry
+// the element and options of an add line and that of a Set its element.
+//
+// The Nfgenmsg, Flags and ProtoMin of a GoIPSetResult and the Replace of
+// a GoIPSetEntry are not encoded, they only matter to a single request.
+
+// setJSON is the encoding of every Set type, Type tells which one it is.
+type setJSON struct {
+	Type   string `json:"type" yaml:"type"`
+	Elem   string `json:"elem,omitempty" yaml:"elem,omitempty"`
+	Name   string `json:"name,omitempty" yaml:"name,omitempty"`
+	IP     string `json:"ip,omitempty" yaml:"ip,omitempty"`
+	IPTo   string `json:"ip_to,omitempty" yaml:"ip_to,omitempty"`
+	CIDR   uint8  `json:"cidr,omitempty" yaml:"cidr,omitempty"`
+	Port   uint16 `json:"port,omitempty" yaml:"port,omitempty"`
+	PortTo uint16 `json:"port_to,omitempty" yaml:"port_to,omitempty"`
+	Proto  string `json:"proto,omitempty" yaml:"proto,omitempty"`
+	MAC    string `json:"mac,omitempty" yaml:"mac,omitempty"`
+}
+
+type entryJSON struct {
+	setJSON  `yaml:",inline"`
+	Timeout  uint32 `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	Packets  uint64 `json:"packets,omitempty" yaml:"packets,omitempty"`
+	Bytes    uint64 `json:"bytes,omitempty" yaml:"bytes,omitempty"`
+	Comment  string `json:"comment,omitempty" yaml:"comment,omitempty"`
+	NoMatch  bool   `json:"nomatch,omitempty" yaml:"nomatch,omitempty"`
+	SkbMark  uint32 `json:"skbmark,omitempty" yaml:"skbmark,omitempty"`
+	SkbMask  uint32 `json:"skbmask,omitempty" yaml:"skbmask,omitempty"`
+	SkbPrio  uint32 `json:"skbprio,omitempty" yaml:"skbprio,omitempty"`
+	SkbQueue uint16 `json:"skbqueue,omitempty" yaml:"skbqueue,omitempty"`
+}
+
+type resultJSON struct {
+	Name         string         `json:"name" yaml:"name"`
+	Type         string         `json:"type" yaml:"type"`
+	Family       string         `json:"family,omitempty" yaml:"family,omitempty"`
+	Revision     uint8          `json:"revision" yaml:"revision"`
+	HashSize     uint32         `json:"hashsize,omitempty" yaml:"hashsize,omitempty"`
+	MaxElements  uint32         `json:"maxelem,omitempty" yaml:"maxelem,omitempty"`
+	NetMask      uint8          `json:"netmask,omitempty" yaml:"netmask,omitempty"`
+	Timeout      uint32         `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	Counters     bool           `json:"counters,omitempty" yaml:"counters,omitempty"`
+	Comment      bool           `json:"comment,omitempty" yaml:"comment,omitempty"`
+	Skbinfo      bool           `json:"skbinfo,omitempty" yaml:"skbinfo,omitempty"`
+	ForceAdd     bool           `json:"forceadd,omitempty" yaml:"forceadd,omitempty"`
+	BucketSize   uint8          `json:"bucketsize,omitempty" yaml:"bucketsize,omitempty"`
+	InitVal      uint32         `json:"initval,omitempty" yaml:"initval,omitempty"`
+	References   uint32         `json:"references" yaml:"references"`
+	SizeInMemory uint32         `json:"memsize" yaml:"memsize"`
+	NumEntries   uint32         `json:"numentries" yaml:"numentries"`
+	Entries      []GoIPSetEntry `json:"entries" yaml:"entries"`
+}
+
+// setFlags maps the create options kept in CadtFlags to resultJSON.
+var setFlags = []struct {
+	flag  uint32
+	field func(*resultJSON) *bool
+}{
+	{nl.IPSET_FLAG_WITH_COUNTERS, func(r *resultJSON) *bool { return &r.Counters }},
+	{nl.IPSET_FLAG_WITH_COMMENT, func(r *resultJSON) *bool { return &r.Comment }},
+	{nl.IPSET_FLAG_WITH_SKBINFO, func(r *resultJSON) *bool { return &r.Skbinfo }},
+	{nl.IPSET_FLAG_WITH_FORCEADD, func(r *resultJSON) *bool { return &r.ForceAdd }},
+}
+
+func (result GoIPSetResult) toJSON() resultJSON {
+	r := resultJSON{
+		Name:         result.SetName,
+		Type:         result.TypeName,
+		Revision:     result.Revision,
+		HashSize:     result.HashSize,
+		MaxElements:  result.MaxElements,
+		NetMask:      result.NetMask,
+		Timeout:      result.Timeout,
+		BucketSize:   result.BucketSize,
+		InitVal:      result.InitVal,
+		References:   result.References,
+		SizeInMemory: result.SizeInMemory,
+		NumEntries:   result.NumEntries,
+		Entries:      result.Entries,
+	}
+	if result.Family != unix.AF_UNSPEC {
+		r.Family = familyString(int(result.Family))
+	}
+	for _, f := range setFlags {
+		*f.field(&r) = result.CadtFlags&f.flag != 0
+	}
+	if r.Entries == nil {
+		r.Entries = []GoIPSetEntry{}
+	}
+	return r
+}
+
+func (result *GoIPSetResult) fromJSON(r resultJSON) error {
+	family := 0
+	if r.Family != "" {
+		var err error
+		if family, err = parseFamily(r.Family); err != nil {
+			return err
+		}
+	}
+	*result = GoIPSetResult{
+		SetName:      r.Name,
+		TypeName:     r.Type,
+		Family:       uint8(family),
+		Revision:     r.Revision,
+		HashSize:     r.HashSize,
+		MaxElements:  r.MaxElements,
+		NetMask:      r.NetMask,
+		Timeout:      r.Timeout,
+		BucketSize:   r.BucketSize,
+		InitVal:      r.InitVal,
+		References:   r.References,
+		SizeInMemory: r.SizeInMemory,
+		NumEntries:   r.NumEntries,
+		Entries:      r.Entries,
+	}
+	for _, f := range setFlags {
+		if *f.field(&r) {
+			result.CadtFlags |= f.flag
+		}
+	}
+	return nil
+}
+
+// MarshalJSON encodes the set as described above.
+func (result GoIPSetResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(result.toJSON())
+}
+
+// UnmarshalJSON decodes a set encoded by MarshalJSON.
+func (result *GoIPSetResult) UnmarshalJSON(data []byte) error {
+	var r resultJSON
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	return result.fromJSON(r)
+}
+
+// MarshalYAML encodes the set like MarshalJSON, for gopkg.in/yaml.
+func (result GoIPSetResult) MarshalYAML() (interface{}, error) {
+	return result.toJSON(), nil
+}
+
+// UnmarshalYAML decodes a set encoded by MarshalYAML, for gopkg.in/yaml.
+func (result *GoIPSetResult) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var r resultJSON
+	if err := unmarshal(&r); err != nil {
+		return err
+	}
+	return result.fromJSON(r)
+}
+
+// MarshalText returns the set in the format of ipset save.
+func (result GoIPSetResult) MarshalText() ([]byte, error) {
+	var b bytes.Buffer
+	if err := WriteSave(&b, result); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// UnmarshalText parses a set in the format of ipset save: a create line
+// followed by the add lines of its entries.
+func (result *GoIPSetResult) UnmarshalText(text []byte) error {
+	*result = GoIPSetResult{}
+	for i, line := range strings.Split(string(text), "\n") {
+		if err := result.parseSaveLine(line); err != nil {
+			return &RestoreError{Line: i + 1, Err: err}
+		}
+	}
+	if result.SetName == "" {
+		return fmt.Errorf("no create line")
+	}
+	return nil
+}
+
+func (result *GoIPSetResult) parseSaveLine(line string) error {
+	args, err := SplitFields(line)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 || strings.HasPrefix(args[0], "#") {
+		return nil
+	}
+
+	switch {
+	case args[0] == "create" && result.SetName != "":
+		return fmt.Errorf("a second create line")
+	case args[0] == "create":
+		if len(args) < 3 {
+			return fmt.Errorf("create expects a set name and a type")
+		}
+		create, err := ParseCreateOptions(args[3:])
+		if err != nil {
+			return err
+		}
+		if create.Family == 0 {
+			if setType, ok := LookupSetType(args[2]); ok {
+				family, _ := setType.family(0)
+				create.Family = int(family)
+			}
+		}
+		result.SetName, result.TypeName = args[1], args[2]
+		result.Family = uint8(create.Family)
+		result.HashSize, result.MaxElements = create.HashSize, create.MaxElem
+		result.Timeout, result.BucketSize, result.InitVal = create.Timeout, create.BucketSize, create.InitVal
+		result.NetMask = create.NetMask
+		for _, f := range []struct {
+			on   bool
+			flag uint32
+		}{
+			{create.Counters, nl.IPSET_FLAG_WITH_COUNTERS},
+			{create.Comments, nl.IPSET_FLAG_WITH_COMMENT},
+			{create.Skbinfo, nl.IPSET_FLAG_WITH_SKBINFO},
+			{create.ForceAdd, nl.IPSET_FLAG_WITH_FORCEADD},
+		} {
+			if f.on {
+				result.CadtFlags |= f.flag
+			}
+		}
+		return nil
+	case args[0] == "add" && result.SetName == "":
+		return fmt.Errorf("add before the create line")
+	case args[0] == "add":
+		if len(args) < 3 {
+			return fmt.Errorf("add expects a set name and an entry")
+		}
+		if args[1] != result.SetName {
+			return fmt.Errorf("add to %s in the save output of %s", args[1], result.SetName)
+		}
+		family := ""
+		if result.Family == unix.AF_INET6 {
+			family = "inet6"
+		}
+		set, err := ParseEntry(result.TypeName, family, args[2])
+		if err != nil {
+			return err
+		}
+		entry := GoIPSetEntry{Set: set}
+		if err := ParseEntryOptions(&entry, args[3:]); err != nil {
+			return err
+		}
+		result.Entries = append(result.Entries, entry)
+		result.NumEntries++
+		return nil
+	}
+	return fmt.Errorf("unexpected command %q", args[0])
+}
+
+func (entry GoIPSetEntry) toJSON() (entryJSON, error) {
+	if entry.Set == nil {
+		return entryJSON{}, fmt.Errorf("Set is nil in GoIPSetEntry")
+	}
+	set, err := setToJSON(entry.Set)
+	if err != nil {
+		return entryJSON{}, err
+	}
+	return entryJSON{
+		setJSON:  set,
+		Timeout:  entry.Timeout,
+		Packets:  entry.Packets,
+		Bytes:    entry.Bytes,
+		Comment:  entry.Comment,
+		NoMatch:  entry.NoMatch,
+		SkbMark:  entry.SkbMark,
+		SkbMask:  entry.SkbMask,
+		SkbPrio:  entry.SkbPrio,
+		SkbQueue: entry.SkbQueue,
+	}, nil
+}
+
+func (entry *GoIPSetEntry) fromJSON(e entryJSON) error {
+	set, err := setFromJSON(e.setJSON)
+	if err != nil {
+		return err
+	}
+	*entry = GoIPSetEntry{
+		Set:      set,
+		Timeout:  e.Timeout,
+		Packets:  e.Packets,
+		Bytes:    e.Bytes,
+		Comment:  e.Comment,
+		NoMatch:  e.NoMatch,
+		SkbMark:  e.SkbMark,
+		SkbMask:  e.SkbMask,
+		SkbPrio:  e.SkbPrio,
+		SkbQueue: e.SkbQueue,
+	}
+	return nil
+}
+
+// MarshalJSON encodes the entry as described above.
+func (entry GoIPSetEntry) MarshalJSON() ([]byte, error) {
+	e, err := entry.toJSON()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(e)
+}
+
+// UnmarshalJSON decodes an entry encoded by MarshalJSON, the type tag
+// tells which Set it has.
+func (entry *GoIPSetEntry) UnmarshalJSON(data []byte) error {
+	var e entryJSON
+	if err := json.Unmarshal(data, &e); err != nil {
+		return err
+	}
+	return entry.fromJSON(e)
+}
+
+// MarshalYAML encodes the entry like MarshalJSON, for gopkg.in/yaml.
+func (entry GoIPSetEntry) MarshalYAML() (interface{}, error) {
+	return entry.toJSON()
+}
+
+// UnmarshalYAML decodes an entry encoded by MarshalYAML, for gopkg.in/yaml.
+func (entry *GoIPSetEntry) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var e entryJSON
+	if err := unmarshal(&e); err != nil {
+		return err
+	}
+	return entry.fromJSON(e)
+}
+
+// MarshalText returns the element and the options of the entry as on an
+// add line of ipset save.
+func (entry GoIPSetEntry) MarshalText() ([]byte, error) {
+	if entry.Set == nil {
+		return nil, fmt.Errorf("Set is nil in GoIPSetEntry")
+	}
+	return []byte(saveEntry(&GoIPSetResult{}, &entry)), nil
+}
+
+// UnmarshalText parses the element and the options of an add line. The
+// element is parsed for the type of the Set of the entry, or if it has
+// none, guessed: a MAC address is a SetMac, an element with a port a
+// SetNetPort if it has a prefix length and a SetIPPort if not, else one
+// with a prefix length a SetNet and anything else a SetIP.
+func (entry *GoIPSetEntry) UnmarshalText(text []byte) error {
+	args, err := SplitFields(string(text))
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("no element")
+	}
+	typename := setTypeName(entry.Set)
+	if typename == "" {
+		typename = guessSetTypeName(args[0])
+	}
+	set, err := ParseEntry(typename, guessFamily(args[0]), args[0])
+	if err != nil {
+		return err
+	}
+	*entry = GoIPSetEntry{Set: set}
+	return ParseEntryOptions(entry, args[1:])
+}
+
+// setTypeName returns the set type of the Set types that belong to one.
+func setTypeName(set Set) string {
+	switch set.(type) {
+	case *SetIP:
+		return "hash:ip"
+	case *SetIPPort:
+		return "hash:ip,port"
+	case *SetNet:
+		return "hash:net"
+	case *SetNetPort:
+		return "hash:net,port"
+	case *SetMac:
+		return "hash:mac"
+	}
+	return ""
+}
+
+// guessSetTypeName returns the set type an element looks like it is of.
+func guessSetTypeName(elem string) string {
+	if _, err := net.ParseMAC(elem); err == nil {
+		return "hash:mac"
+	}
+	ip := strings.SplitN(elem, ",", 2)
+	switch {
+	case len(ip) == 2 && strings.Contains(ip[0], "/"):
+		return "hash:net,port"
+	case len(ip) == 2:
+		return "hash:ip,port"
+	case strings.Contains(elem, "/"):
+		return "hash:net"
+	}
+	return "hash:ip"
+}
+
+// guessFamily returns the family for ParseEntry an element is of.
+func guessFamily(elem string) string {
+	ip := strings.SplitN(elem, ",", 2)[0]
+	if _, err := net.ParseMAC(ip); err != nil && strings.Contains(ip, ":") {
+		return "inet6"
+	}
+	return ""
+}
+
+// setToJSON returns the encoding of set.
+func setToJSON(set Set) (setJSON, error) {
+	s := setJSON{Elem: saveElem(set)}
+	var proto uint8
+	switch set := set.(type) {
+	case *SetIP:
+		s.Type = "ip"
+		s.IP, s.IPTo = ipString(set.IP), ipString(set.IPTO)
+	case *SetIPPort:
+		s.Type = "ip,port"
+		s.Name = set.Name
+		s.IP, s.IPTo = ipString(set.IP), ipString(set.IPTO)
+		s.Port, s.PortTo, proto = set.Port, set.PortTo, set.Proto
+	case *SetNet:
+		s.Type = "net"
+		s.IP, s.IPTo, s.CIDR = ipString(set.IP), ipString(set.IPTO), set.CIDR
+	case *SetNetPort:
+		s.Type = "net,port"
+		s.IP, s.IPTo, s.CIDR = ipString(set.IP), ipString(set.IPTO), set.CIDR
+		s.Port, s.PortTo, proto = set.Port, set.PortTo, set.Proto
+	case *SetMac:
+		s.Type = "mac"
+		s.MAC = macString(set.MAC)
+	case *SetResult:
+		s.Type = "result"
+		s.MAC, s.IP, s.CIDR = macString(set.MAC), ipString(set.IP), set.CIDR
+		s.Port, proto = set.Port, set.Proto
+	default:
+		return s, fmt.Errorf("can't encode a %T", set)
+	}
+	if proto != 0 {
+		s.Proto = protoName(proto)
+	}
+	return s, nil
+}
+
+// setFromJSON returns the Set s is the encoding of.
+func setFromJSON(s setJSON) (Set, error) {
+	ip, err := parseJSONIP(s.IP)
+	if err != nil {
+		return nil, err
+	}
+	ipTo, err := parseJSONIP(s.IPTo)
+	if err != nil {
+		return nil, err
+	}
+	var mac net.HardwareAddr
+	if s.MAC != "" {
+		if mac, err = net.ParseMAC(s.MAC); err != nil {
+			return nil, err
+		}
+	}
+	var proto uint8
+	if s.Proto != "" {
+		var ok bool
+		if proto, ok = lookupProto(s.Proto); !ok {
+			return nil, fmt.Errorf("unknown protocol %q", s.Proto)
+		}
+	}
+
+	switch s.Type {
+	case "ip":
+		return &SetIP{IP: ip, IPTO: ipTo}, nil
+	case "ip,port":
+		return &SetIPPort{Name: s.Name, IP: ip, IPTO: ipTo, Port: s.Port, PortTo: s.PortTo, Proto: proto}, nil
+	case "net":
+		return &SetNet{IP: ip, IPTO: ipTo, CIDR: s.CIDR}, nil
+	case "net,port":
+		return &SetNetPort{IP: ip, IPTO: ipTo, CIDR: s.CIDR, Port: s.Port, PortTo: s.PortTo, Proto: proto}, nil
+	case "mac":
+		return &SetMac{MAC: mac}, nil
+	case "result":
+		return &SetResult{MAC: mac, IP: ip, CIDR: s.CIDR, Port: s.Port, Proto: proto}, nil
+	}
+	return nil, fmt.Errorf("unknown entry type %q", s.Type)
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+func macString(mac net.HardwareAddr) string {
+	if mac == nil {
+		return ""
+	}
+	return mac.String()
+}
+
+func parseJSONIP(s string) (net.IP, error) {
+	if s == "" {
+		return nil, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", s)
+	}
+	return ip, nil
+}
+
+// marshalSetJSON and unmarshalSetJSON implement the JSON encoding of the
+// Set types, typ is the type tag of the Set to decode.
+func marshalSetJSON(set Set) ([]byte, error) {
+	s, err := setToJSON(set)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(s)
+}
+
+func unmarshalSetJSON(data []byte, typ string) (Set, error) {
+	var s setJSON
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	if s.Type == "" {
+		s.Type = typ
+	}
+	if s.Type != typ {
+		return nil, fmt.Errorf("expected an entry of type %s, got %s", typ, s.Type)
+	}
+	return setFromJSON(s)
+}
+
+// marshalSetText and unmarshalSetText implement the text encoding of the
+// Set types, typename is the set type the element is parsed for.
+func marshalSetText(set Set) ([]byte, error) {
+	return []byte(saveElem(set)), nil
+}
+
+func unmarshalSetText(text []byte, typename string) (Set, error) {
+	return ParseEntry(typename, guessFamily(string(text)), string(text))
+}
+
+// MarshalJSON encodes the Set as described above.
+func (set *SetIP) MarshalJSON() ([]byte, error) { return marshalSetJSON(set) }
+
+// UnmarshalJSON decodes a SetIP encoded by MarshalJSON.
+func (set *SetIP) UnmarshalJSON(data []byte) error {
+	s, err := unmarshalSetJSON(data, "ip")
+	if err == nil {
+		*set = *s.(*SetIP)
+	}
+	return err
+}
+
+// MarshalText returns the element as ipset prints it.
+func (set *SetIP) MarshalText() ([]byte, error) { return marshalSetText(set) }
+
+// UnmarshalText parses a hash:ip element.
+func (set *SetIP) UnmarshalText(text []byte) error {
+	s, err := unmarshalSetText(text, "hash:ip")
+	if err == nil {
+		*set = *s.(*SetIP)
+	}
+	return err
+}
+
+// MarshalJSON encodes the Set as described above.
+func (set *SetIPPort) MarshalJSON() ([]byte, error) { return marshalSetJSON(set) }
+
+// UnmarshalJSON decodes a SetIPPort encoded by MarshalJSON.
+func (set *SetIPPort) UnmarshalJSON(data []byte) error {
+	s, err := unmarshalSetJSON(data, "ip,port")
+	if err == nil {
+		*set = *s.(*SetIPPort)
+	}
+	return err
+}
+
+// MarshalText returns the element as ipset prints it.
+func (set *SetIPPort) MarshalText() ([]byte, error) { return marshalSetText(set) }
+
+// UnmarshalText parses a hash:ip,port element.
+func (set *SetIPPort) UnmarshalText(text []byte) error {
+	s, err := unmarshalSetText(text, "hash:ip,port")
+	if err == nil {
+		*set = *s.(*SetIPPort)
+	}
+	return err
+}
+
+// MarshalJSON encodes the Set as described above.
+func (set *SetNet) MarshalJSON() ([]byte, error) { return marshalSetJSON(set) }
+
+// UnmarshalJSON decodes a SetNet encoded by MarshalJSON.
+func (set *SetNet) UnmarshalJSON(data []byte) error {
+	s, err := unmarshalSetJSON(data, "net")
+	if err == nil {
+		*set = *s.(*SetNet)
+	}
+	return err
+}
+
+// MarshalText returns the element as ipset prints it.
+func (set *SetNet) MarshalText() ([]byte, error) { return marshalSetText(set) }
+
+// UnmarshalText parses a hash:net element.
+func (set *SetNet) UnmarshalText(text []byte) error {
+	s, err := unmarshalSetText(text, "hash:net")
+	if err == nil {
+		*set = *s.(*SetNet)
+	}
+	return err
+}
+
+// MarshalJSON encodes the Set as described above.
+func (set *SetNetPort) MarshalJSON() ([]byte, error) { return marshalSetJSON(set) }
+
+// UnmarshalJSON decodes a SetNetPort encoded by MarshalJSON.
+func (set *SetNetPort) UnmarshalJSON(data []byte) error {
+	s, err := unmarshalSetJSON(data, "net,port")
+	if err == nil {
+		*set = *s.(*SetNetPort)
+	}
+	return err
+}
+
+// MarshalText returns the element as ipset prints it.
+func (set *SetNetPort) MarshalText() ([]byte, error) { return marshalSetText(set) }
+
+// UnmarshalText parses a hash:net,port element.
+func (set *SetNetPort) UnmarshalText(text []byte) error {
+	s, err := unmarshalSetText(text, "hash:net,port")
+	if err == nil {
+		*set = *s.(*SetNetPort)
+	}
+	return err
+}
+
+// MarshalJSON encodes the Set as described above.
+func (set *SetMac) MarshalJSON() ([]byte, error) { return marshalSetJSON(set) }
+
+// UnmarshalJSON decodes a SetMac encoded by MarshalJSON.
+func (set *SetMac) UnmarshalJSON(data []byte) error {
+	s, err := unmarshalSetJSON(data, "mac")
+	if err == nil {
+		*set = *s.(*SetMac)
+	}
+	return err
+}
+
+// MarshalText returns the element as ipset prints it.
+func (set *SetMac) MarshalText() ([]byte, error) { return marshalSetText(set) }
+
+// UnmarshalText parses a hash:mac element.
+func (set *SetMac) UnmarshalText(text []byte) error {
+	s, err := unmarshalSetText(text, "hash:mac")
+	if err == nil {
+		*set = *s.(*SetMac)
+	}
+	return err
+}
+
+// MarshalJSON encodes the Set as described above.
+func (set *SetResult) MarshalJSON() ([]byte, error) { return marshalSetJSON(set) }
+
+// UnmarshalJSON decodes a SetResult encoded by MarshalJSON.
+func (set *SetResult) UnmarshalJSON(data []byte) error {
+	s, err := unmarshalSetJSON(data, "result")
+	if err == nil {
+		*set = *s.(*SetResult)
+	}
+	return err
+}
+
+// MarshalText returns the element as ipset prints it.
+func (set *SetResult) MarshalText() ([]byte, error) { return marshalSetText(set) }
+
+// UnmarshalText parses an element of any set type, guessed as by
+// GoIPSetEntry.UnmarshalText.
+func (set *SetResult) UnmarshalText(text []byte) error {
+	s, err := unmarshalSetText(text, guessSetTypeName(string(text)))
+	if err != nil {
+		return err
+	}
+	e := entryFieldsOf(s)
+	if e.ipTo != nil || e.portTo != 0 {
+		return fmt.Errorf("a SetResult can't hold the range %s", text)
+	}
+	*set = SetResult{IP: e.ip, CIDR: e.cidr, Port: e.port, Proto: e.proto}
+	if mac, ok := s.(*SetMac); ok {
+		set.MAC = mac.MAC
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package goipset
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/JiHanHuang/goipset/ipsettest"
+	"golang.org/x/sys/unix"
+	"gopkg.in/yaml.v3"
+)
+
+// restoredSets returns the sets of testdata/save.txt as listed by the
+// fake kernel, and the file.
+func restoredSets(t *testing.T) ([]GoIPSetResult, []byte) {
+	saved, err := ioutil.ReadFile("testdata/save.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipset := NewGoIpsetWithTransport(ipsettest.NewKernel())
+	if err := ipset.Restore(bytes.NewReader(saved), RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	sets, err := ipset.ListAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sets, saved
+}
+
+func TestMarshalJSON(t *testing.T) {
+	sets, saved := restoredSets(t)
+	data, err := json.MarshalIndent(sets, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadFile("testdata/sets.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data)+"\n" != string(expected) {
+		t.Errorf("expected\n%s\ngot\n%s", expected, data)
+	}
+
+	var decoded []GoIPSetResult
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := WriteSave(&out, decoded...); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != string(saved) {
+		t.Errorf("expected the decoded sets to save as\n%s\ngot\n%s", saved, out.String())
+	}
+}
+
+func TestMarshalJSONSets(t *testing.T) {
+	entries := []GoIPSetEntry{
+		{Set: &SetIP{IP: net.ParseIP("10.0.0.1"), IPTO: net.ParseIP("10.0.0.9")}, Timeout: 5},
+		{Set: &SetIPPort{Name: "ssh", IP: net.ParseIP("::1"), Port: 22, Proto: unix.IPPROTO_TCP}},
+		{Set: &SetNet{IP: net.ParseIP("10.0.0.0"), CIDR: 8}, NoMatch: true},
+		{Set: &SetNetPort{IP: net.ParseIP("10.0.0.0"), CIDR: 8, Port: 80, PortTo: 90, Proto: unix.IPPROTO_UDP}, Comment: "web"},
+		{Set: &SetMac{MAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}}, SkbMark: 1, SkbMask: 0xff, SkbPrio: 2, SkbQueue: 3},
+		{Set: &SetResult{IP: net.ParseIP("10.0.0.1"), CIDR: 32, Port: 53, Proto: unix.IPPROTO_UDP}, Packets: 1, Bytes: 2},
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []GoIPSetEntry
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, entries) {
+		t.Errorf("expected %+v, got %+v from %s", entries, decoded, data)
+	}
+
+	// A Set on its own has the same encoding.
+	set := entries[3].Set.(*SetNetPort)
+	data, err = json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"type":"net,port","elem":"10.0.0.0/8,udp:80-90","ip":"10.0.0.0","cidr":8,"port":80,"port_to":90,"proto":"udp"}`
+	if string(data) != expected {
+		t.Errorf("expected %s, got %s", expected, data)
+	}
+	var decodedSet SetNetPort
+	if err := json.Unmarshal(data, &decodedSet); err != nil || !reflect.DeepEqual(&decodedSet, set) {
+		t.Errorf("expected %+v, got %+v %v", set, decodedSet, err)
+	}
+	if err := json.Unmarshal(data, &SetNet{}); err == nil {
+		t.Errorf("expected a net,port entry not to decode into a SetNet")
+	}
+	if err := json.Unmarshal([]byte(`{"type":"bogus","ip":"10.0.0.1"}`), &GoIPSetEntry{}); err == nil {
+		t.Errorf("expected an unknown type to fail")
+	}
+}
+
+func TestMarshalYAML(t *testing.T) {
+	sets, saved := restoredSets(t)
+	data, err := yaml.Marshal(sets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadFile("testdata/sets.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(expected) {
+		t.Errorf("expected\n%s\ngot\n%s", expected, data)
+	}
+
+	var decoded []GoIPSetResult
+	if err := yaml.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := WriteSave(&out, decoded...); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != string(saved) {
+		t.Errorf("expected the decoded sets to save as\n%s\ngot\n%s", saved, out.String())
+	}
+}
+
+func TestMarshalYAMLSets(t *testing.T) {
+	entries := []GoIPSetEntry{
+		{Set: &SetIP{IP: net.ParseIP("10.0.0.1"), IPTO: net.ParseIP("10.0.0.9")}, Timeout: 5},
+		{Set: &SetIPPort{Name: "ssh", IP: net.ParseIP("::1"), Port: 22, Proto: unix.IPPROTO_TCP}},
+		{Set: &SetNet{IP: net.ParseIP("10.0.0.0"), CIDR: 8}, NoMatch: true},
+		{Set: &SetNetPort{IP: net.ParseIP("10.0.0.0"), CIDR: 8, Port: 80, PortTo: 90, Proto: unix.IPPROTO_UDP}, Comment: "web"},
+		{Set: &SetMac{MAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}}, SkbMark: 1, SkbMask: 0xff, SkbPrio: 2, SkbQueue: 3},
+		{Set: &SetResult{IP: net.ParseIP("10.0.0.1"), CIDR: 32, Port: 53, Proto: unix.IPPROTO_UDP}, Packets: 1, Bytes: 2},
+	}
+	data, err := yaml.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []GoIPSetEntry
+	if err := yaml.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, entries) {
+		t.Errorf("expected %+v, got %+v from\n%s", entries, decoded, data)
+	}
+
+	// The fields of the Set are inlined in the entry and those left at 0
+	// are left out.
+	data, err = yaml.Marshal(entries[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `type: net,port
+elem: 10.0.0.0/8,udp:80-90
+ip: 10.0.0.0
+cidr: 8
+port: 80
+port_to: 90
+proto: udp
+comment: web
+`
+	if string(data) != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, data)
+	}
+	if err := yaml.Unmarshal([]byte("type: bogus\nip: 10.0.0.1\n"), &GoIPSetEntry{}); err == nil {
+		t.Errorf("expected an unknown type to fail")
+	}
+}
+
+func TestMarshalText(t *testing.T) {
+	sets, saved := restoredSets(t)
+	var all []byte
+	for _, set := range sets {
+		text, err := set.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded GoIPSetResult
+		if err := decoded.UnmarshalText(text); err != nil {
+			t.Fatal(err)
+		}
+		again, err := decoded.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(again, text) {
+			t.Errorf("expected\n%s\ngot\n%s", text, again)
+		}
+		all = append(all, text...)
+	}
+	if !bytes.Equal(all, saved) {
+		t.Errorf("expected\n%s\ngot\n%s", saved, all)
+	}
+
+	var decoded GoIPSetResult
+	if err := decoded.UnmarshalText([]byte("create a hash:ip\nadd b 10.0.0.1\n")); err == nil || err.Error() != "line 2: add to b in the save output of a" {
+		t.Errorf("expected an add to another set to fail, got %v", err)
+	}
+}
+
+func TestEntryText(t *testing.T) {
+	tests := []struct {
+		text  string
+		entry GoIPSetEntry
+	}{
+		{"10.0.0.1 timeout 5", GoIPSetEntry{Set: &SetIP{IP: net.ParseIP("10.0.0.1").To4()}, Timeout: 5}},
+		{"2001:db8::/32 nomatch", GoIPSetEntry{Set: &SetNet{IP: net.ParseIP("2001:db8::"), CIDR: 32}, NoMatch: true}},
+		{"10.0.0.1,udp:53", GoIPSetEntry{Set: &SetIPPort{IP: net.ParseIP("10.0.0.1").To4(), Port: 53, Proto: unix.IPPROTO_UDP}}},
+		{"10.0.0.0/8,tcp:80 comment \"a b\"", GoIPSetEntry{Set: &SetNetPort{IP: net.ParseIP("10.0.0.0").To4(), CIDR: 8, Port: 80, Proto: unix.IPPROTO_TCP}, Comment: "a b"}},
+		{"02:00:00:00:00:01 skbmark 0x1/0xff", GoIPSetEntry{Set: &SetMac{MAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}}, SkbMark: 1, SkbMask: 0xff}},
+	}
+	for _, test := range tests {
+		var entry GoIPSetEntry
+		if err := entry.UnmarshalText([]byte(test.text)); err != nil {
+			t.Errorf("%q: %v", test.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(entry, test.entry) {
+			t.Errorf("expected %+v for %q, got %+v", test.entry, test.text, entry)
+		}
+		text, err := entry.MarshalText()
+		if err != nil || string(text) != test.text {
+			t.Errorf("expected %q, got %q %v", test.text, text, err)
+		}
+	}
+
+	// The Set of the entry tells how to parse the element.
+	entry := GoIPSetEntry{Set: &SetNet{}}
+	if err := entry.UnmarshalText([]byte("10.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := entry.Set.(*SetNet); !ok {
+		t.Errorf("expected a SetNet, got %T", entry.Set)
+	}
+
+	var result SetResult
+	if err := result.UnmarshalText([]byte("10.0.0.0/24,udp:53")); err != nil {
+		t.Fatal(err)
+	}
+	if text, _ := result.MarshalText(); string(text) != "10.0.0.0/24,udp:53" {
+		t.Errorf("expected 10.0.0.0/24,udp:53, got %s", text)
+	}
+}
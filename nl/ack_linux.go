@@ -0,0 +1,141 @@
+package nl
+
+import (
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Extended ACK attributes, see NLMSGERR_ATTR_* in linux/netlink.h
+const (
+	NLMSGERR_ATTR_UNUSED = iota
+	NLMSGERR_ATTR_MSG    /* 1: error message string */
+	NLMSGERR_ATTR_OFFS   /* 2: offset of the invalid attribute in the request */
+	NLMSGERR_ATTR_COOKIE /* 3: arbitrary subsystem specific cookie */
+)
+
+// ExtAckError is an error the kernel reported together with an extended
+// acknowledgement. Err is the errno, or whatever a subsystem specific layer
+// converted it to.
+type ExtAckError struct {
+	Err error
+	// Msg is the human readable message of the kernel, if any
+	Msg string
+	// Offset is the byte offset of the offending attribute in the request,
+	// counted from the start of its netlink header. 0 if not reported.
+	Offset uint32
+}
+
+func (e *ExtAckError) Error() string {
+	s := e.Err.Error()
+	if e.Msg != "" {
+		s = fmt.Sprintf("%s: %s", s, e.Msg)
+	}
+	if e.Offset != 0 {
+		s = fmt.Sprintf("%s (attribute at offset %d)", s, e.Offset)
+	}
+	return s
+}
+
+func (e *ExtAckError) Unwrap() error {
+	return e.Err
+}
+
+// RequestError is an error the kernel reported together with the request
+// it echoed, which some subsystems modify to point at what failed, e.g.
+// ipset at the line number of the entry of a batch. It is only returned
+// for requests with EchoErrors set.
+type RequestError struct {
+	Err error
+	// Request is the echoed request, from its netlink header on.
+	Request []byte
+}
+
+func (e *RequestError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// echoedRequest returns the request echoed in full by a NLMSG_ERROR
+// message, nil if it was capped or is not there.
+func echoedRequest(m syscall.NetlinkMessage) []byte {
+	if m.Header.Type != unix.NLMSG_ERROR || m.Header.Flags&unix.NLM_F_CAPPED != 0 || len(m.Data) < 4+unix.SizeofNlMsghdr {
+		return nil
+	}
+	echoed := m.Data[4:]
+	n := int(NativeEndian().Uint32(echoed[0:4]))
+	if n < unix.SizeofNlMsghdr || n > len(echoed) {
+		return nil
+	}
+	return echoed[:n]
+}
+
+// SetExtendedAck asks the kernel to cap the request echoed in error
+// messages (NETLINK_CAP_ACK) and to append extended ACK attributes
+// (NETLINK_EXT_ACK). Kernels without support for them return an error.
+func (s *NetlinkSocket) SetExtendedAck() error {
+	fd := s.GetFd()
+	if err := unix.SetsockoptInt(fd, unix.SOL_NETLINK, unix.NETLINK_CAP_ACK, 1); err != nil {
+		return err
+	}
+	return unix.SetsockoptInt(fd, unix.SOL_NETLINK, unix.NETLINK_EXT_ACK, 1)
+}
+
+// parseAckError returns the error carried by a NLMSG_ERROR or NLMSG_DONE
+// message, nil for a plain acknowledgement. Errors with extended ACK
+// attributes are returned as *ExtAckError, all others as syscall.Errno.
+func parseAckError(m syscall.NetlinkMessage) error {
+	if len(m.Data) < 4 {
+		return nil
+	}
+	native := NativeEndian()
+	errno := int32(native.Uint32(m.Data[0:4]))
+	if errno == 0 {
+		return nil
+	}
+	err := syscall.Errno(-errno)
+	if m.Header.Flags&unix.NLM_F_ACK_TLVS == 0 {
+		return err
+	}
+
+	// NLMSG_ERROR echoes the request header, and its payload too unless
+	// it was capped. NLMSG_DONE carries the attributes right away.
+	tlvs := m.Data[4:]
+	if m.Header.Type == unix.NLMSG_ERROR {
+		if len(tlvs) < unix.SizeofNlMsghdr {
+			return err
+		}
+		echoed := unix.SizeofNlMsghdr
+		if m.Header.Flags&unix.NLM_F_CAPPED == 0 {
+			echoed = int(native.Uint32(tlvs[0:4]))
+		}
+		// The attributes start after the padding of the echoed request.
+		echoed = rtaAlignOf(echoed)
+		if echoed > len(tlvs) {
+			return err
+		}
+		tlvs = tlvs[echoed:]
+	}
+
+	ackErr := &ExtAckError{Err: err}
+	it := NewAttributeIterator(tlvs)
+	for it.Next() {
+		attr := it.Attribute()
+		switch attr.Type & NLA_TYPE_MASK {
+		case NLMSGERR_ATTR_MSG:
+			ackErr.Msg = BytesToString(attr.Value)
+		case NLMSGERR_ATTR_OFFS:
+			if len(attr.Value) == 4 {
+				ackErr.Offset = attr.Uint32()
+			}
+		}
+	}
+	if ackErr.Msg == "" && ackErr.Offset == 0 {
+		return err
+	}
+	return ackErr
+}
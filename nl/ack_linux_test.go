@@ -0,0 +1,85 @@
+package nl
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func ackMessage(errno int32, flags uint16, tlvs ...*RtAttr) syscall.NetlinkMessage {
+	native := NativeEndian()
+	data := make([]byte, 4+unix.SizeofNlMsghdr)
+	native.PutUint32(data[0:4], uint32(errno))
+	// the echoed request header, its payload was capped
+	native.PutUint32(data[4:8], 64)
+	for _, tlv := range tlvs {
+		data = append(data, tlv.Serialize()...)
+	}
+	return syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{Type: unix.NLMSG_ERROR, Flags: flags},
+		Data:   data,
+	}
+}
+
+func TestParseAckError(t *testing.T) {
+	if err := parseAckError(ackMessage(0, 0)); err != nil {
+		t.Errorf("expected no error for an ack, got %v", err)
+	}
+
+	err := parseAckError(ackMessage(-int32(unix.EINVAL), 0))
+	if err != unix.EINVAL {
+		t.Errorf("expected EINVAL, got %v", err)
+	}
+
+	err = parseAckError(ackMessage(-int32(unix.EINVAL), unix.NLM_F_CAPPED|unix.NLM_F_ACK_TLVS,
+		NewRtAttr(NLMSGERR_ATTR_MSG, ZeroTerminated("missing attribute")),
+		NewRtAttr(NLMSGERR_ATTR_OFFS, Uint32Attr(36))))
+	var ackErr *ExtAckError
+	if !errors.As(err, &ackErr) {
+		t.Fatalf("expected an ExtAckError, got %T %v", err, err)
+	}
+	if ackErr.Msg != "missing attribute" {
+		t.Errorf("unexpected message %q", ackErr.Msg)
+	}
+	if ackErr.Offset != 36 {
+		t.Errorf("expected offset 36, got %d", ackErr.Offset)
+	}
+	if !errors.Is(err, unix.EINVAL) {
+		t.Error("expected the ExtAckError to unwrap to EINVAL")
+	}
+	if s := err.Error(); s != "invalid argument: missing attribute (attribute at offset 36)" {
+		t.Errorf("unexpected error string %q", s)
+	}
+}
+
+func TestEchoedRequest(t *testing.T) {
+	req := NewNetlinkRequest(0, 0)
+	req.AddData(NewRtAttr(1, []byte("set")))
+	b := req.Serialize()
+
+	errno := -int32(unix.EINVAL)
+	data := make([]byte, 4)
+	NativeEndian().PutUint32(data, uint32(errno))
+	m := syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{Type: unix.NLMSG_ERROR},
+		Data:   append(data, b...),
+	}
+	if echoed := echoedRequest(m); string(echoed) != string(b) {
+		t.Errorf("expected the request to be echoed, got %v", echoed)
+	}
+
+	m.Header.Flags = unix.NLM_F_CAPPED
+	if echoed := echoedRequest(m); echoed != nil {
+		t.Errorf("expected no request of a capped error, got %v", echoed)
+	}
+	if echoed := echoedRequest(ackMessage(-int32(unix.EINVAL), 0)); echoed != nil {
+		t.Errorf("expected no request of a truncated error, got %v", echoed)
+	}
+
+	err := error(&RequestError{Err: unix.EINVAL, Request: b})
+	if !errors.Is(err, unix.EINVAL) || err.Error() != unix.EINVAL.Error() {
+		t.Errorf("expected the RequestError to wrap EINVAL, got %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+//go:build go1.18
+// +build go1.18
+
+package nl
+
+import (
+	"io/ioutil"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// addFixtureSeeds adds the attributes of the ipset messages captured in
+// testdata to the corpus of f.
+func addFixtureSeeds(f *testing.F) {
+	for _, name := range []string{"ipset_list_result", "ipset_protocol_result"} {
+		msg, err := ioutil.ReadFile("../testdata/" + name)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(msg[SizeofNfgenmsg:])
+	}
+}
+
+func walkAttributes(t *testing.T, data []byte, depth int) {
+	it := NewAttributeIterator(data)
+	for it.Next() {
+		attr := it.Attribute()
+		if len(attr.Value) > len(data)-4 {
+			t.Fatalf("attribute value of %d bytes in %d bytes of data", len(attr.Value), len(data))
+		}
+		attr.Uint8()
+		attr.Uint16()
+		attr.Uint32()
+		attr.Uint64()
+		BytesToString(attr.Value)
+		if depth < 8 {
+			walkAttributes(t, attr.Value, depth+1)
+		}
+	}
+}
+
+func FuzzAttributeIterator(f *testing.F) {
+	addFixtureSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		walkAttributes(t, data, 0)
+		for range ParseAttributes(data) {
+		}
+	})
+}
+
+func FuzzDeserializeNfgenmsg(f *testing.F) {
+	f.Add([]byte{2, 0, 0, 0})
+	f.Add([]byte{2})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := DeserializeNfgenmsg(data)
+		if (err == nil) != (len(data) >= SizeofNfgenmsg) {
+			t.Fatalf("unexpected error %v for %d bytes", err, len(data))
+		}
+		if err == nil && msg.NfgenFamily != data[0] {
+			t.Fatalf("expected family %d, got %d", data[0], msg.NfgenFamily)
+		}
+	})
+}
+
+func FuzzParseAckError(f *testing.F) {
+	f.Add(uint16(unix.NLMSG_ERROR), uint16(unix.NLM_F_ACK_TLVS|unix.NLM_F_CAPPED), []byte{
+		0xef, 0xef, 0xff, 0xff,
+		0x14, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0x08, 0, NLMSGERR_ATTR_MSG, 0, 'b', 'a', 'd', 0,
+	})
+	f.Add(uint16(unix.NLMSG_DONE), uint16(unix.NLM_F_ACK_TLVS), []byte{
+		0xef, 0xef, 0xff, 0xff,
+		0x08, 0, NLMSGERR_ATTR_OFFS, 0, 0x10, 0, 0, 0,
+	})
+	// An echoed request whose length is not aligned, the padding used to
+	// run past the message.
+	f.Add(uint16(unix.NLMSG_ERROR), uint16(unix.NLM_F_ACK_TLVS), []byte{
+		0xff, 0xff, 0xff, 0xff,
+		0x15, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	})
+	f.Fuzz(func(t *testing.T, msgType, flags uint16, data []byte) {
+		parseAckError(syscall.NetlinkMessage{
+			Header: syscall.NlMsghdr{Type: msgType, Flags: flags},
+			Data:   data,
+		})
+	})
+}
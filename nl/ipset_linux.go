@@ -88,6 +88,12 @@ const (
 	SET_ATTR_CREATE_MAX
 )
 
+// Create attributes which took over unused slots in newer kernels
+const (
+	IPSET_ATTR_INITVAL    = IPSET_ATTR_GC     /* Hash seed, was unused GC */
+	IPSET_ATTR_BUCKETSIZE = IPSET_ATTR_PROBES /* Bucket size, was unused PROBES */
+)
+
 // IP specific attributes
 const (
 	IPSET_ATTR_IPADDR_IPV4 = 1
@@ -111,6 +117,16 @@ const (
 	IPSET_ATTR_SKBQUEUE
 )
 
+/* Flags at command level, in IPSET_ATTR_FLAGS */
+const (
+	IPSET_FLAG_BIT_EXIST        = 0
+	IPSET_FLAG_EXIST            = (1 << IPSET_FLAG_BIT_EXIST)
+	IPSET_FLAG_BIT_LIST_SETNAME = 1
+	IPSET_FLAG_LIST_SETNAME     = (1 << IPSET_FLAG_BIT_LIST_SETNAME)
+	IPSET_FLAG_BIT_LIST_HEADER  = 2
+	IPSET_FLAG_LIST_HEADER      = (1 << IPSET_FLAG_BIT_LIST_HEADER)
+)
+
 /* Flags at CADT attribute level, upper half of cmdattrs */
 const (
 	IPSET_FLAG_BIT_BEFORE        = 0
@@ -155,6 +171,16 @@ const (
 	IPSET_ERR_TYPE_SPECIFIC = 4352
 )
 
+/* Hash type specific error codes */
+const (
+	IPSET_ERR_HASH_FULL = IPSET_ERR_TYPE_SPECIFIC + iota
+	IPSET_ERR_HASH_ELEM
+	IPSET_ERR_INVALID_PROTO
+	IPSET_ERR_MISSING_PROTO
+	IPSET_ERR_HASH_RANGE_UNSUPPORTED
+	IPSET_ERR_HASH_RANGE
+)
+
 type IPSetError uintptr
 
 func (e IPSetError) Error() string {
@@ -197,6 +223,18 @@ func (e IPSetError) Error() string {
 		return "invalid markmask"
 	case IPSET_ERR_SKBINFO:
 		return "skbinfo"
+	case IPSET_ERR_HASH_FULL:
+		return "hash full"
+	case IPSET_ERR_HASH_ELEM:
+		return "null-valued element"
+	case IPSET_ERR_INVALID_PROTO:
+		return "invalid l4 protocol"
+	case IPSET_ERR_MISSING_PROTO:
+		return "missing l4 protocol"
+	case IPSET_ERR_HASH_RANGE_UNSUPPORTED:
+		return "range unsupported"
+	case IPSET_ERR_HASH_RANGE:
+		return "invalid range"
 	default:
 		return "errno " + strconv.Itoa(int(e))
 	}
@@ -4,6 +4,7 @@ package nl
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
@@ -47,6 +48,24 @@ var nextSeqNr uint32
 // Default netlink socket timeout, 60s
 var SocketTimeoutTv = unix.Timeval{Sec: 60, Usec: 0}
 
+// SocketReceiveBufferSize is the SO_RCVBUF size in bytes of the sockets
+// opened by Execute, 0 keeps the system default. Privileged processes get
+// it through SO_RCVBUFFORCE, so it may exceed net.core.rmem_max.
+var SocketReceiveBufferSize = 0
+
+// DumpRetries is how many times a dump is restarted after the kernel
+// interrupted it (NLM_F_DUMP_INTR) or replies were dropped (ENOBUFS).
+var DumpRetries = 3
+
+var (
+	// ErrDumpInterrupted is returned when a dump could not be completed
+	// consistently, because the dumped data changed meanwhile.
+	ErrDumpInterrupted = errors.New("netlink dump was interrupted")
+	// ErrMessageTruncated is returned when a reply did not fit in the
+	// receive buffer.
+	ErrMessageTruncated = errors.New("netlink message was truncated")
+)
+
 // GetIPFamily returns the family type of a net.IP.
 func GetIPFamily(ip net.IP) int {
 	if len(ip) <= net.IPv4len {
@@ -377,6 +396,9 @@ type NetlinkRequest struct {
 	Data         []NetlinkRequestData
 	RawData      []byte
 	SocketHandle *SocketHandle
+	// EchoErrors returns errors of the kernel that echo the request as
+	// *RequestError.
+	EchoErrors bool
 }
 
 // Serialize the Netlink Request into a byte array
@@ -418,8 +440,63 @@ func (req *NetlinkRequest) AddRawData(data []byte) {
 
 // Execute the request against a the given sockType.
 // Returns a list of netlink messages in serialized format, optionally filtered
-// by resType.
+// by resType. Interrupted dumps are transparently restarted.
 func (req *NetlinkRequest) Execute(sockType int, resType uint16) ([][]byte, error) {
+	var res [][]byte
+
+	err := req.ExecuteDump(sockType, resType, func(msg []byte) error {
+		res = append(res, msg)
+		return nil
+	}, func() error {
+		res = nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ExecuteEach executes the request against the given sockType and hands every
+// reply message, optionally filtered by resType, to fn as soon as it is
+// received. Messages are not retained, so fn must copy anything it wants to
+// keep. If fn returns an error the remaining replies are discarded and the
+// error is returned.
+//
+// An interrupted dump is only restarted as long as fn has not seen any
+// message yet, otherwise ErrDumpInterrupted or ENOBUFS is returned. Use
+// ExecuteDump to restart it anyway.
+func (req *NetlinkRequest) ExecuteEach(sockType int, resType uint16, fn func(msg []byte) error) error {
+	return req.ExecuteDump(sockType, resType, fn, nil)
+}
+
+// ExecuteDump is like ExecuteEach, but a dump interrupted after messages
+// were delivered is restarted too, up to DumpRetries times. restart is
+// called before every such restart, so that the caller can drop what it
+// got so far.
+func (req *NetlinkRequest) ExecuteDump(sockType int, resType uint16, fn func(msg []byte) error, restart func() error) error {
+	isDump := req.Flags&unix.NLM_F_DUMP == unix.NLM_F_DUMP
+	for attempt := 0; ; attempt++ {
+		delivered := false
+		err := req.execute(sockType, resType, func(msg []byte) error {
+			delivered = true
+			return fn(msg)
+		})
+		if !isDump || attempt >= DumpRetries || (!errors.Is(err, ErrDumpInterrupted) && !errors.Is(err, unix.ENOBUFS)) {
+			return err
+		}
+		if delivered {
+			if restart == nil {
+				return err
+			}
+			if err := restart(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (req *NetlinkRequest) execute(sockType int, resType uint16, fn func(msg []byte) error) error {
 	var (
 		s      *NetlinkSocket
 		err    error
@@ -435,18 +512,10 @@ func (req *NetlinkRequest) Execute(sockType int, resType uint16) ([][]byte, erro
 	sharedSocket := s != nil
 
 	if s == nil {
-		s, err = getNetlinkSocket(sockType)
+		s, err = newRequestSocket(sockType)
 		if err != nil {
-			return nil, err
+			return err
 		}
-
-		if err := s.SetSendTimeout(&SocketTimeoutTv); err != nil {
-			return nil, err
-		}
-		if err := s.SetReceiveTimeout(&SocketTimeoutTv); err != nil {
-			return nil, err
-		}
-
 		defer s.Close()
 	} else {
 		s.Lock()
@@ -454,53 +523,98 @@ func (req *NetlinkRequest) Execute(sockType int, resType uint16) ([][]byte, erro
 	}
 
 	if err := s.Send(req); err != nil {
-		return nil, err
+		return err
 	}
 
 	pid, err := s.GetPid()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	var res [][]byte
+	// stopErr is set once the caller asked to stop or the dump got
+	// interrupted. A shared socket must still be drained up to the end of
+	// the reply, otherwise the leftovers would be read by the next request.
+	var stopErr error
 
 done:
 	for {
 		msgs, from, err := s.Receive()
 		if err != nil {
-			return nil, err
+			return err
 		}
 		if from.Pid != PidKernel {
-			return nil, fmt.Errorf("Wrong sender portid %d, expected %d", from.Pid, PidKernel)
+			return fmt.Errorf("Wrong sender portid %d, expected %d", from.Pid, PidKernel)
 		}
 		for _, m := range msgs {
 			if m.Header.Seq != reqSeq {
 				if sharedSocket {
 					continue
 				}
-				return nil, fmt.Errorf("Wrong Seq nr %d, expected %d", m.Header.Seq, reqSeq)
+				return fmt.Errorf("Wrong Seq nr %d, expected %d", m.Header.Seq, reqSeq)
 			}
 			if m.Header.Pid != pid {
 				continue
 			}
+			if m.Header.Flags&unix.NLM_F_DUMP_INTR != 0 && stopErr == nil {
+				stopErr = ErrDumpInterrupted
+				if !sharedSocket {
+					return stopErr
+				}
+			}
 			if m.Header.Type == unix.NLMSG_DONE || m.Header.Type == unix.NLMSG_ERROR {
-				native := NativeEndian()
-				error := int32(native.Uint32(m.Data[0:4]))
-				if error == 0 {
+				err := parseAckError(m)
+				if err == nil {
 					break done
 				}
-				return nil, syscall.Errno(-error)
+				if stopErr != nil {
+					return stopErr
+				}
+				if echoed := echoedRequest(m); req.EchoErrors && echoed != nil {
+					return &RequestError{Err: err, Request: echoed}
+				}
+				return err
 			}
 			if resType != 0 && m.Header.Type != resType {
 				continue
 			}
-			res = append(res, m.Data)
+			if stopErr == nil {
+				stopErr = fn(m.Data)
+				if stopErr != nil && !sharedSocket {
+					return stopErr
+				}
+			}
 			if m.Header.Flags&unix.NLM_F_MULTI == 0 {
 				break done
 			}
 		}
 	}
-	return res, nil
+	return stopErr
+}
+
+// newRequestSocket opens a socket of protocol for requests, with the
+// timeouts, extended ACKs and receive buffer size they expect.
+func newRequestSocket(protocol int) (*NetlinkSocket, error) {
+	s, err := getNetlinkSocket(protocol)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.SetSendTimeout(&SocketTimeoutTv); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.SetReceiveTimeout(&SocketTimeoutTv); err != nil {
+		s.Close()
+		return nil, err
+	}
+	// Best effort, older kernels only report the errno.
+	s.SetExtendedAck()
+	if SocketReceiveBufferSize > 0 {
+		if err := s.SetReceiveBufferSize(SocketReceiveBufferSize, true); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
 }
 
 // Create a new netlink request from proto and flags
@@ -586,17 +700,25 @@ func (s *NetlinkSocket) Send(request *NetlinkRequest) error {
 	return nil
 }
 
+// Receive reads the next datagram from the socket. Its size is peeked first,
+// so the buffer always fits the reply, however large.
 func (s *NetlinkSocket) Receive() ([]syscall.NetlinkMessage, *unix.SockaddrNetlink, error) {
 	fd := int(atomic.LoadInt32(&s.fd))
 	if fd < 0 {
 		return nil, nil, fmt.Errorf("Receive called on a closed socket")
 	}
-	var fromAddr *unix.SockaddrNetlink
-	var rb [RECEIVE_BUFFER_SIZE]byte
-	nr, from, err := unix.Recvfrom(fd, rb[:], 0)
+	size, _, err := unix.Recvfrom(fd, nil, unix.MSG_PEEK|unix.MSG_TRUNC)
 	if err != nil {
 		return nil, nil, err
 	}
+	rb := make([]byte, size)
+	nr, _, flags, from, err := unix.Recvmsg(fd, rb, nil, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if flags&unix.MSG_TRUNC != 0 {
+		return nil, nil, ErrMessageTruncated
+	}
 	fromAddr, ok := from.(*unix.SockaddrNetlink)
 	if !ok {
 		return nil, nil, fmt.Errorf("Error converting to netlink sockaddr")
@@ -604,15 +726,34 @@ func (s *NetlinkSocket) Receive() ([]syscall.NetlinkMessage, *unix.SockaddrNetli
 	if nr < unix.NLMSG_HDRLEN {
 		return nil, nil, fmt.Errorf("Got short response from netlink")
 	}
-	rb2 := make([]byte, nr)
-	copy(rb2, rb[:nr])
-	nl, err := syscall.ParseNetlinkMessage(rb2)
+	nl, err := syscall.ParseNetlinkMessage(rb[:nr])
 	if err != nil {
 		return nil, nil, err
 	}
 	return nl, fromAddr, nil
 }
 
+// SetReceiveBufferSize sets the receive buffer size of the socket. With
+// force SO_RCVBUFFORCE is tried first, which lets privileged processes exceed
+// net.core.rmem_max, falling back to SO_RCVBUF when it is not permitted.
+func (s *NetlinkSocket) SetReceiveBufferSize(size int, force bool) error {
+	fd := int(atomic.LoadInt32(&s.fd))
+	if force {
+		err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUFFORCE, size)
+		if err != unix.EPERM {
+			return err
+		}
+	}
+	return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, size)
+}
+
+// GetReceiveBufferSize returns the receive buffer size of the socket, as
+// reported by the kernel.
+func (s *NetlinkSocket) GetReceiveBufferSize() (int, error) {
+	fd := int(atomic.LoadInt32(&s.fd))
+	return unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF)
+}
+
 // SetSendTimeout allows to set a send timeout on the socket
 func (s *NetlinkSocket) SetSendTimeout(timeout *unix.Timeval) error {
 	// Set a send timeout of SOCKET_SEND_TIMEOUT, this will allow the Send to periodically unblock and avoid that a routine
@@ -657,8 +798,13 @@ func NonZeroTerminated(s string) []byte {
 	return bytes
 }
 
+// BytesToString returns the NUL terminated string at the start of b, or all
+// of b if it isn't terminated.
 func BytesToString(b []byte) string {
-	n := bytes.Index(b, []byte{0})
+	n := bytes.IndexByte(b, 0)
+	if n < 0 {
+		return string(b)
+	}
 	return string(b[:n])
 }
 
@@ -716,6 +862,17 @@ type SocketHandle struct {
 	Socket *NetlinkSocket
 }
 
+// NewSocketHandle opens a socket of protocol that requests share by
+// setting their SocketHandle, set up like the socket a request without one
+// opens for itself.
+func NewSocketHandle(protocol int) (*SocketHandle, error) {
+	s, err := newRequestSocket(protocol)
+	if err != nil {
+		return nil, err
+	}
+	return &SocketHandle{Socket: s}, nil
+}
+
 // Close closes the netlink socket
 func (sh *SocketHandle) Close() {
 	if sh.Socket != nil {
@@ -743,8 +900,14 @@ func (msg *Nfgenmsg) Len() int {
 	return SizeofNfgenmsg
 }
 
-func DeserializeNfgenmsg(b []byte) *Nfgenmsg {
-	return (*Nfgenmsg)(unsafe.Pointer(&b[0:SizeofNfgenmsg][0]))
+// DeserializeNfgenmsg returns a copy of the nfgenmsg header at the start of
+// b.
+func DeserializeNfgenmsg(b []byte) (*Nfgenmsg, error) {
+	if len(b) < SizeofNfgenmsg {
+		return nil, fmt.Errorf("nfgenmsg header too short: %d bytes, expected %d", len(b), SizeofNfgenmsg)
+	}
+	msg := *(*Nfgenmsg)(unsafe.Pointer(&b[0:SizeofNfgenmsg][0]))
+	return &msg, nil
 }
 
 func (msg *Nfgenmsg) Serialize() []byte {
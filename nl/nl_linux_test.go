@@ -98,3 +98,53 @@ func TestIfSocketCloses(t *testing.T) {
 		t.Fatalf("Expected error instead received nil")
 	}
 }
+
+func TestSetReceiveBufferSize(t *testing.T) {
+	s, err := getNetlinkSocket(unix.NETLINK_ROUTE)
+	if err != nil {
+		t.Fatalf("Error on creating the socket: %v", err)
+	}
+	defer s.Close()
+
+	if err := s.SetReceiveBufferSize(32768, true); err != nil {
+		t.Fatal(err)
+	}
+	size, err := s.GetReceiveBufferSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the kernel doubles the value to leave room for bookkeeping
+	if size < 32768 {
+		t.Errorf("expected a receive buffer of at least 32768 bytes, got %d", size)
+	}
+}
+
+func TestExecuteDump(t *testing.T) {
+	defer func(size int) { SocketReceiveBufferSize = size }(SocketReceiveBufferSize)
+	SocketReceiveBufferSize = 4096
+
+	req := NewNetlinkRequest(unix.RTM_GETLINK, unix.NLM_F_DUMP)
+	req.AddData(NewIfInfomsg(unix.AF_UNSPEC))
+
+	msgs, err := req.Execute(unix.NETLINK_ROUTE, unix.RTM_NEWLINK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) == 0 {
+		t.Fatal("expected at least the loopback link")
+	}
+
+	restarts := 0
+	err = req.ExecuteDump(unix.NETLINK_ROUTE, unix.RTM_NEWLINK, func(msg []byte) error {
+		return nil
+	}, func() error {
+		restarts++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restarts > DumpRetries {
+		t.Errorf("expected at most %d restarts, got %d", DumpRetries, restarts)
+	}
+}
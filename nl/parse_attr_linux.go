@@ -11,31 +11,82 @@ type Attribute struct {
 	Value []byte
 }
 
-func ParseAttributes(data []byte) <-chan Attribute {
+// AttributeIterator walks over the netlink attributes in a buffer without
+// spawning goroutines or allocating. Use it like
+//
+//	it := NewAttributeIterator(data)
+//	for it.Next() {
+//		attr := it.Attribute()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type AttributeIterator struct {
+	data []byte
+	attr Attribute
+	err  error
+}
+
+// NewAttributeIterator returns an iterator over the attributes in data.
+func NewAttributeIterator(data []byte) AttributeIterator {
+	return AttributeIterator{data: data}
+}
+
+// Next advances to the next attribute. It returns false when there are no
+// attributes left or a malformed attribute was found, see Err.
+func (it *AttributeIterator) Next() bool {
+	if it.err != nil || len(it.data) < 4 {
+		return false
+	}
 	native := NativeEndian()
+	length := int(native.Uint16(it.data[0:2]))
+	attrType := native.Uint16(it.data[2:4])
+
+	if length < 4 {
+		it.err = fmt.Errorf("attribute 0x%02x has invalid length of %d bytes", attrType, length)
+		return false
+	}
+	if len(it.data) < length {
+		it.err = fmt.Errorf("attribute 0x%02x of length %d is truncated, only %d bytes remaining", attrType, length, len(it.data))
+		return false
+	}
+
+	it.attr.Type = attrType
+	it.attr.Value = it.data[4:length]
+	if next := rtaAlignOf(length); next < len(it.data) {
+		it.data = it.data[next:]
+	} else {
+		it.data = nil
+	}
+	return true
+}
+
+// Attribute returns the current attribute. Its value aliases the buffer the
+// iterator was created with.
+func (it *AttributeIterator) Attribute() Attribute {
+	return it.attr
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *AttributeIterator) Err() error {
+	return it.err
+}
+
+// ParseAttributes sends the attributes in data over the returned channel.
+//
+// Deprecated: ParseAttributes starts a goroutine per call which leaks if the
+// channel is not drained. Use NewAttributeIterator instead.
+func ParseAttributes(data []byte) <-chan Attribute {
 	result := make(chan Attribute)
 
 	go func() {
-		i := 0
-		for i+4 < len(data) {
-			length := int(native.Uint16(data[i : i+2]))
-			attrType := native.Uint16(data[i+2 : i+4])
-
-			if length < 4 {
-				log.Printf("attribute 0x%02x has invalid length of %d bytes", attrType, length)
-				break
-			}
-
-			if len(data) < i+length {
-				log.Printf("attribute 0x%02x of length %d is truncated, only %d bytes remaining", attrType, length, len(data)-i)
-				break
-			}
-
-			result <- Attribute{
-				Type:  attrType,
-				Value: data[i+4 : i+length],
-			}
-			i += rtaAlignOf(length)
+		it := NewAttributeIterator(data)
+		for it.Next() {
+			result <- it.Attribute()
+		}
+		if err := it.Err(); err != nil {
+			log.Print(err)
 		}
 		close(result)
 	}()
@@ -48,7 +99,9 @@ func PrintAttributes(data []byte) {
 }
 
 func printAttributes(data []byte, level int) {
-	for attr := range ParseAttributes(data) {
+	it := NewAttributeIterator(data)
+	for it.Next() {
+		attr := it.Attribute()
 		for i := 0; i < level; i++ {
 			print("> ")
 		}
@@ -60,14 +113,21 @@ func printAttributes(data []byte, level int) {
 	}
 }
 
-// Uint8 returns the uint8 value respecting the NET_BYTEORDER flag
+// Uint8 returns the uint8 value respecting the NET_BYTEORDER flag. Like
+// the other accessors it returns 0 if the value is too short, callers
+// decoding untrusted messages should check the length first.
 func (attr *Attribute) Uint8() uint8 {
-	_ = attr.Value[0]
+	if len(attr.Value) < 1 {
+		return 0
+	}
 	return uint8(attr.Value[0])
 }
 
 // Uint16 returns the uint16 value respecting the NET_BYTEORDER flag
 func (attr *Attribute) Uint16() uint16 {
+	if len(attr.Value) < 2 {
+		return 0
+	}
 	if attr.Type&NLA_F_NET_BYTEORDER != 0 {
 		return binary.BigEndian.Uint16(attr.Value)
 	} else {
@@ -77,6 +137,9 @@ func (attr *Attribute) Uint16() uint16 {
 
 // Uint32 returns the uint32 value respecting the NET_BYTEORDER flag
 func (attr *Attribute) Uint32() uint32 {
+	if len(attr.Value) < 4 {
+		return 0
+	}
 	if attr.Type&NLA_F_NET_BYTEORDER != 0 {
 		return binary.BigEndian.Uint32(attr.Value)
 	} else {
@@ -86,6 +149,9 @@ func (attr *Attribute) Uint32() uint32 {
 
 // Uint64 returns the uint64 value respecting the NET_BYTEORDER flag
 func (attr *Attribute) Uint64() uint64 {
+	if len(attr.Value) < 8 {
+		return 0
+	}
 	if attr.Type&NLA_F_NET_BYTEORDER != 0 {
 		return binary.BigEndian.Uint64(attr.Value)
 	} else {
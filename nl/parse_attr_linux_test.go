@@ -0,0 +1,123 @@
+package nl
+
+import (
+	"testing"
+)
+
+func TestAttributeIterator(t *testing.T) {
+	data := NewRtAttr(1, []byte{0x1, 0x2, 0x3}).Serialize()
+	data = append(data, NewRtAttr(2, nil).Serialize()...)
+	nested := NewRtAttr(3|int(NLA_F_NESTED), nil)
+	nested.AddRtAttr(4, Uint32Attr(42))
+	data = append(data, nested.Serialize()...)
+
+	var types []uint16
+	it := NewAttributeIterator(data)
+	for it.Next() {
+		attr := it.Attribute()
+		types = append(types, attr.Type&NLA_TYPE_MASK)
+		switch attr.Type {
+		case 1:
+			if len(attr.Value) != 3 || attr.Value[2] != 0x3 {
+				t.Errorf("unexpected value of attribute 1: %v", attr.Value)
+			}
+		case 2:
+			if len(attr.Value) != 0 {
+				t.Errorf("expected empty attribute 2, got %v", attr.Value)
+			}
+		case 3 | NLA_F_NESTED:
+			child := NewAttributeIterator(attr.Value)
+			if !child.Next() {
+				t.Fatalf("expected a nested attribute: %v", child.Err())
+			}
+			if v := child.Attribute(); v.Uint32() != 42 {
+				t.Errorf("expected nested value 42, got %d", v.Uint32())
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 3 || types[0] != 1 || types[1] != 2 || types[2] != 3 {
+		t.Errorf("unexpected attributes: %v", types)
+	}
+}
+
+func TestAttributeIteratorMalformed(t *testing.T) {
+	data := NewRtAttr(1, Uint32Attr(1)).Serialize()
+
+	it := NewAttributeIterator(data[:6])
+	if it.Next() {
+		t.Error("expected a truncated attribute to stop the iteration")
+	}
+	if it.Err() == nil {
+		t.Error("expected an error for a truncated attribute")
+	}
+
+	data[0], data[1] = 2, 0
+	it = NewAttributeIterator(data)
+	if it.Next() {
+		t.Error("expected an invalid length to stop the iteration")
+	}
+	if it.Err() == nil {
+		t.Error("expected an error for an invalid length")
+	}
+}
+
+// benchListMessage builds the attributes of an ipset list reply carrying n
+// hash:ip entries.
+func benchListMessage(n int) []byte {
+	adt := NewRtAttr(IPSET_ATTR_ADT|int(NLA_F_NESTED), nil)
+	for i := 0; i < n; i++ {
+		data := NewRtAttr(IPSET_ATTR_DATA|int(NLA_F_NESTED), nil)
+		ip := NewRtAttr(IPSET_ATTR_IP|int(NLA_F_NESTED), nil)
+		ip.AddRtAttr(IPSET_ATTR_IPADDR_IPV4, []byte{10, byte(i >> 16), byte(i >> 8), byte(i)})
+		data.AddChild(ip)
+		data.AddChild(&Uint32Attribute{Type: IPSET_ATTR_TIMEOUT | NLA_F_NET_BYTEORDER, Value: 300})
+		adt.AddChild(data)
+	}
+	msg := NewRtAttr(IPSET_ATTR_SETNAME, ZeroTerminated("bench")).Serialize()
+	return append(msg, adt.Serialize()...)
+}
+
+func walkChannel(data []byte) (n int) {
+	for attr := range ParseAttributes(data) {
+		n++
+		if attr.Type&NLA_F_NESTED != 0 {
+			n += walkChannel(attr.Value)
+		}
+	}
+	return n
+}
+
+func walkIterator(data []byte) (n int) {
+	it := NewAttributeIterator(data)
+	for it.Next() {
+		attr := it.Attribute()
+		n++
+		if attr.Type&NLA_F_NESTED != 0 {
+			n += walkIterator(attr.Value)
+		}
+	}
+	return n
+}
+
+func BenchmarkParseAttributesChannel(b *testing.B) {
+	msg := benchListMessage(1000)
+	b.SetBytes(int64(len(msg)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		walkChannel(msg)
+	}
+}
+
+func BenchmarkParseAttributesIterator(b *testing.B) {
+	msg := benchListMessage(1000)
+	b.SetBytes(int64(len(msg)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		walkIterator(msg)
+	}
+}
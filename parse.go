@@ -0,0 +1,249 @@
+package goipset
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// ParseEntry parses an element in the syntax of the ipset command for a set
+// of type typename and family, which is "inet", "inet6" or empty for inet.
+// The element syntax depends on the type:
+//
+//	hash:ip        IP, IP-IP or IP/CIDR, ranges for IPv4 only
+//	hash:net       IP[/CIDR] or IP-IP
+//	hash:ip,port   IP part of hash:ip, then [proto:]port[-port] or icmp:type/code
+//	hash:net,port  IP part of hash:net, then the port part of hash:ip,port
+//	hash:mac       MAC
+//
+// The protocol defaults to tcp. Ports of tcp, udp, sctp and udplite may be
+// service names, icmp and icmpv6 take a type/code or its name, e.g.
+// icmp:echo-request, and any other protocol, by name or number, needs
+// port 0, e.g. gre:0.
+func ParseEntry(typename, family, text string) (Set, error) {
+	set, err := parseEntry(typename, family, text)
+	if err != nil {
+		return nil, fmt.Errorf("%s element %q: %v", typename, text, err)
+	}
+	return set, nil
+}
+
+func parseEntry(typename, family, text string) (Set, error) {
+	af, err := parseFamily(family)
+	if err != nil {
+		return nil, err
+	}
+
+	switch typename {
+	case "hash:ip":
+		ip, ipTo, err := parseIPRange(af, text)
+		if err != nil {
+			return nil, err
+		}
+		return &SetIP{IP: ip, IPTO: ipTo}, nil
+	case "hash:net":
+		ip, ipTo, cidr, err := parseNetRange(af, text)
+		if err != nil {
+			return nil, err
+		}
+		return &SetNet{IP: ip, IPTO: ipTo, CIDR: cidr}, nil
+	case "hash:ip,port":
+		ipPart, portPart, err := splitDimensions(text)
+		if err != nil {
+			return nil, err
+		}
+		ip, ipTo, err := parseIPRange(af, ipPart)
+		if err != nil {
+			return nil, err
+		}
+		port, portTo, proto, err := parseProtoPort(af, portPart)
+		if err != nil {
+			return nil, err
+		}
+		return &SetIPPort{IP: ip, IPTO: ipTo, Port: port, PortTo: portTo, Proto: proto}, nil
+	case "hash:net,port":
+		ipPart, portPart, err := splitDimensions(text)
+		if err != nil {
+			return nil, err
+		}
+		ip, ipTo, cidr, err := parseNetRange(af, ipPart)
+		if err != nil {
+			return nil, err
+		}
+		port, portTo, proto, err := parseProtoPort(af, portPart)
+		if err != nil {
+			return nil, err
+		}
+		return &SetNetPort{IP: ip, IPTO: ipTo, CIDR: cidr, Port: port, PortTo: portTo, Proto: proto}, nil
+	case "hash:mac":
+		mac, err := net.ParseMAC(text)
+		if err != nil || len(mac) != 6 {
+			return nil, fmt.Errorf("invalid MAC address %q", text)
+		}
+		return &SetMac{MAC: mac}, nil
+	}
+	return nil, fmt.Errorf("unsupported set type")
+}
+
+func parseFamily(family string) (int, error) {
+	switch family {
+	case "", "inet":
+		return unix.AF_INET, nil
+	case "inet6":
+		return unix.AF_INET6, nil
+	}
+	return 0, fmt.Errorf("invalid family %q, expected inet or inet6", family)
+}
+
+func familyName(af int) string {
+	if af == unix.AF_INET6 {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+func splitDimensions(text string) (string, string, error) {
+	parts := strings.Split(text, ",")
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("expected 2 comma separated parts, got %d", len(parts))
+	}
+	return parts[0], parts[1], nil
+}
+
+// parseIP parses a single address of family af.
+func parseIP(af int, text string) (net.IP, error) {
+	ip := net.ParseIP(text)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", text)
+	}
+	if (ip.To4() != nil) != (af == unix.AF_INET) {
+		return nil, fmt.Errorf("%s is not an %s address", text, familyName(af))
+	}
+	if af == unix.AF_INET {
+		return ip.To4(), nil
+	}
+	return ip, nil
+}
+
+// parseCIDR parses IP/CIDR, returning the network address.
+func parseCIDR(af int, text string) (net.IP, uint8, error) {
+	i := strings.IndexByte(text, '/')
+	ip, err := parseIP(af, text[:i])
+	if err != nil {
+		return nil, 0, err
+	}
+	bits := len(ip) * 8
+	cidr, err := strconv.Atoi(text[i+1:])
+	if err != nil || cidr < 1 || cidr > bits {
+		return nil, 0, fmt.Errorf("invalid prefix length %q, expected 1-%d", text[i+1:], bits)
+	}
+	return ip.Mask(net.CIDRMask(cidr, bits)), uint8(cidr), nil
+}
+
+// parseIPRange parses IP, IP-IP or IP/CIDR into the first and last address,
+// the last one is nil for a single address.
+func parseIPRange(af int, text string) (ip, ipTo net.IP, err error) {
+	switch {
+	case strings.Contains(text, "-"):
+		parts := strings.SplitN(text, "-", 2)
+		if ip, err = parseIP(af, parts[0]); err != nil {
+			return nil, nil, err
+		}
+		if ipTo, err = parseIP(af, parts[1]); err != nil {
+			return nil, nil, err
+		}
+	case strings.Contains(text, "/"):
+		cidr := uint8(0)
+		if ip, cidr, err = parseCIDR(af, text); err != nil {
+			return nil, nil, err
+		}
+		if int(cidr) == len(ip)*8 {
+			return ip, nil, nil
+		}
+		ipTo = lastIP(ip, cidr)
+	default:
+		ip, err = parseIP(af, text)
+		return ip, nil, err
+	}
+	if af == unix.AF_INET6 {
+		return nil, nil, fmt.Errorf("IPv6 ranges are not supported")
+	}
+	if ipCompare(ip, ipTo) > 0 {
+		return nil, nil, fmt.Errorf("range %s ends before it starts", text)
+	}
+	return ip, ipTo, nil
+}
+
+// parseNetRange parses IP[/CIDR] or IP-IP, a missing CIDR means a single
+// host. Unlike for hash:ip, IPv6 ranges are fine as Add splits them into
+// networks.
+func parseNetRange(af int, text string) (ip, ipTo net.IP, cidr uint8, err error) {
+	switch {
+	case strings.Contains(text, "-"):
+		parts := strings.SplitN(text, "-", 2)
+		if ip, err = parseIP(af, parts[0]); err != nil {
+			return nil, nil, 0, err
+		}
+		if ipTo, err = parseIP(af, parts[1]); err != nil {
+			return nil, nil, 0, err
+		}
+		if ipCompare(ip, ipTo) > 0 {
+			return nil, nil, 0, fmt.Errorf("range %s ends before it starts", text)
+		}
+		return ip, ipTo, 0, nil
+	case strings.Contains(text, "/"):
+		ip, cidr, err = parseCIDR(af, text)
+		return ip, nil, cidr, err
+	}
+	if ip, err = parseIP(af, text); err != nil {
+		return nil, nil, 0, err
+	}
+	return ip, nil, uint8(len(ip) * 8), nil
+}
+
+func lastIP(ip net.IP, cidr uint8) net.IP {
+	last := make(net.IP, len(ip))
+	mask := net.CIDRMask(int(cidr), len(ip)*8)
+	for i := range ip {
+		last[i] = ip[i] | ^mask[i]
+	}
+	return last
+}
+
+func ipCompare(a, b net.IP) int {
+	return new(big.Int).SetBytes(a).Cmp(new(big.Int).SetBytes(b))
+}
+
+// parseProtoPort parses [proto:]port[-port] or icmp:type/code, ICMP types
+// and codes are stored in the port as type<<8|code. Ports may be service
+// names, ICMP types and codes may be given by name.
+func parseProtoPort(af int, text string) (port, portTo uint16, proto uint8, err error) {
+	proto = unix.IPPROTO_TCP
+	if i := strings.IndexByte(text, ':'); i >= 0 {
+		var ok bool
+		if proto, ok = lookupProto(text[:i]); !ok {
+			return 0, 0, 0, fmt.Errorf("invalid protocol %q", text[:i])
+		}
+		text = text[i+1:]
+	}
+
+	switch {
+	case isICMP(proto):
+		if (proto == unix.IPPROTO_ICMP) != (af == unix.AF_INET) {
+			return 0, 0, 0, fmt.Errorf("%s is not an %s protocol", protoName(proto), familyName(af))
+		}
+		port, err = parseICMP(proto, text)
+		return port, 0, proto, err
+	case protoHasPorts(proto):
+		port, portTo, err = parsePortRange(proto, text)
+		return port, portTo, proto, err
+	}
+	if text != "0" {
+		return 0, 0, 0, fmt.Errorf("protocol %s has no ports, expected port 0", protoName(proto))
+	}
+	return 0, 0, proto, nil
+}
@@ -0,0 +1,114 @@
+package goipset
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseEntry(t *testing.T) {
+	tests := []struct {
+		typename string
+		family   string
+		text     string
+		expected string
+	}{
+		{"hash:ip", "", "1.1.1.1", "1.1.1.1"},
+		{"hash:ip", "inet", "1.1.1.3-1.1.1.6", "1.1.1.3-1.1.1.6"},
+		{"hash:ip", "inet", "10.0.0.5/30", "10.0.0.4-10.0.0.7"},
+		{"hash:ip", "inet", "10.0.0.5/32", "10.0.0.5"},
+		{"hash:ip", "inet6", "fe80::250:56ff:fea9:1cd4", "fe80::250:56ff:fea9:1cd4"},
+		{"hash:net", "inet", "1.1.1.1/24", "1.1.1.0/24"},
+		{"hash:net", "inet", "1.1.1.1", "1.1.1.1/32"},
+		{"hash:net", "inet", "10.0.0.0-10.0.0.255", "10.0.0.0-10.0.0.255"},
+		{"hash:net", "inet6", "fe80:2510::250:56ff:fea9:1cd4/64", "fe80:2510::/64"},
+		{"hash:ip,port", "inet", "1.1.1.1,80", "1.1.1.1,TCP:80"},
+		{"hash:ip,port", "inet", "1.1.1.2,TCP:81", "1.1.1.2,TCP:81"},
+		{"hash:ip,port", "inet", "1.1.1.3,udp:80-90", "1.1.1.3,UDP:80-90"},
+		{"hash:ip,port", "inet", "1.1.1.4-1.1.1.6,UDP:80", "1.1.1.4-1.1.1.6,UDP:80"},
+		{"hash:ip,port", "inet6", "fe80::1,tcp:443", "fe80::1,TCP:443"},
+		{"hash:net,port", "inet", "1.1.2.1/24,UDP:88", "1.1.2.0/24,UDP:88"},
+		{"hash:mac", "", "02:42:AC:11:00:02", "02:42:ac:11:00:02"},
+	}
+	for _, test := range tests {
+		set, err := ParseEntry(test.typename, test.family, test.text)
+		if err != nil {
+			t.Errorf("parsing %s %q failed: %v", test.typename, test.text, err)
+			continue
+		}
+		if s := set.String(); s != test.expected {
+			t.Errorf("expected %s %q to parse as %s, got %s", test.typename, test.text, test.expected, s)
+		}
+	}
+}
+
+func TestParseEntryPorts(t *testing.T) {
+	set, err := ParseEntry("hash:ip,port", "inet", "1.1.1.1,icmp:8/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ipPort := set.(*SetIPPort); ipPort.Proto != 1 || ipPort.Port != 8<<8 {
+		t.Errorf("expected icmp echo-request, got proto %d port %#x", ipPort.Proto, ipPort.Port)
+	}
+
+	set, err = ParseEntry("hash:net,port", "inet6", "fe80::/64,icmpv6:128/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if netPort := set.(*SetNetPort); netPort.Proto != 58 || netPort.Port != 128<<8 {
+		t.Errorf("expected icmpv6 echo-request, got proto %d port %#x", netPort.Proto, netPort.Port)
+	}
+
+	set, err = ParseEntry("hash:ip,port", "inet", "1.1.1.1,80-90")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ipPort := set.(*SetIPPort); ipPort.Port != 80 || ipPort.PortTo != 90 {
+		t.Errorf("expected ports 80-90, got %d-%d", ipPort.Port, ipPort.PortTo)
+	}
+
+	set, err = ParseEntry("hash:ip,port", "inet", "1.1.1.1,47:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ipPort := set.(*SetIPPort); ipPort.Proto != 47 || ipPort.Port != 0 {
+		t.Errorf("expected protocol 47 without port, got proto %d port %d", ipPort.Proto, ipPort.Port)
+	}
+}
+
+func TestParseEntryErrors(t *testing.T) {
+	tests := []struct {
+		typename string
+		family   string
+		text     string
+		err      string
+	}{
+		{"hash:ip", "inet", "1.1.1", `invalid IP address "1.1.1"`},
+		{"hash:ip", "inet", "fe80::1", "fe80::1 is not an IPv4 address"},
+		{"hash:ip", "inet6", "1.1.1.1", "1.1.1.1 is not an IPv6 address"},
+		{"hash:ip", "inet6", "fe80::1-fe80::2", "IPv6 ranges are not supported"},
+		{"hash:ip", "inet", "1.1.1.6-1.1.1.3", "range 1.1.1.6-1.1.1.3 ends before it starts"},
+		{"hash:ip", "ipx", "1.1.1.1", `invalid family "ipx"`},
+		{"hash:net", "inet", "1.1.1.0/33", `invalid prefix length "33", expected 1-32`},
+		{"hash:net", "inet", "1.1.1.0/0", `invalid prefix length "0"`},
+		{"hash:ip,port", "inet", "1.1.1.1", "expected 2 comma separated parts, got 1"},
+		{"hash:ip,port", "inet", "1.1.1.1,tcp:http2x", `invalid port "http2x"`},
+		{"hash:ip,port", "inet", "1.1.1.1,tcp:70000", `invalid port "70000"`},
+		{"hash:ip,port", "inet", "1.1.1.1,90-80", "port range 90-80 ends before it starts"},
+		{"hash:ip,port", "inet", "1.1.1.1,foo:80", `invalid protocol "foo"`},
+		{"hash:ip,port", "inet", "1.1.1.1,icmpv6:128/0", "icmpv6 is not an IPv4 protocol"},
+		{"hash:ip,port", "inet", "1.1.1.1,icmp:8", `invalid ICMP type/code "8"`},
+		{"hash:ip,port", "inet", "1.1.1.1,47:80", "protocol gre has no ports, expected port 0"},
+		{"hash:mac", "", "02:42:ac", `invalid MAC address "02:42:ac"`},
+		{"hash:foo", "", "1.1.1.1", "unsupported set type"},
+	}
+	for _, test := range tests {
+		_, err := ParseEntry(test.typename, test.family, test.text)
+		if err == nil {
+			t.Errorf("expected parsing %s %q to fail", test.typename, test.text)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected the error for %s %q to contain %q, got %q", test.typename, test.text, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package goipset
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// protocols are the protocol names entries are printed with, any other
+// protocol is printed as its number. Parsing also looks in /etc/protocols.
+var protocols = []struct {
+	name  string
+	proto uint8
+}{
+	{"icmp", unix.IPPROTO_ICMP},
+	{"igmp", unix.IPPROTO_IGMP},
+	{"ipencap", unix.IPPROTO_IPIP},
+	{"tcp", unix.IPPROTO_TCP},
+	{"udp", unix.IPPROTO_UDP},
+	{"ipv6", unix.IPPROTO_IPV6},
+	{"gre", unix.IPPROTO_GRE},
+	{"esp", unix.IPPROTO_ESP},
+	{"ah", unix.IPPROTO_AH},
+	{"icmpv6", unix.IPPROTO_ICMPV6},
+	{"ospf", 89},
+	{"pim", unix.IPPROTO_PIM},
+	{"vrrp", 112},
+	{"l2tp", 115},
+	{"sctp", unix.IPPROTO_SCTP},
+	{"udplite", unix.IPPROTO_UDPLITE},
+}
+
+// icmpTypes and icmpv6Types are the names ipset knows for ICMP types and
+// codes, stored as type<<8|code. The first name of a value is the one it is
+// printed with.
+var icmpTypes = []struct {
+	name string
+	port uint16
+}{
+	{"echo-reply", 0<<8 | 0},
+	{"pong", 0<<8 | 0},
+	{"network-unreachable", 3<<8 | 0},
+	{"host-unreachable", 3<<8 | 1},
+	{"protocol-unreachable", 3<<8 | 2},
+	{"port-unreachable", 3<<8 | 3},
+	{"fragmentation-needed", 3<<8 | 4},
+	{"source-route-failed", 3<<8 | 5},
+	{"network-unknown", 3<<8 | 6},
+	{"host-unknown", 3<<8 | 7},
+	{"network-prohibited", 3<<8 | 9},
+	{"host-prohibited", 3<<8 | 10},
+	{"TOS-network-unreachable", 3<<8 | 11},
+	{"TOS-host-unreachable", 3<<8 | 12},
+	{"communication-prohibited", 3<<8 | 13},
+	{"host-precedence-violation", 3<<8 | 14},
+	{"precedence-cutoff", 3<<8 | 15},
+	{"source-quench", 4<<8 | 0},
+	{"network-redirect", 5<<8 | 0},
+	{"host-redirect", 5<<8 | 1},
+	{"TOS-network-redirect", 5<<8 | 2},
+	{"TOS-host-redirect", 5<<8 | 3},
+	{"echo-request", 8<<8 | 0},
+	{"ping", 8<<8 | 0},
+	{"router-advertisement", 9<<8 | 0},
+	{"router-solicitation", 10<<8 | 0},
+	{"ttl-zero-during-transit", 11<<8 | 0},
+	{"ttl-zero-during-reassembly", 11<<8 | 1},
+	{"ip-header-bad", 12<<8 | 0},
+	{"required-option-missing", 12<<8 | 1},
+	{"timestamp-request", 13<<8 | 0},
+	{"timestamp-reply", 14<<8 | 0},
+	{"address-mask-request", 17<<8 | 0},
+	{"address-mask-reply", 18<<8 | 0},
+}
+
+var icmpv6Types = []struct {
+	name string
+	port uint16
+}{
+	{"no-route", 1<<8 | 0},
+	{"communication-prohibited", 1<<8 | 1},
+	{"address-unreachable", 1<<8 | 3},
+	{"port-unreachable", 1<<8 | 4},
+	{"packet-too-big", 2<<8 | 0},
+	{"ttl-zero-during-transit", 3<<8 | 0},
+	{"ttl-zero-during-reassembly", 3<<8 | 1},
+	{"bad-header", 4<<8 | 0},
+	{"unknown-header-type", 4<<8 | 1},
+	{"unknown-option", 4<<8 | 2},
+	{"echo-request", 128<<8 | 0},
+	{"ping", 128<<8 | 0},
+	{"echo-reply", 129<<8 | 0},
+	{"pong", 129<<8 | 0},
+	{"router-solicitation", 133<<8 | 0},
+	{"router-advertisement", 134<<8 | 0},
+	{"neighbour-solicitation", 135<<8 | 0},
+	{"neighbour-advertisement", 136<<8 | 0},
+	{"redirect", 137<<8 | 0},
+}
+
+// protoHasPorts reports whether the kernel stores port numbers for proto,
+// for other protocols than these and ICMP the port is always 0.
+func protoHasPorts(proto uint8) bool {
+	switch proto {
+	case unix.IPPROTO_TCP, unix.IPPROTO_UDP, unix.IPPROTO_SCTP, unix.IPPROTO_UDPLITE:
+		return true
+	}
+	return false
+}
+
+func isICMP(proto uint8) bool {
+	return proto == unix.IPPROTO_ICMP || proto == unix.IPPROTO_ICMPV6
+}
+
+func protoName(proto uint8) string {
+	for _, p := range protocols {
+		if p.proto == proto {
+			return p.name
+		}
+	}
+	return strconv.Itoa(int(proto))
+}
+
+// lookupProto returns the protocol called name, which may also be a number.
+func lookupProto(name string) (uint8, bool) {
+	name = strings.ToLower(name)
+	for _, p := range protocols {
+		if p.name == name {
+			return p.proto, true
+		}
+	}
+	if n, err := strconv.ParseUint(name, 10, 8); err == nil && n > 0 {
+		return uint8(n), true
+	}
+	proto, ok := systemNames().protocols[name]
+	return proto, ok
+}
+
+// formatProtoPort is the inverse of parseProtoPort, the protocol is upper
+// case as goipset always printed it.
+func formatProtoPort(proto uint8, port, portTo uint16) string {
+	name := strings.ToUpper(protoName(proto))
+	switch {
+	case isICMP(proto):
+		return fmt.Sprintf("%s:%s", name, icmpName(proto, port))
+	case protoHasPorts(proto) && portTo > 0:
+		return fmt.Sprintf("%s:%d-%d", name, port, portTo)
+	}
+	return fmt.Sprintf("%s:%d", name, port)
+}
+
+// icmpName returns the name of an ICMP or ICMPv6 type/code, or type/code
+// if it has none.
+func icmpName(proto uint8, port uint16) string {
+	types := icmpTypes
+	if proto == unix.IPPROTO_ICMPV6 {
+		types = icmpv6Types
+	}
+	for _, t := range types {
+		if t.port == port {
+			return t.name
+		}
+	}
+	return fmt.Sprintf("%d/%d", port>>8, port&0xff)
+}
+
+// parseICMP parses an ICMP type/code, numeric or by name.
+func parseICMP(proto uint8, text string) (uint16, error) {
+	types := icmpTypes
+	if proto == unix.IPPROTO_ICMPV6 {
+		types = icmpv6Types
+	}
+	for _, t := range types {
+		if strings.EqualFold(t.name, text) {
+			return t.port, nil
+		}
+	}
+	parts := strings.Split(text, "/")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid ICMP type/code %q", text)
+	}
+	typ, err1 := strconv.ParseUint(parts[0], 10, 8)
+	code, err2 := strconv.ParseUint(parts[1], 10, 8)
+	if err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("invalid ICMP type/code %q", text)
+	}
+	return uint16(typ)<<8 | uint16(code), nil
+}
+
+// parsePort parses a port number or a service name of proto.
+func parsePort(proto uint8, text string) (uint16, error) {
+	if port, err := strconv.ParseUint(text, 10, 16); err == nil {
+		return uint16(port), nil
+	}
+	if port, ok := systemNames().services[strings.ToLower(text)+"/"+protoName(proto)]; ok {
+		return port, nil
+	}
+	return 0, fmt.Errorf("invalid port %q", text)
+}
+
+// parsePortRange parses port[-port]. Service names may contain dashes, so
+// every dash is tried as the separator.
+func parsePortRange(proto uint8, text string) (port, portTo uint16, err error) {
+	if port, err = parsePort(proto, text); err == nil {
+		return port, 0, nil
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] != '-' {
+			continue
+		}
+		from, err1 := parsePort(proto, text[:i])
+		to, err2 := parsePort(proto, text[i+1:])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if to < from {
+			return 0, 0, fmt.Errorf("port range %s ends before it starts", text)
+		}
+		return from, to, nil
+	}
+	return 0, 0, err
+}
+
+// The system's protocol and service names, read once when first needed.
+var (
+	protocolsFile = "/etc/protocols"
+	servicesFile  = "/etc/services"
+
+	namesOnce sync.Once
+	names     *nameDB
+)
+
+type nameDB struct {
+	protocols map[string]uint8
+	// services maps name/proto to a port, e.g. http/tcp to 80
+	services map[string]uint16
+}
+
+func systemNames() *nameDB {
+	namesOnce.Do(func() {
+		names = readNames(protocolsFile, servicesFile)
+	})
+	return names
+}
+
+// readNames reads the protocols(5) and services(5) files, which may be
+// missing.
+func readNames(protocolsPath, servicesPath string) *nameDB {
+	db := &nameDB{protocols: map[string]uint8{}, services: map[string]uint16{}}
+	readNameFile(protocolsPath, func(fields []string) {
+		n, err := strconv.ParseUint(fields[1], 10, 8)
+		if err != nil {
+			return
+		}
+		for _, name := range append(fields[:1:1], fields[2:]...) {
+			db.protocols[strings.ToLower(name)] = uint8(n)
+		}
+	})
+	readNameFile(servicesPath, func(fields []string) {
+		parts := strings.SplitN(fields[1], "/", 2)
+		if len(parts) != 2 {
+			return
+		}
+		port, err := strconv.ParseUint(parts[0], 10, 16)
+		if err != nil {
+			return
+		}
+		for _, name := range append(fields[:1:1], fields[2:]...) {
+			db.services[strings.ToLower(name)+"/"+parts[1]] = uint16(port)
+		}
+	})
+	return db
+}
+
+// readNameFile calls fn with the fields of every line of path that has at
+// least two of them, without comments.
+func readNameFile(path string, fn func(fields []string)) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if fields := strings.Fields(line); len(fields) >= 2 {
+			fn(fields)
+		}
+	}
+}
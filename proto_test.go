@@ -0,0 +1,95 @@
+package goipset
+
+import (
+	"net"
+	"testing"
+
+	"github.com/JiHanHuang/goipset/ipsettest"
+	"golang.org/x/sys/unix"
+)
+
+// useTestNames replaces the system's protocol and service names with those
+// in testdata.
+func useTestNames() {
+	namesOnce.Do(func() {})
+	names = readNames("testdata/protocols", "testdata/services")
+}
+
+func TestParseProtoPort(t *testing.T) {
+	useTestNames()
+
+	tests := []struct {
+		family string
+		text   string
+		proto  uint8
+		port   uint16
+		portTo uint16
+		str    string
+	}{
+		{"inet", "tcp:http", unix.IPPROTO_TCP, 80, 0, "TCP:80"},
+		{"inet", "tcp:WWW", unix.IPPROTO_TCP, 80, 0, "TCP:80"},
+		{"inet", "http-https", unix.IPPROTO_TCP, 80, 443, "TCP:80-443"},
+		{"inet", "ftp-data-http", unix.IPPROTO_TCP, 20, 80, "TCP:20-80"},
+		{"inet", "http-alt", unix.IPPROTO_TCP, 8080, 0, "TCP:8080"},
+		{"inet", "udp:domain", unix.IPPROTO_UDP, 53, 0, "UDP:53"},
+		{"inet", "sctp:9899", unix.IPPROTO_SCTP, 9899, 0, "SCTP:9899"},
+		{"inet", "udplite:1000-1010", unix.IPPROTO_UDPLITE, 1000, 1010, "UDPLITE:1000-1010"},
+		{"inet", "icmp:echo-request", unix.IPPROTO_ICMP, 8 << 8, 0, "ICMP:echo-request"},
+		{"inet", "icmp:ping", unix.IPPROTO_ICMP, 8 << 8, 0, "ICMP:echo-request"},
+		{"inet", "ICMP:tos-host-redirect", unix.IPPROTO_ICMP, 5<<8 | 3, 0, "ICMP:TOS-host-redirect"},
+		{"inet", "icmp:0/0", unix.IPPROTO_ICMP, 0, 0, "ICMP:echo-reply"},
+		{"inet", "icmp:42/7", unix.IPPROTO_ICMP, 42<<8 | 7, 0, "ICMP:42/7"},
+		{"inet6", "icmpv6:neighbour-solicitation", unix.IPPROTO_ICMPV6, 135 << 8, 0, "ICMPV6:neighbour-solicitation"},
+		{"inet6", "ipv6-icmp:1/4", unix.IPPROTO_ICMPV6, 1<<8 | 4, 0, "ICMPV6:port-unreachable"},
+		{"inet", "gre:0", unix.IPPROTO_GRE, 0, 0, "GRE:0"},
+		{"inet", "rsvp:0", 46, 0, 0, "46:0"},
+		{"inet", "253:0", 253, 0, 0, "253:0"},
+	}
+	for _, test := range tests {
+		set, err := ParseEntry("hash:ip,port", test.family, "1.1.1.1,"+test.text)
+		if test.family == "inet6" {
+			set, err = ParseEntry("hash:ip,port", test.family, "::1,"+test.text)
+		}
+		if err != nil {
+			t.Errorf("parsing %q failed: %v", test.text, err)
+			continue
+		}
+		ipPort := set.(*SetIPPort)
+		if ipPort.Proto != test.proto || ipPort.Port != test.port || ipPort.PortTo != test.portTo {
+			t.Errorf("expected %q to parse as %d:%d-%d, got %d:%d-%d", test.text,
+				test.proto, test.port, test.portTo, ipPort.Proto, ipPort.Port, ipPort.PortTo)
+		}
+		str := formatProtoPort(ipPort.Proto, ipPort.Port, ipPort.PortTo)
+		if str != test.str {
+			t.Errorf("expected %q to print as %s, got %s", test.text, test.str, str)
+		}
+
+		// What is printed parses to the same entry again.
+		again, err := ParseEntry("hash:ip,port", test.family, set.String())
+		if err != nil {
+			t.Errorf("parsing %q again failed: %v", set.String(), err)
+		} else if again.String() != set.String() {
+			t.Errorf("expected %q to survive a round trip, got %q", set.String(), again.String())
+		}
+	}
+}
+
+func TestListProtocols(t *testing.T) {
+	ipset := NewGoIpsetWithTransport(ipsettest.NewKernel())
+	if err := ipset.Create("test", "hash:ip,port", GoIpsetCreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	entries := []Set{
+		&SetIPPort{IP: net.ParseIP("10.0.0.1"), Port: 8 << 8, Proto: unix.IPPROTO_ICMP},
+		&SetIPPort{IP: net.ParseIP("10.0.0.1"), Port: 0, Proto: unix.IPPROTO_GRE},
+		&SetIPPort{IP: net.ParseIP("10.0.0.1"), Port: 9899, Proto: unix.IPPROTO_SCTP},
+		&SetIPPort{IP: net.ParseIP("10.0.0.1"), Port: 53, Proto: unix.IPPROTO_UDPLITE},
+	}
+	for _, set := range entries {
+		if err := ipset.Add("test", &GoIPSetEntry{Set: set}); err != nil {
+			t.Fatalf("adding %s failed: %v", set, err)
+		}
+	}
+	expectEntries(t, entryStrings(t, ipset, "test"),
+		"10.0.0.1,ICMP:echo-request", "10.0.0.1,GRE:0", "10.0.0.1,SCTP:9899", "10.0.0.1,UDPLITE:53")
+}
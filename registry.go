@@ -0,0 +1,496 @@
+package goipset
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"golang.org/x/sys/unix"
+)
+
+// Dimension is one part of the elements of a set type.
+type Dimension int
+
+const (
+	DimIP Dimension = iota
+	DimNet
+	DimPort
+	DimMAC
+)
+
+var dimensionNames = map[Dimension]string{
+	DimIP:   "an IP address",
+	DimNet:  "a network",
+	DimPort: "a port",
+	DimMAC:  "a MAC address",
+}
+
+// SetOption is an option of a set or of its entries, the options a set type
+// accepts are or'ed together.
+type SetOption uint32
+
+const (
+	OptionTimeout SetOption = 1 << iota
+	OptionCounters
+	OptionComment
+	OptionSkbinfo
+	OptionNomatch
+	OptionForceAdd
+	OptionNetMask
+)
+
+var optionNames = map[SetOption]string{
+	OptionTimeout:  "timeouts",
+	OptionCounters: "counters",
+	OptionComment:  "comments",
+	OptionSkbinfo:  "skbinfo",
+	OptionNomatch:  "nomatch",
+	OptionForceAdd: "forceadd",
+	OptionNetMask:  "netmask",
+}
+
+// Feature is something a set type only supports from some revision on.
+type Feature int
+
+const (
+	FeatureCounters Feature = iota
+	FeatureComment
+	FeatureSkbinfo
+	FeatureNomatch
+	FeatureIPv4Range // IPv4 ranges are split into networks
+	FeatureSCTP      // SCTP and UDPLite ports
+	FeatureBucketSize
+	FeatureForceAdd
+	FeatureInitVal
+	FeatureNetMask
+)
+
+var featureNames = map[Feature]string{
+	FeatureCounters:   "counters",
+	FeatureComment:    "comments",
+	FeatureSkbinfo:    "skbinfo",
+	FeatureNomatch:    "nomatch",
+	FeatureIPv4Range:  "IPv4 ranges",
+	FeatureSCTP:       "SCTP and UDPLite ports",
+	FeatureBucketSize: "bucket sizes",
+	FeatureForceAdd:   "forceadd",
+	FeatureInitVal:    "initial hash values",
+	FeatureNetMask:    "netmask",
+}
+
+// SetType describes an ipset set type as the kernel implements it.
+type SetType struct {
+	Name       string
+	Dimensions []Dimension
+	// Families lists the families a set can be created with, the first
+	// one is the default.
+	Families      []int
+	CreateOptions SetOption
+	EntryOptions  SetOption
+	// Features maps a feature to the revision that introduced it.
+	Features map[Feature]uint8
+}
+
+const (
+	commonCreateOptions = OptionTimeout | OptionCounters | OptionComment | OptionSkbinfo
+	hashCreateOptions   = commonCreateOptions | OptionForceAdd
+	commonEntryOptions  = OptionTimeout | OptionCounters | OptionComment | OptionSkbinfo
+	netEntryOptions     = commonEntryOptions | OptionNomatch
+)
+
+var ipFamilies = []int{unix.AF_INET, unix.AF_INET6}
+
+// The revisions come from the changelogs of the kernel set types.
+var setTypes = map[string]*SetType{
+	"hash:ip": {
+		Name:          "hash:ip",
+		Dimensions:    []Dimension{DimIP},
+		Families:      ipFamilies,
+		CreateOptions: hashCreateOptions | OptionNetMask,
+		EntryOptions:  commonEntryOptions,
+		Features: map[Feature]uint8{
+			FeatureIPv4Range:  0,
+			FeatureNetMask:    0,
+			FeatureCounters:   1,
+			FeatureComment:    2,
+			FeatureForceAdd:   3,
+			FeatureSkbinfo:    4,
+			FeatureBucketSize: 5,
+			FeatureInitVal:    5,
+		},
+	},
+	"hash:net": {
+		Name:          "hash:net",
+		Dimensions:    []Dimension{DimNet},
+		Families:      ipFamilies,
+		CreateOptions: hashCreateOptions,
+		EntryOptions:  netEntryOptions,
+		Features: map[Feature]uint8{
+			FeatureIPv4Range:  1,
+			FeatureNomatch:    2,
+			FeatureCounters:   3,
+			FeatureComment:    4,
+			FeatureForceAdd:   5,
+			FeatureSkbinfo:    6,
+			FeatureBucketSize: 7,
+			FeatureInitVal:    7,
+		},
+	},
+	"hash:ip,port": {
+		Name:          "hash:ip,port",
+		Dimensions:    []Dimension{DimIP, DimPort},
+		Families:      ipFamilies,
+		CreateOptions: hashCreateOptions | OptionNetMask,
+		EntryOptions:  commonEntryOptions,
+		Features: map[Feature]uint8{
+			FeatureIPv4Range:  0,
+			FeatureSCTP:       1,
+			FeatureCounters:   2,
+			FeatureComment:    3,
+			FeatureForceAdd:   4,
+			FeatureSkbinfo:    5,
+			FeatureBucketSize: 6,
+			FeatureInitVal:    6,
+			FeatureNetMask:    7,
+		},
+	},
+	"hash:net,port": {
+		Name:          "hash:net,port",
+		Dimensions:    []Dimension{DimNet, DimPort},
+		Families:      ipFamilies,
+		CreateOptions: hashCreateOptions,
+		EntryOptions:  netEntryOptions,
+		Features: map[Feature]uint8{
+			FeatureSCTP:       1,
+			FeatureIPv4Range:  2,
+			FeatureNomatch:    3,
+			FeatureCounters:   4,
+			FeatureComment:    5,
+			FeatureForceAdd:   6,
+			FeatureSkbinfo:    7,
+			FeatureBucketSize: 8,
+			FeatureInitVal:    8,
+		},
+	},
+	// hash:mac has no IP dimension, the kernel only accepts it without a
+	// family
+	"hash:mac": {
+		Name:          "hash:mac",
+		Dimensions:    []Dimension{DimMAC},
+		Families:      []int{unix.AF_UNSPEC},
+		CreateOptions: hashCreateOptions,
+		EntryOptions:  commonEntryOptions,
+		Features: map[Feature]uint8{
+			FeatureCounters:   0,
+			FeatureComment:    0,
+			FeatureSkbinfo:    0,
+			FeatureForceAdd:   0,
+			FeatureBucketSize: 1,
+			FeatureInitVal:    1,
+		},
+	},
+}
+
+// LookupSetType returns the description of the set type called name.
+func LookupSetType(name string) (*SetType, bool) {
+	t, ok := setTypes[name]
+	return t, ok
+}
+
+// SetTypes returns the set types goipset knows, sorted by name.
+func SetTypes() []*SetType {
+	types := make([]*SetType, 0, len(setTypes))
+	for _, t := range setTypes {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+	return types
+}
+
+// Supports reports whether revision of the set type has feature.
+func (t *SetType) Supports(feature Feature, revision uint8) bool {
+	min, ok := t.Features[feature]
+	return ok && revision >= min
+}
+
+// family returns the family to create a set with for the family of the
+// create options, 0 selects the default.
+func (t *SetType) family(family int) (uint8, error) {
+	if family == 0 {
+		return uint8(t.Families[0]), nil
+	}
+	for _, f := range t.Families {
+		if f == family {
+			return uint8(f), nil
+		}
+	}
+	return 0, fmt.Errorf("%s does not accept family %s", t.Name, familyString(family))
+}
+
+// ValidateCreate checks options against the set type, revision is the one
+// the set will be created with.
+func (t *SetType) ValidateCreate(options GoIpsetCreateOptions, revision uint8) error {
+	family, err := t.family(options.Family)
+	if err != nil {
+		return err
+	}
+	requested := []struct {
+		on      bool
+		option  SetOption
+		feature Feature
+	}{
+		{options.Timeout != 0, OptionTimeout, -1},
+		{options.Counters, OptionCounters, FeatureCounters},
+		{options.Comments, OptionComment, FeatureComment},
+		{options.Skbinfo, OptionSkbinfo, FeatureSkbinfo},
+		{options.ForceAdd, OptionForceAdd, FeatureForceAdd},
+		{options.NetMask != 0, OptionNetMask, FeatureNetMask},
+	}
+	for _, r := range requested {
+		if !r.on {
+			continue
+		}
+		if t.CreateOptions&r.option == 0 {
+			return fmt.Errorf("%s does not accept %s", t.Name, optionNames[r.option])
+		}
+		if r.feature >= 0 && !t.Supports(r.feature, revision) {
+			return fmt.Errorf("%s revision %d does not support %s", t.Name, revision, featureNames[r.feature])
+		}
+	}
+	for _, r := range []struct {
+		on      bool
+		feature Feature
+	}{
+		{options.BucketSize != 0, FeatureBucketSize},
+		{options.InitVal != 0, FeatureInitVal},
+	} {
+		if r.on && !t.Supports(r.feature, revision) {
+			return fmt.Errorf("%s revision %d does not support %s", t.Name, revision, featureNames[r.feature])
+		}
+	}
+	bits := 32
+	if family == unix.AF_INET6 {
+		bits = 128
+	}
+	if int(options.NetMask) > bits {
+		return fmt.Errorf("%s does not accept netmask %d for %s", t.Name, options.NetMask, familyString(int(family)))
+	}
+	return nil
+}
+
+// ValidateEntry checks entry against the set type, family and revision are
+// those of the set the entry is for.
+func (t *SetType) ValidateEntry(family int, revision uint8, entry *GoIPSetEntry) error {
+	if entry.Set == nil {
+		return fmt.Errorf("Set is nil in GoIPSetEntry")
+	}
+	if entry.Timeout != 0 && t.EntryOptions&OptionTimeout == 0 {
+		return fmt.Errorf("%s does not accept %s", t.Name, optionNames[OptionTimeout])
+	}
+	if (entry.Packets != 0 || entry.Bytes != 0) && t.EntryOptions&OptionCounters == 0 {
+		return fmt.Errorf("%s does not accept %s", t.Name, optionNames[OptionCounters])
+	}
+	if entry.Comment != "" && t.EntryOptions&OptionComment == 0 {
+		return fmt.Errorf("%s does not accept %s", t.Name, optionNames[OptionComment])
+	}
+	if (entry.SkbMark != 0 || entry.SkbMask != 0 || entry.SkbPrio != 0 || entry.SkbQueue != 0) && t.EntryOptions&OptionSkbinfo == 0 {
+		return fmt.Errorf("%s does not accept %s", t.Name, optionNames[OptionSkbinfo])
+	}
+	if entry.NoMatch {
+		if t.EntryOptions&OptionNomatch == 0 {
+			return fmt.Errorf("%s does not accept %s", t.Name, optionNames[OptionNomatch])
+		}
+		if !t.Supports(FeatureNomatch, revision) {
+			return fmt.Errorf("%s revision %d does not support %s", t.Name, revision, featureNames[FeatureNomatch])
+		}
+	}
+
+	e := entryFieldsOf(entry.Set)
+	for _, dim := range e.dims {
+		if !t.accepts(dim) {
+			return fmt.Errorf("%s does not accept %s", t.Name, dimensionNames[dim])
+		}
+	}
+	for _, dim := range t.Dimensions {
+		if !e.has(dim) && !(dim == DimNet && e.has(DimIP)) {
+			return fmt.Errorf("%s requires %s", t.Name, dimensionNames[dim])
+		}
+	}
+
+	if e.ip != nil {
+		if err := t.validateIP(family, e.ip); err != nil {
+			return err
+		}
+	}
+	if e.ipTo != nil {
+		if err := t.validateIP(family, e.ipTo); err != nil {
+			return err
+		}
+		// Add and Del split the ranges of network types themselves.
+		if !t.hasNet() {
+			if family == unix.AF_INET6 {
+				return fmt.Errorf("%s does not accept IPv6 ranges", t.Name)
+			}
+			if !t.Supports(FeatureIPv4Range, revision) {
+				return fmt.Errorf("%s revision %d does not support %s", t.Name, revision, featureNames[FeatureIPv4Range])
+			}
+		}
+		if ipCompare(e.ip.To16(), e.ipTo.To16()) > 0 {
+			return fmt.Errorf("range %s-%s ends before it starts", e.ip, e.ipTo)
+		}
+	}
+	if e.cidr > 0 {
+		bits := 32
+		if family == unix.AF_INET6 {
+			bits = 128
+		}
+		if int(e.cidr) > bits {
+			return fmt.Errorf("%s does not accept prefix length %d for %s", t.Name, e.cidr, familyString(family))
+		}
+	}
+	if e.has(DimPort) {
+		switch e.proto {
+		case 0:
+			return fmt.Errorf("%s does not accept protocol 0", t.Name)
+		case unix.IPPROTO_SCTP, unix.IPPROTO_UDPLITE:
+			if !t.Supports(FeatureSCTP, revision) {
+				return fmt.Errorf("%s revision %d does not support %s", t.Name, revision, featureNames[FeatureSCTP])
+			}
+		}
+		if e.portTo != 0 && e.portTo < e.port {
+			return fmt.Errorf("port range %d-%d ends before it starts", e.port, e.portTo)
+		}
+	}
+	return nil
+}
+
+func (t *SetType) hasNet() bool {
+	for _, d := range t.Dimensions {
+		if d == DimNet {
+			return true
+		}
+	}
+	return false
+}
+
+// expandRange splits the IP range of entry into networks if the set type
+// stores networks and the kernel cannot take the range itself, which is the
+// case for IPv6 and for revisions without IPv4 ranges. Otherwise entry is
+// returned alone.
+func (t *SetType) expandRange(family int, revision uint8, entry *GoIPSetEntry) ([]*GoIPSetEntry, error) {
+	e := entryFieldsOf(entry.Set)
+	if e.ipTo == nil || !t.hasNet() || (family == unix.AF_INET && t.Supports(FeatureIPv4Range, revision)) {
+		return []*GoIPSetEntry{entry}, nil
+	}
+	nets, err := RangeToCIDRs(e.ip, e.ipTo)
+	if err != nil {
+		return nil, err
+	}
+	nets = splitWhole(nets)
+
+	entries := make([]*GoIPSetEntry, 0, len(nets))
+	for _, n := range nets {
+		ones, _ := n.Mask.Size()
+		expanded := *entry
+		if e.has(DimPort) {
+			expanded.Set = &SetNetPort{IP: n.IP, CIDR: uint8(ones), Port: e.port, PortTo: e.portTo, Proto: e.proto}
+		} else {
+			expanded.Set = &SetNet{IP: n.IP, CIDR: uint8(ones)}
+		}
+		entries = append(entries, &expanded)
+	}
+	return entries, nil
+}
+
+// splitWhole returns nets with a network of all addresses split into two
+// halves, since the kernel does not take a prefix length of 0.
+func splitWhole(nets []*net.IPNet) []*net.IPNet {
+	if len(nets) == 1 {
+		if ones, bits := nets[0].Mask.Size(); ones == 0 {
+			half := make(net.IP, len(nets[0].IP))
+			half[0] = 0x80
+			return []*net.IPNet{
+				{IP: nets[0].IP, Mask: net.CIDRMask(1, bits)},
+				{IP: half, Mask: net.CIDRMask(1, bits)},
+			}
+		}
+	}
+	return nets
+}
+
+func (t *SetType) accepts(dim Dimension) bool {
+	for _, d := range t.Dimensions {
+		// A single address is a network of one host.
+		if d == dim || d == DimNet && dim == DimIP {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *SetType) validateIP(family int, ip net.IP) error {
+	if (ip.To4() != nil) != (family == unix.AF_INET) {
+		return fmt.Errorf("%s does not accept %s in a set of family %s", t.Name, ip, familyString(family))
+	}
+	return nil
+}
+
+func familyString(family int) string {
+	switch family {
+	case unix.AF_INET:
+		return "inet"
+	case unix.AF_INET6:
+		return "inet6"
+	case unix.AF_UNSPEC:
+		return "unspec"
+	}
+	return fmt.Sprintf("%d", family)
+}
+
+// entryFields are the parts of a Set that the set types care about.
+type entryFields struct {
+	dims         []Dimension
+	ip, ipTo     net.IP
+	cidr         uint8
+	port, portTo uint16
+	proto        uint8
+}
+
+func (e *entryFields) has(dim Dimension) bool {
+	for _, d := range e.dims {
+		if d == dim {
+			return true
+		}
+	}
+	return false
+}
+
+func entryFieldsOf(set Set) entryFields {
+	switch set := set.(type) {
+	case *SetIP:
+		return entryFields{dims: []Dimension{DimIP}, ip: set.IP, ipTo: set.IPTO}
+	case *SetIPPort:
+		return entryFields{dims: []Dimension{DimIP, DimPort}, ip: set.IP, ipTo: set.IPTO,
+			port: set.Port, portTo: set.PortTo, proto: set.Proto}
+	case *SetNet:
+		return entryFields{dims: []Dimension{DimNet}, ip: set.IP, ipTo: set.IPTO, cidr: set.CIDR}
+	case *SetNetPort:
+		return entryFields{dims: []Dimension{DimNet, DimPort}, ip: set.IP, ipTo: set.IPTO, cidr: set.CIDR,
+			port: set.Port, portTo: set.PortTo, proto: set.Proto}
+	case *SetMac:
+		return entryFields{dims: []Dimension{DimMAC}}
+	case *SetResult:
+		if set.MAC != nil {
+			return entryFields{dims: []Dimension{DimMAC}}
+		}
+		e := entryFields{dims: []Dimension{DimIP}, ip: set.IP, cidr: set.CIDR, port: set.Port, proto: set.Proto}
+		if set.CIDR > 0 {
+			e.dims[0] = DimNet
+		}
+		if set.Proto > 0 {
+			e.dims = append(e.dims, DimPort)
+		}
+		return e
+	}
+	return entryFields{}
+}
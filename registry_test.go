@@ -0,0 +1,250 @@
+package goipset
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/JiHanHuang/goipset/ipsettest"
+	"github.com/JiHanHuang/goipset/nl"
+	"golang.org/x/sys/unix"
+)
+
+func TestValidateEntry(t *testing.T) {
+	tests := []struct {
+		typename string
+		family   int
+		revision uint8
+		set      Set
+		err      string
+	}{
+		{"hash:ip", unix.AF_INET, 6, &SetIP{IP: net.ParseIP("1.1.1.1")}, ""},
+		{"hash:ip", unix.AF_INET, 6, &SetIPPort{IP: net.ParseIP("1.1.1.1"), Port: 80, Proto: unix.IPPROTO_TCP},
+			"hash:ip does not accept a port"},
+		{"hash:ip", unix.AF_INET, 6, &SetNet{IP: net.ParseIP("1.1.1.0"), CIDR: 24}, "hash:ip does not accept a network"},
+		{"hash:ip", unix.AF_INET, 6, &SetMac{MAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}}, "hash:ip does not accept a MAC address"},
+		{"hash:ip", unix.AF_INET, 6, &SetIP{IP: net.ParseIP("::1")}, "hash:ip does not accept ::1 in a set of family inet"},
+		{"hash:ip", unix.AF_INET6, 6, &SetIP{IP: net.ParseIP("::1"), IPTO: net.ParseIP("::2")},
+			"hash:ip does not accept IPv6 ranges"},
+		{"hash:ip", unix.AF_INET, 6, &SetIP{IP: net.ParseIP("1.1.1.2"), IPTO: net.ParseIP("1.1.1.1")},
+			"range 1.1.1.2-1.1.1.1 ends before it starts"},
+		{"hash:net", unix.AF_INET, 7, &SetIP{IP: net.ParseIP("1.1.1.1")}, ""},
+		{"hash:net", unix.AF_INET, 7, &SetNet{IP: net.ParseIP("1.1.1.0"), CIDR: 33},
+			"hash:net does not accept prefix length 33 for inet"},
+		// goipset splits the ranges of network types if the kernel can't.
+		{"hash:net", unix.AF_INET, 0, &SetNet{IP: net.ParseIP("1.1.1.0"), IPTO: net.ParseIP("1.1.1.9")}, ""},
+		{"hash:net", unix.AF_INET6, 7, &SetNet{IP: net.ParseIP("2001:db8::1"), IPTO: net.ParseIP("2001:db8::9")}, ""},
+		{"hash:net", unix.AF_INET6, 7, &SetNet{IP: net.ParseIP("2001:db8::9"), IPTO: net.ParseIP("2001:db8::1")},
+			"range 2001:db8::9-2001:db8::1 ends before it starts"},
+		{"hash:ip,port", unix.AF_INET, 7, &SetIP{IP: net.ParseIP("1.1.1.1")}, "hash:ip,port requires a port"},
+		{"hash:ip,port", unix.AF_INET, 7, &SetIPPort{IP: net.ParseIP("1.1.1.1"), Port: 80}, "hash:ip,port does not accept protocol 0"},
+		{"hash:ip,port", unix.AF_INET, 0, &SetIPPort{IP: net.ParseIP("1.1.1.1"), Port: 80, Proto: unix.IPPROTO_SCTP},
+			"hash:ip,port revision 0 does not support SCTP and UDPLite ports"},
+		{"hash:net,port", unix.AF_INET, 8, &SetIPPort{IP: net.ParseIP("1.1.1.1"), Port: 80, Proto: unix.IPPROTO_TCP}, ""},
+		{"hash:net,port", unix.AF_INET, 8, &SetNetPort{IP: net.ParseIP("1.1.1.0"), CIDR: 24, Port: 90, PortTo: 80, Proto: unix.IPPROTO_TCP},
+			"port range 90-80 ends before it starts"},
+		{"hash:mac", unix.AF_UNSPEC, 1, &SetMac{MAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}}, ""},
+		{"hash:mac", unix.AF_UNSPEC, 1, &SetIP{IP: net.ParseIP("1.1.1.1")}, "hash:mac does not accept an IP address"},
+		{"hash:mac", unix.AF_UNSPEC, 1, &SetResult{IP: net.ParseIP("1.1.1.1"), Port: 80, Proto: unix.IPPROTO_TCP},
+			"hash:mac does not accept an IP address"},
+	}
+	for _, test := range tests {
+		setType, ok := LookupSetType(test.typename)
+		if !ok {
+			t.Fatalf("expected %s to be known", test.typename)
+		}
+		err := setType.ValidateEntry(test.family, test.revision, &GoIPSetEntry{Set: test.set})
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("expected %s to accept %s, got %v", test.typename, test.set, err)
+		case test.err != "" && (err == nil || err.Error() != test.err):
+			t.Errorf("expected %s to reject %s with %q, got %v", test.typename, test.set, test.err, err)
+		}
+	}
+}
+
+func TestValidateCreate(t *testing.T) {
+	hashIP, _ := LookupSetType("hash:ip")
+	hashMac, _ := LookupSetType("hash:mac")
+	hashNet, _ := LookupSetType("hash:net")
+	hashIPPort, _ := LookupSetType("hash:ip,port")
+	// A set type without the options of the hash types, as bitmap:ip.
+	bitmap := &SetType{Name: "bitmap:ip", Families: []int{unix.AF_INET}, CreateOptions: commonCreateOptions}
+	tests := []struct {
+		setType  *SetType
+		options  GoIpsetCreateOptions
+		revision uint8
+		expected string
+	}{
+		{hashIP, GoIpsetCreateOptions{Comments: true, Counters: true}, 6, ""},
+		{hashIP, GoIpsetCreateOptions{Comments: true}, 1, "hash:ip revision 1 does not support comments"},
+		{hashMac, GoIpsetCreateOptions{Family: unix.AF_INET6}, 1, "hash:mac does not accept family inet6"},
+		{hashIP, GoIpsetCreateOptions{ForceAdd: true}, 3, ""},
+		{hashIP, GoIpsetCreateOptions{ForceAdd: true}, 2, "hash:ip revision 2 does not support forceadd"},
+		{hashMac, GoIpsetCreateOptions{ForceAdd: true}, 0, ""},
+		{bitmap, GoIpsetCreateOptions{ForceAdd: true}, 3, "bitmap:ip does not accept forceadd"},
+		{hashIP, GoIpsetCreateOptions{InitVal: 0xe40c292c}, 5, ""},
+		{hashIP, GoIpsetCreateOptions{InitVal: 0xe40c292c}, 4, "hash:ip revision 4 does not support initial hash values"},
+		{hashMac, GoIpsetCreateOptions{InitVal: 1}, 0, "hash:mac revision 0 does not support initial hash values"},
+		{hashIP, GoIpsetCreateOptions{BucketSize: 12}, 4, "hash:ip revision 4 does not support bucket sizes"},
+		{hashIP, GoIpsetCreateOptions{NetMask: 24}, 0, ""},
+		{hashIP, GoIpsetCreateOptions{NetMask: 33}, 6, "hash:ip does not accept netmask 33 for inet"},
+		{hashIP, GoIpsetCreateOptions{Family: unix.AF_INET6, NetMask: 64}, 6, ""},
+		{hashNet, GoIpsetCreateOptions{NetMask: 24}, 7, "hash:net does not accept netmask"},
+		{hashIPPort, GoIpsetCreateOptions{NetMask: 24}, 7, ""},
+		{hashIPPort, GoIpsetCreateOptions{NetMask: 24}, 6, "hash:ip,port revision 6 does not support netmask"},
+	}
+	for _, test := range tests {
+		got := ""
+		err := test.setType.ValidateCreate(test.options, test.revision)
+		if err != nil {
+			got = err.Error()
+		}
+		if got != test.expected {
+			t.Errorf("%s revision %d %+v: expected %q, got %v", test.setType.Name, test.revision, test.options, test.expected, err)
+		}
+	}
+
+	if n := len(SetTypes()); n != 5 {
+		t.Errorf("expected 5 set types, got %d", n)
+	}
+}
+
+func TestValidateWithKernel(t *testing.T) {
+	kernel := ipsettest.NewKernel()
+	ipset := NewGoIpsetWithTransport(kernel)
+
+	if err := ipset.Create("test", "hash:foo", GoIpsetCreateOptions{}); err == nil || !strings.Contains(err.Error(), "unsupported set type") {
+		t.Errorf("expected an unknown set type to be rejected, got %v", err)
+	}
+	if err := ipset.Create("test", "hash:ip", GoIpsetCreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	entry := GoIPSetEntry{Set: &SetIPPort{IP: net.ParseIP("1.1.1.1"), Port: 80, Proto: unix.IPPROTO_TCP}}
+	if err := ipset.Add("test", &entry); err == nil || err.Error() != "test: hash:ip does not accept a port" {
+		t.Errorf("expected the port to be rejected, got %v", err)
+	}
+
+	// A set created by someone else is looked up once.
+	other := NewGoIpsetWithTransport(kernel)
+	if err := other.Add("test", &entry); err == nil || err.Error() != "test: hash:ip does not accept a port" {
+		t.Errorf("expected the port to be rejected, got %v", err)
+	}
+	entry.Set = &SetIP{IP: net.ParseIP("1.1.1.1")}
+	if err := other.Add("test", &entry); err != nil {
+		t.Error(err)
+	}
+	if err := other.Add("missing", &entry); err != unix.ENOENT {
+		t.Errorf("expected ENOENT for a missing set, got %v", err)
+	}
+}
+
+// extAckTransport wraps the errors of a transport as the kernel does when
+// it sends extended ACKs.
+type extAckTransport struct {
+	Transport
+}
+
+func (t extAckTransport) Execute(req *nl.NetlinkRequest, fn func(msg []byte) error, restart func() error) error {
+	if err := t.Transport.Execute(req, fn, restart); err != nil {
+		return &nl.ExtAckError{Err: err, Msg: "wrapped"}
+	}
+	return nil
+}
+
+func TestValidateWrappedENOENT(t *testing.T) {
+	kernel := ipsettest.NewKernel()
+	ipset := NewGoIpsetWithTransport(extAckTransport{kernel})
+	other := NewGoIpsetWithTransport(kernel)
+	host := GoIPSetEntry{Set: &SetIP{IP: net.ParseIP("1.1.1.1")}}
+	network := GoIPSetEntry{Set: &SetNet{IP: net.ParseIP("10.0.0.0"), CIDR: 8}}
+
+	// The set is recreated as hash:net after each ENOENT, which must drop
+	// the header of the hash:ip set before it.
+	for _, add := range []func() error{
+		func() error { return ipset.Add("test", &host) },
+		func() error {
+			_, err := ipset.Load("test", []GoIPSetEntry{host}, LoadOptions{})
+			return err
+		},
+	} {
+		if err := other.Create("test", "hash:ip", GoIpsetCreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := add(); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.Destroy("test"); err != nil {
+			t.Fatal(err)
+		}
+		if err := add(); !errors.Is(err, unix.ENOENT) {
+			t.Fatalf("expected ENOENT, got %v", err)
+		}
+		if err := other.Create("test", "hash:net", GoIpsetCreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := ipset.Add("test", &network); err != nil {
+			t.Errorf("expected the header of the new set, got %v", err)
+		}
+		if err := other.Destroy("test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestValidateRecreatedSet(t *testing.T) {
+	kernel := ipsettest.NewKernel()
+	ipset := NewGoIpsetWithTransport(kernel)
+	other := NewGoIpsetWithTransport(kernel)
+	host := GoIPSetEntry{Set: &SetIP{IP: net.ParseIP("1.1.1.1")}}
+	network := GoIPSetEntry{Set: &SetNet{IP: net.ParseIP("10.0.0.0"), CIDR: 8}}
+
+	// Someone else recreates the set as hash:net after its hash:ip header
+	// was cached, which must not keep the network from being added.
+	for _, add := range []func() error{
+		func() error { return ipset.Add("test", &network) },
+		func() error {
+			_, err := ipset.Load("test", []GoIPSetEntry{network}, LoadOptions{})
+			return err
+		},
+		func() error {
+			_, err := ipset.Sync("test", []GoIPSetEntry{network}, SyncOptions{})
+			return err
+		},
+		func() error {
+			return ipset.Restore(strings.NewReader("add test 10.0.0.0/8\n"), RestoreOptions{})
+		},
+	} {
+		if err := other.Create("test", "hash:ip", GoIpsetCreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := ipset.Add("test", &host); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.Destroy("test"); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.Create("test", "hash:net", GoIpsetCreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := add(); err != nil {
+			t.Errorf("expected the header of the new set, got %v", err)
+		}
+		if result, err := other.List("test"); err != nil || len(result.Entries) != 1 {
+			t.Errorf("expected the network in the set, got %+v %v", result.Entries, err)
+		}
+		if err := other.Destroy("test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An entry the new set rejects too is reported against the new set.
+	if err := other.Create("test", "hash:ip", GoIpsetCreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	port := GoIPSetEntry{Set: &SetIPPort{IP: net.ParseIP("1.1.1.1"), Port: 80, Proto: unix.IPPROTO_TCP}}
+	if err := ipset.Add("test", &port); err == nil || err.Error() != "test: hash:ip does not accept a port" {
+		t.Errorf("expected the port to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package goipset
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/JiHanHuang/goipset/nl"
+	"golang.org/x/sys/unix"
+)
+
+// tempSets counts the temporary sets made by Replace, to keep their names
+// apart within the process.
+var tempSets uint32
+
+// Replace atomically replaces the entries of an ipset.
+func Replace(setname string, entries []GoIPSetEntry) error {
+	return gipset.Replace(setname, entries)
+}
+
+// Replace makes an ipset contain exactly entries without a moment in which
+// it is empty or half loaded. The entries are loaded into a temporary set
+// created with the type, family and options of the ipset, which is then
+// swapped with it, and the old entries are destroyed with the temporary
+// set. If anything fails before the swap, the temporary set is destroyed
+// and the ipset stays as it was. An ipset with options the temporary set
+// can't be created with is not replaced.
+//
+// If the ipset does not exist yet, it is created with the type the entries
+// need, hash:net if any of them is a network and hash:ip otherwise, with a
+// port if any of them has one, or hash:mac, and the family of the first
+// entry.
+func (g *GoIpset) Replace(setname string, entries []GoIPSetEntry) error {
+	header, err := g.ListHeader(setname)
+	if errors.Is(err, unix.ENOENT) {
+		return g.replaceMissing(setname, entries)
+	}
+	if err != nil {
+		return err
+	}
+
+	options, err := createOptionsOf(&header)
+	if err != nil {
+		return err
+	}
+
+	var temp string
+	for {
+		temp = tempSetName(setname)
+		err = g.Create(temp, header.TypeName, options)
+		if !errors.Is(err, unix.EEXIST) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := g.Load(temp, entries, LoadOptions{Exist: true}); err != nil {
+		g.Destroy(temp)
+		return err
+	}
+	if err := g.Swap(temp, setname); err != nil {
+		g.Destroy(temp)
+		return err
+	}
+	return g.Destroy(temp)
+}
+
+// createFlags are the flags of a set header createOptionsOf recreates.
+const createFlags = nl.IPSET_FLAG_WITH_COUNTERS | nl.IPSET_FLAG_WITH_COMMENT |
+	nl.IPSET_FLAG_WITH_SKBINFO | nl.IPSET_FLAG_WITH_FORCEADD
+
+// createOptionsOf returns the options to create a set like header with. It
+// fails if the header has options they can't express, rather than making a
+// set that differs from it.
+func createOptionsOf(header *GoIPSetResult) (GoIpsetCreateOptions, error) {
+	if flags := header.CadtFlags &^ createFlags; flags != 0 {
+		return GoIpsetCreateOptions{}, fmt.Errorf("%s has flags 0x%x that can't be recreated", header.SetName, flags)
+	}
+	return GoIpsetCreateOptions{
+		Family:     int(header.Family),
+		Timeout:    header.Timeout,
+		Counters:   header.CadtFlags&nl.IPSET_FLAG_WITH_COUNTERS != 0,
+		Comments:   header.CadtFlags&nl.IPSET_FLAG_WITH_COMMENT != 0,
+		Skbinfo:    header.CadtFlags&nl.IPSET_FLAG_WITH_SKBINFO != 0,
+		ForceAdd:   header.CadtFlags&nl.IPSET_FLAG_WITH_FORCEADD != 0,
+		HashSize:   header.HashSize,
+		MaxElem:    header.MaxElements,
+		BucketSize: header.BucketSize,
+		InitVal:    header.InitVal,
+		NetMask:    header.NetMask,
+	}, nil
+}
+
+// replaceMissing creates an ipset that Replace did not find for entries.
+func (g *GoIpset) replaceMissing(setname string, entries []GoIPSetEntry) error {
+	typename, family, err := setTypeFor(entries)
+	if err != nil {
+		return fmt.Errorf("%s: %v", setname, err)
+	}
+	if err := g.Create(setname, typename, GoIpsetCreateOptions{Family: family}); err != nil {
+		return err
+	}
+	if _, err := g.Load(setname, entries, LoadOptions{Exist: true}); err != nil {
+		g.Destroy(setname)
+		return err
+	}
+	return nil
+}
+
+// setTypeFor returns the set type and family entries need.
+func setTypeFor(entries []GoIPSetEntry) (string, int, error) {
+	if len(entries) == 0 {
+		return "", 0, fmt.Errorf("no entries to tell the set type from")
+	}
+	var isNet, isPort, isMAC bool
+	family := 0
+	for i := range entries {
+		if entries[i].Set == nil {
+			return "", 0, fmt.Errorf("Set is nil in GoIPSetEntry")
+		}
+		e := entryFieldsOf(entries[i].Set)
+		isNet = isNet || e.has(DimNet) || entries[i].NoMatch
+		isPort = isPort || e.has(DimPort)
+		isMAC = isMAC || e.has(DimMAC)
+		if family == 0 && e.ip != nil {
+			family = nl.GetIPFamily(e.ip)
+		}
+	}
+	switch {
+	case isMAC && (isNet || isPort || family != 0):
+		return "", 0, fmt.Errorf("MAC addresses and IP addresses can't be in the same set")
+	case isMAC:
+		return "hash:mac", 0, nil
+	case isNet && isPort:
+		return "hash:net,port", family, nil
+	case isNet:
+		return "hash:net", family, nil
+	case isPort:
+		return "hash:ip,port", family, nil
+	}
+	return "hash:ip", family, nil
+}
+
+// tempSetName returns a name for a temporary set to replace setname with,
+// which fits into the name length the kernel allows.
+func tempSetName(setname string) string {
+	suffix := fmt.Sprintf("~%d.%d", os.Getpid(), atomic.AddUint32(&tempSets, 1))
+	if max := nl.IPSET_MAXNAMELEN - 1 - len(suffix); len(setname) > max {
+		setname = setname[:max]
+	}
+	return setname + suffix
+}
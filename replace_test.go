@@ -0,0 +1,148 @@
+package goipset
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/JiHanHuang/goipset/ipsettest"
+	"github.com/JiHanHuang/goipset/nl"
+	"golang.org/x/sys/unix"
+)
+
+func TestReplace(t *testing.T) {
+	kernel := ipsettest.NewKernel()
+	ipset := NewGoIpsetWithTransport(kernel)
+	options := GoIpsetCreateOptions{Comments: true, Timeout: 300, HashSize: 4096, MaxElem: 1000, Family: unix.AF_INET6}
+	if err := ipset.Create("live", "hash:net", options); err != nil {
+		t.Fatal(err)
+	}
+	old := GoIPSetEntry{Set: &SetNet{IP: net.ParseIP("2001:db8::"), CIDR: 32}}
+	if err := ipset.Add("live", &old); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []GoIPSetEntry{
+		{Set: &SetNet{IP: net.ParseIP("2001:db8:1::"), CIDR: 48}, Comment: "new"},
+		{Set: &SetIP{IP: net.ParseIP("2001:db8:2::1")}},
+		{Set: &SetIP{IP: net.ParseIP("2001:db8:2::1")}},
+	}
+	if err := ipset.Replace("live", entries); err != nil {
+		t.Fatal(err)
+	}
+	result, err := ipset.List("live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range result.Entries {
+		got = append(got, entry.Set.String())
+	}
+	expectEntries(t, got, "2001:db8:1::/48", "2001:db8:2::1/128")
+	if result.Family != unix.AF_INET6 || result.Timeout != 300 || result.HashSize != 4096 || result.MaxElements != 1000 ||
+		result.CadtFlags&nl.IPSET_FLAG_WITH_COMMENT == 0 {
+		t.Errorf("expected the options to be kept, got %+v", result)
+	}
+
+	all, err := ipset.ListAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 {
+		t.Errorf("expected the temporary set to be destroyed, got %d sets", len(all))
+	}
+
+	// A failed load leaves the set as it was.
+	bad := []GoIPSetEntry{{Set: &SetIP{IP: net.ParseIP("10.0.0.1")}}}
+	if err := ipset.Replace("live", bad); err == nil {
+		t.Errorf("expected an IPv4 address to fail")
+	}
+	if all, _ := ipset.ListAll(); len(all) != 1 || len(all[0].Entries) != 2 {
+		t.Errorf("expected the set to be untouched, got %+v", all)
+	}
+
+	// A missing set is created.
+	if err := ipset.Replace("ports", []GoIPSetEntry{
+		{Set: &SetIPPort{IP: net.ParseIP("10.0.0.1"), Port: 22, Proto: unix.IPPROTO_TCP}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	header, err := ipset.Header("ports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.TypeName != "hash:ip,port" || header.Family != unix.AF_INET {
+		t.Errorf("expected an inet hash:ip,port set, got %s %d", header.TypeName, header.Family)
+	}
+	if err := ipset.Replace("empty", nil); err == nil {
+		t.Errorf("expected a missing set without entries to fail")
+	}
+}
+
+func TestReplaceOptions(t *testing.T) {
+	ipset := NewGoIpsetWithTransport(extAckTransport{ipsettest.NewKernel()})
+	options := GoIpsetCreateOptions{ForceAdd: true, InitVal: 0x12345678, NetMask: 24, BucketSize: 4}
+	if err := ipset.Create("masked", "hash:ip", options); err != nil {
+		t.Fatal(err)
+	}
+	before, err := ipset.ListHeader("masked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ipset.Replace("masked", []GoIPSetEntry{{Set: &SetIP{IP: net.ParseIP("10.0.0.0")}}}); err != nil {
+		t.Fatal(err)
+	}
+	after, err := ipset.ListHeader("masked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.CadtFlags&nl.IPSET_FLAG_WITH_FORCEADD == 0 || after.InitVal != 0x12345678 || after.NetMask != 24 ||
+		after.BucketSize != 4 || after.CadtFlags != before.CadtFlags {
+		t.Errorf("expected the options to be kept, got %+v", after)
+	}
+
+	// A set missing behind a wrapped ENOENT is created.
+	if err := ipset.Replace("new", []GoIPSetEntry{{Set: &SetIP{IP: net.ParseIP("10.0.0.1")}}}); err != nil {
+		t.Errorf("expected the missing set to be created, got %v", err)
+	}
+
+	// Options that can't be recreated are refused.
+	if _, err := createOptionsOf(&GoIPSetResult{SetName: "odd", CadtFlags: 1 << 7}); err == nil {
+		t.Errorf("expected unknown flags to be refused")
+	}
+}
+
+func TestSetTypeFor(t *testing.T) {
+	mac := &SetMac{MAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}}
+	tests := []struct {
+		sets     []Set
+		typename string
+		family   int
+	}{
+		{[]Set{&SetIP{IP: net.ParseIP("1.1.1.1")}}, "hash:ip", unix.AF_INET},
+		{[]Set{&SetIP{IP: net.ParseIP("::1")}, &SetNet{IP: net.ParseIP("::"), CIDR: 64}}, "hash:net", unix.AF_INET6},
+		{[]Set{&SetNetPort{IP: net.ParseIP("1.1.1.0"), CIDR: 24, Port: 1, Proto: unix.IPPROTO_UDP}}, "hash:net,port", unix.AF_INET},
+		{[]Set{mac}, "hash:mac", 0},
+		{[]Set{mac, &SetIP{IP: net.ParseIP("1.1.1.1")}}, "", 0},
+	}
+	for _, test := range tests {
+		var entries []GoIPSetEntry
+		for _, set := range test.sets {
+			entries = append(entries, GoIPSetEntry{Set: set})
+		}
+		typename, family, err := setTypeFor(entries)
+		switch {
+		case test.typename == "" && err == nil:
+			t.Errorf("expected %v to fail", test.sets)
+		case test.typename != "" && (typename != test.typename || family != test.family):
+			t.Errorf("expected %s %d for %v, got %s %d %v", test.typename, test.family, test.sets, typename, family, err)
+		}
+	}
+}
+
+func TestTempSetName(t *testing.T) {
+	a, b := tempSetName(strings.Repeat("x", 31)), tempSetName("short")
+	if len(a) > 31 || !strings.HasPrefix(b, "short~") || a[strings.Index(a, "~"):] == b[5:] {
+		t.Errorf("unexpected temporary names %q and %q", a, b)
+	}
+}
@@ -0,0 +1,399 @@
+package goipset
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/JiHanHuang/goipset/nl"
+	"golang.org/x/sys/unix"
+)
+
+// RestoreOptions are the options of Restore.
+type RestoreOptions struct {
+	// Exist ignores sets that already exist with the same type and
+	// options, entries that are already added and entries that are
+	// already deleted, like ipset -exist.
+	Exist bool
+}
+
+// RestoreError is the error of a line of the input of Restore.
+type RestoreError struct {
+	Line int // starting at 1
+	Err  error
+}
+
+func (e *RestoreError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RestoreError) Unwrap() error {
+	return e.Err
+}
+
+// Restore executes the commands read from r in the format of ipset save and
+// ipset restore.
+func Restore(r io.Reader, options RestoreOptions) error {
+	return gipset.Restore(r, options)
+}
+
+// Restore executes the commands read from r in the format of ipset save and
+// ipset restore, one per line:
+//
+//	create SETNAME TYPENAME [create options]
+//	add SETNAME ENTRY [entry options]
+//	del SETNAME ENTRY [entry options]
+//	flush [SETNAME]
+//	destroy [SETNAME]
+//	rename SETNAME SETNAME
+//	swap SETNAME SETNAME
+//	COMMIT
+//
+// The short forms -N, -A, -D, -F, -X, -E and -W of the commands are
+// accepted too, empty lines and lines starting with # are skipped.
+// Consecutive add or del lines of a set are sent to the kernel in batches.
+//
+// Restore stops at the first line that fails and returns a *RestoreError
+// telling its number, the lines before it have been executed. When the
+// kernel does not tell which entry of a batch it failed at, the line of the
+// first entry of the batch is reported.
+func (g *GoIpset) Restore(r io.Reader, options RestoreOptions) error {
+	rs := &restorer{g: g, exist: options.Exist}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		rs.lineno++
+		if err := rs.line(scanner.Text()); err != nil {
+			if _, ok := err.(*RestoreError); ok {
+				return err
+			}
+			if flushErr := rs.flush(); flushErr != nil {
+				return flushErr
+			}
+			return &RestoreError{Line: rs.lineno, Err: err}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return rs.flush()
+}
+
+// restorer executes the lines of Restore and collects the entries of
+// consecutive add or del lines of a set into a batch.
+type restorer struct {
+	g      *GoIpset
+	exist  bool
+	lineno int
+
+	cmd     int
+	setname string
+	entries []*GoIPSetEntry
+	lines   []int // of every entry of the batch
+}
+
+var restoreCommands = map[string]string{
+	"-N": "create",
+	"-A": "add",
+	"-D": "del",
+	"-F": "flush",
+	"-X": "destroy",
+	"-E": "rename",
+	"-W": "swap",
+}
+
+func (rs *restorer) line(text string) error {
+	args, err := SplitFields(text)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 || strings.HasPrefix(args[0], "#") {
+		return nil
+	}
+	cmd := args[0]
+	if long, ok := restoreCommands[cmd]; ok {
+		cmd = long
+	}
+	args = args[1:]
+
+	switch cmd {
+	case "add", "del":
+		if len(args) < 2 {
+			return fmt.Errorf("%s expects a set name and an entry", cmd)
+		}
+		nlCmd := nl.IPSET_CMD_ADD
+		if cmd == "del" {
+			nlCmd = nl.IPSET_CMD_DEL
+		}
+		return rs.addDel(nlCmd, args[0], args[1], args[2:])
+	}
+
+	if err := rs.flush(); err != nil {
+		return err
+	}
+	switch cmd {
+	case "create":
+		if len(args) < 2 {
+			return fmt.Errorf("create expects a set name and a type")
+		}
+		options, err := ParseCreateOptions(args[2:])
+		if err != nil {
+			return err
+		}
+		options.Replace = rs.exist
+		return rs.g.Create(args[0], args[1], options)
+	case "flush", "destroy":
+		switch {
+		case len(args) > 1:
+			return fmt.Errorf("%s expects at most a set name", cmd)
+		case cmd == "flush" && len(args) == 0:
+			return rs.g.FlushAll()
+		case cmd == "flush":
+			return rs.g.Flush(args[0])
+		case len(args) == 0:
+			return rs.g.DestroyAll()
+		}
+		return rs.g.Destroy(args[0])
+	case "rename", "swap":
+		if len(args) != 2 {
+			return fmt.Errorf("%s expects two set names", cmd)
+		}
+		if cmd == "rename" {
+			return rs.g.Rename(args[0], args[1])
+		}
+		return rs.g.Swap(args[0], args[1])
+	case "COMMIT":
+		if len(args) != 0 {
+			return fmt.Errorf("COMMIT expects no arguments")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown command %q", cmd)
+}
+
+// addDel parses an entry of an add or del line and adds it to the batch,
+// sending the batch first if it is of another command or set.
+func (rs *restorer) addDel(nlCmd int, setname, elem string, options []string) error {
+	if len(rs.entries) > 0 && (rs.cmd != nlCmd || rs.setname != setname) {
+		if err := rs.flush(); err != nil {
+			return err
+		}
+	}
+
+	// A missing set is reported with its name.
+	if _, err := rs.g.setHeader(setname); err != nil {
+		return fmt.Errorf("%s: %v", setname, err)
+	}
+	var expanded []*GoIPSetEntry
+	err := rs.g.withHeader(setname, func(header *setHeader) error {
+		if header.setType == nil {
+			return fmt.Errorf("%s: the type of the set is not supported", setname)
+		}
+		family := ""
+		if header.family == unix.AF_INET6 {
+			family = "inet6"
+		}
+		set, err := ParseEntry(header.setType.Name, family, elem)
+		if err != nil {
+			return err
+		}
+		entry := &GoIPSetEntry{Set: set}
+		if err := ParseEntryOptions(entry, options); err != nil {
+			return err
+		}
+		if err := header.setType.ValidateEntry(header.family, header.revision, entry); err != nil {
+			return fmt.Errorf("%s: %v", setname, err)
+		}
+		expanded, err = header.setType.expandRange(header.family, header.revision, entry)
+		if err != nil {
+			return fmt.Errorf("%s: %v", setname, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	rs.cmd, rs.setname = nlCmd, setname
+	for _, entry := range expanded {
+		rs.entries = append(rs.entries, entry)
+		rs.lines = append(rs.lines, rs.lineno)
+	}
+	return nil
+}
+
+// flush sends the batch to the kernel.
+func (rs *restorer) flush() error {
+	if len(rs.entries) == 0 {
+		return nil
+	}
+	failed, err := rs.g.addDelBatch(rs.cmd, rs.setname, rs.entries, rs.exist)
+	if err != nil {
+		line := rs.lines[0]
+		if failed >= 0 {
+			line = rs.lines[failed]
+		}
+		err = &RestoreError{Line: line, Err: err}
+	}
+	rs.entries, rs.lines = rs.entries[:0], rs.lines[:0]
+	return err
+}
+
+// ParseCreateOptions parses the options of ipset create, e.g.
+// "family inet6 timeout 60 comment", as they follow the set type.
+func ParseCreateOptions(args []string) (GoIpsetCreateOptions, error) {
+	var options GoIpsetCreateOptions
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "counters":
+			options.Counters = true
+			continue
+		case "comment":
+			options.Comments = true
+			continue
+		case "skbinfo":
+			options.Skbinfo = true
+			continue
+		case "forceadd":
+			options.ForceAdd = true
+			continue
+		}
+
+		name := args[i]
+		if i+1 == len(args) {
+			return options, fmt.Errorf("%s expects a value", name)
+		}
+		i++
+		value := args[i]
+		var err error
+		switch name {
+		case "family":
+			options.Family, err = parseFamily(value)
+		case "hashsize":
+			options.HashSize, err = parseUint32(value)
+		case "maxelem":
+			options.MaxElem, err = parseUint32(value)
+		case "timeout":
+			options.Timeout, err = parseUint32(value)
+		case "initval":
+			options.InitVal, err = parseUint32(value)
+		case "bucketsize":
+			var v uint64
+			v, err = strconv.ParseUint(value, 0, 8)
+			options.BucketSize = uint8(v)
+		case "netmask":
+			var v uint64
+			if v, err = strconv.ParseUint(value, 0, 8); err == nil && (v == 0 || v > 128) {
+				err = fmt.Errorf("out of range")
+			}
+			options.NetMask = uint8(v)
+		default:
+			return options, fmt.Errorf("unsupported create option %q", name)
+		}
+		if err != nil {
+			return options, fmt.Errorf("invalid %s %q", name, value)
+		}
+	}
+	// Whether the set type takes a netmask at all is up to ValidateCreate.
+	if options.NetMask > 32 && options.Family != unix.AF_INET6 {
+		return options, fmt.Errorf("invalid netmask %d for inet", options.NetMask)
+	}
+	return options, nil
+}
+
+// ParseEntryOptions parses the options of ipset add, del or test, e.g.
+// "timeout 60 comment text", as they follow the element, into entry.
+func ParseEntryOptions(entry *GoIPSetEntry, args []string) error {
+	for i := 0; i < len(args); i++ {
+		if args[i] == "nomatch" {
+			entry.NoMatch = true
+			continue
+		}
+
+		name := args[i]
+		if i+1 == len(args) {
+			return fmt.Errorf("%s expects a value", name)
+		}
+		i++
+		value := args[i]
+		var err error
+		switch name {
+		case "timeout":
+			entry.Timeout, err = parseUint32(value)
+		case "packets":
+			entry.Packets, err = strconv.ParseUint(value, 10, 64)
+		case "bytes":
+			entry.Bytes, err = strconv.ParseUint(value, 10, 64)
+		case "comment":
+			entry.Comment = value
+		case "skbmark":
+			mark, mask := value, ""
+			if slash := strings.IndexByte(value, '/'); slash >= 0 {
+				mark, mask = value[:slash], value[slash+1:]
+			}
+			if entry.SkbMark, err = parseUint32(mark); err == nil && mask != "" {
+				entry.SkbMask, err = parseUint32(mask)
+			}
+		case "skbprio":
+			var major, minor uint64
+			parts := strings.Split(value, ":")
+			if len(parts) != 2 {
+				err = fmt.Errorf("expected MAJOR:MINOR")
+				break
+			}
+			if major, err = strconv.ParseUint(parts[0], 16, 16); err == nil {
+				minor, err = strconv.ParseUint(parts[1], 16, 16)
+			}
+			entry.SkbPrio = uint32(major<<16 | minor)
+		case "skbqueue":
+			var v uint64
+			v, err = strconv.ParseUint(value, 10, 16)
+			entry.SkbQueue = uint16(v)
+		default:
+			return fmt.Errorf("unsupported entry option %q", name)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s %q", name, value)
+		}
+	}
+	return nil
+}
+
+// parseUint32 parses a decimal number or, with a 0x prefix, a hexadecimal
+// one.
+func parseUint32(s string) (uint32, error) {
+	v, err := strconv.ParseUint(s, 0, 32)
+	return uint32(v), err
+}
+
+// SplitFields splits a line of ipset restore at white space, double
+// quotes group words into one, e.g. the text of a comment.
+func SplitFields(text string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	inField, quoted := false, false
+	for _, r := range text {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inField = true
+		case !quoted && (r == ' ' || r == '\t' || r == '\r'):
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
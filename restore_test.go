@@ -0,0 +1,186 @@
+package goipset
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/JiHanHuang/goipset/ipsettest"
+	"github.com/JiHanHuang/goipset/nl"
+	"golang.org/x/sys/unix"
+)
+
+func TestRestore(t *testing.T) {
+	saved, err := ioutil.ReadFile("testdata/save.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipset := NewGoIpsetWithTransport(ipsettest.NewKernel())
+	if err := ipset.Restore(bytes.NewReader(saved), RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	sets, err := ipset.ListAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := WriteSave(&out, sets...); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != string(saved) {
+		t.Errorf("expected\n%s\ngot\n%s", saved, out.String())
+	}
+
+	// Without -exist the sets of the second restore exist already.
+	err = ipset.Restore(bytes.NewReader(saved), RestoreOptions{})
+	var restoreErr *RestoreError
+	if !errors.As(err, &restoreErr) || restoreErr.Line != 1 || !errors.Is(err, unix.EEXIST) {
+		t.Errorf("expected line 1 to fail with EEXIST, got %v", err)
+	}
+	if err := ipset.Restore(bytes.NewReader(saved), RestoreOptions{Exist: true}); err != nil {
+		t.Errorf("expected -exist to ignore the sets and entries, got %v", err)
+	}
+}
+
+func TestRestoreCommands(t *testing.T) {
+	ipset := NewGoIpsetWithTransport(ipsettest.NewKernel())
+	input := `# comment
+-N a hash:ip
+create b hash:net family inet6 timeout 60
+
+-A a 10.0.0.0/30
+add b 2001:db8::/32 timeout 30
+-D a 10.0.0.1
+flush b
+-E a c
+-N d hash:ip
+add d 10.0.0.9
+-W c d
+destroy d
+COMMIT
+`
+	if err := ipset.Restore(strings.NewReader(input), RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	sets, err := ipset.ListAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string][]string{}
+	for _, set := range sets {
+		got[set.SetName] = []string{}
+		for _, entry := range set.Entries {
+			got[set.SetName] = append(got[set.SetName], entry.Set.String())
+		}
+	}
+	expected := map[string][]string{
+		"b": {},
+		"c": {"10.0.0.9"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	if err := ipset.Restore(strings.NewReader("destroy\n"), RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if sets, _ := ipset.ListAll(); len(sets) != 0 {
+		t.Errorf("expected all sets to be destroyed, got %d", len(sets))
+	}
+}
+
+func TestRestoreErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		line  int
+		text  string
+	}{
+		{"create a hash:ip\nbogus a\n", 2, `unknown command "bogus"`},
+		{"create a hash:ip comment\nadd a 10.0.0.1 comment \"open\n", 2, "unterminated quote"},
+		{"create a hash:ip netmask 129\n", 1, `invalid netmask "129"`},
+		{"create a hash:ip netmask 33\n", 1, "invalid netmask 33 for inet"},
+		{"create a hash:net netmask 24\n", 1, "hash:net does not accept netmask"},
+		{"create a hash:ip hashsize\n", 1, "hashsize expects a value"},
+		{"create a hash:ip\nadd a 10.0.0.1 timeout\n", 2, "timeout expects a value"},
+		{"create a hash:ip\nadd a 10.0.0.1 skbprio 1\n", 2, `invalid skbprio "1"`},
+		{"add missing 10.0.0.1\n", 1, "missing:"},
+		{"create a hash:ip\nadd a 10.0.0.1\n\nadd a 10.0.0.2\nadd a 10.0.0.1\nadd a 10.0.0.3\n", 5, ""},
+		{"create a hash:ip\nadd a 10.0.0.1\nadd a 10.0.0.2\ncreate a hash:ip\n", 4, ""},
+	}
+	for _, test := range tests {
+		ipset := NewGoIpsetWithTransport(ipsettest.NewKernel())
+		err := ipset.Restore(strings.NewReader(test.input), RestoreOptions{})
+		restoreErr, ok := err.(*RestoreError)
+		if !ok || restoreErr.Line != test.line || !strings.Contains(err.Error(), test.text) {
+			t.Errorf("expected %q to fail at line %d with %q, got %v", test.input, test.line, test.text, err)
+		}
+	}
+
+	// The lines before the one that failed have been executed.
+	ipset := NewGoIpsetWithTransport(ipsettest.NewKernel())
+	input := "create a hash:ip\nadd a 10.0.0.1\nadd a 10.0.0.2\nadd a 10.0.0.1\n"
+	err := ipset.Restore(strings.NewReader(input), RestoreOptions{})
+	if !errors.Is(err, nl.IPSetError(nl.IPSET_ERR_EXIST)) {
+		t.Errorf("expected IPSET_ERR_EXIST, got %v", err)
+	}
+	if result, _ := ipset.List("a"); len(result.Entries) != 2 {
+		t.Errorf("expected 2 entries, got %+v", result.Entries)
+	}
+}
+
+// A set with a netmask is saved with it and restored from the save.
+func TestRestoreNetMask(t *testing.T) {
+	ipset := NewGoIpsetWithTransport(ipsettest.NewKernel())
+	if err := ipset.Create("masked", "hash:ip", GoIpsetCreateOptions{NetMask: 24, Timeout: 60}); err != nil {
+		t.Fatal(err)
+	}
+	saved := func(ipset *GoIpset) string {
+		result, err := ipset.List("masked")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := WriteSave(&out, result); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+	save := saved(ipset)
+	if !strings.Contains(save, " netmask 24 ") {
+		t.Fatalf("expected the netmask to be saved, got %q", save)
+	}
+
+	restored := NewGoIpsetWithTransport(ipsettest.NewKernel())
+	if err := restored.Restore(strings.NewReader(save), RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := saved(restored); got != save {
+		t.Errorf("expected\n%s\ngot\n%s", save, got)
+	}
+
+	var result GoIPSetResult
+	if err := result.UnmarshalText([]byte(save)); err != nil || result.NetMask != 24 {
+		t.Errorf("expected the text to have netmask 24, got %d %v", result.NetMask, err)
+	}
+}
+
+func TestRestoreFields(t *testing.T) {
+	tests := []struct {
+		text   string
+		fields []string
+	}{
+		{"", nil},
+		{"  add\ta  1.1.1.1 ", []string{"add", "a", "1.1.1.1"}},
+		{`add a 1.1.1.1 comment "two words" timeout 5`, []string{"add", "a", "1.1.1.1", "comment", "two words", "timeout", "5"}},
+		{`comment ""`, []string{"comment", ""}},
+	}
+	for _, test := range tests {
+		fields, err := SplitFields(test.text)
+		if err != nil || !reflect.DeepEqual(fields, test.fields) {
+			t.Errorf("expected %q for %q, got %q %v", test.fields, test.text, fields, err)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package goipset
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/JiHanHuang/goipset/nl"
+	"golang.org/x/sys/unix"
+)
+
+// WriteSave writes sets as listed by List or ListAll in the format of
+// ipset save: a create line with the options of every set, followed by an
+// add line for each of its entries.
+func WriteSave(w io.Writer, sets ...GoIPSetResult) error {
+	for i := range sets {
+		if _, err := fmt.Fprintln(w, saveCreate(&sets[i])); err != nil {
+			return err
+		}
+		for j := range sets[i].Entries {
+			if _, err := fmt.Fprintln(w, saveAdd(&sets[i], &sets[i].Entries[j])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// saveOption is an option of a create or add line, flags have no value.
+type saveOption struct {
+	name, value string
+}
+
+// joinOptions returns options as on a line of ipset save.
+func joinOptions(options []saveOption) string {
+	var b strings.Builder
+	for _, option := range options {
+		b.WriteString(" " + option.name)
+		if option.value != "" {
+			b.WriteString(" " + option.value)
+		}
+	}
+	return b.String()
+}
+
+// saveCreate returns the create line of set.
+func saveCreate(set *GoIPSetResult) string {
+	return fmt.Sprintf("create %s %s%s", set.SetName, set.TypeName, joinOptions(createOptions(set)))
+}
+
+// createOptions returns the options of set as ipset save prints them.
+func createOptions(set *GoIPSetResult) []saveOption {
+	var options []saveOption
+	add := func(name string, format string, value ...interface{}) {
+		options = append(options, saveOption{name, fmt.Sprintf(format, value...)})
+	}
+	switch set.Family {
+	case unix.AF_INET:
+		add("family", "%s", "inet")
+	case unix.AF_INET6:
+		add("family", "%s", "inet6")
+	}
+	if set.HashSize != 0 {
+		add("hashsize", "%d", set.HashSize)
+	}
+	if set.MaxElements != 0 {
+		add("maxelem", "%d", set.MaxElements)
+	}
+	if set.NetMask != 0 {
+		add("netmask", "%d", set.NetMask)
+	}
+	if set.Timeout != 0 {
+		add("timeout", "%d", set.Timeout)
+	}
+	for _, flag := range []struct {
+		flag uint32
+		name string
+	}{
+		{nl.IPSET_FLAG_WITH_COUNTERS, "counters"},
+		{nl.IPSET_FLAG_WITH_COMMENT, "comment"},
+		{nl.IPSET_FLAG_WITH_FORCEADD, "forceadd"},
+		{nl.IPSET_FLAG_WITH_SKBINFO, "skbinfo"},
+	} {
+		if set.CadtFlags&flag.flag != 0 {
+			options = append(options, saveOption{name: flag.name})
+		}
+	}
+	if set.BucketSize != 0 {
+		add("bucketsize", "%d", set.BucketSize)
+	}
+	if set.InitVal != 0 {
+		add("initval", "0x%08x", set.InitVal)
+	}
+	return options
+}
+
+// saveAdd returns the add line of an entry of set.
+func saveAdd(set *GoIPSetResult, entry *GoIPSetEntry) string {
+	return fmt.Sprintf("add %s %s", set.SetName, saveEntry(set, entry))
+}
+
+// saveEntry returns the element and the options of an entry of set as on
+// an add line.
+func saveEntry(set *GoIPSetResult, entry *GoIPSetEntry) string {
+	return saveElem(entry.Set) + joinOptions(entryOptions(set, entry))
+}
+
+// entryOptions returns the options of an entry of set as ipset save
+// prints them.
+func entryOptions(set *GoIPSetResult, entry *GoIPSetEntry) []saveOption {
+	var options []saveOption
+	add := func(name string, format string, value ...interface{}) {
+		options = append(options, saveOption{name, fmt.Sprintf(format, value...)})
+	}
+	if set.Timeout != 0 || entry.Timeout != 0 {
+		add("timeout", "%d", entry.Timeout)
+	}
+	if entry.NoMatch {
+		options = append(options, saveOption{name: "nomatch"})
+	}
+	if set.CadtFlags&nl.IPSET_FLAG_WITH_COUNTERS != 0 || entry.Packets != 0 || entry.Bytes != 0 {
+		add("packets", "%d", entry.Packets)
+		add("bytes", "%d", entry.Bytes)
+	}
+	if entry.Comment != "" {
+		add("comment", "\"%s\"", entry.Comment)
+	}
+	if entry.SkbMark != 0 || entry.SkbMask != 0 {
+		if mask := skbMask(entry); mask == 0xffffffff {
+			add("skbmark", "0x%x", entry.SkbMark)
+		} else {
+			add("skbmark", "0x%x/0x%x", entry.SkbMark, mask)
+		}
+	}
+	if entry.SkbPrio != 0 {
+		add("skbprio", "%x:%x", entry.SkbPrio>>16, entry.SkbPrio&0xffff)
+	}
+	if entry.SkbQueue != 0 {
+		add("skbqueue", "%d", entry.SkbQueue)
+	}
+	return options
+}
+
+// saveElem returns an entry as ipset prints it: protocols in lower case,
+// MAC addresses in upper case and single hosts without a prefix length.
+func saveElem(set Set) string {
+	return formatElem(set, net.IP.String)
+}
+
+// formatElem is saveElem, formatting the addresses of hosts and ranges
+// with formatIP.
+func formatElem(set Set, formatIP func(net.IP) string) string {
+	switch s := set.(type) {
+	case *SetMac:
+		return strings.ToUpper(s.MAC.String())
+	case *SetResult:
+		if s.MAC != nil {
+			return strings.ToUpper(s.MAC.String())
+		}
+	}
+
+	e := entryFieldsOf(set)
+	if e.ip == nil {
+		return set.String()
+	}
+	bits := 128
+	if e.ip.To4() != nil {
+		bits = 32
+	}
+	var elem string
+	switch {
+	case e.ipTo != nil:
+		elem = formatIP(e.ip) + "-" + formatIP(e.ipTo)
+	case e.cidr != 0 && int(e.cidr) < bits:
+		elem = fmt.Sprintf("%s/%d", e.ip, e.cidr)
+	default:
+		elem = formatIP(e.ip)
+	}
+	if e.has(DimPort) {
+		elem += "," + strings.ToLower(formatProtoPort(e.proto, e.port, e.portTo))
+	}
+	return elem
+}
@@ -0,0 +1,44 @@
+package goipset
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/JiHanHuang/goipset/ipsettest"
+	"golang.org/x/sys/unix"
+)
+
+func TestWriteSave(t *testing.T) {
+	ipset := NewGoIpsetWithTransport(ipsettest.NewKernel())
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check(ipset.Create("ips", "hash:ip", GoIpsetCreateOptions{Timeout: 600, Counters: true, Comments: true}))
+	check(ipset.Add("ips", &GoIPSetEntry{Set: &SetIP{IP: net.ParseIP("10.0.0.1")}, Timeout: 300, Packets: 7, Bytes: 420, Comment: "a host"}))
+
+	check(ipset.Create("nets", "hash:net,port", GoIpsetCreateOptions{Family: unix.AF_INET6, HashSize: 2048, MaxElem: 100}))
+	check(ipset.Add("nets", &GoIPSetEntry{Set: &SetNetPort{IP: net.ParseIP("2001:db8::"), CIDR: 32, Port: 443, Proto: unix.IPPROTO_TCP}}))
+	check(ipset.Add("nets", &GoIPSetEntry{Set: &SetNetPort{IP: net.ParseIP("2001:db8::1"), CIDR: 128, Port: 443, Proto: unix.IPPROTO_TCP}, NoMatch: true}))
+	check(ipset.Add("nets", &GoIPSetEntry{Set: &SetNetPort{IP: net.ParseIP("2001:db8::2"), CIDR: 128, Port: 128 << 8, Proto: unix.IPPROTO_ICMPV6}}))
+
+	check(ipset.Create("macs", "hash:mac", GoIpsetCreateOptions{Skbinfo: true}))
+	check(ipset.Add("macs", &GoIPSetEntry{Set: &SetMac{MAC: net.HardwareAddr{0x02, 0, 0, 0, 0, 0xab}}, SkbMark: 0x10, SkbPrio: 1<<16 | 2, SkbQueue: 3}))
+	check(ipset.Add("macs", &GoIPSetEntry{Set: &SetMac{MAC: net.HardwareAddr{0x02, 0, 0, 0, 0, 0xac}}, SkbMark: 0x10, SkbMask: 0xff}))
+
+	sets, err := ipset.ListAll()
+	check(err)
+	var out bytes.Buffer
+	check(WriteSave(&out, sets...))
+
+	expected, err := ioutil.ReadFile("testdata/save.txt")
+	check(err)
+	if out.String() != string(expected) {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+}
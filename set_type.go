@@ -15,7 +15,6 @@ import (
 	"net"
 
 	"github.com/JiHanHuang/goipset/nl"
-	"golang.org/x/sys/unix"
 )
 
 type Set interface {
@@ -24,11 +23,6 @@ type Set interface {
 	//update(date interface{})
 }
 
-var protoStr = map[uint8]string{
-	unix.IPPROTO_TCP: "TCP",
-	unix.IPPROTO_UDP: "UDP",
-}
-
 //SetResult is ipset list result set
 type SetResult struct {
 	MAC   net.HardwareAddr
@@ -49,8 +43,8 @@ func (set *SetResult) String() string {
 	if set.CIDR > 0 {
 		retStr = fmt.Sprintf("%s/%d", retStr, set.CIDR)
 	}
-	if set.Port > 0 && set.Proto > 0 {
-		retStr = fmt.Sprintf("%s,%s:%d", retStr, protoStr[set.Proto], set.Port)
+	if set.Proto > 0 {
+		retStr = fmt.Sprintf("%s,%s", retStr, formatProtoPort(set.Proto, set.Port, 0))
 	}
 	return retStr
 }
@@ -93,7 +87,8 @@ ip,port:
 	entry type: ip,<proto:>port
 	ip type:x.x.x.x or x.x.x.x-x.x.x.x or ipv6
 	port type: xx or xx-xx
-	proto type: udp or tcp or null
+	proto type: tcp, udp, sctp, udplite, icmp, icmpv6 or another protocol
+	with port 0, tcp if null
 ip type not support ipv6 range
 */
 type SetIPPort struct {
@@ -106,7 +101,7 @@ type SetIPPort struct {
 }
 
 func (set *SetIPPort) serializeAttr(parent *nl.RtAttr) {
-	if set.IP != nil && set.Port > 0 {
+	if set.IP != nil {
 		attrIP := nl.NewRtAttr(nl.IPSET_ATTR_IP|int(nl.NLA_F_NESTED), nil)
 		if ip4 := set.IP.To4(); ip4 != nil {
 			attrIP.AddChild(nl.NewRtAttr(nl.IPSET_ATTR_IPADDR_IPV4|int(nl.NLA_F_NET_BYTEORDER), set.IP.To4()))
@@ -139,11 +134,7 @@ func (set *SetIPPort) String() string {
 	if set.IPTO != nil {
 		ipStr = fmt.Sprintf("%s-%s", set.IP.String(), set.IPTO.String())
 	}
-	portStr := fmt.Sprintf("%s:%d", protoStr[set.Proto], set.Port)
-	if set.PortTo > 0 {
-		portStr = fmt.Sprintf("%s:%d-%d", protoStr[set.Proto], set.Port, set.PortTo)
-	}
-	return fmt.Sprintf("%s,%s", ipStr, portStr)
+	return fmt.Sprintf("%s,%s", ipStr, formatProtoPort(set.Proto, set.Port, set.PortTo))
 }
 
 //SetMac
@@ -160,9 +151,11 @@ func (set *SetMac) String() string {
 	return set.MAC.String()
 }
 
-//SetNet
+//SetNet support ip/cidr, and ip-ipto which is split into networks, by the
+//kernel for ipv4 and by Add and Del for ipv6
 type SetNet struct {
 	IP   net.IP
+	IPTO net.IP
 	CIDR uint8
 }
 
@@ -175,12 +168,16 @@ func (set *SetNet) serializeAttr(parent *nl.RtAttr) {
 			attrIP.AddChild(nl.NewRtAttr(nl.IPSET_ATTR_IPADDR_IPV6|int(nl.NLA_F_NET_BYTEORDER), set.IP.To16()))
 		}
 		parent.AddChild(attrIP)
+		serializeIPTo(parent, set.IP, set.IPTO)
 		if set.CIDR > 0 {
 			parent.AddChild(nl.NewRtAttr(nl.IPSET_ATTR_CIDR, nl.Uint8Attr(set.CIDR)))
 		}
 	}
 }
 func (set *SetNet) String() string {
+	if set.IPTO != nil {
+		return fmt.Sprintf("%s-%s", set.IP.String(), set.IPTO.String())
+	}
 	if set.CIDR > 0 {
 		return fmt.Sprintf("%s/%d", set.IP.String(), set.CIDR)
 	}
@@ -190,6 +187,7 @@ func (set *SetNet) String() string {
 //SetNetPort
 type SetNetPort struct {
 	IP     net.IP
+	IPTO   net.IP
 	CIDR   uint8
 	Port   uint16
 	PortTo uint16
@@ -205,6 +203,7 @@ func (set *SetNetPort) serializeAttr(parent *nl.RtAttr) {
 			attrIP.AddChild(nl.NewRtAttr(nl.IPSET_ATTR_IPADDR_IPV6|int(nl.NLA_F_NET_BYTEORDER), set.IP.To16()))
 		}
 		parent.AddChild(attrIP)
+		serializeIPTo(parent, set.IP, set.IPTO)
 		if set.CIDR > 0 {
 			parent.AddChild(nl.NewRtAttr(nl.IPSET_ATTR_CIDR, nl.Uint8Attr(set.CIDR)))
 		}
@@ -221,12 +220,24 @@ func (set *SetNetPort) serializeAttr(parent *nl.RtAttr) {
 }
 func (set *SetNetPort) String() string {
 	ipStr := fmt.Sprintf("%s", set.IP.String())
-	if set.CIDR > 0 {
+	if set.IPTO != nil {
+		ipStr = fmt.Sprintf("%s-%s", set.IP.String(), set.IPTO.String())
+	} else if set.CIDR > 0 {
 		ipStr = fmt.Sprintf("%s/%d", set.IP.String(), set.CIDR)
 	}
-	portStr := fmt.Sprintf("%s:%d", protoStr[set.Proto], set.Port)
-	if set.PortTo > 0 {
-		portStr = fmt.Sprintf("%s:%d-%d", protoStr[set.Proto], set.Port, set.PortTo)
+	return fmt.Sprintf("%s,%s", ipStr, formatProtoPort(set.Proto, set.Port, set.PortTo))
+}
+
+// serializeIPTo adds the end of an IP range starting at ip, if any.
+func serializeIPTo(parent *nl.RtAttr, ip, ipTo net.IP) {
+	if ipTo == nil {
+		return
+	}
+	attrIPTO := nl.NewRtAttr(nl.IPSET_ATTR_IP_TO|int(nl.NLA_F_NESTED), nil)
+	if ip.To4() != nil {
+		attrIPTO.AddChild(nl.NewRtAttr(nl.IPSET_ATTR_IPADDR_IPV4|int(nl.NLA_F_NET_BYTEORDER), ipTo.To4()))
+	} else {
+		attrIPTO.AddChild(nl.NewRtAttr(nl.IPSET_ATTR_IPADDR_IPV6|int(nl.NLA_F_NET_BYTEORDER), ipTo.To16()))
 	}
-	return fmt.Sprintf("%s,%s", ipStr, portStr)
+	parent.AddChild(attrIPTO)
 }
@@ -0,0 +1,277 @@
+package goipset
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+
+	"github.com/JiHanHuang/goipset/nl"
+)
+
+// maxSyncExpand is the most entries a single range of a desired entry may
+// stand for, the kernel refuses larger ranges of addresses anyway.
+const maxSyncExpand = 1 << 16
+
+// SyncOptions are the options of Sync.
+type SyncOptions struct {
+	// Update replaces entries that are in the set already but with another
+	// comment, timeout, skbinfo or nomatch flag. Without it they are left
+	// alone.
+	Update bool
+	// DryRun only computes the report without changing the set.
+	DryRun bool
+}
+
+// SyncReport tells what Sync changed, or would change with DryRun.
+type SyncReport struct {
+	Added     int // entries added
+	Deleted   int // entries deleted
+	Updated   int // entries replaced because of SyncOptions.Update
+	Unchanged int // entries kept as they were
+}
+
+// Sync makes an existing ipset contain exactly the desired entries.
+func Sync(setname string, desired []GoIPSetEntry, options SyncOptions) (SyncReport, error) {
+	return gipset.Sync(setname, desired, options)
+}
+
+// Sync lists the ipset, compares it with the desired entries and only adds
+// and deletes the entries that differ. Entries are compared the way the
+// kernel stores them: networks by their first address, addresses of a set
+// with a netmask by their network, ranges of addresses and ports as the
+// networks, addresses and ports they are split into by the set type.
+//
+// An entry in the set differs from the desired one if its comment,
+// skbinfo or nomatch flag is another one, or if the desired entry has a timeout and
+// the entry in the set has none or a longer one. The remaining time of an
+// entry only shrinks, so one with less time left than desired is not
+// renewed. Counters are not compared. Of desired entries that end up the same, the
+// first one is used.
+//
+// Entries are deleted before anything is added, so a set with maxelem
+// does not run full. If the kernel rejects an entry, the changes made so
+// far stay and the report counts what was done until then.
+func (g *GoIpset) Sync(setname string, desired []GoIPSetEntry, options SyncOptions) (SyncReport, error) {
+	var report SyncReport
+	var header *setHeader
+	var want map[string]*GoIPSetEntry
+	var order []string
+	err := g.withHeader(setname, func(h *setHeader) error {
+		header, want, order = h, map[string]*GoIPSetEntry{}, nil
+		for i := range desired {
+			entry := &desired[i]
+			if entry.Set == nil {
+				return fmt.Errorf("Set is nil in GoIPSetEntry")
+			}
+			if header.setType != nil {
+				if err := header.setType.ValidateEntry(header.family, header.revision, entry); err != nil {
+					return fmt.Errorf("%s: %v", setname, err)
+				}
+			}
+			sets, err := header.normalize(entry.Set)
+			if err != nil {
+				return fmt.Errorf("%s: %v", setname, err)
+			}
+			for _, set := range sets {
+				key := set.String()
+				if _, ok := want[key]; ok {
+					continue
+				}
+				normalized := *entry
+				normalized.Set = set
+				normalized.Replace = false
+				want[key] = &normalized
+				order = append(order, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	var del, add, update []*GoIPSetEntry
+	have := map[string]bool{}
+	err = g.ListEach(setname, func(current GoIPSetEntry) error {
+		sets, err := header.normalize(current.Set)
+		if err != nil || len(sets) != 1 {
+			return fmt.Errorf("%s: unexpected entry %s from kernel", setname, current.Set)
+		}
+		key := sets[0].String()
+		have[key] = true
+		entry, ok := want[key]
+		switch {
+		case !ok:
+			current.Set = sets[0]
+			del = append(del, &current)
+		case !syncDiffers(&current, entry):
+			report.Unchanged++
+		case options.Update:
+			update = append(update, entry)
+		default:
+			report.Unchanged++
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	for _, key := range order {
+		if !have[key] {
+			add = append(add, want[key])
+		}
+	}
+
+	if options.DryRun {
+		report.Deleted, report.Added, report.Updated = len(del), len(add), len(update)
+		return report, nil
+	}
+	if err := g.ipsetAddDelBatch(nl.IPSET_CMD_DEL, setname, del, false); err != nil {
+		return report, err
+	}
+	report.Deleted = len(del)
+	if err := g.ipsetAddDelBatch(nl.IPSET_CMD_ADD, setname, add, false); err != nil {
+		return report, err
+	}
+	report.Added = len(add)
+	if err := g.ipsetAddDelBatch(nl.IPSET_CMD_ADD, setname, update, true); err != nil {
+		return report, err
+	}
+	report.Updated = len(update)
+	return report, nil
+}
+
+// syncDiffers returns whether the entry in the set has to be replaced to
+// become the desired one.
+func syncDiffers(current, desired *GoIPSetEntry) bool {
+	if current.Comment != desired.Comment || current.NoMatch != desired.NoMatch {
+		return true
+	}
+	if current.SkbMark != desired.SkbMark || skbMask(current) != skbMask(desired) ||
+		current.SkbPrio != desired.SkbPrio || current.SkbQueue != desired.SkbQueue {
+		return true
+	}
+	// Without a timeout the entry gets the one of the set, whatever it is.
+	if desired.Timeout == 0 {
+		return false
+	}
+	return current.Timeout == 0 || current.Timeout > desired.Timeout
+}
+
+// skbMask returns the mask of the skbmark the kernel stores for entry.
+func skbMask(entry *GoIPSetEntry) uint32 {
+	if entry.SkbMask == 0 && entry.SkbMark != 0 {
+		return 0xffffffff
+	}
+	return entry.SkbMask
+}
+
+// normalize returns the entries the kernel stores for set in a set with
+// this header: ranges are split, networks start at their first address,
+// addresses are masked with the netmask of the set and a single address of
+// a network type is a network of one host.
+func (h *setHeader) normalize(set Set) ([]Set, error) {
+	switch s := set.(type) {
+	case *SetMac:
+		return []Set{&SetMac{MAC: s.MAC}}, nil
+	case *SetResult:
+		if s.MAC != nil {
+			return []Set{&SetMac{MAC: s.MAC}}, nil
+		}
+	}
+
+	e := entryFieldsOf(set)
+	if e.ip == nil {
+		return nil, fmt.Errorf("entry %s has no IP address", set)
+	}
+	isNet := e.has(DimNet)
+	if h.setType != nil {
+		isNet = h.setType.hasNet()
+	}
+	isPort := e.has(DimPort)
+
+	ip := e.ip.To16()
+	bits := 128
+	if ip4 := e.ip.To4(); ip4 != nil {
+		ip, bits = ip4, 32
+	}
+	cidr := int(e.cidr)
+	if cidr == 0 || !isNet {
+		cidr = bits
+	}
+
+	var nets []*net.IPNet
+	switch {
+	case e.ipTo == nil:
+		nets = []*net.IPNet{{IP: h.maskIP(ip).Mask(net.CIDRMask(cidr, bits)), Mask: net.CIDRMask(cidr, bits)}}
+	case isNet:
+		var err error
+		if nets, err = RangeToCIDRs(e.ip, e.ipTo); err != nil {
+			return nil, err
+		}
+		nets = splitWhole(nets)
+	default:
+		// Every address of the range is an entry of its own, with a
+		// netmask every network of that size.
+		first := new(big.Int).SetBytes(h.maskIP(ip))
+		last := new(big.Int).SetBytes(h.maskIP(ipOfLen(e.ipTo, len(ip))))
+		step := big.NewInt(1)
+		if h.netmask != 0 {
+			step.Lsh(step, uint(bits-int(h.netmask)))
+		}
+		size := new(big.Int).Sub(last, first)
+		size.Quo(size, step).Add(size, big.NewInt(1))
+		if size.Sign() <= 0 || size.Cmp(big.NewInt(maxSyncExpand)) > 0 {
+			return nil, fmt.Errorf("range %s-%s is empty or larger than %d addresses", e.ip, e.ipTo, maxSyncExpand)
+		}
+		for v := first; v.Cmp(last) <= 0; v.Add(v, step) {
+			nets = append(nets, &net.IPNet{IP: bigToIP(v, len(ip)), Mask: net.CIDRMask(bits, bits)})
+		}
+	}
+
+	ports := []uint16{e.port}
+	if isPort && e.portTo > e.port {
+		ports = nil
+		for port := int(e.port); port <= int(e.portTo); port++ {
+			ports = append(ports, uint16(port))
+		}
+	}
+	if len(nets)*len(ports) > maxSyncExpand {
+		return nil, fmt.Errorf("entry %s is larger than %d entries", set, maxSyncExpand)
+	}
+
+	var sets []Set
+	for _, n := range nets {
+		ones, _ := n.Mask.Size()
+		for _, port := range ports {
+			switch {
+			case isNet && isPort:
+				sets = append(sets, &SetNetPort{IP: n.IP, CIDR: uint8(ones), Port: port, Proto: e.proto})
+			case isNet:
+				sets = append(sets, &SetNet{IP: n.IP, CIDR: uint8(ones)})
+			case isPort:
+				sets = append(sets, &SetIPPort{IP: n.IP, Port: port, Proto: e.proto})
+			default:
+				sets = append(sets, &SetIP{IP: n.IP})
+			}
+		}
+	}
+	return sets, nil
+}
+
+// maskIP returns ip, of 4 or 16 bytes, as a set with the netmask of the
+// header stores it.
+func (h *setHeader) maskIP(ip net.IP) net.IP {
+	if h.netmask == 0 {
+		return ip
+	}
+	return ip.Mask(net.CIDRMask(int(h.netmask), len(ip)*8))
+}
+
+// ipOfLen returns ip in its 4 or 16 byte form.
+func ipOfLen(ip net.IP, n int) net.IP {
+	if n == net.IPv4len {
+		return ip.To4()
+	}
+	return ip.To16()
+}
@@ -0,0 +1,174 @@
+package goipset
+
+import (
+	"net"
+	"testing"
+
+	"github.com/JiHanHuang/goipset/ipsettest"
+	"golang.org/x/sys/unix"
+)
+
+func listStrings(t *testing.T, ipset *GoIpset, setname string) map[string]GoIPSetEntry {
+	t.Helper()
+	result, err := ipset.List(setname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string]GoIPSetEntry{}
+	for _, entry := range result.Entries {
+		entries[entry.Set.String()] = entry
+	}
+	return entries
+}
+
+func TestSync(t *testing.T) {
+	ipset := NewGoIpsetWithTransport(ipsettest.NewKernel())
+	if err := ipset.Create("test", "hash:net", GoIpsetCreateOptions{Comments: true, Timeout: 600}); err != nil {
+		t.Fatal(err)
+	}
+	for _, set := range []Set{
+		&SetNet{IP: net.ParseIP("10.0.0.0"), CIDR: 24},
+		&SetNet{IP: net.ParseIP("10.0.1.0"), CIDR: 24},
+		&SetNet{IP: net.ParseIP("10.0.2.0"), CIDR: 24},
+	} {
+		if err := ipset.Add("test", &GoIPSetEntry{Set: set, Comment: "old"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	desired := []GoIPSetEntry{
+		// Not the first address of the network, but the same entry.
+		{Set: &SetNet{IP: net.ParseIP("10.0.0.7"), CIDR: 24}, Comment: "old"},
+		{Set: &SetNet{IP: net.ParseIP("10.0.1.0"), CIDR: 24}, Comment: "new"},
+		{Set: &SetNet{IP: net.ParseIP("10.1.0.0"), IPTO: net.ParseIP("10.1.0.5")}, Comment: "range"},
+		{Set: &SetIP{IP: net.ParseIP("10.2.0.1")}, Comment: "host"},
+	}
+	report, err := ipset.Sync("test", desired, SyncOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (SyncReport{Added: 3, Deleted: 1, Unchanged: 2}) {
+		t.Errorf("unexpected dry run report %+v", report)
+	}
+	if n := len(listStrings(t, ipset, "test")); n != 3 {
+		t.Errorf("expected a dry run not to change the set, got %d entries", n)
+	}
+
+	report, err = ipset.Sync("test", desired, SyncOptions{Update: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (SyncReport{Added: 3, Deleted: 1, Updated: 1, Unchanged: 1}) {
+		t.Errorf("unexpected report %+v", report)
+	}
+	entries := listStrings(t, ipset, "test")
+	got := []string{}
+	for s := range entries {
+		got = append(got, s)
+	}
+	expectEntries(t, got, "10.0.0.0/24", "10.0.1.0/24", "10.1.0.0/30", "10.1.0.4/31", "10.2.0.1/32")
+	if c := entries["10.0.1.0/24"].Comment; c != "new" {
+		t.Errorf("expected the comment to be updated, got %q", c)
+	}
+
+	// Nothing left to do.
+	report, err = ipset.Sync("test", desired, SyncOptions{Update: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (SyncReport{Unchanged: 5}) {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	report, err = ipset.Sync("test", nil, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (SyncReport{Deleted: 5}) {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	if _, err := ipset.Sync("missing", nil, SyncOptions{}); err != unix.ENOENT {
+		t.Errorf("expected ENOENT for a missing set, got %v", err)
+	}
+}
+
+func TestSyncPorts(t *testing.T) {
+	ipset := NewGoIpsetWithTransport(ipsettest.NewKernel())
+	if err := ipset.Create("test", "hash:ip,port", GoIpsetCreateOptions{Timeout: 600}); err != nil {
+		t.Fatal(err)
+	}
+	entry := GoIPSetEntry{Set: &SetIPPort{IP: net.ParseIP("10.0.0.1"), Port: 80, Proto: unix.IPPROTO_TCP}, Timeout: 100}
+	if err := ipset.Add("test", &entry); err != nil {
+		t.Fatal(err)
+	}
+
+	desired := []GoIPSetEntry{
+		{Set: &SetIPPort{IP: net.ParseIP("10.0.0.1"), IPTO: net.ParseIP("10.0.0.2"), Port: 80, PortTo: 81, Proto: unix.IPPROTO_TCP}, Timeout: 300},
+	}
+	report, err := ipset.Sync("test", desired, SyncOptions{Update: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An entry with less time left than desired is not renewed.
+	if report != (SyncReport{Added: 3, Unchanged: 1}) {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	// But one that would stay too long is.
+	desired[0].Timeout = 50
+	report, err = ipset.Sync("test", desired, SyncOptions{Update: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (SyncReport{Updated: 4}) {
+		t.Errorf("unexpected report %+v", report)
+	}
+	for s, entry := range listStrings(t, ipset, "test") {
+		if entry.Timeout == 0 || entry.Timeout > 50 {
+			t.Errorf("expected %s to time out within 50s, got timeout %d", s, entry.Timeout)
+		}
+	}
+}
+
+func TestSyncNetMask(t *testing.T) {
+	kernel := ipsettest.NewKernel()
+	ipset := NewGoIpsetWithTransport(kernel)
+	if err := ipset.Create("test", "hash:ip", GoIpsetCreateOptions{NetMask: 24}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipset.Add("test", &GoIPSetEntry{Set: &SetIP{IP: net.ParseIP("10.0.0.77")}}); err != nil {
+		t.Fatal(err)
+	}
+	if entries := listStrings(t, ipset, "test"); len(entries) != 1 || entries["10.0.0.0"].Set == nil {
+		t.Errorf("expected the address to be stored as 10.0.0.0, got %v", entries)
+	}
+	desired := []GoIPSetEntry{
+		{Set: &SetIP{IP: net.ParseIP("10.0.0.5")}},
+		{Set: &SetIP{IP: net.ParseIP("10.0.1.200"), IPTO: net.ParseIP("10.0.3.1")}},
+	}
+	report, err := ipset.Sync("test", desired, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (SyncReport{Added: 3, Unchanged: 1}) {
+		t.Errorf("unexpected report %+v", report)
+	}
+	got := []string{}
+	for s := range listStrings(t, ipset, "test") {
+		got = append(got, s)
+	}
+	expectEntries(t, got, "10.0.0.0", "10.0.1.0", "10.0.2.0", "10.0.3.0")
+
+	// The entries are stored by their network, which is what is desired.
+	// A client that has to ask for the netmask compares them the same way.
+	for _, ipset := range []*GoIpset{ipset, NewGoIpsetWithTransport(kernel)} {
+		report, err = ipset.Sync("test", desired, SyncOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report != (SyncReport{Unchanged: 4}) {
+			t.Errorf("unexpected report %+v", report)
+		}
+	}
+}
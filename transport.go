@@ -0,0 +1,57 @@
+package goipset
+
+import (
+	"github.com/JiHanHuang/goipset/nl"
+	"golang.org/x/sys/unix"
+)
+
+// Transport carries ipset requests to the kernel, or to something standing
+// in for it such as ipsettest.Kernel.
+type Transport interface {
+	// Execute sends req and calls fn for every reply message, each
+	// starting with its nfgenmsg header. If a dump has to be restarted
+	// after fn was called, restart is called first, when restart is nil
+	// the dump fails instead. Errors of the kernel are a syscall.Errno,
+	// possibly wrapped in an *nl.ExtAckError or, for requests with
+	// EchoErrors, an *nl.RequestError, so check them with errors.Is or
+	// errors.As rather than comparing or type asserting them.
+	Execute(req *nl.NetlinkRequest, fn func(msg []byte) error, restart func() error) error
+}
+
+// NetlinkTransport sends requests to the kernel over a NETLINK_NETFILTER
+// socket. It is the Transport used unless another one is set.
+type NetlinkTransport struct{}
+
+var netlinkTransport Transport = &NetlinkTransport{}
+
+func (t *NetlinkTransport) Execute(req *nl.NetlinkRequest, fn func(msg []byte) error, restart func() error) error {
+	return req.ExecuteDump(unix.NETLINK_NETFILTER, 0, fn, restart)
+}
+
+// SocketTransport sends requests to the kernel over a single
+// NETLINK_NETFILTER socket that stays open until Close, instead of one
+// socket per request as NetlinkTransport does. It suits long running
+// clients sending many requests. Requests may be sent concurrently, they
+// take turns on the socket.
+type SocketTransport struct {
+	handle *nl.SocketHandle
+}
+
+// NewSocketTransport opens the socket of a SocketTransport.
+func NewSocketTransport() (*SocketTransport, error) {
+	handle, err := nl.NewSocketHandle(unix.NETLINK_NETFILTER)
+	if err != nil {
+		return nil, err
+	}
+	return &SocketTransport{handle: handle}, nil
+}
+
+func (t *SocketTransport) Execute(req *nl.NetlinkRequest, fn func(msg []byte) error, restart func() error) error {
+	req.SocketHandle = t.handle
+	return req.ExecuteDump(unix.NETLINK_NETFILTER, 0, fn, restart)
+}
+
+// Close closes the socket.
+func (t *SocketTransport) Close() {
+	t.handle.Close()
+}
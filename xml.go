@@ -0,0 +1,136 @@
+package goipset
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// WriteXML writes sets as listed by List or ListAll in the XML format of
+// ipset list -output xml:
+//
+//	<ipsets>
+//	<ipset name="ips">
+//	<type>hash:ip</type>
+//	<revision>6</revision>
+//	<header>
+//	<family>inet</family>
+//	<hashsize>1024</hashsize>
+//	...
+//	<comment></comment>
+//	<memsize>264</memsize>
+//	<references>0</references>
+//	<numentries>1</numentries>
+//	</header>
+//	<members>
+//	<member><elem>10.0.0.1</elem><timeout>300</timeout><comment>a host</comment></member>
+//	</members>
+//	</ipset>
+//	</ipsets>
+//
+// The create and entry options are those of ipset save, in the same order,
+// the options without a value are empty elements and comments have no
+// quotes.
+func WriteXML(w io.Writer, sets ...GoIPSetResult) error {
+	x := xmlWriter{enc: xml.NewEncoder(w)}
+	x.start("ipsets")
+	x.newline()
+	for i := range sets {
+		if x.err == nil {
+			x.err = sets[i].MarshalXML(x.enc, xml.StartElement{Name: xml.Name{Local: "ipset"}})
+		}
+		x.newline()
+	}
+	x.end("ipsets")
+	x.newline()
+	if x.err == nil {
+		x.err = x.enc.Flush()
+	}
+	return x.err
+}
+
+// MarshalXML encodes the set as an ipset element of WriteXML, start is
+// ignored. Without it encoding/xml would use MarshalText.
+func (result GoIPSetResult) MarshalXML(enc *xml.Encoder, _ xml.StartElement) error {
+	x := xmlWriter{enc: enc}
+	x.start("ipset", xml.Attr{Name: xml.Name{Local: "name"}, Value: result.SetName})
+	x.newline()
+	x.element("type", result.TypeName)
+	x.newline()
+	x.element("revision", fmt.Sprint(result.Revision))
+	x.newline()
+
+	x.start("header")
+	x.newline()
+	for _, option := range createOptions(&result) {
+		x.element(option.name, option.value)
+		x.newline()
+	}
+	x.element("memsize", fmt.Sprint(result.SizeInMemory))
+	x.newline()
+	x.element("references", fmt.Sprint(result.References))
+	x.newline()
+	x.element("numentries", fmt.Sprint(result.NumEntries))
+	x.newline()
+	x.end("header")
+	x.newline()
+
+	x.start("members")
+	x.newline()
+	for i := range result.Entries {
+		entry := &result.Entries[i]
+		if entry.Set == nil {
+			return fmt.Errorf("Set is nil in GoIPSetEntry")
+		}
+		x.start("member")
+		x.element("elem", saveElem(entry.Set))
+		for _, option := range entryOptions(&result, entry) {
+			if option.name == "comment" {
+				option.value = entry.Comment
+			}
+			x.element(option.name, option.value)
+		}
+		x.end("member")
+		x.newline()
+	}
+	x.end("members")
+	x.newline()
+	x.end("ipset")
+	if x.err == nil {
+		x.err = enc.Flush()
+	}
+	return x.err
+}
+
+// xmlWriter writes the tokens of WriteXML and keeps the first error.
+type xmlWriter struct {
+	enc *xml.Encoder
+	err error
+}
+
+func (x *xmlWriter) token(t xml.Token) {
+	if x.err == nil {
+		x.err = x.enc.EncodeToken(t)
+	}
+}
+
+func (x *xmlWriter) start(name string, attr ...xml.Attr) {
+	x.token(xml.StartElement{Name: xml.Name{Local: name}, Attr: attr})
+}
+
+func (x *xmlWriter) end(name string) {
+	x.token(xml.EndElement{Name: xml.Name{Local: name}})
+}
+
+// element writes <name>value</name>.
+func (x *xmlWriter) element(name, value string) {
+	x.start(name)
+	if value != "" {
+		x.token(xml.CharData(value))
+	}
+	x.end(name)
+}
+
+func (x *xmlWriter) newline() {
+	x.token(xml.CharData("\n"))
+}
@@ -0,0 +1,59 @@
+package goipset
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"testing"
+)
+
+func TestWriteXML(t *testing.T) {
+	sets, _ := restoredSets(t)
+	var out bytes.Buffer
+	if err := WriteXML(&out, sets...); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadFile("testdata/list.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != string(expected) {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+
+	var decoded struct {
+		Sets []struct {
+			Name     string    `xml:"name,attr"`
+			Type     string    `xml:"type"`
+			Timeout  *int      `xml:"header>timeout"`
+			Counters *struct{} `xml:"header>counters"`
+			Members  []struct {
+				Elem    string    `xml:"elem"`
+				Comment string    `xml:"comment"`
+				NoMatch *struct{} `xml:"nomatch"`
+			} `xml:"members>member"`
+		} `xml:"ipset"`
+	}
+	if err := xml.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Sets) != 3 || decoded.Sets[0].Name != "ips" || decoded.Sets[1].Type != "hash:net,port" ||
+		decoded.Sets[0].Timeout == nil || *decoded.Sets[0].Timeout != 600 || decoded.Sets[0].Counters == nil || decoded.Sets[1].Counters != nil {
+		t.Fatalf("unexpected sets %+v", decoded.Sets)
+	}
+	if m := decoded.Sets[0].Members; len(m) != 1 || m[0].Elem != "10.0.0.1" || m[0].Comment != "a host" {
+		t.Errorf("unexpected members %+v", m)
+	}
+	if m := decoded.Sets[1].Members; len(m) != 3 || m[1].NoMatch == nil || m[0].NoMatch != nil {
+		t.Errorf("unexpected members %+v", m)
+	}
+
+	// encoding/xml encodes a set like WriteXML, not as its text.
+	data, err := xml.Marshal(sets[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(expected, data) {
+		t.Errorf("expected %s to be in\n%s", data, expected)
+	}
+}